@@ -0,0 +1,127 @@
+package infinigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// adaptive is an AIMD controller of the number of requests in flight. The limit grows by one request
+// per round of requests answered without errors and within twice the best latency seen, and halves,
+// at most once per round, when Infinity rate limits the requests, fails them with a 5xx status or
+// cannot be reached.
+type adaptive struct {
+	mu       sync.Mutex
+	min, max float64
+	limit    float64
+	inflight int
+	best     time.Duration // best is the lowest latency seen, drifting up slowly so an outlier does not stick
+	hold     time.Time     // hold is when the limit can be decreased again
+	wake     chan struct{} // wake is closed when a request is done
+}
+
+// SetAdaptiveConcurrency lets requests to Infinity run in parallel, between min and max at once as
+// latencies and errors allow, starting at min. QueryAll then sends its batches in parallel. It is
+// unset by default, when requests are not limited and QueryAll sends its batches one at a time.
+func SetAdaptiveConcurrency(min, max int) OptionFunc {
+	return func(c *Client) error {
+		if min < 1 || max < min {
+			err := &Error{ID: "bad_option", Details: "Adaptive concurrency requires 1 <= min <= max"}
+			c.errorf("%v\n", err)
+			return err
+		}
+		c.adaptive = &adaptive{min: float64(min), max: float64(max), limit: float64(min), wake: make(chan struct{})}
+		return nil
+	}
+}
+
+// Concurrency returns the number of requests currently allowed in flight, 0 without adaptive concurrency
+func (c *Client) Concurrency() int {
+	if c.adaptive == nil {
+		return 0
+	}
+	c.adaptive.mu.Lock()
+	defer c.adaptive.mu.Unlock()
+	return int(c.adaptive.limit)
+}
+
+// acquire waits for a request to be allowed
+func (a *adaptive) acquire(ctx context.Context) error {
+	for {
+		a.mu.Lock()
+		if a.inflight < int(a.limit) {
+			a.inflight++
+			a.mu.Unlock()
+			return nil
+		}
+		wake := a.wake
+		a.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// release adjusts the limit with the outcome of a request
+func (a *adaptive) release(latency time.Duration, resp *http.Response, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inflight--
+	close(a.wake)
+	a.wake = make(chan struct{})
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		// Says nothing about Infinity
+	case resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500) || resp == nil && Unreachable(err):
+		if now := time.Now(); now.After(a.hold) {
+			a.limit = max(a.min, a.limit/2)
+			a.hold = now.Add(latency)
+		}
+	case err == nil:
+		if a.best == 0 || latency < a.best {
+			a.best = latency
+		} else {
+			a.best += (latency - a.best) / 100
+		}
+		if latency <= 2*a.best && a.limit < a.max {
+			a.limit = min(a.max, a.limit+1/a.limit)
+		}
+	}
+}
+
+// queryParallel queries the batches of QueryAll in parallel, as many at once as the controller allows.
+// The other batches are cancelled on the first failed one.
+func (c *Client) queryParallel(ctx context.Context, classifiers string, hash []string) (map[string]QueryResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	resp := make(map[string]QueryResponse, len(hash))
+	var mu sync.Mutex
+	var failed error
+	var wg sync.WaitGroup
+	for start := 0; start < len(hash); start += QueryBatchSize {
+		batch := hash[start:min(start+QueryBatchSize, len(hash))]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := c.QueryContext(ctx, classifiers, batch...)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if failed == nil {
+					failed = err
+					cancel()
+				}
+				return
+			}
+			for k, v := range r {
+				resp[k] = v
+			}
+		}()
+	}
+	wg.Wait()
+	return resp, failed
+}
@@ -0,0 +1,260 @@
+/*
+Package aggregate cross-checks hashes with several engines before acting on them. A lookup is sent to
+Infinity and to every other engine implementing infinigo.Scanner concurrently, and the verdicts of the
+engines are merged by a consensus policy: Majority by default, Strictest to act on any engine flagging
+a hash, Quorum to require a number of engines to agree, or any custom Policy.
+
+An engine failing a lookup does not fail it, the verdict is reached by the engines that answered and the
+failure is reported in the results. The lookup fails only if every engine failed. Engines that did not
+answer once the context of the lookup is done, or within SetTimeout, fail with the error of the context.
+*/
+package aggregate
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// Infinity is the name of the Infinity engine in the results
+const Infinity = "infinity"
+
+// Result is the merged verdict of the engines for a hash
+type Result struct {
+	Hash      string                            `json:"hash"`
+	Verdict   infinigo.Verdict                  `json:"verdict"`
+	Responses map[string]infinigo.QueryResponse `json:"responses"`        // Responses of the engines by name
+	Errors    map[string]string                 `json:"errors,omitempty"` // Errors of the engines that failed the lookup by name
+}
+
+// Policy merges the verdicts of the engines, by name, into one. Engines that failed the lookup are not
+// included, and those without a verdict for the hash are included with VerdictUnknown.
+type Policy func(verdicts map[string]infinigo.Verdict) infinigo.Verdict
+
+// severity orders the verdicts from no opinion to malicious
+var severity = map[infinigo.Verdict]int{
+	infinigo.VerdictError:      0,
+	infinigo.VerdictUnknown:    0,
+	infinigo.VerdictBenign:     1,
+	infinigo.VerdictSuspicious: 2,
+	infinigo.VerdictMalicious:  3,
+}
+
+// Strictest is the worst verdict of any engine, for acting on a hash as soon as one engine flags it
+func Strictest(verdicts map[string]infinigo.Verdict) infinigo.Verdict {
+	verdict := infinigo.VerdictUnknown
+	for _, v := range verdicts {
+		if severity[v] > severity[verdict] {
+			verdict = v
+		}
+	}
+	return verdict
+}
+
+// Majority is the verdict of most of the engines that have one, the worse one on a tie
+func Majority(verdicts map[string]infinigo.Verdict) infinigo.Verdict {
+	counts := make(map[infinigo.Verdict]int)
+	for _, v := range verdicts {
+		if severity[v] > 0 {
+			counts[v]++
+		}
+	}
+	verdict, most := infinigo.VerdictUnknown, 0
+	for v, n := range counts {
+		if n > most || n == most && severity[v] > severity[verdict] {
+			verdict, most = v, n
+		}
+	}
+	return verdict
+}
+
+// Quorum requires n engines to agree. A hash is malicious if n engines say so, suspicious if n engines
+// say it is suspicious or malicious, and benign if n engines say so. It is unknown otherwise.
+// An n below 1 is taken as 1, as no engines agreeing cannot make a verdict.
+func Quorum(n int) Policy {
+	n = max(n, 1)
+	return func(verdicts map[string]infinigo.Verdict) infinigo.Verdict {
+		counts := make(map[infinigo.Verdict]int)
+		for _, v := range verdicts {
+			counts[v]++
+		}
+		switch malicious := counts[infinigo.VerdictMalicious]; {
+		case malicious >= n:
+			return infinigo.VerdictMalicious
+		case malicious+counts[infinigo.VerdictSuspicious] >= n:
+			return infinigo.VerdictSuspicious
+		case counts[infinigo.VerdictBenign] >= n:
+			return infinigo.VerdictBenign
+		}
+		return infinigo.VerdictUnknown
+	}
+}
+
+// Aggregator looks up hashes with several engines
+type Aggregator struct {
+	engines  map[string]infinigo.Scanner
+	policy   Policy
+	errorlog *log.Logger
+	timeout  time.Duration
+}
+
+// OptionFunc is a function that configures an Aggregator.
+// It is used in New
+type OptionFunc func(*Aggregator) error
+
+// New creates an aggregator of Infinity, usually an *infinigo.Client, and the engines added with SetEngine
+func New(infinity infinigo.Scanner, options ...OptionFunc) (*Aggregator, error) {
+	if infinity == nil {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Infinity scanner is required"}
+	}
+	a := &Aggregator{
+		engines: map[string]infinigo.Scanner{Infinity: infinity},
+		policy:  Majority,
+	}
+	for _, option := range options {
+		if err := option(a); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// SetEngine adds an engine with the name, which must be unique
+func SetEngine(name string, s infinigo.Scanner) OptionFunc {
+	return func(a *Aggregator) error {
+		if name == "" || s == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "Engine name and scanner are required"}
+		}
+		if _, ok := a.engines[name]; ok {
+			return &infinigo.Error{ID: "bad_option", Details: "Duplicate engine " + name}
+		}
+		a.engines[name] = s
+		return nil
+	}
+}
+
+// SetPolicy sets how the verdicts of the engines are merged. It is Majority by default.
+func SetPolicy(p Policy) OptionFunc {
+	return func(a *Aggregator) error {
+		if p == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "Policy is required"}
+		}
+		a.policy = p
+		return nil
+	}
+}
+
+// SetTimeout sets how long each engine has to answer a lookup, so a slow engine does not hold up the
+// others. It is 0, no limit other than the context of the lookup, by default.
+func SetTimeout(timeout time.Duration) OptionFunc {
+	return func(a *Aggregator) error {
+		if timeout < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Timeout cannot be negative"}
+		}
+		a.timeout = timeout
+		return nil
+	}
+}
+
+// SetErrorLog sets the logger of the failed lookups of engines. It is nil by default.
+func SetErrorLog(logger *log.Logger) OptionFunc {
+	return func(a *Aggregator) error {
+		a.errorlog = logger
+		return nil
+	}
+}
+
+// Engines returns the names of the engines, sorted
+func (a *Aggregator) Engines() []string {
+	names := make([]string, 0, len(a.engines))
+	for name := range a.engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup looks up the hashes with every engine concurrently and merges their verdicts, returning the
+// results keyed by hash. It fails only if every engine failed.
+func (a *Aggregator) Lookup(ctx context.Context, hash ...string) (map[string]Result, error) {
+	if len(hash) == 0 {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "hash is required"}
+	}
+	type lookup struct {
+		name string
+		resp map[string]infinigo.QueryResponse
+		err  error
+	}
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+	}
+	// Buffered so the engines that answer once the lookup gave up on them do not block
+	answers := make(chan lookup, len(a.engines))
+	for name, s := range a.engines {
+		go func(name string, s infinigo.Scanner) {
+			resp, err := s.LookupHashes(ctx, hash...)
+			answers <- lookup{name, resp, err}
+		}(name, s)
+	}
+	lookups := make([]lookup, 0, len(a.engines))
+	pending := make(map[string]bool, len(a.engines))
+	for name := range a.engines {
+		pending[name] = true
+	}
+collect:
+	for len(pending) > 0 {
+		select {
+		case l := <-answers:
+			delete(pending, l.name)
+			lookups = append(lookups, l)
+		case <-ctx.Done():
+			// Engines ignoring the context are not waited for
+			for name := range pending {
+				lookups = append(lookups, lookup{name: name, err: ctx.Err()})
+			}
+			break collect
+		}
+	}
+	results := make(map[string]Result, len(hash))
+	for _, h := range hash {
+		results[h] = Result{Hash: h, Responses: make(map[string]infinigo.QueryResponse, len(a.engines))}
+	}
+	failures := 0
+	var lastErr error
+	for _, l := range lookups {
+		if l.err != nil {
+			if a.errorlog != nil {
+				a.errorlog.Printf("Lookup with %s failed - %v", l.name, l.err)
+			}
+			failures, lastErr = failures+1, l.err
+			for h, r := range results {
+				if r.Errors == nil {
+					r.Errors = make(map[string]string)
+				}
+				r.Errors[l.name] = l.err.Error()
+				results[h] = r
+			}
+			continue
+		}
+		for h, r := range results {
+			r.Responses[l.name] = l.resp[h]
+		}
+	}
+	if failures == len(a.engines) {
+		return nil, lastErr
+	}
+	for h, r := range results {
+		verdicts := make(map[string]infinigo.Verdict, len(r.Responses))
+		for name, resp := range r.Responses {
+			verdicts[name] = resp.Verdict()
+		}
+		r.Verdict = a.policy(verdicts)
+		results[h] = r
+	}
+	return results, nil
+}
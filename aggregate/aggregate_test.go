@@ -0,0 +1,91 @@
+package aggregate
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+func TestQuorum(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int
+		verdicts map[string]infinigo.Verdict
+		want     infinigo.Verdict
+	}{
+		{"agreeing malicious", 2, map[string]infinigo.Verdict{"a": infinigo.VerdictMalicious, "b": infinigo.VerdictMalicious}, infinigo.VerdictMalicious},
+		{"suspicious with malicious", 2, map[string]infinigo.Verdict{"a": infinigo.VerdictMalicious, "b": infinigo.VerdictSuspicious}, infinigo.VerdictSuspicious},
+		{"no quorum", 2, map[string]infinigo.Verdict{"a": infinigo.VerdictMalicious, "b": infinigo.VerdictBenign}, infinigo.VerdictUnknown},
+		{"zero unflagged", 0, map[string]infinigo.Verdict{"a": infinigo.VerdictUnknown, "b": infinigo.VerdictUnknown}, infinigo.VerdictUnknown},
+		{"zero no engines", 0, map[string]infinigo.Verdict{}, infinigo.VerdictUnknown},
+		{"negative benign", -3, map[string]infinigo.Verdict{"a": infinigo.VerdictBenign}, infinigo.VerdictBenign},
+		{"zero as one", 0, map[string]infinigo.Verdict{"a": infinigo.VerdictMalicious, "b": infinigo.VerdictBenign}, infinigo.VerdictMalicious},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Quorum(tt.n)(tt.verdicts); got != tt.want {
+				t.Errorf("Quorum(%d) = %s, want %s", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+// engine answers every hash with the verdict's score after the delay, unless the context is done first
+type engine struct {
+	score float32
+	delay time.Duration
+}
+
+func (e engine) LookupHashes(ctx context.Context, hash ...string) (map[string]infinigo.QueryResponse, error) {
+	select {
+	case <-time.After(e.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	resp := make(map[string]infinigo.QueryResponse, len(hash))
+	for _, h := range hash {
+		resp[h] = infinigo.QueryResponse{GeneralScore: e.score}
+	}
+	return resp, nil
+}
+
+func (e engine) SubmitSample(context.Context, string, io.Reader) error {
+	return nil
+}
+
+func TestLookupTimeout(t *testing.T) {
+	a, err := New(engine{score: -1}, SetEngine("slow", engine{score: 1, delay: time.Minute}), SetTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	results, err := a.Lookup(context.Background(), "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Lookup waited %v for the slow engine", elapsed)
+	}
+	r := results["abc"]
+	if r.Verdict != infinigo.VerdictMalicious {
+		t.Errorf("Verdict = %s, want %s", r.Verdict, infinigo.VerdictMalicious)
+	}
+	if r.Errors["slow"] == "" {
+		t.Error("Expected the slow engine to fail the lookup")
+	}
+}
+
+func TestLookupCanceled(t *testing.T) {
+	a, err := New(engine{delay: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = a.Lookup(ctx, "abc"); err == nil {
+		t.Fatal("Expected a canceled lookup to fail")
+	}
+}
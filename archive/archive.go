@@ -0,0 +1,138 @@
+/*
+Package archive keeps the report of every result, and optionally a gzipped copy of every sample
+uploaded to Infinity, in object storage to meet retention requirements on what was submitted.
+
+Objects are keyed by date and hash under an optional prefix:
+
+	reports/2016/01/02/<hash>/<time>.json   the result as JSON
+	samples/2016/01/02/<hash>.gz            the gzipped sample
+
+Sink archives the results of a pipeline with an Archiver. The s3 subpackage implements Archiver
+for Amazon S3 and compatible services, the gcs subpackage for Google Cloud Storage and the azure
+subpackage for Azure Blob Storage.
+*/
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// Archiver stores objects, e.g. in a cloud storage bucket
+type Archiver interface {
+	// Put stores the body under the key, replacing any object with the same key.
+	// The body is read from its current offset and can be read again to retry.
+	Put(ctx context.Context, key, contentType string, body io.ReadSeeker) error
+}
+
+// ReportKey returns the key of the report of the hash archived at the time
+func ReportKey(prefix string, t time.Time, hash string) string {
+	t = t.UTC()
+	return prefix + "reports/" + t.Format("2006/01/02") + "/" + strings.ToLower(hash) + "/" + t.Format("20060102T150405.000000000Z") + ".json"
+}
+
+// SampleKey returns the key of the sample of the hash archived at the time
+func SampleKey(prefix string, t time.Time, hash string) string {
+	return prefix + "samples/" + t.UTC().Format("2006/01/02") + "/" + strings.ToLower(hash) + ".gz"
+}
+
+// Sink archives the results of a pipeline
+type Sink struct {
+	archiver Archiver
+	prefix   string
+	samples  bool
+}
+
+// OptionFunc is a function that configures a Sink.
+// It is used in New
+type OptionFunc func(*Sink) error
+
+// New creates a sink archiving the results with the archiver
+func New(a Archiver, options ...OptionFunc) (*Sink, error) {
+	if a == nil {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Archiver is required"}
+	}
+	s := &Sink{archiver: a}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SetPrefix sets the prefix of the keys, e.g. infinigo/ to share a bucket. There is none by default.
+func SetPrefix(prefix string) OptionFunc {
+	return func(s *Sink) error {
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		s.prefix = strings.TrimPrefix(prefix, "/")
+		return nil
+	}
+}
+
+// SetSamples also archives a gzipped copy of the files uploaded to Infinity. Only reports are archived by default.
+func SetSamples(samples bool) OptionFunc {
+	return func(s *Sink) error {
+		s.samples = samples
+		return nil
+	}
+}
+
+// OnResult archives the report of the result, and its sample if it was uploaded and samples are archived
+func (s *Sink) OnResult(ctx context.Context, r pipeline.Result) error {
+	now := time.Now()
+	report, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = s.archiver.Put(ctx, ReportKey(s.prefix, now, r.Submission.Hash), "application/json", bytes.NewReader(report)); err != nil {
+		return err
+	}
+	if !s.samples || !r.Submission.Uploaded || r.Submission.Path == "" {
+		return nil
+	}
+	return s.putSample(ctx, SampleKey(s.prefix, now, r.Submission.Hash), r.Submission.Path)
+}
+
+// gzipWriters are reused across samples, as each writer allocates large buffers
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// putSample gzips the file to a temporary file and archives it, so large samples are not held in memory
+func (s *Sink) putSample(ctx context.Context, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tmp, err := os.CreateTemp("", "infinigo-sample")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	gz := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(gz)
+	gz.Reset(tmp)
+	if _, err = io.Copy(gz, f); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return s.archiver.Put(ctx, key, "application/gzip", tmp)
+}
@@ -0,0 +1,259 @@
+/*
+Package azure archives objects as block blobs in an Azure Blob Storage container.
+
+Requests are authorized with the account key set with SetSharedKey or the AZURE_STORAGE_KEY
+environment variable, or with the SAS token set with SetSASToken or the AZURE_STORAGE_SAS_TOKEN
+environment variable. Azure AD credentials are not supported.
+*/
+package azure
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/internal/backoff"
+)
+
+const (
+	DefaultRetries = 3 // DefaultRetries of an upload that failed
+	apiVersion     = "2021-08-06"
+)
+
+// Access tiers of the blobs
+const (
+	Hot     = "Hot"     // Hot is for frequently read blobs
+	Cool    = "Cool"    // Cool is for blobs kept at least 30 days and rarely read
+	Cold    = "Cold"    // Cold is for blobs kept at least 90 days and rarely read
+	Archive = "Archive" // Archive is for blobs kept at least 180 days, which must be rehydrated to be read
+)
+
+// Archiver puts blobs in a container
+type Archiver struct {
+	account   string
+	container string
+	endpoint  string
+	key       []byte
+	sas       url.Values
+	tier      string
+	c         *http.Client
+	retries   int
+	backoff   time.Duration
+}
+
+// OptionFunc is a function that configures an Archiver.
+// It is used in New
+type OptionFunc func(*Archiver) error
+
+// New creates an archiver putting blobs in the container of the storage account
+func New(account, container string, options ...OptionFunc) (*Archiver, error) {
+	if account == "" || container == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Azure storage account and container are required"}
+	}
+	a := &Archiver{
+		account:   account,
+		container: container,
+		endpoint:  "https://" + account + ".blob.core.windows.net",
+		c:         http.DefaultClient,
+		retries:   DefaultRetries,
+		backoff:   time.Second,
+	}
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		options = append([]OptionFunc{SetSharedKey(key)}, options...)
+	} else if token := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); token != "" {
+		options = append([]OptionFunc{SetSASToken(token)}, options...)
+	}
+	for _, option := range options {
+		if err := option(a); err != nil {
+			return nil, err
+		}
+	}
+	if a.key == nil && a.sas == nil {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Azure storage account key or SAS token is required"}
+	}
+	return a, nil
+}
+
+// SetHTTPClient sets the client of the requests to Blob Storage, e.g. to reach a storage account through
+// a proxy or private endpoint. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(a *Archiver) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		a.c = c
+		return nil
+	}
+}
+
+// SetEndpoint sets the URL of the blob service of the account, e.g. http://127.0.0.1:10000/devstoreaccount1
+// for the Azurite emulator. It is https://<account>.blob.core.windows.net by default.
+func SetEndpoint(endpoint string) OptionFunc {
+	return func(a *Archiver) error {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad Azure endpoint [%s]", endpoint)}
+		}
+		a.endpoint = strings.TrimSuffix(endpoint, "/")
+		return nil
+	}
+}
+
+// SetSharedKey authorizes the requests with the base64 access key of the storage account
+func SetSharedKey(key string) OptionFunc {
+	return func(a *Archiver) error {
+		b, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(b) == 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Azure storage account key must be base64"}
+		}
+		a.key, a.sas = b, nil
+		return nil
+	}
+}
+
+// SetSASToken authorizes the requests with a shared access signature, which must allow creating and writing blobs
+func SetSASToken(token string) OptionFunc {
+	return func(a *Archiver) error {
+		q, err := url.ParseQuery(strings.TrimPrefix(token, "?"))
+		if err != nil || q.Get("sig") == "" {
+			return &infinigo.Error{ID: "bad_option", Details: "Bad Azure SAS token"}
+		}
+		a.sas, a.key = q, nil
+		return nil
+	}
+}
+
+// SetAccessTier sets the access tier of the blobs to Hot, Cool, Cold or Archive. Blobs use the default tier of
+// the account by default.
+func SetAccessTier(tier string) OptionFunc {
+	return func(a *Archiver) error {
+		switch tier {
+		case "", Hot, Cool, Cold, Archive:
+		default:
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Unsupported access tier [%s], expected %s, %s, %s or %s", tier, Hot, Cool, Cold, Archive)}
+		}
+		a.tier = tier
+		return nil
+	}
+}
+
+// SetRetries sets how many times a failed upload is sent again, doubling the delay from a second.
+// It is DefaultRetries by default.
+func SetRetries(retries int) OptionFunc {
+	return func(a *Archiver) error {
+		if retries < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Retries must not be negative"}
+		}
+		a.retries = retries
+		return nil
+	}
+}
+
+// Put uploads the body as the blob with the key
+func (a *Archiver) Put(ctx context.Context, key, contentType string, body io.ReadSeeker) error {
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	size -= start
+	return backoff.Retry(ctx, a.retries, a.backoff, func() (bool, error) {
+		if _, err := body.Seek(start, io.SeekStart); err != nil {
+			return false, err
+		}
+		return a.put(ctx, key, contentType, io.LimitReader(body, size), size)
+	})
+}
+
+// put sends the blob and returns whether a failure is worth retrying
+func (a *Archiver) put(ctx context.Context, key, contentType string, body io.Reader, size int64) (bool, error) {
+	u := a.endpoint + "/" + escapePath(a.container) + "/" + escapePath(key)
+	if a.sas != nil {
+		u += "?" + a.sas.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, io.NopCloser(body))
+	if err != nil {
+		return false, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	req.Header.Set("X-Ms-Version", apiVersion)
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	if a.tier != "" {
+		req.Header.Set("X-Ms-Access-Tier", a.tier)
+	}
+	if a.key != nil {
+		a.sign(req)
+	}
+	resp, err := a.c.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("Azure returned %d for %s - %s", resp.StatusCode, key, bytes.TrimSpace(msg))}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// sign adds the Shared Key authorization of the request
+func (a *Archiver) sign(req *http.Request) {
+	length := ""
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+	var names []string
+	for k := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, k := range names {
+		canonical.WriteString(k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n")
+	}
+	canonical.WriteString("/" + a.account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for k := range query {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		values := query[k]
+		sort.Strings(values)
+		canonical.WriteString("\n" + strings.ToLower(k) + ":" + strings.Join(values, ","))
+	}
+	toSign := strings.Join([]string{req.Method, "", "", length, "", req.Header.Get("Content-Type"), "", "", "", "", "", ""}, "\n") + "\n" + canonical.String()
+	m := hmac.New(sha256.New, a.key)
+	m.Write([]byte(toSign))
+	req.Header.Set("Authorization", "SharedKey "+a.account+":"+base64.StdEncoding.EncodeToString(m.Sum(nil)))
+}
+
+// escapePath escapes every segment of the path, keeping the slashes
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
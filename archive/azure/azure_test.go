@@ -0,0 +1,151 @@
+package azure
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+var accountKey = base64.StdEncoding.EncodeToString([]byte("Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq"))
+
+// blob is a blob of the fake container
+type blob struct {
+	body   string
+	header http.Header
+}
+
+// service is a fake blob service of the account devstoreaccount1 at /devstoreaccount1, as Azurite is,
+// authorizing the requests signed with accountKey or with the SAS signature "sig", and answering the
+// first uploads with the statuses in fail
+type service struct {
+	mu    sync.Mutex
+	blobs map[string]blob
+	fail  []int
+	puts  int
+}
+
+func newService(t *testing.T) (*service, string) {
+	t.Helper()
+	s := &service{blobs: map[string]blob{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Query().Get("sig") != "sig" && r.Header.Get("Authorization") != sharedKey(r) {
+			http.Error(w, "AuthenticationFailed", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPut || r.Header.Get("X-Ms-Blob-Type") != "BlockBlob" || r.Header.Get("X-Ms-Version") == "" {
+			http.Error(w, "InvalidHeaderValue", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.puts++
+		if len(s.fail) > 0 {
+			w.WriteHeader(s.fail[0])
+			s.fail = s.fail[1:]
+			return
+		}
+		s.blobs[strings.TrimPrefix(r.URL.Path, "/devstoreaccount1/")] = blob{string(body), r.Header}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(srv.Close)
+	return s, srv.URL + "/devstoreaccount1"
+}
+
+// sharedKey returns the Shared Key authorization of the request as the blob service computes it
+func sharedKey(r *http.Request) string {
+	length := r.Header.Get("Content-Length")
+	if length == "0" {
+		length = ""
+	}
+	var b strings.Builder
+	b.WriteString(r.Method + "\n\n\n" + length + "\n\n" + r.Header.Get("Content-Type") + "\n\n\n\n\n\n\n")
+	var names []string
+	for k := range r.Header {
+		if strings.HasPrefix(strings.ToLower(k), "x-ms-") {
+			names = append(names, strings.ToLower(k))
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		b.WriteString(k + ":" + r.Header.Get(k) + "\n")
+	}
+	b.WriteString("/devstoreaccount1" + r.URL.EscapedPath())
+	key, _ := base64.StdEncoding.DecodeString(accountKey)
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(b.String()))
+	return "SharedKey devstoreaccount1:" + base64.StdEncoding.EncodeToString(m.Sum(nil))
+}
+
+func (s *service) blob(name string) (blob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.blobs[name]
+	return b, ok
+}
+
+func TestPut(t *testing.T) {
+	s, url := newService(t)
+	a, err := New("devstoreaccount1", "archive", SetEndpoint(url), SetSharedKey(accountKey), SetAccessTier(Cool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Put(context.Background(), "reports/2016/01/02/a b/1.json", "application/json", strings.NewReader("report")); err != nil {
+		t.Fatal(err)
+	}
+	b, ok := s.blob("archive/reports/2016/01/02/a b/1.json")
+	if !ok || b.body != "report" || b.header.Get("Content-Type") != "application/json" || b.header.Get("X-Ms-Access-Tier") != Cool {
+		t.Fatalf("Expected the blob to be put in the cool tier, got %v", s.blobs)
+	}
+
+	a, err = New("devstoreaccount1", "archive", SetEndpoint(url), SetSASToken("?sv=2021-08-06&sp=cw&sig=sig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Put(context.Background(), "report.json", "application/json", strings.NewReader("report")); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok = s.blob("archive/report.json"); !ok || b.header.Get("Authorization") != "" {
+		t.Fatalf("Expected the blob to be put with the SAS token, got %v", b.header)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	s, url := newService(t)
+	s.fail = []int{http.StatusServiceUnavailable, http.StatusInternalServerError}
+	a, err := New("devstoreaccount1", "archive", SetEndpoint(url), SetSharedKey(accountKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.backoff = time.Millisecond
+	if err = a.Put(context.Background(), "report.json", "application/json", strings.NewReader("report")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.blob("archive/report.json"); !ok || s.puts != 3 {
+		t.Fatalf("Expected the blob to be put on the third attempt, got %d attempts", s.puts)
+	}
+
+	wrong := base64.StdEncoding.EncodeToString([]byte("wrong"))
+	if a, err = New("devstoreaccount1", "archive", SetEndpoint(url), SetSharedKey(wrong)); err != nil {
+		t.Fatal(err)
+	}
+	var ierr *infinigo.Error
+	if err = a.Put(context.Background(), "report.json", "application/json", strings.NewReader("report")); !errors.As(err, &ierr) || ierr.ID != "http_error" || !strings.Contains(ierr.Details, "AuthenticationFailed") {
+		t.Fatalf("Expected a wrong key to be refused, got %v", err)
+	}
+	if _, err = New("devstoreaccount1", "archive", SetAccessTier("Premium")); !errors.As(err, &ierr) || ierr.ID != "bad_option" {
+		t.Fatalf("Expected an unknown tier to be refused, got %v", err)
+	}
+}
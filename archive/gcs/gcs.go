@@ -0,0 +1,315 @@
+/*
+Package gcs archives objects in a Google Cloud Storage bucket with the JSON API.
+
+Requests are authorized with the service account key file set with SetCredentialsFile or the
+GOOGLE_APPLICATION_CREDENTIALS environment variable, or else with the default service account of the
+Compute Engine, GKE or Cloud Run instance from the metadata server. SetToken authorizes with a fixed
+access token instead, e.g. one printed by gcloud auth print-access-token.
+*/
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/internal/backoff"
+)
+
+const (
+	DefaultEndpoint = "https://storage.googleapis.com" // DefaultEndpoint of the JSON API
+	DefaultRetries  = 3                                // DefaultRetries of an upload that failed
+)
+
+const (
+	scope       = "https://www.googleapis.com/auth/devstorage.read_write"
+	metadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// Archiver puts objects in a bucket
+type Archiver struct {
+	bucket   string
+	endpoint string
+	kmsKey   string
+	account  *serviceAccount
+	c        *http.Client
+	retries  int
+	backoff  time.Duration
+	mu       sync.Mutex
+	token    string
+	expires  time.Time // zero if the token does not expire
+}
+
+// serviceAccount is the part of a service account key file used to get access tokens
+type serviceAccount struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	key         *rsa.PrivateKey
+}
+
+// OptionFunc is a function that configures an Archiver.
+// It is used in New
+type OptionFunc func(*Archiver) error
+
+// New creates an archiver putting objects in the bucket
+func New(bucket string, options ...OptionFunc) (*Archiver, error) {
+	if bucket == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "GCS bucket is required"}
+	}
+	a := &Archiver{
+		bucket:   bucket,
+		endpoint: DefaultEndpoint,
+		c:        http.DefaultClient,
+		retries:  DefaultRetries,
+		backoff:  time.Second,
+	}
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		options = append([]OptionFunc{SetCredentialsFile(path)}, options...)
+	}
+	for _, option := range options {
+		if err := option(a); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// SetHTTPClient sets the client of the uploads and of the requests for access tokens, e.g. to go through
+// a proxy. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(a *Archiver) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		a.c = c
+		return nil
+	}
+}
+
+// SetEndpoint sets the URL of the JSON API, e.g. of an emulator. It is DefaultEndpoint by default.
+func SetEndpoint(endpoint string) OptionFunc {
+	return func(a *Archiver) error {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad GCS endpoint [%s]", endpoint)}
+		}
+		a.endpoint = strings.TrimSuffix(endpoint, "/")
+		return nil
+	}
+}
+
+// SetCredentialsFile authorizes the requests as the service account of the JSON key file
+func SetCredentialsFile(path string) OptionFunc {
+	return func(a *Archiver) error {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sa := &serviceAccount{}
+		if err = json.Unmarshal(b, sa); err != nil {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad GCS credentials file [%s] - %v", path, err)}
+		}
+		if sa.Type != "service_account" || sa.ClientEmail == "" {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("GCS credentials file [%s] is not a service account key", path)}
+		}
+		block, _ := pem.Decode([]byte(sa.PrivateKey))
+		if block == nil {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("GCS credentials file [%s] has no private key", path)}
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad private key in GCS credentials file [%s] - %v", path, err)}
+			}
+		}
+		var ok bool
+		if sa.key, ok = key.(*rsa.PrivateKey); !ok {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("GCS credentials file [%s] does not have an RSA key", path)}
+		}
+		if sa.TokenURI == "" {
+			sa.TokenURI = "https://oauth2.googleapis.com/token"
+		}
+		a.account, a.token = sa, ""
+		return nil
+	}
+}
+
+// SetToken authorizes the requests with a fixed OAuth access token instead of getting tokens for a service account
+func SetToken(token string) OptionFunc {
+	return func(a *Archiver) error {
+		if token == "" {
+			return &infinigo.Error{ID: "bad_option", Details: "GCS access token is required"}
+		}
+		a.account, a.token, a.expires = nil, token, time.Time{}
+		return nil
+	}
+}
+
+// SetKMSKey encrypts the objects with the Cloud KMS key, as
+// projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>.
+// Objects use the default encryption of the bucket by default.
+func SetKMSKey(name string) OptionFunc {
+	return func(a *Archiver) error {
+		a.kmsKey = name
+		return nil
+	}
+}
+
+// SetRetries sets how many times a failed upload is sent again, doubling the delay from a second.
+// It is DefaultRetries by default.
+func SetRetries(retries int) OptionFunc {
+	return func(a *Archiver) error {
+		if retries < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Retries must not be negative"}
+		}
+		a.retries = retries
+		return nil
+	}
+}
+
+// Put uploads the body as the object with the key
+func (a *Archiver) Put(ctx context.Context, key, contentType string, body io.ReadSeeker) error {
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	size -= start
+	return backoff.Retry(ctx, a.retries, a.backoff, func() (bool, error) {
+		if _, err := body.Seek(start, io.SeekStart); err != nil {
+			return false, err
+		}
+		return a.put(ctx, key, contentType, io.LimitReader(body, size), size)
+	})
+}
+
+// put uploads the object and returns whether a failure is worth retrying
+func (a *Archiver) put(ctx context.Context, key, contentType string, body io.Reader, size int64) (bool, error) {
+	token, err := a.accessToken(ctx)
+	if err != nil {
+		return true, err
+	}
+	q := url.Values{"uploadType": {"media"}, "name": {key}}
+	if a.kmsKey != "" {
+		q.Set("kmsKeyName", a.kmsKey)
+	}
+	u := a.endpoint + "/upload/storage/v1/b/" + url.PathEscape(a.bucket) + "/o?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, io.NopCloser(body))
+	if err != nil {
+		return false, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := a.c.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	if resp.StatusCode == http.StatusUnauthorized && a.account != nil {
+		// The token was revoked or the clock is off, get a new one on the retry
+		a.mu.Lock()
+		a.token = ""
+		a.mu.Unlock()
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("GCS returned %d for %s - %s", resp.StatusCode, key, bytes.TrimSpace(msg))}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 || resp.StatusCode == http.StatusUnauthorized && a.account != nil, err
+}
+
+// accessToken returns a valid access token, getting a new one a minute before the current one expires
+func (a *Archiver) accessToken(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && (a.expires.IsZero() || time.Until(a.expires) > time.Minute) {
+		return a.token, nil
+	}
+	var req *http.Request
+	var err error
+	if a.account != nil {
+		req, err = a.account.tokenRequest(ctx)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+		if req != nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	resp, err := a.c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("GCS token request returned %d - %s", resp.StatusCode, bytes.TrimSpace(msg))}
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", err
+	}
+	if t.AccessToken == "" {
+		return "", &infinigo.Error{ID: "http_error", Details: "GCS token response has no access token"}
+	}
+	a.token, a.expires = t.AccessToken, time.Now().Add(time.Duration(t.ExpiresIn)*time.Second)
+	return a.token, nil
+}
+
+// tokenRequest creates the request exchanging a signed JWT of the service account for an access token
+func (sa *serviceAccount) tokenRequest(ctx context.Context) (*http.Request, error) {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": scope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, sa.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sa.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
@@ -0,0 +1,194 @@
+package gcs
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// storage is a fake JSON API and OAuth token endpoint, granting tokens for JWTs signed by the key of
+// the service account. Revoking the tokens answers the next upload with 401.
+type storage struct {
+	mu      sync.Mutex
+	key     *rsa.PrivateKey
+	tokens  int
+	revoked bool
+	uploads []*http.Request
+	objects map[string]string
+}
+
+func newStorage(t *testing.T) (*storage, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &storage{key: key, objects: map[string]string{}}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.URL.Path {
+		case "/token":
+			if err := s.verify(r.FormValue("assertion"), srv.URL+"/token"); r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || err != nil {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			s.tokens++
+			s.revoked = false
+			fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":3600,"token_type":"Bearer"}`, s.tokens)
+		case "/upload/storage/v1/b/archive/o":
+			body, _ := io.ReadAll(r.Body)
+			s.uploads = append(s.uploads, r)
+			if s.revoked || r.Header.Get("Authorization") != fmt.Sprintf("Bearer token-%d", s.tokens) {
+				http.Error(w, `{"error":{"code":401}}`, http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("uploadType") != "media" {
+				http.Error(w, `{"error":{"code":400}}`, http.StatusBadRequest)
+				return
+			}
+			s.objects[r.URL.Query().Get("name")] = string(body)
+			w.Write([]byte(`{"kind":"storage#object"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return s, srv.URL
+}
+
+// verify checks the signature and claims of the JWT
+func (s *storage) verify(jwt, audience string) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return errors.New("bad JWT")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(&s.key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		return err
+	}
+	b, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims struct {
+		Iss   string `json:"iss"`
+		Scope string `json:"scope"`
+		Aud   string `json:"aud"`
+		Exp   int64  `json:"exp"`
+	}
+	if err = json.Unmarshal(b, &claims); err != nil {
+		return err
+	}
+	if claims.Iss != "archiver@project.iam.gserviceaccount.com" || claims.Scope != scope || claims.Aud != audience || claims.Exp < time.Now().Unix() {
+		return fmt.Errorf("bad claims %+v", claims)
+	}
+	return nil
+}
+
+// credentialsFile writes the key file of the service account
+func (s *storage) credentialsFile(t *testing.T, url string) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "archiver@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    url + "/token",
+	})
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err = os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPut(t *testing.T) {
+	s, url := newStorage(t)
+	a, err := New("archive", SetEndpoint(url), SetCredentialsFile(s.credentialsFile(t, url)), SetKMSKey("projects/p/locations/l/keyRings/r/cryptoKeys/k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.backoff = time.Millisecond
+	for _, key := range []string{"reports/2016/01/02/abc/1.json", "reports/2016/01/02/abc/2.json"} {
+		if err = a.Put(context.Background(), key, "application/json", strings.NewReader("report")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.mu.Lock()
+	if s.tokens != 1 || len(s.objects) != 2 || s.objects["reports/2016/01/02/abc/2.json"] != "report" {
+		t.Fatalf("Expected the objects to be uploaded with a single token, got %d tokens and %v", s.tokens, s.objects)
+	}
+	if r := s.uploads[0]; r.Header.Get("Content-Type") != "application/json" || r.URL.Query().Get("kmsKeyName") != "projects/p/locations/l/keyRings/r/cryptoKeys/k" {
+		t.Fatalf("Expected the content type and KMS key, got %v", r.URL)
+	}
+	// A revoked token is replaced on the retry
+	s.revoked = true
+	s.mu.Unlock()
+	if err = a.Put(context.Background(), "report.json", "application/json", strings.NewReader("report")); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens != 2 || s.objects["report.json"] != "report" {
+		t.Fatalf("Expected a new token to be used, got %d tokens", s.tokens)
+	}
+}
+
+func TestPutToken(t *testing.T) {
+	s, url := newStorage(t)
+	a, err := New("archive", SetEndpoint(url), SetToken("revoked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.backoff = time.Millisecond
+	var ierr *infinigo.Error
+	if err = a.Put(context.Background(), "report.json", "application/json", strings.NewReader("report")); !errors.As(err, &ierr) || ierr.ID != "http_error" || !strings.Contains(ierr.Details, "401") {
+		t.Fatalf("Expected the fixed token to be refused, got %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.uploads) != 1 {
+		t.Fatalf("Expected a fixed token not to be retried, got %d attempts", len(s.uploads))
+	}
+}
+
+func TestSetCredentialsFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"user.json":   `{"type":"authorized_user","client_id":"id"}`,
+		"nokey.json":  `{"type":"service_account","client_email":"archiver@project.iam.gserviceaccount.com"}`,
+		"broken.json": `{"type":`,
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0600)
+		var ierr *infinigo.Error
+		if _, err := New("archive", SetCredentialsFile(path)); !errors.As(err, &ierr) || ierr.ID != "bad_option" {
+			t.Errorf("%s: expected the credentials to be refused, got %v", name, err)
+		}
+	}
+}
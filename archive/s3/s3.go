@@ -0,0 +1,305 @@
+/*
+Package s3 archives objects in an Amazon S3 bucket, or a bucket of a service compatible with the S3
+API with SetEndpoint, signing requests with AWS Signature Version 4.
+
+Credentials are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+environment variables unless set with SetCredentials, and the region from AWS_REGION or
+AWS_DEFAULT_REGION unless set with SetRegion. Instance and container role credentials are not looked up.
+*/
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/internal/backoff"
+	"github.com/demisto/infinigo/internal/sigv4"
+)
+
+const (
+	DefaultRegion  = "us-east-1" // DefaultRegion of the bucket if none is set
+	DefaultRetries = 3           // DefaultRetries of an upload that failed
+)
+
+// Server side encryption algorithms
+const (
+	SSES3  = "AES256"  // SSES3 encrypts with keys managed by S3
+	SSEKMS = "aws:kms" // SSEKMS encrypts with a KMS key
+)
+
+// Archiver puts objects in a bucket
+type Archiver struct {
+	bucket      string
+	region      string
+	endpoint    string // endpoint of a compatible service, addressing the bucket in the path
+	credentials sigv4.Credentials
+	sse         string
+	kmsKeyID    string
+	c           *http.Client
+	retries     int
+	backoff     time.Duration
+}
+
+// OptionFunc is a function that configures an Archiver.
+// It is used in New
+type OptionFunc func(*Archiver) error
+
+// New creates an archiver putting objects in the bucket
+func New(bucket string, options ...OptionFunc) (*Archiver, error) {
+	if bucket == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "S3 bucket is required"}
+	}
+	a := &Archiver{
+		bucket:      bucket,
+		region:      sigv4.Region(DefaultRegion),
+		credentials: sigv4.FromEnv(),
+		c:           http.DefaultClient,
+		retries:     DefaultRetries,
+		backoff:     time.Second,
+	}
+	for _, option := range options {
+		if err := option(a); err != nil {
+			return nil, err
+		}
+	}
+	if a.credentials.AccessKey == "" || a.credentials.SecretKey == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "AWS access key and secret key are required"}
+	}
+	return a, nil
+}
+
+// SetHTTPClient sets the client of the requests to S3, e.g. to trust the CA of a MinIO server set with
+// SetEndpoint. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(a *Archiver) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		a.c = c
+		return nil
+	}
+}
+
+// SetRegion sets the region of the bucket
+func SetRegion(region string) OptionFunc {
+	return func(a *Archiver) error {
+		if region == "" {
+			return &infinigo.Error{ID: "bad_option", Details: "S3 region is required"}
+		}
+		a.region = region
+		return nil
+	}
+}
+
+// SetEndpoint sets the URL of a service compatible with S3, e.g. http://minio:9000. The bucket is
+// addressed in the path of the URL instead of its host name.
+func SetEndpoint(endpoint string) OptionFunc {
+	return func(a *Archiver) error {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad S3 endpoint [%s]", endpoint)}
+		}
+		a.endpoint = strings.TrimSuffix(endpoint, "/")
+		return nil
+	}
+}
+
+// SetCredentials sets the access key, secret key and optional session token of the requests
+func SetCredentials(accessKey, secretKey, sessionToken string) OptionFunc {
+	return func(a *Archiver) error {
+		a.credentials = sigv4.Credentials{AccessKey: accessKey, SecretKey: secretKey, SessionToken: sessionToken}
+		return nil
+	}
+}
+
+// SetSSE encrypts the objects with SSES3 or SSEKMS, with the KMS key ID or the default KMS key of the
+// account if empty. Objects use the default encryption of the bucket by default.
+func SetSSE(algorithm, kmsKeyID string) OptionFunc {
+	return func(a *Archiver) error {
+		switch algorithm {
+		case "", SSES3:
+			if kmsKeyID != "" {
+				return &infinigo.Error{ID: "bad_option", Details: "A KMS key requires " + SSEKMS + " encryption"}
+			}
+		case SSEKMS:
+		default:
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Unsupported server side encryption [%s], expected %s or %s", algorithm, SSES3, SSEKMS)}
+		}
+		a.sse, a.kmsKeyID = algorithm, kmsKeyID
+		return nil
+	}
+}
+
+// SetRetries sets how many times a failed upload is sent again, doubling the delay from a second.
+// It is DefaultRetries by default.
+func SetRetries(retries int) OptionFunc {
+	return func(a *Archiver) error {
+		if retries < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Retries must not be negative"}
+		}
+		a.retries = retries
+		return nil
+	}
+}
+
+// Put uploads the body as the object with the key
+func (a *Archiver) Put(ctx context.Context, key, contentType string, body io.ReadSeeker) error {
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, body)
+	if err != nil {
+		return err
+	}
+	payloadHash := hex.EncodeToString(h.Sum(nil))
+	return backoff.Retry(ctx, a.retries, a.backoff, func() (bool, error) {
+		if _, err := body.Seek(start, io.SeekStart); err != nil {
+			return false, err
+		}
+		return a.put(ctx, key, contentType, io.LimitReader(body, size), size, payloadHash)
+	})
+}
+
+// put sends the object and returns whether a failure is worth retrying
+func (a *Archiver) put(ctx context.Context, key, contentType string, body io.Reader, size int64, payloadHash string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, a.url(key), io.NopCloser(body))
+	if err != nil {
+		return false, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	if a.sse != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", a.sse)
+	}
+	if a.kmsKeyID != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", a.kmsKeyID)
+	}
+	sigv4.Sign(req, a.credentials, a.region, "s3", payloadHash, time.Now())
+	resp, err := a.c.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("S3 returned %d for %s - %s", resp.StatusCode, key, bytes.TrimSpace(msg))}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// Get downloads the object with the key to w
+func (a *Archiver) Get(ctx context.Context, key string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.url(key), nil)
+	if err != nil {
+		return err
+	}
+	sigv4.Sign(req, a.credentials, a.region, "s3", sigv4.PayloadHash(nil), time.Now())
+	resp, err := a.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("S3 returned %d for %s - %s", resp.StatusCode, key, bytes.TrimSpace(msg))}
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Object is an object listed in a bucket
+type Object struct {
+	Key          string    `xml:"Key"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// List calls fn with the objects whose keys start with the prefix, in the order of their keys,
+// stopping at the first error fn returns
+func (a *Archiver) List(ctx context.Context, prefix string, fn func(Object) error) error {
+	token := ""
+	for {
+		query := "list-type=2&prefix=" + escape(prefix)
+		if token != "" {
+			// Parameters are sorted as the signature expects
+			query = "continuation-token=" + escape(token) + "&" + query
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.url("")+"?"+query, nil)
+		if err != nil {
+			return err
+		}
+		sigv4.Sign(req, a.credentials, a.region, "s3", sigv4.PayloadHash(nil), time.Now())
+		resp, err := a.c.Do(req)
+		if err != nil {
+			return err
+		}
+		var out struct {
+			Contents              []Object `xml:"Contents"`
+			IsTruncated           bool     `xml:"IsTruncated"`
+			NextContinuationToken string   `xml:"NextContinuationToken"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			return &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("S3 returned %d listing %s - %s", resp.StatusCode, prefix, bytes.TrimSpace(msg))}
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, o := range out.Contents {
+			if err = fn(o); err != nil {
+				return err
+			}
+		}
+		if !out.IsTruncated || out.NextContinuationToken == "" {
+			return nil
+		}
+		token = out.NextContinuationToken
+	}
+}
+
+// url returns the URL of the object with the key
+func (a *Archiver) url(key string) string {
+	if a.endpoint != "" {
+		return a.endpoint + "/" + escapePath(a.bucket) + "/" + escapePath(key)
+	}
+	return "https://" + a.bucket + ".s3." + a.region + ".amazonaws.com/" + escapePath(key)
+}
+
+// escapePath escapes every segment of the path as S3 expects, keeping the slashes
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// escape escapes all but the unreserved characters of the string, as in the paths and queries of signed requests
+func escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,283 @@
+package infinigo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// Audited actions
+const (
+	AuditQuery   = "query"   // AuditQuery is a hash query
+	AuditUpload  = "upload"  // AuditUpload is a file upload
+	AuditRequest = "request" // AuditRequest is a request to another endpoint, sent with Do
+)
+
+// AuditEntry records a request sent to Infinity. Every entry includes the hash of the previous one,
+// so removing or changing an entry breaks the chain from that point on.
+type AuditEntry struct {
+	Seq         int64              `json:"seq"`                   // Seq is the position of the entry in the log, starting at 1
+	Time        time.Time          `json:"time"`                  // Time the request was sent
+	User        string             `json:"user"`                  // User running the process
+	Host        string             `json:"host"`                  // Host running the process
+	Key         string             `json:"key"`                   // Key is a fingerprint of the API key used
+	Action      string             `json:"action"`                // Action is AuditQuery, AuditUpload or AuditRequest
+	Method      string             `json:"method,omitempty"`      // Method of the request
+	URL         string             `json:"url"`                   // URL of the request
+	Endpoint    string             `json:"endpoint,omitempty"`    // Endpoint is the path of requests sent with Do
+	Params      map[string]string  `json:"params,omitempty"`      // Params of requests sent with Do
+	Classifiers string             `json:"classifiers,omitempty"` // Classifiers of queries
+	Hashes      []string           `json:"hashes"`                // Hashes queried, or the SHA256 of the uploaded content
+	ConfirmCode string             `json:"confirmcode,omitempty"` // ConfirmCode of uploads
+	Path        string             `json:"path,omitempty"`        // Path of uploaded files
+	Size        int64              `json:"size,omitempty"`        // Size of the uploaded content
+	Sent        int64              `json:"sent"`                  // Sent is the number of bytes in the request body
+	Duration    time.Duration      `json:"duration"`              // Duration of the request
+	Status      int                `json:"status,omitempty"`      // Status is the HTTP status code, 0 if there was no response
+	Error       string             `json:"error,omitempty"`       // Error of the request
+	Verdicts    map[string]Verdict `json:"verdicts,omitempty"`    // Verdicts of queried hashes
+	Response    json.RawMessage    `json:"response,omitempty"`    // Response is the body returned by the API
+	Prev        string             `json:"prev"`                  // Prev is the hash of the previous entry, empty for the first
+	Hash        string             `json:"hash"`                  // Hash is the SHA256 of the entry with an empty Hash
+}
+
+// AuditLog is an append-only JSON lines file of every request sent to Infinity, used to prove what was
+// shared with the cloud. It is safe for concurrent use, and processes sharing a log lock the file and
+// pick up each other's entries before appending. On platforms without file locks, e.g. WebAssembly,
+// only a single process may append to a log.
+type AuditLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64  // size of the file after the last entry seen
+	seq  int64  // seq of the last entry
+	last string // hash of the last entry
+	user string
+	host string
+}
+
+// OpenAuditLog opens the audit log at path for appending, creating it if needed
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &AuditLog{f: f}
+	if u, err := user.Current(); err == nil {
+		l.user = u.Username
+	}
+	l.host, _ = os.Hostname()
+	if err = l.locked(l.catchUp); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// locked calls fn holding the lock on the file, so other processes do not append in between
+func (l *AuditLog) locked(fn func() error) error {
+	if err := lockFile(l.f); err != nil {
+		return &Error{ID: "bad_audit_log", Details: fmt.Sprintf("Failed locking %s - %v", l.f.Name(), err)}
+	}
+	defer unlockFile(l.f)
+	return fn()
+}
+
+// Close closes the log
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// Append chains the entry to the log and writes it. Seq, Prev and Hash are set by the log,
+// as well as Time, User and Host if empty.
+func (l *AuditLog) Append(e AuditEntry) (AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.locked(func() error {
+		var err error
+		e, err = l.append(e)
+		return err
+	})
+	return e, err
+}
+
+// append chains the entry to the entries of other processes and writes it. Must be called with the
+// file locked.
+func (l *AuditLog) append(e AuditEntry) (AuditEntry, error) {
+	if err := l.catchUp(); err != nil {
+		return e, err
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.User == "" {
+		e.User = l.user
+	}
+	if e.Host == "" {
+		e.Host = l.host
+	}
+	e.Seq, e.Prev = l.seq+1, l.last
+	var err error
+	if e.Hash, err = e.hash(); err != nil {
+		return e, err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+	b = append(b, '\n')
+	if _, err = l.f.Write(b); err != nil {
+		return e, err
+	}
+	if err = l.f.Sync(); err != nil {
+		return e, err
+	}
+	l.size += int64(len(b))
+	l.seq, l.last = e.Seq, e.Hash
+	return e, nil
+}
+
+// catchUp reads the entries appended since the last one seen, by this or other processes
+func (l *AuditLog) catchUp() error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == l.size {
+		return nil
+	}
+	if info.Size() < l.size {
+		return &Error{ID: "bad_audit_log", Details: fmt.Sprintf("%s was truncated", l.f.Name())}
+	}
+	r := bufio.NewReader(io.NewSectionReader(l.f, l.size, info.Size()-l.size))
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A partial line is being written by a process not locking the file, read it next time
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var e AuditEntry
+		if err = json.Unmarshal(line, &e); err != nil {
+			return &Error{ID: "bad_audit_log", Details: fmt.Sprintf("%s entry after %d - %v", l.f.Name(), l.seq, err)}
+		}
+		l.size += int64(len(line))
+		l.seq, l.last = e.Seq, e.Hash
+	}
+}
+
+// hash returns the SHA256 of the entry with an empty Hash
+func (e AuditEntry) hash() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// VerifyAuditLog checks the chain of the entries read from r and returns the number of entries.
+// The error describes the first entry that was modified, removed or added out of order.
+func VerifyAuditLog(r io.Reader) (int64, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	var n int64
+	prev := ""
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return n, &Error{ID: "bad_audit_log", Details: fmt.Sprintf("Entry after %d is not valid - %v", n, err)}
+		}
+		hash, err := e.hash()
+		if err != nil {
+			return n, err
+		}
+		switch {
+		case e.Seq != n+1:
+			return n, &Error{ID: "bad_audit_log", Details: fmt.Sprintf("Entry %d follows entry %d", e.Seq, n)}
+		case e.Prev != prev:
+			return n, &Error{ID: "bad_audit_log", Details: fmt.Sprintf("Entry %d does not chain to the previous entry", e.Seq)}
+		case e.Hash != hash:
+			return n, &Error{ID: "bad_audit_log", Details: fmt.Sprintf("Entry %d was modified", e.Seq)}
+		}
+		n, prev = e.Seq, e.Hash
+	}
+	return n, scanner.Err()
+}
+
+// SetAuditLog records every query and upload in the audit log. If the entry of a request can not
+// be recorded, the request returns the error instead of its result.
+func SetAuditLog(l *AuditLog) OptionFunc {
+	return func(c *Client) error {
+		c.audit = l
+		return nil
+	}
+}
+
+// record appends the entry of a request to the audit log, returning the error of the request
+// or the error appending the entry if it failed
+func (c *Client) record(e *AuditEntry, req *http.Request, resp *http.Response, recorder *recordingBody, start time.Time, err error) error {
+	e.Time, e.Duration = start.UTC(), time.Since(start)
+	e.Key, e.Method, e.URL, e.Sent = keyFingerprint(c.key), req.Method, req.URL.String(), req.ContentLength
+	if resp != nil {
+		e.Status = resp.StatusCode
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if recorder != nil && recorder.buf.Len() > 0 {
+		raw := bytes.TrimSpace(recorder.buf.Bytes())
+		if json.Valid(raw) {
+			e.Response = raw
+		} else {
+			e.Response, _ = json.Marshal(string(raw))
+		}
+		var queried map[string]QueryResponse
+		if e.Action == AuditQuery && err == nil && json.Unmarshal(raw, &queried) == nil {
+			e.Verdicts = make(map[string]Verdict, len(queried))
+			for h, r := range queried {
+				e.Verdicts[h] = r.Verdict()
+			}
+		}
+	}
+	if _, aerr := c.audit.Append(*e); aerr != nil {
+		c.errorf("Failed recording %s in the audit log - %v\n", e.Action, aerr)
+		return &Error{ID: "audit_failed", Details: aerr.Error()}
+	}
+	return err
+}
+
+// recordingBody keeps a copy of the response body as it is read
+type recordingBody struct {
+	io.ReadCloser
+	buf bytes.Buffer
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+// keyFingerprint identifies an API key in the audit log without revealing it
+func keyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
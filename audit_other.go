@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package infinigo
+
+import "os"
+
+// lockFile does nothing where files cannot be locked, so only a single process may append to a log
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile does nothing where files cannot be locked
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package infinigo
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAuditLogSharedByProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	// Each log opens the file on its own, as separate processes would
+	var logs []*AuditLog
+	for range 4 {
+		l, err := OpenAuditLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		logs = append(logs, l)
+	}
+	var wg sync.WaitGroup
+	for _, l := range logs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if _, err := l.Append(AuditEntry{Action: AuditQuery, Hashes: []string{"abc"}}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n, err := VerifyAuditLog(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 200 {
+		t.Fatalf("Expected 200 entries, got %d", n)
+	}
+}
+
+func TestAuditLogDo(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := New(SetKey("key"), SetURL(srv.URL+"/"), SetAuditLog(l), SetUploadRetries(3, time.Millisecond), SetQueryRetries(3, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{"h": "abc", "c": "ml"}
+	_, err = Do[map[string]string](context.Background(), c, http.MethodPost, "/v2/scan", params, strings.NewReader("data"))
+	if !errors.Is(err, ErrServiceUnavailable) {
+		t.Fatalf("Expected Infinity to be unavailable, got %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("Expected Do not to retry, got %d requests", requests.Load())
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	if !s.Scan() {
+		t.Fatal("Expected the request to be recorded")
+	}
+	var e AuditEntry
+	if err = json.Unmarshal(s.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Action != AuditRequest || e.Method != http.MethodPost || e.Endpoint != "v2/scan" || !reflect.DeepEqual(e.Params, params) {
+		t.Fatalf("Expected the method, endpoint and params to be recorded, got %+v", e)
+	}
+	if e.Status != http.StatusServiceUnavailable || e.Sent != 4 {
+		t.Fatalf("Expected the status and size to be recorded, got %+v", e)
+	}
+}
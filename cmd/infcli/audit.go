@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/demisto/infinigo"
+)
+
+var (
+	auditPath string
+	auditLog  *infinigo.AuditLog
+)
+
+// openAuditLog opens the audit log or returns nil if it is disabled
+func openAuditLog() *infinigo.AuditLog {
+	if auditPath == "" {
+		return nil
+	}
+	if auditLog == nil {
+		l, err := infinigo.OpenAuditLog(auditPath)
+		check(err)
+		auditLog = l
+	}
+	return auditLog
+}
+
+// auditVerify checks the hash chain of the audit log
+func auditVerify(fs *flag.FlagSet) func(args []string) {
+	return func(args []string) {
+		paths := args
+		if len(paths) == 0 {
+			paths = []string{os.Getenv("INFINITY_AUDIT_LOG")}
+		}
+		failed := false
+		for _, path := range paths {
+			if path == "" {
+				fmt.Fprintf(os.Stderr, "Please specify the audit log to verify\n")
+				os.Exit(1)
+			}
+			f, err := os.Open(path)
+			check(err)
+			n, err := infinigo.VerifyAuditLog(f)
+			f.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v - the first %d entries are intact\n", path, err, n)
+				failed = true
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "%s: %d entries verified\n", path, n)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// baselineDiff lists the differences between a baseline and the current scan
+type baselineDiff struct {
+	New     []scanEntry      `json:"new"`
+	Removed []scanEntry      `json:"removed"`
+	Changed []baselineChange `json:"changed"`
+}
+
+// baselineChange is a file whose content changed since the baseline
+type baselineChange struct {
+	Path     string    `json:"path"`
+	Baseline scanEntry `json:"baseline"`
+	Current  scanEntry `json:"current"`
+}
+
+// saveBaseline stores the scan entries as a baseline
+func saveBaseline(path string, entries []scanEntry) error {
+	b, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// loadBaseline loads a baseline stored by saveBaseline keyed by path
+func loadBaseline(path string) (map[string]scanEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []scanEntry
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	base := make(map[string]scanEntry, len(entries))
+	for _, e := range entries {
+		base[e.Path] = e
+	}
+	return base, nil
+}
+
+// diffBaseline compares the current scan with the baseline
+func diffBaseline(base map[string]scanEntry, entries []scanEntry) baselineDiff {
+	diff := baselineDiff{New: []scanEntry{}, Removed: []scanEntry{}, Changed: []baselineChange{}}
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		seen[e.Path] = true
+		b, ok := base[e.Path]
+		switch {
+		case !ok:
+			diff.New = append(diff.New, e)
+		case b.Hash != e.Hash:
+			diff.Changed = append(diff.Changed, baselineChange{Path: e.Path, Baseline: b, Current: e})
+		}
+	}
+	for path, b := range base {
+		if !seen[path] {
+			diff.Removed = append(diff.Removed, b)
+		}
+	}
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Path < diff.Removed[j].Path })
+	return diff
+}
+
+// printBaselineDiff prints the differences from the baseline
+func printBaselineDiff(diff baselineDiff) {
+	if jsonFormat {
+		printJSON(diff)
+		return
+	}
+	for _, e := range diff.New {
+		fmt.Printf("new\t%s\t%s\t%s\t%v\n", e.Path, e.Hash, e.Response.Verdict(), e.Response.GeneralScore)
+	}
+	for _, ch := range diff.Changed {
+		fmt.Printf("changed\t%s\t%s -> %s\t%s\t%v\n", ch.Path, ch.Baseline.Hash, ch.Current.Hash, ch.Current.Response.Verdict(), ch.Current.Response.GeneralScore)
+	}
+	for _, e := range diff.Removed {
+		fmt.Printf("removed\t%s\t%s\n", e.Path, e.Hash)
+	}
+	fmt.Fprintf(os.Stderr, "%d new, %d changed, %d removed\n", len(diff.New), len(diff.Changed), len(diff.Removed))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/demisto/infinigo"
+)
+
+var (
+	classifiers       = newChoiceFlag("all", "none", "ml", "industry", "human")
+	minScores         = classifierBounds{}
+	maxScores         = classifierBounds{}
+	classifierColumns bool
+)
+
+// classifierBounds holds per classifier score limits given as name=value
+type classifierBounds map[string]float32
+
+func (b classifierBounds) String() string {
+	parts := make([]string, 0, len(b))
+	for k, v := range b {
+		parts = append(parts, k+"="+strconv.FormatFloat(float64(v), 'f', -1, 32))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (b classifierBounds) Set(val string) error {
+	name, score, ok := strings.Cut(val, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected classifier=score")
+	}
+	f, err := strconv.ParseFloat(score, 32)
+	if err != nil {
+		return err
+	}
+	b[name] = float32(f)
+	return nil
+}
+
+// classifierFlags registers the flags selecting, filtering and displaying classifiers
+func classifierFlags(fs *flag.FlagSet) {
+	fs.Var(classifiers, "classifiers", "The classifiers to request - "+classifiers.choices())
+	fs.Var(minScores, "min", "Only show results where the classifier score is at least the value, e.g. ml=0.5. Can be repeated.")
+	fs.Var(maxScores, "max", "Only show results where the classifier score is at most the value, e.g. ml=-0.5. Can be repeated.")
+	fs.BoolVar(&classifierColumns, "classifier-columns", false, "Show a column per classifier")
+}
+
+// matchClassifiers returns true if the response is within all the -min and -max bounds.
+// Responses missing a bounded classifier do not match.
+func matchClassifiers(resp infinigo.QueryResponse) bool {
+	for name, min := range minScores {
+		if score, ok := resp.Classifiers[name]; !ok || score < min {
+			return false
+		}
+	}
+	for name, max := range maxScores {
+		if score, ok := resp.Classifiers[name]; !ok || score > max {
+			return false
+		}
+	}
+	return true
+}
+
+// classifierNames returns the sorted names of all the classifiers in the responses
+func classifierNames(responses []infinigo.QueryResponse) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, resp := range responses {
+		for name := range resp.Classifiers {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// classifierCells returns the scores of the named classifiers as tab separated columns, "-" if missing
+func classifierCells(resp infinigo.QueryResponse, names []string) string {
+	cells := make([]string, len(names))
+	for i, name := range names {
+		cells[i] = "-"
+		if score, ok := resp.Classifiers[name]; ok {
+			cells[i] = strconv.FormatFloat(float64(score), 'f', -1, 32)
+		}
+	}
+	return strings.Join(cells, "\t")
+}
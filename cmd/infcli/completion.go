@@ -0,0 +1,259 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// prog is the name completions are registered for
+const prog = "infcli"
+
+// shells supported by the completion command
+var shells = []string{"bash", "zsh", "fish", "powershell"}
+
+// completionTree describes the commands and flags for the completion scripts.
+// Commands are identified by their path, e.g. "infcli db export".
+type completionTree struct {
+	words   map[string][]string // words maps a command path to the sub commands and flags that can follow it
+	choices map[string][]string // choices maps "path:-flag" to the values of flags taking an argument, empty for free values
+	usage   map[string]string   // usage maps "path:-flag" to the flag usage
+	paths   []string            // paths of all the commands, sorted
+}
+
+// isBoolFlag returns true if the flag does not take a value
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// buildCompletionTree collects the commands and their flags
+func buildCompletionTree() *completionTree {
+	t := &completionTree{words: make(map[string][]string), choices: make(map[string][]string), usage: make(map[string]string)}
+	addFlags := func(path string, fs *flag.FlagSet) {
+		fs.VisitAll(func(f *flag.Flag) {
+			t.words[path] = append(t.words[path], "-"+f.Name)
+			t.usage[path+":-"+f.Name] = f.Usage
+			if isBoolFlag(f) {
+				return
+			}
+			var values []string
+			if c, ok := f.Value.(*choiceFlag); ok {
+				values = c.allowed
+			}
+			t.choices[path+":-"+f.Name] = values
+		})
+	}
+	t.words[prog] = nil
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parent := prog
+		for _, w := range strings.Fields(name) {
+			path := parent + " " + w
+			if _, ok := t.words[path]; !ok {
+				t.words[path] = nil
+				t.words[parent] = append(t.words[parent], w)
+			}
+			parent = path
+		}
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		commands[name](fs)
+		addFlags(parent, fs)
+	}
+	t.words[prog+" completion"] = append(append([]string(nil), shells...), t.words[prog+" completion"]...)
+	addFlags(prog, flag.CommandLine)
+	for path := range t.words {
+		t.paths = append(t.paths, path)
+	}
+	sort.Strings(t.paths)
+	return t
+}
+
+// choiceKeys returns the keys of the choices map, sorted
+func (t *completionTree) choiceKeys() []string {
+	keys := make([]string, 0, len(t.choices))
+	for k := range t.choices {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// completion prints a completion script for the given shell
+func completion(fs *flag.FlagSet) func(args []string) {
+	return func(args []string) {
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "Usage: %s completion %s\n", os.Args[0], strings.Join(shells, "|"))
+			os.Exit(1)
+		}
+		t := buildCompletionTree()
+		switch args[0] {
+		case "bash":
+			t.bash(os.Stdout)
+		case "zsh":
+			t.zsh(os.Stdout)
+		case "fish":
+			t.fish(os.Stdout)
+		case "powershell":
+			t.powershell(os.Stdout)
+		default:
+			fmt.Fprintf(os.Stderr, "Unsupported shell [%s] - use one of %s\n", args[0], strings.Join(shells, ", "))
+			os.Exit(1)
+		}
+	}
+}
+
+func (t *completionTree) bash(w io.Writer) {
+	fmt.Fprintf(w, "# bash completion for %s. Load with: source <(%s completion bash)\n", prog, prog)
+	fmt.Fprintf(w, "_%s() {\n\tlocal -A commands=(\n", prog)
+	for _, path := range t.paths {
+		fmt.Fprintf(w, "\t\t[\"%s\"]=\"%s\"\n", path, strings.Join(t.words[path], " "))
+	}
+	fmt.Fprintf(w, "\t)\n\tlocal -A choices=(\n")
+	for _, k := range t.choiceKeys() {
+		fmt.Fprintf(w, "\t\t[\"%s\"]=\"%s\"\n", k, strings.Join(t.choices[k], " "))
+	}
+	fmt.Fprintf(w, `	)
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" cmd=%s depth=1 i
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		if ((i == depth)) && [[ -n "${commands["$cmd ${COMP_WORDS[i]}"]+x}" ]]; then
+			cmd="$cmd ${COMP_WORDS[i]}"
+			((depth++))
+		fi
+	done
+	if [[ -n "${choices["$cmd:$prev"]+x}" ]]; then
+		COMPREPLY=($(compgen -W "${choices["$cmd:$prev"]}" -- "$cur"))
+		return
+	fi
+	COMPREPLY=($(compgen -W "${commands[$cmd]}" -- "$cur"))
+}
+complete -o default -F _%s %s
+`, prog, prog, prog)
+}
+
+func (t *completionTree) zsh(w io.Writer) {
+	fmt.Fprintf(w, "#compdef %s\n# zsh completion for %s. Load with: source <(%s completion zsh)\n", prog, prog, prog)
+	fmt.Fprintf(w, "_%s() {\n\tlocal -A commands choices\n\tcommands=(\n", prog)
+	for _, path := range t.paths {
+		fmt.Fprintf(w, "\t\t'%s' '%s'\n", path, strings.Join(t.words[path], " "))
+	}
+	fmt.Fprintf(w, "\t)\n\tchoices=(\n")
+	for _, k := range t.choiceKeys() {
+		fmt.Fprintf(w, "\t\t'%s' '%s'\n", k, strings.Join(t.choices[k], " "))
+	}
+	fmt.Fprintf(w, `	)
+	local cmd=%s depth=2 i key prev=${words[CURRENT-1]}
+	for ((i = 2; i < CURRENT; i++)); do
+		key="$cmd ${words[i]}"
+		if ((i == depth)) && (( ${+commands[$key]} )); then
+			cmd=$key
+			((depth++))
+		fi
+	done
+	key="$cmd:$prev"
+	if (( ${+choices[$key]} )); then
+		if [[ -n ${choices[$key]} ]]; then
+			compadd -- ${=choices[$key]}
+		else
+			_files
+		fi
+		return
+	fi
+	compadd -- ${=commands[$cmd]}
+	_files
+}
+compdef _%s %s
+`, prog, prog, prog)
+}
+
+func (t *completionTree) fish(w io.Writer) {
+	fmt.Fprintf(w, "# fish completion for %s. Load with: %s completion fish | source\n", prog, prog)
+	fmt.Fprintf(w, "set -g __%s_commands", prog)
+	for _, path := range t.paths {
+		fmt.Fprintf(w, " '%s'", path)
+	}
+	fmt.Fprintf(w, `
+function __%s_cmd
+	set -l words (commandline -opc)
+	set -l cmd %s
+	set -l depth 2
+	for i in (seq 2 (count $words))
+		if test $i -eq $depth; and contains -- "$cmd $words[$i]" $__%s_commands
+			set cmd "$cmd $words[$i]"
+			set depth (math $depth + 1)
+		end
+	end
+	echo $cmd
+end
+`, prog, prog, prog)
+	quote := func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+	}
+	for _, path := range t.paths {
+		cond := fmt.Sprintf(`-n "test (__%s_cmd) = '%s'"`, prog, path)
+		var subs []string
+		for _, word := range t.words[path] {
+			if !strings.HasPrefix(word, "-") {
+				subs = append(subs, word)
+				continue
+			}
+			k := path + ":" + word
+			opts := ""
+			if values, ok := t.choices[k]; ok {
+				opts = " -r"
+				if len(values) > 0 {
+					opts = " -x -a " + quote(strings.Join(values, " "))
+				}
+			}
+			fmt.Fprintf(w, "complete -c %s %s -o %s%s -d %s\n", prog, cond, word[1:], opts, quote(t.usage[k]))
+		}
+		if len(subs) > 0 {
+			fmt.Fprintf(w, "complete -c %s %s -a %s\n", prog, cond, quote(strings.Join(subs, " ")))
+		}
+	}
+}
+
+func (t *completionTree) powershell(w io.Writer) {
+	list := func(values []string) string {
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+		}
+		return "@(" + strings.Join(quoted, ", ") + ")"
+	}
+	fmt.Fprintf(w, "# PowerShell completion for %s. Load with: %s completion powershell | Out-String | Invoke-Expression\n", prog, prog)
+	fmt.Fprintf(w, "Register-ArgumentCompleter -Native -CommandName %s -ScriptBlock {\n", prog)
+	fmt.Fprintf(w, "\tparam($wordToComplete, $commandAst, $cursorPosition)\n\t$commands = @{\n")
+	for _, path := range t.paths {
+		fmt.Fprintf(w, "\t\t'%s' = %s\n", path, list(t.words[path]))
+	}
+	fmt.Fprintf(w, "\t}\n\t$choices = @{\n")
+	for _, k := range t.choiceKeys() {
+		fmt.Fprintf(w, "\t\t'%s' = %s\n", k, list(t.choices[k]))
+	}
+	fmt.Fprintf(w, `	}
+	$words = @($commandAst.CommandElements | Where-Object { $_.Extent.EndOffset -lt $cursorPosition } | ForEach-Object { $_.ToString() })
+	$cmd = '%s'
+	for ($i = 1; $i -lt $words.Count; $i++) {
+		if ($i -eq $cmd.Split(' ').Count -and $commands.ContainsKey("$cmd $($words[$i])")) {
+			$cmd = "$cmd $($words[$i])"
+		}
+	}
+	$candidates = $commands[$cmd]
+	$key = "${cmd}:$($words[-1])"
+	if ($choices.ContainsKey($key)) {
+		$candidates = $choices[$key]
+	}
+	$candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+		[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+	}
+}
+`, prog)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/demisto/infinigo"
+)
+
+// cortexJob is the input of a Cortex analyzer
+type cortexJob struct {
+	Data     string `json:"data"`
+	DataType string `json:"dataType"`
+	File     string `json:"file"`
+	Filename string `json:"filename"`
+	TLP      int    `json:"tlp"`
+	Config   struct {
+		Key    string `json:"key"`
+		URL    string `json:"url"`
+		Upload bool   `json:"upload"`
+		MaxTLP *int   `json:"max_tlp"`
+	} `json:"config"`
+}
+
+// cortexTaxonomy is the short summary TheHive shows on an observable
+type cortexTaxonomy struct {
+	Level     string `json:"level"`
+	Namespace string `json:"namespace"`
+	Predicate string `json:"predicate"`
+	Value     string `json:"value"`
+}
+
+// cortexReport is the output of a Cortex analyzer
+type cortexReport struct {
+	Success      bool           `json:"success"`
+	ErrorMessage string         `json:"errorMessage,omitempty"`
+	Input        *cortexJob     `json:"input,omitempty"`
+	Summary      *cortexSummary `json:"summary,omitempty"`
+	Full         *cortexFull    `json:"full,omitempty"`
+	Artifacts    []interface{}  `json:"artifacts"`
+}
+
+type cortexSummary struct {
+	Taxonomies []cortexTaxonomy `json:"taxonomies"`
+}
+
+// cortexFull is the full report of a hash
+type cortexFull struct {
+	Hash     string                 `json:"hash"`
+	Filename string                 `json:"filename,omitempty"`
+	Verdict  infinigo.Verdict       `json:"verdict"`
+	Score    float32                `json:"score"`
+	Uploaded bool                   `json:"uploaded"`
+	Response infinigo.QueryResponse `json:"response"`
+}
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// cortexLevels maps verdicts to taxonomy levels
+var cortexLevels = map[infinigo.Verdict]string{
+	infinigo.VerdictMalicious:  "malicious",
+	infinigo.VerdictSuspicious: "suspicious",
+	infinigo.VerdictBenign:     "safe",
+}
+
+// cortex runs as a Cortex analyzer of hash and file observables, so infinigo can be added to TheHive as is.
+// The job is read from stdin and the report printed to stdout, or with a job directory as the argument,
+// read from input/input.json and written to output/output.json as Cortex 3 does.
+// The key, url and upload settings of the analyzer configuration take precedence over the flags.
+func cortex(fs *flag.FlagSet) func(args []string) {
+	clientFlags(fs)
+	return func(args []string) {
+		var in io.Reader = os.Stdin
+		out, dir := io.Writer(os.Stdout), ""
+		if len(args) > 0 {
+			dir = args[0]
+			f, err := os.Open(filepath.Join(dir, "input", "input.json"))
+			check(err)
+			defer f.Close()
+			in = f
+			check(os.MkdirAll(filepath.Join(dir, "output"), 0755))
+			o, err := os.Create(filepath.Join(dir, "output", "output.json"))
+			check(err)
+			defer o.Close()
+			out = o
+		}
+		var job cortexJob
+		var full cortexFull
+		report := cortexReport{Artifacts: []interface{}{}}
+		if err := json.NewDecoder(in).Decode(&job); err != nil {
+			report.ErrorMessage = fmt.Sprintf("Bad job - %v", err)
+		} else {
+			full, report.ErrorMessage = analyze(&job, dir)
+		}
+		if report.ErrorMessage != "" {
+			// The key must not end up in the report TheHive stores
+			job.Config.Key = ""
+			report.Input = &job
+		} else {
+			level := cortexLevels[full.Verdict]
+			if level == "" {
+				level = "info"
+			}
+			report.Success, report.Full = true, &full
+			report.Summary = &cortexSummary{Taxonomies: []cortexTaxonomy{{Level: level, Namespace: "Infinity", Predicate: "Score", Value: fmt.Sprint(full.Score)}}}
+		}
+		check(json.NewEncoder(out).Encode(report))
+		if !report.Success {
+			os.Exit(1)
+		}
+	}
+}
+
+// analyze queries the hash of the job, or of its file uploading it if requested, and returns the full report or an error message
+func analyze(job *cortexJob, dir string) (cortexFull, string) {
+	if job.Config.MaxTLP != nil && job.TLP > *job.Config.MaxTLP {
+		return cortexFull{}, "TLP is higher than allowed"
+	}
+	if job.Config.Key != "" {
+		key = job.Config.Key
+	}
+	if job.Config.URL != "" {
+		url = job.Config.URL
+	}
+	full := cortexFull{Filename: job.Filename}
+	path := ""
+	switch job.DataType {
+	case "hash":
+		full.Hash = strings.TrimSpace(job.Data)
+	case "file":
+		path = job.File
+		if dir != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(dir, "input", path)
+		}
+		fh := hashAll(path)
+		if fh.Err != "" {
+			return full, fmt.Sprintf("Failed hashing the file - %s", fh.Err)
+		}
+		full.Hash = fh.SHA256
+	default:
+		return full, fmt.Sprintf("Unsupported data type [%s], expected hash or file", job.DataType)
+	}
+	if !sha256Hex.MatchString(full.Hash) {
+		return full, "Infinity only knows SHA-256 hashes"
+	}
+	if key == "" {
+		return full, "The Infinity key is required in the key setting of the analyzer"
+	}
+	inf := newClient()
+	res, err := inf.QueryAll("", full.Hash)
+	if err != nil {
+		return full, err.Error()
+	}
+	for _, r := range res {
+		full.Response = r
+	}
+	full.Verdict, full.Score = full.Response.Verdict(), full.Response.GeneralScore
+	if path != "" && job.Config.Upload && full.Response.ConfirmCode != "" {
+		if _, err = inf.UploadFile(full.Response.ConfirmCode, path); err != nil {
+			return full, fmt.Sprintf("Failed uploading the file - %v", err)
+		}
+		full.Uploaded = true
+	}
+	return full, ""
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/store"
+)
+
+// dbFlags registers the flags needed by commands that only use the local database
+func dbFlags(fs *flag.FlagSet) {
+	fs.StringVar(&db, "db", defaultDB(), "The local results database, a JSON file, SQLite if it ends in .db, .sqlite or .sqlite3, or a postgres:// URL. Can be provided as an environment variable INFINITY_DB.")
+	fs.BoolVar(&jsonFormat, "json", false, "Should we print replies as JSON or formatted")
+}
+
+// mustOpenDB opens the local database and fails if it is disabled
+func mustOpenDB() store.Store {
+	s := openDB()
+	if s == nil {
+		fmt.Fprintf(os.Stderr, "No local database specified\n")
+		os.Exit(1)
+	}
+	return s
+}
+
+// saveDB writes the changes to the local database if it is not written immediately, as the JSON file
+func saveDB(s store.Store) error {
+	if f, ok := s.(*store.File); ok {
+		return f.Save()
+	}
+	return nil
+}
+
+// stats summarizes the local database
+type stats struct {
+	Path        string                   `json:"path"`
+	Total       int                      `json:"total"`
+	Verdicts    map[infinigo.Verdict]int `json:"verdicts"`
+	OldestCheck time.Time                `json:"oldest_check"`
+	NewestCheck time.Time                `json:"newest_check"`
+}
+
+// dbStats summarizes the local database
+func dbStats(fs *flag.FlagSet) func(args []string) {
+	dbFlags(fs)
+	return func(args []string) {
+		s := mustOpenDB()
+		st := stats{Path: s.Path(), Verdicts: make(map[infinigo.Verdict]int)}
+		records, err := s.ListSince(context.Background(), time.Time{})
+		check(err)
+		for _, r := range records {
+			st.Total++
+			st.Verdicts[r.Response.Verdict()]++
+			if st.OldestCheck.IsZero() || r.LastChecked.Before(st.OldestCheck) {
+				st.OldestCheck = r.LastChecked
+			}
+			if r.LastChecked.After(st.NewestCheck) {
+				st.NewestCheck = r.LastChecked
+			}
+		}
+		if jsonFormat {
+			printJSON(st)
+			return
+		}
+		fmt.Printf("Database:\t%s\nRecords:\t%d\n", st.Path, st.Total)
+		for _, verdict := range []infinigo.Verdict{infinigo.VerdictMalicious, infinigo.VerdictSuspicious, infinigo.VerdictBenign, infinigo.VerdictUnknown, infinigo.VerdictError} {
+			fmt.Printf("%s:\t%d\n", verdict, st.Verdicts[verdict])
+		}
+		if st.Total > 0 {
+			fmt.Printf("Oldest check:\t%v\nNewest check:\t%v\n", st.OldestCheck, st.NewestCheck)
+		}
+	}
+}
+
+// dbExport dumps the local database as CSV or JSON
+func dbExport(fs *flag.FlagSet) func(args []string) {
+	dbFlags(fs)
+	format := newChoiceFlag("json", "csv")
+	fs.Var(format, "format", "The export format - "+format.choices())
+	out := fs.String("o", "", "The file to export to. Defaults to stdout.")
+	tag := fs.String("tag", "", "Only export records with the given tag")
+	return func(args []string) {
+		s := mustOpenDB()
+		var w io.Writer = os.Stdout
+		if *out != "" {
+			f, err := os.Create(*out)
+			check(err)
+			defer f.Close()
+			w = f
+		}
+		records, err := s.ListTagged(context.Background(), *tag)
+		check(err)
+		switch format.String() {
+		case "json":
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "\t")
+			check(enc.Encode(records))
+		case "csv":
+			check(exportCSV(w, records))
+		}
+	}
+}
+
+// dbPurge removes records that were not checked for a while
+func dbPurge(fs *flag.FlagSet) func(args []string) {
+	dbFlags(fs)
+	var olderThan durationFlag
+	fs.Var(&olderThan, "older-than", "Remove records not checked within this duration, e.g. 90d")
+	includeTagged := fs.Bool("include-tagged", false, "Also remove tagged records")
+	return func(args []string) {
+		if olderThan <= 0 {
+			fmt.Fprintf(os.Stderr, "Please specify -older-than\n")
+			os.Exit(1)
+		}
+		s := mustOpenDB()
+		removed, err := s.PurgeBefore(context.Background(), time.Now().Add(-time.Duration(olderThan)), *includeTagged)
+		check(err)
+		check(saveDB(s))
+		fmt.Fprintf(os.Stderr, "Purged %d records\n", removed)
+	}
+}
+
+// csvHeader is the header row of the CSV export
+var csvHeader = []string{"hash", "path", "verdict", "score", "status", "statuscode", "error", "confirmcode", "classifiers", "first_seen", "last_checked", "changed", "tags", "notes"}
+
+// exportCSV writes the records as CSV with classifiers flattened into name=score pairs
+func exportCSV(w io.Writer, records []store.Record) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		classifiers := make([]string, 0, len(r.Response.Classifiers))
+		for k, v := range r.Response.Classifiers {
+			classifiers = append(classifiers, k+"="+strconv.FormatFloat(float64(v), 'f', -1, 32))
+		}
+		sort.Strings(classifiers)
+		notes := make([]string, len(r.Notes))
+		for i, n := range r.Notes {
+			notes[i] = n.Text
+		}
+		err := cw.Write([]string{
+			r.Hash,
+			r.Path,
+			string(r.Response.Verdict()),
+			strconv.FormatFloat(float64(r.Response.GeneralScore), 'f', -1, 32),
+			r.Response.Status,
+			strconv.FormatFloat(float64(r.Response.StatusCode), 'f', -1, 32),
+			r.Response.Error,
+			r.Response.ConfirmCode,
+			strings.Join(classifiers, ";"),
+			r.FirstSeen.Format(time.RFC3339),
+			r.LastChecked.Format(time.RFC3339),
+			r.Changed.Format(time.RFC3339),
+			strings.Join(r.Tags, ";"),
+			strings.Join(notes, ";"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/demisto/infinigo/digest"
+)
+
+// digestCmd emails a digest of the new malicious findings, verdict changes and quota usage of a period.
+// It is meant to run daily or weekly from cron or a scheduled task.
+func digestCmd(fs *flag.FlagSet) func(args []string) {
+	dbFlags(fs)
+	period := newChoiceFlag("daily", "weekly")
+	fs.Var(period, "period", "The period of the digest, ending now: "+period.choices())
+	fs.StringVar(&auditPath, "audit-log", os.Getenv("INFINITY_AUDIT_LOG"), "The audit log to count the quota usage from. Can be provided as an environment variable INFINITY_AUDIT_LOG.")
+	quota := fs.Int64("daily-quota", 0, "The daily quota of the API key, to report usage as a percentage")
+	out := fs.String("o", "", "Write the HTML digest to this file instead of emailing it")
+	m := &digest.Mailer{}
+	var recipients stringsFlag
+	fs.StringVar(&m.Addr, "smtp", os.Getenv("SMTP_SERVER"), "The SMTP server as host:port. Can be provided as an environment variable SMTP_SERVER.")
+	fs.StringVar(&m.User, "smtp-user", os.Getenv("SMTP_USER"), "The SMTP user. Can be provided as an environment variable SMTP_USER.")
+	fs.StringVar(&m.Password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "The SMTP password. Can be provided as an environment variable SMTP_PASSWORD.")
+	fs.BoolVar(&m.TLS, "smtp-tls", false, "Connect with implicit TLS, as on port 465, instead of STARTTLS")
+	fs.StringVar(&m.From, "from", "", "The sender of the digest")
+	fs.Var(&recipients, "to", "A recipient of the digest. Can be repeated.")
+	return func(args []string) {
+		to := time.Now()
+		from := to.AddDate(0, 0, -1)
+		if period.String() == "weekly" {
+			from = to.AddDate(0, 0, -7)
+		}
+		records, err := mustOpenDB().ListChanged(context.Background(), from)
+		check(err)
+		r := digest.New(records, from, to)
+		r.DailyQuota = *quota
+		m.To = recipients
+		if auditPath != "" {
+			f, err := os.Open(auditPath)
+			check(err)
+			err = r.AddUsage(f)
+			f.Close()
+			check(err)
+		}
+		if *out != "" {
+			f, err := os.Create(*out)
+			check(err)
+			check(r.HTML(f))
+			check(f.Close())
+			return
+		}
+		check(m.Send(r))
+		fmt.Fprintf(os.Stderr, "Sent %s\n", r.Subject())
+	}
+}
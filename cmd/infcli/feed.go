@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/feed"
+)
+
+// feedExport writes the malicious hashes of the local database as a feed EDR products import
+func feedExport(fs *flag.FlagSet) func(args []string) {
+	dbFlags(fs)
+	formats := make([]string, 0, len(feed.Formats))
+	for name := range feed.Formats {
+		formats = append(formats, name)
+	}
+	sort.Strings(formats)
+	format := fs.String("format", "plain", "The feed format: "+strings.Join(formats, "|"))
+	out := fs.String("o", "", "The feed file, replaced atomically. Defaults to stdout.")
+	maxScore := fs.Float64("max-score", infinigo.MaliciousThreshold, "Only export hashes scored at or below this, e.g. -0.8 for confident findings")
+	window := durationFlag(90 * 24 * time.Hour)
+	fs.Var(&window, "window", "Only export hashes checked within this duration, and expire the indicators after it, e.g. 30d")
+	limit := fs.Int("limit", 0, "Only export this many of the most recently checked hashes, for products capping custom indicators. 0 for no limit.")
+	return func(args []string) {
+		write, ok := feed.Formats[*format]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown feed format [%s], expected %s\n", *format, strings.Join(formats, "|"))
+			os.Exit(1)
+		}
+		if window <= 0 {
+			fmt.Fprintf(os.Stderr, "Please specify a positive -window\n")
+			os.Exit(1)
+		}
+		now := time.Now()
+		records, err := mustOpenDB().ListSince(context.Background(), now.Add(-time.Duration(window)))
+		check(err)
+		var entries []feed.Entry
+		for _, r := range records {
+			if r.Response.Verdict() != infinigo.VerdictMalicious || float64(r.Response.GeneralScore) > *maxScore {
+				continue
+			}
+			entries = append(entries, feed.Entry{Hash: r.Hash, Path: r.Path, Score: r.Response.GeneralScore, FirstSeen: r.FirstSeen,
+				LastChecked: r.LastChecked, Expires: r.LastChecked.Add(time.Duration(window))})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			if !entries[i].LastChecked.Equal(entries[j].LastChecked) {
+				return entries[i].LastChecked.After(entries[j].LastChecked)
+			}
+			return entries[i].Hash < entries[j].Hash
+		})
+		if *limit > 0 && len(entries) > *limit {
+			entries = entries[:*limit]
+		}
+		if *out == "" {
+			check(write(os.Stdout, entries))
+			return
+		}
+		check(feed.WriteFile(*out, write, entries))
+		fmt.Fprintf(os.Stderr, "Exported %d hashes to %s\n", len(entries), *out)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// defaultUserAgent is sent when fetching URLs, so they are served as to a browser and not told apart from a victim
+const defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// fetchEntry is the result of fetching and scanning a single URL
+type fetchEntry struct {
+	URL         string `json:"url"`
+	FinalURL    string `json:"final_url,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	scanEntry
+}
+
+// fetchOptions control how URLs are downloaded
+type fetchOptions struct {
+	dir       string   // dir the files are downloaded to
+	maxSize   int64    // maxSize skips responses larger than this many bytes, 0 for no limit
+	types     []string // types are the prefixes of the accepted content types, any if empty
+	userAgent string
+}
+
+// fetch downloads the files at the given URLs, queries them and optionally uploads unknown ones
+func fetch(fs *flag.FlagSet) func(args []string) {
+	clientFlags(fs)
+	cacheFlags(fs)
+	classifierFlags(fs)
+	sinkFlags(fs)
+	formatFlags(fs)
+	in := fs.String("i", "", "A file with URLs to fetch, one per line. Defanged URLs such as hxxp://example[.]com are accepted. - for standard input.")
+	upload := fs.Bool("upload", false, "Upload files Infinity requests a confirmation for")
+	opts := fetchOptions{maxSize: 100 << 20}
+	fs.Var((*byteSizeFlag)(&opts.maxSize), "max-file-size", "Skip responses larger than this size, e.g. 10M. 0 for no limit.")
+	var types stringsFlag
+	fs.Var(&types, "content-type", "Only fetch responses with this content type or type prefix, e.g. application/ to skip web pages. Can be repeated. Defaults to any type.")
+	fs.StringVar(&opts.userAgent, "user-agent", defaultUserAgent, "The User-Agent header sent with the requests")
+	timeout := durationFlag(time.Minute)
+	fs.Var(&timeout, "timeout", "How long a single URL may take to download")
+	allowPrivate := fs.Bool("allow-private", false, "Also fetch URLs on loopback, private and link local addresses, which are refused so harvested URLs cannot reach internal services")
+	fs.StringVar(&opts.dir, "download-dir", "", "Keep the downloaded files in this directory. Defaults to a temporary directory removed once done.")
+	return func(args []string) {
+		urls := args
+		if *in != "" {
+			r := os.Stdin
+			if *in != "-" {
+				f, err := os.Open(*in)
+				check(err)
+				defer f.Close()
+				r = f
+			}
+			scanner := bufio.NewScanner(r)
+			for scanner.Scan() {
+				if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+					urls = append(urls, line)
+				}
+			}
+			check(scanner.Err())
+		}
+		if len(urls) == 0 {
+			fmt.Fprintf(os.Stderr, "Please specify the URLs to fetch\n")
+			os.Exit(1)
+		}
+		for _, t := range types {
+			opts.types = append(opts.types, strings.ToLower(t))
+		}
+		keep := opts.dir != ""
+		if keep {
+			check(os.MkdirAll(opts.dir, 0700))
+		} else {
+			dir, err := os.MkdirTemp("", "infinigo-fetch")
+			check(err)
+			opts.dir = dir
+		}
+		c := fetchClient(time.Duration(timeout), *allowPrivate)
+		entries := make([]fetchEntry, 0, len(urls))
+		seen := make(map[string]bool, len(urls))
+		for _, u := range urls {
+			u = refang(u)
+			if seen[u] {
+				continue
+			}
+			seen[u] = true
+			e, err := fetchURL(c, u, opts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipped %s: %v\n", u, err)
+				continue
+			}
+			infof("Fetched %s (%d bytes, %s)", u, e.Size, e.ContentType)
+			entries = append(entries, e)
+		}
+		infof("Fetched %d of %d URLs", len(entries), len(seen))
+		inf := newClient()
+		if len(entries) > 0 {
+			hashes := make([]string, 0, len(entries))
+			urlsByHash := make(map[string]string, len(entries))
+			for _, e := range entries {
+				if _, ok := urlsByHash[e.Hash]; !ok {
+					hashes = append(hashes, e.Hash)
+					urlsByHash[e.Hash] = e.URL
+				}
+			}
+			s := openDB()
+			res, err := queryCached(inf, s, hashes, urlsByHash)
+			enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddQuery("", hashes...) })
+			if s != nil {
+				check(saveDB(s))
+			}
+			notify(res, urlsByHash)
+			closeSinks()
+			for i := range entries {
+				entries[i].Response = res[entries[i].Hash]
+			}
+		}
+		queued := false
+		if *upload {
+			uploaded := make(map[string]bool, len(entries))
+			for _, e := range entries {
+				if e.Response.ConfirmCode == "" || uploaded[e.Hash] {
+					continue
+				}
+				uploaded[e.Hash] = true
+				infof("Uploading %s (%d bytes)", e.URL, e.Size)
+				_, err := inf.UploadFile(e.Response.ConfirmCode, e.Path)
+				if err != nil && infinigo.Unreachable(err) {
+					queued = true
+				}
+				enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddUpload(e.Response.ConfirmCode, e.Path) })
+				if err == nil {
+					fmt.Fprintf(os.Stderr, "Uploaded %s\n", e.URL)
+				}
+			}
+		}
+		if !keep {
+			if queued {
+				fmt.Fprintf(os.Stderr, "Keeping the downloaded files in %s for the queued uploads\n", opts.dir)
+			} else {
+				os.RemoveAll(opts.dir)
+			}
+		}
+		matched := entries[:0]
+		for _, e := range entries {
+			if matchClassifiers(e.Response) {
+				matched = append(matched, e)
+			}
+		}
+		entries = matched
+		results := make([]pipeline.Result, len(entries))
+		for i, e := range entries {
+			results[i] = pipeline.Result{Submission: pipeline.Submission{Hash: e.Hash, Path: e.URL}, Response: e.Response}
+		}
+		if printFormatted(results) {
+			return
+		}
+		if jsonFormat {
+			printJSON(entries)
+			return
+		}
+		for _, e := range entries {
+			fmt.Printf("%s\t%s\t%s\t%v\n", e.URL, e.Hash, e.Response.Verdict(), e.Response.GeneralScore)
+		}
+	}
+}
+
+// refang restores the URLs defanged in reports, e.g. hxxps://example[.]com
+func refang(u string) string {
+	u = strings.NewReplacer("[.]", ".", "(.)", ".", "[:]", ":", "[://]", "://").Replace(u)
+	for _, scheme := range []string{"hxxp", "hXXp", "HXXP"} {
+		if strings.HasPrefix(u, scheme) {
+			u = "http" + u[len(scheme):]
+		}
+	}
+	return u
+}
+
+// fetchClient returns the client downloading URLs, without cookies and refusing to connect to addresses
+// that are not public unless allowPrivate
+func fetchClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = publicOnly
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			// Do not tell the next site where the request came from
+			req.Header.Del("Referer")
+			return nil
+		},
+	}
+}
+
+// publicOnly refuses connections to loopback, private, link local and other addresses that are not public
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("refusing to connect to %s, see -allow-private", host)
+	}
+	return nil
+}
+
+// fetchURL downloads the URL to the download directory and hashes it
+func fetchURL(c *http.Client, rawURL string, opts fetchOptions) (fetchEntry, error) {
+	e := fetchEntry{URL: rawURL}
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return e, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return e, fmt.Errorf("unsupported scheme [%s]", u.Scheme)
+	}
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return e, err
+	}
+	req.Header.Set("User-Agent", opts.userAgent)
+	req.Header.Set("Accept", "*/*")
+	resp, err := c.Do(req)
+	if err != nil {
+		return e, err
+	}
+	defer resp.Body.Close()
+	e.FinalURL = resp.Request.URL.String()
+	if e.FinalURL == rawURL {
+		e.FinalURL = ""
+	}
+	if resp.StatusCode != http.StatusOK {
+		return e, fmt.Errorf("status %s", resp.Status)
+	}
+	e.ContentType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if len(opts.types) > 0 {
+		accepted := false
+		for _, t := range opts.types {
+			accepted = accepted || strings.HasPrefix(e.ContentType, t)
+		}
+		if !accepted {
+			return e, fmt.Errorf("content type [%s] not accepted", e.ContentType)
+		}
+	}
+	if opts.maxSize > 0 && resp.ContentLength > opts.maxSize {
+		return e, fmt.Errorf("larger than %d bytes", opts.maxSize)
+	}
+	name := path.Base(strings.ReplaceAll(resp.Request.URL.Path, "\\", "/"))
+	if name == "/" || name == "." {
+		name = "index"
+	}
+	f, err := os.CreateTemp(opts.dir, "fetch-*-"+name)
+	if err != nil {
+		return e, err
+	}
+	h := sha256.New()
+	var body io.Reader = resp.Body
+	if opts.maxSize > 0 {
+		body = io.LimitReader(resp.Body, opts.maxSize+1)
+	}
+	e.Size, err = io.Copy(io.MultiWriter(f, h), body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && opts.maxSize > 0 && e.Size > opts.maxSize {
+		err = fmt.Errorf("larger than %d bytes", opts.maxSize)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return e, err
+	}
+	e.Path, e.Hash = f.Name(), hex.EncodeToString(h.Sum(nil))
+	return e, nil
+}
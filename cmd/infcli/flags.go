@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stringsFlag is a flag that can be repeated to collect several values
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(val string) error {
+	*s = append(*s, val)
+	return nil
+}
+
+// durationFlag is a time.Duration flag that also accepts days, e.g. 90d
+type durationFlag time.Duration
+
+func (d *durationFlag) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *durationFlag) Set(val string) error {
+	if days, ok := strings.CutSuffix(val, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return err
+		}
+		*d = durationFlag(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	dur, err := time.ParseDuration(val)
+	if err != nil {
+		return err
+	}
+	*d = durationFlag(dur)
+	return nil
+}
+
+// choiceFlag is a string flag limited to a set of values. The first value is the default.
+type choiceFlag struct {
+	value   string
+	allowed []string
+}
+
+func newChoiceFlag(allowed ...string) *choiceFlag {
+	return &choiceFlag{value: allowed[0], allowed: allowed}
+}
+
+func (c *choiceFlag) String() string {
+	if c == nil {
+		return ""
+	}
+	return c.value
+}
+
+func (c *choiceFlag) Set(val string) error {
+	for _, a := range c.allowed {
+		if a == val {
+			c.value = val
+			return nil
+		}
+	}
+	return fmt.Errorf("must be one of %s", c.choices())
+}
+
+// choices returns the allowed values for usage messages
+func (c *choiceFlag) choices() string {
+	return strings.Join(c.allowed, "|")
+}
+
+// byteSizeFlag is a size in bytes that accepts K, M and G suffixes, e.g. 512K
+type byteSizeFlag int64
+
+func (b *byteSizeFlag) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSizeFlag) Set(val string) error {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(strings.ToUpper(val), "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(strings.ToUpper(val), "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(strings.ToUpper(val), "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		val = val[:len(val)-1]
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return err
+	}
+	*b = byteSizeFlag(n * mult)
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/demisto/infinigo"
+)
+
+// defaultQueue returns the location of the offline queue
+func defaultQueue() string {
+	if env := os.Getenv("INFINITY_QUEUE"); env != "" {
+		return env
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".infinigo", "queue.json")
+}
+
+// openQueue opens the offline queue or returns nil if it is disabled
+func openQueue() *infinigo.Queue {
+	if queuePath == "" {
+		return nil
+	}
+	queue, err := infinigo.OpenQueue(queuePath)
+	check(err)
+	return queue
+}
+
+// flushQueue sends the queued requests, recording query results in the local database
+func flushQueue(inf *infinigo.Client, queue *infinigo.Queue) (int, error) {
+	s := openDB()
+	sent, err := queue.Flush(inf, func(res map[string]infinigo.QueryResponse) {
+		if s != nil {
+			for k, v := range res {
+				_, _, err := s.SaveResult(context.Background(), k, "", v)
+				check(err)
+			}
+		}
+		if jsonFormat {
+			printJSON(res)
+			return
+		}
+		for k, v := range res {
+			fmt.Printf("%s\t%s\t%v\n", k, v.Verdict(), v.GeneralScore)
+		}
+	})
+	if s != nil && sent > 0 {
+		check(saveDB(s))
+	}
+	return sent, err
+}
+
+// autoFlush sends whatever was queued on previous runs. Failures are reported but not fatal, as the
+// requests stay in the queue unless they were dropped.
+func autoFlush(inf *infinigo.Client) {
+	queue := openQueue()
+	if queue == nil || queue.Len() == 0 {
+		return
+	}
+	sent, err := flushQueue(inf, queue)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Flushed %d queued requests, %d still queued - %v\n", sent, queue.Len(), err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Flushed %d queued requests\n", sent)
+}
+
+// enqueueOnError queues the request if the API was unreachable, otherwise fails on the error
+func enqueueOnError(err error, add func(queue *infinigo.Queue) error) {
+	if err == nil {
+		return
+	}
+	queue := openQueue()
+	if queue == nil || !infinigo.Unreachable(err) {
+		check(err)
+	}
+	check(add(queue))
+	fmt.Fprintf(os.Stderr, "Infinity is unreachable (%v) - request queued, run flush to send it\n", err)
+}
+
+// flush sends the requests queued while the API was unreachable
+func flush(fs *flag.FlagSet) func(args []string) {
+	clientFlags(fs)
+	list := fs.Bool("l", false, "Only list the queued requests")
+	return func(args []string) {
+		queue := openQueue()
+		if queue == nil {
+			fmt.Fprintf(os.Stderr, "No queue specified\n")
+			os.Exit(1)
+		}
+		if *list {
+			if jsonFormat {
+				printJSON(queue.Items())
+				return
+			}
+			for _, item := range queue.Items() {
+				fmt.Printf("%v\t%s\t%v%s\n", item.Added, item.Kind, item.Hashes, item.Path)
+			}
+			return
+		}
+		sent, err := flushQueue(newClient(), queue)
+		fmt.Fprintf(os.Stderr, "Flushed %d queued requests, %d still queued\n", sent, queue.Len())
+		check(err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo/openioc"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/siem"
+	"github.com/demisto/infinigo/stix"
+	"github.com/demisto/infinigo/xsoar"
+)
+
+var (
+	outputFormat     = newChoiceFlag("text", "json", "stix", "cef", "leef", "xsoar", "openioc", "osquery")
+	xsoarReliability = newChoiceFlag("B", "A+", "A", "C", "D", "E", "F")
+)
+
+// formatFlags registers the flag selecting the output format of results
+func formatFlags(fs *flag.FlagSet) {
+	fs.Var(outputFormat, "format", "The output format of the results: "+outputFormat.choices()+". stix prints a STIX 2.1 bundle, cef an ArcSight CEF record per hash and leef a QRadar LEEF record per hash, xsoar the DBotScore and File context of XSOAR, openioc an OpenIOC document of the malicious hashes and osquery rows with the columns of the osquery hash and file tables to JOIN on.")
+	fs.Var(xsoarReliability, "xsoar-reliability", "The source reliability of the xsoar format: "+xsoarReliability.choices())
+}
+
+// printFormatted prints the results in the selected format and returns true, or returns false if the
+// command prints them itself as text or JSON
+func printFormatted(results []pipeline.Result) bool {
+	switch outputFormat.String() {
+	case "json":
+		jsonFormat = true
+	case "stix":
+		printJSON(stix.NewBundle(results, time.Now()))
+		return true
+	case "cef", "leef":
+		format := siem.CEF
+		if outputFormat.String() == "leef" {
+			format = siem.LEEF
+		}
+		now := time.Now()
+		for _, r := range results {
+			fmt.Println(format(r, now))
+		}
+		return true
+	case "xsoar":
+		reliability := ""
+		for _, rel := range xsoar.Reliabilities {
+			if strings.HasPrefix(rel, xsoarReliability.String()+" ") {
+				reliability = rel
+			}
+		}
+		printJSON(xsoar.NewContext(results, reliability))
+		return true
+	case "openioc":
+		check(openioc.New(results, time.Now()).Write(os.Stdout))
+		return true
+	case "osquery":
+		printJSON(osqueryRows(results))
+		return true
+	}
+	return false
+}
+
+// osqueryRows returns the results as rows of string values like osquery prints them, with the hash in the
+// md5, sha1 or sha256 column of the hash table by its length and the path of the file table if known
+func osqueryRows(results []pipeline.Result) []map[string]string {
+	rows := make([]map[string]string, 0, len(results))
+	for _, r := range results {
+		column := "sha256"
+		switch len(r.Submission.Hash) {
+		case 32:
+			column = "md5"
+		case 40:
+			column = "sha1"
+		}
+		classifiers := []byte("{}")
+		if len(r.Response.Classifiers) > 0 {
+			classifiers, _ = json.Marshal(r.Response.Classifiers)
+		}
+		row := map[string]string{
+			column:         strings.ToLower(r.Submission.Hash),
+			"verdict":      string(r.Response.Verdict()),
+			"score":        strconv.FormatFloat(float64(r.Response.GeneralScore), 'f', -1, 32),
+			"classifiers":  string(classifiers),
+			"confirm_code": r.Response.ConfirmCode,
+			"error":        r.Response.Error,
+		}
+		if r.Submission.Path != "" {
+			row["path"] = r.Submission.Path
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/demisto/infinigo/hashing"
+)
+
+// fileHashes are the digests of a single file
+type fileHashes struct {
+	Path   string `json:"path"`
+	MD5    string `json:"md5"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+	Err    string `json:"error,omitempty"`
+}
+
+// newHasher creates a hasher of the given number of workers opening files as the scans do
+func newHasher(workers int, algorithms hashing.Algorithm) *hashing.Hasher {
+	h, err := hashing.New(hashing.SetWorkers(workers), hashing.SetAlgorithms(algorithms),
+		hashing.SetOpen(func(path string) (io.ReadCloser, error) { return openFile(path) }))
+	check(err)
+	return h
+}
+
+// sha256Hasher hashes the files of the scans, and allHasher single files with all the digests
+var (
+	sha256Hasher = sync.OnceValue(func() *hashing.Hasher { return newHasher(runtime.NumCPU(), hashing.SHA256) })
+	allHasher    = sync.OnceValue(func() *hashing.Hasher { return newHasher(runtime.NumCPU(), hashing.All) })
+)
+
+// toFileHashes converts the result of hashing a file
+func toFileHashes(r hashing.Result) fileHashes {
+	fh := fileHashes{Path: r.Path, MD5: r.MD5, SHA1: r.SHA1, SHA256: r.SHA256}
+	if r.Err != nil {
+		fh.Err = errorReason(r.Err)
+	}
+	return fh
+}
+
+// hashAll computes all the supported digests of the file in a single pass
+func hashAll(path string) fileHashes {
+	return toFileHashes(allHasher().File(context.Background(), path))
+}
+
+// hashPaths hashes all the regular files under the paths using the given number of workers
+func hashPaths(paths []string, workers int) []fileHashes {
+	files := make(chan string)
+	results := newHasher(workers, hashing.All).Stream(context.Background(), files)
+	var failed []fileHashes
+	go func() {
+		for _, root := range paths {
+			filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					failed = append(failed, fileHashes{Path: path, Err: err.Error()})
+					return nil
+				}
+				if info.Mode().IsRegular() {
+					files <- path
+				}
+				return nil
+			})
+		}
+		close(files)
+	}()
+	var all []fileHashes
+	for r := range results {
+		all = append(all, toFileHashes(r))
+	}
+	// The results are closed after the walk is done
+	all = append(all, failed...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Path < all[j].Path })
+	return all
+}
+
+// hashCmd computes file hashes without touching the API, printing them as a checksum manifest
+func hashCmd(fs *flag.FlagSet) func(args []string) {
+	fs.BoolVar(&jsonFormat, "json", false, "Should we print replies as JSON or formatted")
+	algo := newChoiceFlag("sha256", "sha1", "md5", "all")
+	fs.Var(algo, "a", "The hash algorithm - "+algo.choices()+". With all, the BSD tagged manifest format is used.")
+	workers := fs.Int("workers", runtime.NumCPU(), "The number of files to hash concurrently")
+	return func(args []string) {
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "Please specify the paths to hash\n")
+			os.Exit(1)
+		}
+		all := hashPaths(args, *workers)
+		failed := false
+		for _, fh := range all {
+			if fh.Err != "" {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", fh.Path, fh.Err)
+				failed = true
+			}
+		}
+		if jsonFormat {
+			printJSON(all)
+		} else {
+			for _, fh := range all {
+				if fh.Err != "" {
+					continue
+				}
+				switch algo.String() {
+				case "md5":
+					fmt.Printf("%s  %s\n", fh.MD5, fh.Path)
+				case "sha1":
+					fmt.Printf("%s  %s\n", fh.SHA1, fh.Path)
+				case "sha256":
+					fmt.Printf("%s  %s\n", fh.SHA256, fh.Path)
+				case "all":
+					fmt.Printf("MD5 (%s) = %s\nSHA1 (%s) = %s\nSHA256 (%s) = %s\n", fh.Path, fh.MD5, fh.Path, fh.SHA1, fh.Path, fh.SHA256)
+				}
+			}
+		}
+		if failed {
+			os.Exit(2)
+		}
+	}
+}
+
+// readHashes reads hashes from a file with one hash per line or a checksum manifest
+// in either the GNU (hash  path) or BSD (ALGO (path) = hash) format
+func readHashes(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readHashesFrom(f)
+}
+
+// readHashesFrom reads hashes as readHashes does from a reader
+func readHashesFrom(r io.Reader) ([]string, error) {
+	var hashes []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.LastIndex(line, ") = "); i >= 0 {
+			hashes = append(hashes, line[i+len(") = "):])
+			continue
+		}
+		hashes = append(hashes, strings.Fields(line)[0])
+	}
+	return hashes, scanner.Err()
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/oci"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// imageEntry is the result of scanning a single file of an image layer
+type imageEntry struct {
+	Layer    string                 `json:"layer"`
+	Path     string                 `json:"path"`
+	Hash     string                 `json:"hash"`
+	Size     int64                  `json:"size"`
+	Response infinigo.QueryResponse `json:"response"`
+	file     string                 // file the content was extracted to
+}
+
+// executableMagics are the starts of executable formats: ELF, PE, Mach-O, fat Mach-O and scripts
+var executableMagics = [][]byte{{0x7f, 'E', 'L', 'F'}, []byte("MZ"), {0xfe, 0xed, 0xfa, 0xce}, {0xfe, 0xed, 0xfa, 0xcf},
+	{0xce, 0xfa, 0xed, 0xfe}, {0xcf, 0xfa, 0xed, 0xfe}, {0xca, 0xfe, 0xba, 0xbe}, []byte("#!")}
+
+// isExecutable returns whether the file with the mode and first bytes is executable
+func isExecutable(mode fs.FileMode, head []byte) bool {
+	if mode&0111 != 0 {
+		return true
+	}
+	for _, magic := range executableMagics {
+		if bytes.HasPrefix(head, magic) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanImage pulls an image, extracts the executables of its layers, queries them and optionally uploads unknown ones
+func scanImage(fs *flag.FlagSet) func(args []string) {
+	clientFlags(fs)
+	cacheFlags(fs)
+	classifierFlags(fs)
+	sinkFlags(fs)
+	formatFlags(fs)
+	upload := fs.Bool("upload", false, "Upload files Infinity requests a confirmation for")
+	platform := fs.String("platform", oci.DefaultPlatform, "The platform of the image to scan in a multi platform image")
+	user := fs.String("registry-user", os.Getenv("REGISTRY_USER"), "The user name of the registry. Can be provided as an environment variable REGISTRY_USER.")
+	password := fs.String("registry-password", os.Getenv("REGISTRY_PASSWORD"), "The password or access token of the registry. Can be provided as an environment variable REGISTRY_PASSWORD.")
+	plainHTTP := fs.Bool("plain-http", false, "Talk to the registry over plain HTTP, e.g. a local registry")
+	all := fs.Bool("all-files", false, "Scan every regular file instead of only executables and scripts")
+	maxSize := int64(100 << 20)
+	fs.Var((*byteSizeFlag)(&maxSize), "max-file-size", "Skip files larger than this size, e.g. 100M. 0 for no limit.")
+	return func(args []string) {
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "Please specify the image to scan, e.g. alpine:3.19, or the tarball saved by docker save\n")
+			os.Exit(1)
+		}
+		ctx := context.Background()
+		var img oci.Image
+		if _, err := os.Stat(args[0]); err == nil {
+			img, err = oci.OpenArchive(args[0])
+			check(err)
+		} else {
+			ref, err := oci.ParseReference(args[0])
+			check(err)
+			registry, err := oci.New(oci.SetPlatform(*platform), oci.SetCredentials(*user, *password), oci.SetPlainHTTP(*plainHTTP))
+			check(err)
+			img = registry.Image(ref)
+			infof("Pulling %s", ref)
+		}
+		layers, err := img.Layers(ctx)
+		check(err)
+		dir, err := os.MkdirTemp("", "infinigo-image")
+		check(err)
+		var entries []imageEntry
+		for _, layer := range layers {
+			infof("Extracting layer %s (%d bytes)", layer.Digest, layer.Size)
+			r, err := img.OpenLayer(ctx, layer)
+			check(err)
+			err = oci.Walk(r, func(hdr *tar.Header, content io.Reader) error {
+				name := "/" + strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+				if strings.HasPrefix(path.Base(name), ".wh.") {
+					return nil
+				}
+				if maxSize > 0 && hdr.Size > maxSize {
+					if *all || hdr.Mode&0111 != 0 {
+						fmt.Fprintf(os.Stderr, "Skipped %s %s (%d bytes): larger than %d bytes\n", shortDigest(layer.Digest), name, hdr.Size, maxSize)
+					}
+					return nil
+				}
+				br := bufio.NewReader(content)
+				head, _ := br.Peek(4)
+				if !*all && !isExecutable(hdr.FileInfo().Mode(), head) {
+					return nil
+				}
+				e, err := extract(dir, br)
+				if err != nil {
+					return err
+				}
+				e.Layer, e.Path = layer.Digest, name
+				entries = append(entries, e)
+				return nil
+			})
+			r.Close()
+			check(err)
+		}
+		infof("Extracted %d files from %d layers", len(entries), len(layers))
+		inf := newClient()
+		if len(entries) > 0 {
+			var hashes []string
+			paths := make(map[string]string, len(entries))
+			for _, e := range entries {
+				if _, ok := paths[e.Hash]; !ok {
+					hashes = append(hashes, e.Hash)
+					paths[e.Hash] = e.Path
+				}
+			}
+			s := openDB()
+			res, err := queryCached(inf, s, hashes, paths)
+			enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddQuery("", hashes...) })
+			if s != nil {
+				check(saveDB(s))
+			}
+			notify(res, paths)
+			closeSinks()
+			for i := range entries {
+				entries[i].Response = res[entries[i].Hash]
+			}
+		}
+		queued := false
+		if *upload {
+			uploaded := make(map[string]bool, len(entries))
+			for _, e := range entries {
+				if e.Response.ConfirmCode == "" || uploaded[e.Hash] {
+					continue
+				}
+				uploaded[e.Hash] = true
+				infof("Uploading %s (%d bytes)", e.Path, e.Size)
+				_, err := inf.UploadFile(e.Response.ConfirmCode, e.file)
+				if err != nil && infinigo.Unreachable(err) {
+					queued = true
+				}
+				enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddUpload(e.Response.ConfirmCode, e.file) })
+				if err == nil {
+					fmt.Fprintf(os.Stderr, "Uploaded %s\n", e.Path)
+				}
+			}
+		}
+		if queued {
+			fmt.Fprintf(os.Stderr, "Keeping the extracted files in %s for the queued uploads\n", dir)
+		} else {
+			os.RemoveAll(dir)
+		}
+		matched := make([]imageEntry, 0, len(entries))
+		for _, e := range entries {
+			if matchClassifiers(e.Response) {
+				matched = append(matched, e)
+			}
+		}
+		entries = matched
+		results := make([]pipeline.Result, len(entries))
+		for i, e := range entries {
+			results[i] = pipeline.Result{Submission: pipeline.Submission{Hash: e.Hash, Path: e.Path}, Response: e.Response}
+		}
+		if printFormatted(results) {
+			return
+		}
+		if jsonFormat {
+			printJSON(entries)
+			return
+		}
+		for _, e := range entries {
+			fmt.Printf("%s\t%s\t%s\t%s\t%v\n", shortDigest(e.Layer), e.Path, e.Hash, e.Response.Verdict(), e.Response.GeneralScore)
+		}
+	}
+}
+
+// extract copies the content to a file in dir and hashes it
+func extract(dir string, content io.Reader) (imageEntry, error) {
+	f, err := os.CreateTemp(dir, "file-*")
+	if err != nil {
+		return imageEntry{}, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return imageEntry{}, err
+	}
+	return imageEntry{Hash: hex.EncodeToString(h.Sum(nil)), Size: size, file: f.Name()}, nil
+}
+
+// shortDigest returns the first 12 hex digits of the digest, as docker prints image IDs
+func shortDigest(digest string) string {
+	if _, hex, ok := strings.Cut(digest, ":"); ok && len(hex) > 12 {
+		return hex[:12]
+	}
+	return digest
+}
@@ -2,34 +2,167 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/store"
+	"github.com/demisto/infinigo/store/postgres"
+	"github.com/demisto/infinigo/store/sqlite"
 )
 
 var (
 	key        string
 	url        string
 	q          string
+	in         string
 	f          string
 	c          string
+	db         string
+	openedDB   store.Store // openedDB is the local results database once opened by openDB
+	cmdName    = "query"   // cmdName is the command being run, recorded with the results
+	queuePath  string
 	jsonFormat bool
-	v          bool
+	cacheTTL   durationFlag
+	bandwidth  byteSizeFlag
+	spoolSize  byteSizeFlag
+	retries    int
+	upRetries  int
+	upVerify   bool
+	maxConc    int
+	unknownTTL = durationFlag(time.Hour)
 )
 
+// command defines its flags on the flag set and returns the function running it with the positional arguments
+type command func(fs *flag.FlagSet) func(args []string)
+
+// commands are the sub commands supported in addition to the flag based query and upload.
+// Nested commands are named by their words separated with a space.
+var commands = map[string]command{
+	"audit verify":       auditVerify,
+	"cache warm":         cacheWarm,
+	"cortex":             cortex,
+	"db export":          dbExport,
+	"db purge":           dbPurge,
+	"db stats":           dbStats,
+	"digest":             digestCmd,
+	"feed export":        feedExport,
+	"fetch":              fetch,
+	"flush":              flush,
+	"hash":               hashCmd,
+	"queue add":          queueAdd,
+	"queue list":         queueList,
+	"queue retry":        queueRetry,
+	"queue run":          queueRun,
+	"quarantine list":    quarantineList,
+	"quarantine restore": quarantineRestore,
+	"rescan":             rescan,
+	"scan":               scan,
+	"scan-image":         scanImage,
+	"scan-package":       scanPackage,
+	"scan-procs":         scanProcs,
+	"scan-repo":          scanRepo,
+	"serve":              serve,
+	"tag":                tag,
+	"version":            versionCmd,
+}
+
+// lookup finds the longest command matching the start of args and returns it with the rest of the args
+func lookup(args []string) (string, command, []string) {
+	for n := 2; n > 0; n-- {
+		if len(args) < n {
+			continue
+		}
+		name := strings.Join(args[:n], " ")
+		if cmd, ok := commands[name]; ok {
+			return name, cmd, args[n:]
+		}
+	}
+	return "", nil, args
+}
+
+// parseInterspersed parses flags that may appear after positional arguments and returns the positional ones
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
 func init() {
-	flag.StringVar(&key, "k", os.Getenv("INFINITY_KEY"), "The key to use for Infinity API access. Can be provided as an environment variable INFINITY_KEY.")
-	flag.StringVar(&url, "url", infinigo.DefaultURL, "URL of the Infinity API to be used.")
+	// completion walks the commands so it cannot be part of their initialization
+	commands["completion"] = completion
+	clientFlags(flag.CommandLine)
 	flag.StringVar(&q, "q", "", "hash or list of hashes separated by ',' for querying")
+	flag.StringVar(&in, "i", "", "A file with hashes to query, one per line or a checksum manifest as printed by the hash command")
 	flag.StringVar(&f, "f", "", "The file to upload for processing")
 	flag.StringVar(&c, "c", "", "The confirmation code for the upload")
-	flag.BoolVar(&jsonFormat, "json", false, "Should we print replies as JSON or formatted")
-	flag.BoolVar(&v, "v", false, "Verbosity. If specified will trace the requests.")
+	cacheFlags(flag.CommandLine)
+	classifierFlags(flag.CommandLine)
+	sinkFlags(flag.CommandLine)
+	formatFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] | %s <command> [flags]\n\nCommands:\n", os.Args[0], os.Args[0])
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "  %s\n", name)
+		}
+		fmt.Fprintf(os.Stderr, "\nFlags:\n")
+		flag.PrintDefaults()
+	}
+}
+
+// clientFlags registers the flags shared by all commands talking to Infinity
+func clientFlags(fs *flag.FlagSet) {
+	fs.StringVar(&key, "k", os.Getenv("INFINITY_KEY"), "The key to use for Infinity API access. Can be provided as an environment variable INFINITY_KEY.")
+	fs.StringVar(&url, "url", infinigo.DefaultURL, "URL of the Infinity API to be used.")
+	fs.StringVar(&db, "db", defaultDB(), "The local results database, a JSON file, SQLite if it ends in .db, .sqlite or .sqlite3, or a postgres:// URL. Can be provided as an environment variable INFINITY_DB. Empty to disable.")
+	fs.StringVar(&queuePath, "queue", defaultQueue(), "The offline queue for requests made while Infinity is unreachable. Can be provided as an environment variable INFINITY_QUEUE. Empty to disable.")
+	fs.Var(&bandwidth, "bandwidth-limit", "Limit uploads to this many bytes per second, e.g. 512K or 2M. 0 for no limit.")
+	fs.Var(&spoolSize, "upload-spool-threshold", "Compress uploads larger than this size, e.g. 64M, to a temporary file instead of memory. 0 to always use memory.")
+	fs.IntVar(&retries, "query-retries", 0, "Query the hashes Infinity returned an error for again up to this many times, a second apart.")
+	fs.IntVar(&maxConc, "max-concurrency", 0, "Send up to this many requests at once, as many as Infinity handles without slowing down or rate limiting. 0 sends them one at a time.")
+	fs.IntVar(&upRetries, "upload-retries", 0, "Send uploads that failed as Infinity was unreachable, rate limited or failing again up to this many times, 5 seconds apart. Uploads failing once sent are not, as Infinity may have received them, see -upload-verify.")
+	fs.BoolVar(&upVerify, "upload-verify", false, "Query whether Infinity received an upload failing once sent before sending it again with -upload-retries.")
+	fs.StringVar(&auditPath, "audit-log", os.Getenv("INFINITY_AUDIT_LOG"), "Record every query and upload sent to Infinity in this tamper evident log, see audit verify. Can be provided as an environment variable INFINITY_AUDIT_LOG.")
+	fs.BoolVar(&jsonFormat, "json", false, "Should we print replies as JSON or formatted")
+	logFlags(fs)
+}
+
+// cacheFlags registers the flags controlling reuse of results from the local database
+func cacheFlags(fs *flag.FlagSet) {
+	fs.Var(&cacheTTL, "cache-ttl", "Reuse results from the local database checked within this duration (e.g. 12h, 7d) instead of querying. 0 to always query.")
+	fs.Var(&unknownTTL, "unknown-ttl", "Results with an unknown verdict are reused only within this duration so they are re-checked sooner.")
+}
+
+// defaultDB returns the location of the local results database
+func defaultDB() string {
+	if env := os.Getenv("INFINITY_DB"); env != "" {
+		return env
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".infinigo", "results.json")
 }
 
 func check(e error) {
@@ -39,32 +172,196 @@ func check(e error) {
 	}
 }
 
+// newClient creates the Infinity client based on the common flags and sends anything queued on previous runs
+func newClient() *infinigo.Client {
+	options := []infinigo.OptionFunc{infinigo.SetErrorLog(newLogger()), infinigo.SetURL(url), infinigo.SetKey(key),
+		infinigo.SetUploadBandwidth(int64(bandwidth)), infinigo.SetQueryRetries(retries, time.Second),
+		infinigo.SetUploadSpoolThreshold(int64(spoolSize)), infinigo.SetUploadRetries(upRetries, 5*time.Second),
+		infinigo.SetUploadVerify(upVerify)}
+	if verbosity >= 2 {
+		options = append(options, infinigo.SetTraceLog(newLogger()), infinigo.SetTraceBodies(verbosity >= 3))
+	}
+	if l := openAuditLog(); l != nil {
+		options = append(options, infinigo.SetAuditLog(l))
+	}
+	if maxConc > 0 {
+		options = append(options, infinigo.SetAdaptiveConcurrency(1, maxConc))
+	}
+	inf, err := infinigo.New(options...)
+	check(err)
+	autoFlush(inf)
+	return inf
+}
+
+// openDB opens the local results database or returns nil if it is disabled.
+// postgres:// URLs are PostgreSQL databases, paths ending in .db, .sqlite or .sqlite3 SQLite databases
+// and anything else a JSON file.
+// The database is opened once and shared by the whole command.
+func openDB() store.Store {
+	if db == "" {
+		return nil
+	}
+	if openedDB != nil {
+		return openedDB
+	}
+	ext := strings.ToLower(filepath.Ext(db))
+	switch {
+	case strings.HasPrefix(db, "postgres://") || strings.HasPrefix(db, "postgresql://"):
+		s, err := postgres.Open(db, postgres.SetSource(cmdName))
+		check(err)
+		openedDB = s
+	case ext == ".db" || ext == ".sqlite" || ext == ".sqlite3":
+		s, err := sqlite.Open(db, sqlite.SetSource(cmdName))
+		check(err)
+		openedDB = s
+	default:
+		s, err := store.Open(db)
+		check(err)
+		openedDB = s
+	}
+	return openedDB
+}
+
+// closeDB closes the local results database if it was opened
+func closeDB() {
+	if openedDB != nil {
+		check(openedDB.Close())
+	}
+}
+
+// record saves query results to the local database if it is enabled
+func record(s store.Store, res map[string]infinigo.QueryResponse) {
+	if s == nil {
+		return
+	}
+	for k, v := range res {
+		_, _, err := s.SaveResult(context.Background(), k, "", v)
+		check(err)
+	}
+	check(saveDB(s))
+}
+
+// queryCached queries the hashes that do not have a fresh result in the local database and records them.
+// paths optionally maps a hash to the file it was computed from. The caller is responsible for saving the database.
+func queryCached(inf *infinigo.Client, s store.Store, hashes []string, paths map[string]string) (map[string]infinigo.QueryResponse, error) {
+	res := make(map[string]infinigo.QueryResponse, len(hashes))
+	missing := hashes
+	if s != nil && cacheTTL > 0 {
+		now := time.Now()
+		missing = nil
+		for _, h := range hashes {
+			r, ok, err := s.GetByHash(context.Background(), h)
+			if err != nil {
+				return nil, err
+			}
+			if ok && !r.Expired(now, time.Duration(cacheTTL), time.Duration(unknownTTL)) {
+				res[h] = r.Response
+			} else {
+				missing = append(missing, h)
+			}
+		}
+	}
+	infof("%d of %d hashes found in the local database", len(res), len(hashes))
+	if len(missing) == 0 {
+		return res, nil
+	}
+	fetched, err := inf.QueryAll(classifiers.String(), missing...)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range fetched {
+		res[k] = v
+		if s != nil {
+			if _, _, err = s.SaveResult(context.Background(), k, paths[k], v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// printJSON prints the given value as indented JSON
+func printJSON(val interface{}) {
+	b, err := json.MarshalIndent(val, "", "\t")
+	check(err)
+	fmt.Println(string(b))
+}
+
 func main() {
+	defer closeDB()
+	if name, cmd, args := lookup(os.Args[1:]); cmd != nil {
+		cmdName = name
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		run := cmd(fs)
+		run(parseInterspersed(fs, args))
+		return
+	}
 	flag.Parse()
-	if q == "" && f == "" {
-		fmt.Fprintf(os.Stderr, "No command given. Please specify either q or f as parameters\n")
+	if flag.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unknown command [%s]\n", strings.Join(flag.Args(), " "))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if q == "" && in == "" && f == "" {
+		fmt.Fprintf(os.Stderr, "No command given. Please specify either q, i or f as parameters\n")
 		os.Exit(1)
 	}
 	if f != "" && c == "" || c != "" && f == "" {
 		fmt.Fprintf(os.Stderr, "You must provide both the file and confirmation code for upload\n")
 		os.Exit(1)
 	}
-	inf, err := infinigo.New(infinigo.SetErrorLog(log.New(os.Stderr, "", log.Lshortfile)),
-		infinigo.SetURL(url), infinigo.SetKey(key))
-	check(err)
-	if v {
-		infinigo.SetTraceLog(log.New(os.Stderr, "", log.Lshortfile))(inf)
-	}
-	if q != "" {
-		hashes := strings.Split(q, ",")
-		res, err := inf.Query("", hashes...)
-		check(err)
-		if jsonFormat {
-			b, err := json.MarshalIndent(res, "", "\t")
+	inf := newClient()
+	if q != "" || in != "" {
+		var hashes []string
+		if q != "" {
+			hashes = strings.Split(q, ",")
+		}
+		if in != "" {
+			fromFile, err := readHashes(in)
 			check(err)
-			fmt.Println(string(b))
+			hashes = append(hashes, fromFile...)
+		}
+		s := openDB()
+		res, err := queryCached(inf, s, hashes, nil)
+		enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddQuery("", hashes...) })
+		if s != nil {
+			check(saveDB(s))
+		}
+		notify(res, nil)
+		closeSinks()
+		for k, v := range res {
+			if !matchClassifiers(v) {
+				delete(res, k)
+			}
+		}
+		results := make([]pipeline.Result, 0, len(res))
+		for _, h := range hashes {
+			if v, ok := res[h]; ok {
+				results = append(results, pipeline.Result{Submission: pipeline.Submission{Hash: h}, Response: v})
+			}
+		}
+		if printFormatted(results) {
+			return
+		}
+		var names []string
+		if classifierColumns {
+			responses := make([]infinigo.QueryResponse, 0, len(res))
+			for _, v := range res {
+				responses = append(responses, v)
+			}
+			names = classifierNames(responses)
+		}
+		if jsonFormat {
+			printJSON(res)
 		} else {
+			if classifierColumns {
+				fmt.Printf("hash\tverdict\tscore\t%s\n", strings.Join(names, "\t"))
+			}
 			for k, v := range res {
+				if classifierColumns {
+					fmt.Printf("%s\t%s\t%v\t%s\n", k, v.Verdict(), v.GeneralScore, classifierCells(v, names))
+					continue
+				}
 				score := "-"
 				if v.GeneralScore != 0 {
 					score = fmt.Sprintf("%v", v.GeneralScore)
@@ -79,11 +376,9 @@ func main() {
 	}
 	if f != "" {
 		res, err := inf.UploadFile(c, f)
-		check(err)
+		enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddUpload(c, f) })
 		if jsonFormat {
-			b, err := json.MarshalIndent(res, "", "\t")
-			check(err)
-			fmt.Println(string(b))
+			printJSON(res)
 		} else {
 			for _, v := range res {
 				fmt.Printf("Upload done with result: %s [%v] %s\n", v.Status, v.StatusCode, v.Error)
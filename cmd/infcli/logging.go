@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+var (
+	verbosity   int
+	logFile     string
+	logMaxSize  int
+	logBackups  int
+	logOnce     sync.Once
+	logOut      io.Writer = os.Stderr
+	logFlagsSet           = log.Lshortfile
+)
+
+// verbosityFlag raises the verbosity to its level when given so -v, -vv and -vvv can be used
+type verbosityFlag int
+
+func (v verbosityFlag) IsBoolFlag() bool { return true }
+
+func (v verbosityFlag) String() string {
+	return strconv.FormatBool(false)
+}
+
+func (v verbosityFlag) Set(val string) error {
+	on, err := strconv.ParseBool(val)
+	if err != nil {
+		return err
+	}
+	if on && int(v) > verbosity {
+		verbosity = int(v)
+	}
+	return nil
+}
+
+// logFlags registers the verbosity and log file flags
+func logFlags(fs *flag.FlagSet) {
+	fs.Var(verbosityFlag(1), "v", "Verbose - print progress information")
+	fs.Var(verbosityFlag(2), "vv", "More verbose - also trace the requests")
+	fs.Var(verbosityFlag(3), "vvv", "Most verbose - also trace the response bodies")
+	fs.StringVar(&logFile, "log-file", "", "Write logs to this file instead of stderr")
+	fs.IntVar(&logMaxSize, "log-max-size", 10, "Rotate the log file when it grows beyond this size in MB")
+	fs.IntVar(&logBackups, "log-backups", 3, "The number of rotated log files to keep")
+}
+
+// logWriter returns where logs should be written, opening the log file on first use
+func logWriter() io.Writer {
+	logOnce.Do(func() {
+		if logFile == "" {
+			return
+		}
+		f, err := openRotatingFile(logFile, int64(logMaxSize)<<20, logBackups)
+		check(err)
+		logOut = f
+		logFlagsSet = log.LstdFlags | log.Lshortfile
+	})
+	return logOut
+}
+
+// newLogger creates a logger writing to the log output
+func newLogger() *log.Logger {
+	return log.New(logWriter(), "", logFlagsSet)
+}
+
+// infof prints progress information when running verbose
+func infof(format string, args ...interface{}) {
+	if verbosity >= 1 {
+		newLogger().Output(2, fmt.Sprintf(format, args...))
+	}
+}
+
+// rotatingFile is a log file that is rotated when it grows beyond maxSize, keeping up to
+// backups older files named path.1 (the newest) to path.N
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+}
+
+// openRotatingFile opens the log file for appending
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// rotate shifts the old files and starts a new one
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if r.backups > 0 {
+		for i := r.backups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/unpack"
+)
+
+// packageEntry is the result of scanning a single file embedded in a package
+type packageEntry struct {
+	Package  string                 `json:"package"`
+	Path     string                 `json:"path"`
+	Hash     string                 `json:"hash"`
+	Size     int64                  `json:"size"`
+	Response infinigo.QueryResponse `json:"response"`
+	file     string                 // file the content was extracted to
+}
+
+// scriptExtensions are the extensions of the Windows scripts packages run, e.g. the install.ps1 of NuGet packages
+var scriptExtensions = map[string]bool{".ps1": true, ".psm1": true, ".bat": true, ".cmd": true, ".vbs": true, ".js": true}
+
+// scanPackage extracts the executables embedded in deb, rpm, msi and nupkg packages, queries them and
+// optionally uploads unknown ones
+func scanPackage(fs *flag.FlagSet) func(args []string) {
+	clientFlags(fs)
+	cacheFlags(fs)
+	classifierFlags(fs)
+	sinkFlags(fs)
+	formatFlags(fs)
+	upload := fs.Bool("upload", false, "Upload files Infinity requests a confirmation for")
+	all := fs.Bool("all-files", false, "Scan every file of the packages instead of only executables, libraries and scripts")
+	maxSize := int64(100 << 20)
+	fs.Var((*byteSizeFlag)(&maxSize), "max-file-size", "Skip files larger than this size, e.g. 100M. 0 for no limit.")
+	return func(args []string) {
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "Please specify the deb, rpm, msi or nupkg packages to scan\n")
+			os.Exit(1)
+		}
+		dir, err := os.MkdirTemp("", "infinigo-package")
+		check(err)
+		var entries []packageEntry
+		for _, pkg := range args {
+			f, err := os.Open(pkg)
+			check(err)
+			info, err := f.Stat()
+			check(err)
+			err = unpack.Walk(f, info.Size(), func(file unpack.File, content io.Reader) error {
+				if maxSize > 0 && file.Size > maxSize {
+					fmt.Fprintf(os.Stderr, "Skipped %s %s (%d bytes): larger than %d bytes\n", pkg, file.Name, file.Size, maxSize)
+					return nil
+				}
+				br := bufio.NewReader(content)
+				head, _ := br.Peek(4)
+				if !*all && !isExecutable(file.Mode, head) && !scriptExtensions[strings.ToLower(path.Ext(file.Name))] {
+					return nil
+				}
+				e, err := extract(dir, br)
+				if err != nil {
+					return err
+				}
+				entries = append(entries, packageEntry{Package: pkg, Path: file.Name, Hash: e.Hash, Size: e.Size, file: e.file})
+				return nil
+			})
+			f.Close()
+			if err != nil {
+				// What was extracted before a damaged or unsupported part is still scanned
+				fmt.Fprintf(os.Stderr, "Failed to extract all the files of %s - %v\n", pkg, err)
+			}
+		}
+		infof("Extracted %d files from %d packages", len(entries), len(args))
+		inf := newClient()
+		if len(entries) > 0 {
+			var hashes []string
+			paths := make(map[string]string, len(entries))
+			for _, e := range entries {
+				if _, ok := paths[e.Hash]; !ok {
+					hashes = append(hashes, e.Hash)
+					paths[e.Hash] = e.Package + ":" + e.Path
+				}
+			}
+			s := openDB()
+			res, err := queryCached(inf, s, hashes, paths)
+			enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddQuery("", hashes...) })
+			if s != nil {
+				check(saveDB(s))
+			}
+			notify(res, paths)
+			closeSinks()
+			for i := range entries {
+				entries[i].Response = res[entries[i].Hash]
+			}
+		}
+		queued := false
+		if *upload {
+			uploaded := make(map[string]bool, len(entries))
+			for _, e := range entries {
+				if e.Response.ConfirmCode == "" || uploaded[e.Hash] {
+					continue
+				}
+				uploaded[e.Hash] = true
+				infof("Uploading %s %s (%d bytes)", e.Package, e.Path, e.Size)
+				_, err := inf.UploadFile(e.Response.ConfirmCode, e.file)
+				if err != nil && infinigo.Unreachable(err) {
+					queued = true
+				}
+				enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddUpload(e.Response.ConfirmCode, e.file) })
+				if err == nil {
+					fmt.Fprintf(os.Stderr, "Uploaded %s %s\n", e.Package, e.Path)
+				}
+			}
+		}
+		if queued {
+			fmt.Fprintf(os.Stderr, "Keeping the extracted files in %s for the queued uploads\n", dir)
+		} else {
+			os.RemoveAll(dir)
+		}
+		matched := make([]packageEntry, 0, len(entries))
+		for _, e := range entries {
+			if matchClassifiers(e.Response) {
+				matched = append(matched, e)
+			}
+		}
+		entries = matched
+		results := make([]pipeline.Result, len(entries))
+		for i, e := range entries {
+			results[i] = pipeline.Result{Submission: pipeline.Submission{Hash: e.Hash, Path: e.Package + ":" + e.Path}, Response: e.Response}
+		}
+		if printFormatted(results) {
+			return
+		}
+		if jsonFormat {
+			printJSON(entries)
+			return
+		}
+		for _, e := range entries {
+			fmt.Printf("%s\t%s\t%s\t%s\t%v\n", e.Package, e.Path, e.Hash, e.Response.Verdict(), e.Response.GeneralScore)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// process is a running process
+type process struct {
+	pid     int
+	name    string
+	exe     procFile   // exe is the executable, empty if it could not be resolved
+	modules []procFile // modules are the loaded libraries, listed only if requested
+}
+
+// procFile is an executable or a module of a process
+type procFile struct {
+	path string // path of the file as the process loaded it
+	open string // open is the path the content is read from, e.g. /proc/<pid>/exe of a deleted executable
+}
+
+// procEntry is the result of scanning a single file of a running process
+type procEntry struct {
+	PID      int                    `json:"pid"`
+	Process  string                 `json:"process"`
+	Path     string                 `json:"path"`
+	Module   bool                   `json:"module,omitempty"`
+	Hash     string                 `json:"hash"`
+	Response infinigo.QueryResponse `json:"response"`
+	file     string                 // file the content is read from
+}
+
+// pidsFlag is a repeatable or comma separated list of process IDs
+type pidsFlag map[int]bool
+
+func (p pidsFlag) String() string {
+	pids := make([]string, 0, len(p))
+	for pid := range p {
+		pids = append(pids, strconv.Itoa(pid))
+	}
+	sort.Strings(pids)
+	return strings.Join(pids, ",")
+}
+
+func (p pidsFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		pid, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("bad process ID %s", v)
+		}
+		p[pid] = true
+	}
+	return nil
+}
+
+// scanProcs hashes the executables of the running processes, queries them and optionally uploads unknown ones
+func scanProcs(fs *flag.FlagSet) func(args []string) {
+	clientFlags(fs)
+	cacheFlags(fs)
+	classifierFlags(fs)
+	sinkFlags(fs)
+	formatFlags(fs)
+	upload := fs.Bool("upload", false, "Upload files Infinity requests a confirmation for")
+	modules := fs.Bool("modules", false, "Also scan the libraries loaded by the processes (shared objects on Linux, DLLs on Windows)")
+	pids := pidsFlag{}
+	fs.Var(pids, "pid", "Only scan the processes with these IDs. Can be repeated or comma separated.")
+	return func(args []string) {
+		procs, err := listProcesses(*modules)
+		check(err)
+		infof("Listed %d processes", len(procs))
+		var entries []procEntry
+		// Each file is hashed once however many processes loaded it
+		hashed := make(map[string]string)
+		failed := make(map[string]bool)
+		add := func(p process, f procFile, module bool) {
+			hash, ok := hashed[f.open]
+			if !ok {
+				if failed[f.open] {
+					return
+				}
+				if hash, err = hashFile(f.open); err != nil {
+					failed[f.open] = true
+					fmt.Fprintf(os.Stderr, "Skipped %s of process %d (%s): %s\n", f.path, p.pid, p.name, errorReason(err))
+					return
+				}
+				hashed[f.open] = hash
+			}
+			entries = append(entries, procEntry{PID: p.pid, Process: p.name, Path: f.path, Module: module, Hash: hash, file: f.open})
+		}
+		scanned, unresolved := 0, 0
+		for _, p := range procs {
+			if len(pids) > 0 && !pids[p.pid] {
+				continue
+			}
+			if p.exe.path == "" {
+				// Kernel threads and processes of other users without the privileges to inspect them
+				unresolved++
+				continue
+			}
+			scanned++
+			add(p, p.exe, false)
+			for _, m := range p.modules {
+				add(p, m, true)
+			}
+		}
+		if unresolved > 0 {
+			fmt.Fprintf(os.Stderr, "Could not resolve the executables of %d processes, kernel threads or processes that need administrative privileges to inspect\n", unresolved)
+		}
+		infof("Hashed %d files of %d processes", len(hashed), scanned)
+		inf := newClient()
+		if len(entries) > 0 {
+			var hashes []string
+			paths := make(map[string]string, len(entries))
+			for _, e := range entries {
+				if _, ok := paths[e.Hash]; !ok {
+					hashes = append(hashes, e.Hash)
+					paths[e.Hash] = e.Path
+				}
+			}
+			s := openDB()
+			res, err := queryCached(inf, s, hashes, paths)
+			enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddQuery("", hashes...) })
+			if s != nil {
+				check(saveDB(s))
+			}
+			notify(res, paths)
+			closeSinks()
+			for i := range entries {
+				entries[i].Response = res[entries[i].Hash]
+			}
+		}
+		if *upload {
+			uploaded := make(map[string]bool, len(entries))
+			for _, e := range entries {
+				if e.Response.ConfirmCode == "" || uploaded[e.Hash] {
+					continue
+				}
+				uploaded[e.Hash] = true
+				infof("Uploading %s", e.Path)
+				_, err := inf.UploadFile(e.Response.ConfirmCode, e.file)
+				enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddUpload(e.Response.ConfirmCode, e.Path) })
+				if err == nil {
+					fmt.Fprintf(os.Stderr, "Uploaded %s\n", e.Path)
+				}
+			}
+		}
+		matched := make([]procEntry, 0, len(entries))
+		for _, e := range entries {
+			if matchClassifiers(e.Response) {
+				matched = append(matched, e)
+			}
+		}
+		entries = matched
+		results := make([]pipeline.Result, len(entries))
+		for i, e := range entries {
+			results[i] = pipeline.Result{Submission: pipeline.Submission{Hash: e.Hash, Path: e.Path}, Response: e.Response}
+		}
+		if printFormatted(results) {
+			return
+		}
+		if jsonFormat {
+			printJSON(entries)
+			return
+		}
+		for _, e := range entries {
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\t%v\n", e.PID, e.Process, e.Path, e.Hash, e.Response.Verdict(), e.Response.GeneralScore)
+		}
+	}
+}
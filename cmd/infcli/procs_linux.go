@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// deletedSuffix is appended by the kernel to the links of files deleted after they were loaded
+const deletedSuffix = " (deleted)"
+
+// listProcesses lists the running processes from /proc
+func listProcesses(modules bool) ([]process, error) {
+	dirs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var procs []process
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", d.Name())
+		p := process{pid: pid}
+		if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+			p.name = strings.TrimSpace(string(comm))
+		} else {
+			// The process exited
+			continue
+		}
+		if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+			p.exe = procFile{path: exe, open: exe}
+			if strings.HasSuffix(exe, deletedSuffix) {
+				// The content of a deleted executable is still readable through the link
+				p.exe.open = filepath.Join(dir, "exe")
+			}
+		}
+		if modules && p.exe.path != "" {
+			p.modules = mappedFiles(dir, p.exe.path)
+		}
+		procs = append(procs, p)
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].pid < procs[j].pid })
+	return procs, nil
+}
+
+// mappedFiles returns the files mapped executable into the process other than its executable
+func mappedFiles(dir, exe string) []procFile {
+	f, err := os.Open(filepath.Join(dir, "maps"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	seen := map[string]bool{exe: true}
+	var files []procFile
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// address perms offset dev inode pathname, the pathname may contain spaces
+		fields := strings.SplitN(scanner.Text(), " ", 6)
+		if len(fields) < 6 || !strings.Contains(fields[1], "x") {
+			continue
+		}
+		path := strings.TrimLeft(fields[5], " ")
+		if !strings.HasPrefix(path, "/") || seen[path] {
+			continue
+		}
+		seen[path] = true
+		mf := procFile{path: path, open: path}
+		if strings.HasSuffix(path, deletedSuffix) {
+			// Deleted libraries are read through the mapping, which needs root
+			mf.open = filepath.Join(dir, "map_files", fields[0])
+		}
+		files = append(files, mf)
+	}
+	return files
+}
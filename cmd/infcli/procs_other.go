@@ -0,0 +1,38 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// listProcesses lists the running processes with ps, which does not list loaded libraries
+func listProcesses(modules bool) ([]process, error) {
+	out, err := exec.Command("ps", "-axo", "pid=,comm=").Output()
+	if err != nil {
+		return nil, err
+	}
+	var procs []process
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		pid, comm, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		p := process{name: filepath.Base(strings.TrimSpace(comm))}
+		if p.pid, err = strconv.Atoi(pid); err != nil {
+			continue
+		}
+		// ps shows the path of the executable on macOS and the BSDs, and only its name for some processes
+		if comm = strings.TrimSpace(comm); filepath.IsAbs(comm) {
+			p.exe = procFile{path: comm, open: comm}
+		}
+		procs = append(procs, p)
+	}
+	return procs, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	th32csSnapModule32             = 0x10
+	maxModuleName                  = 256
+)
+
+var (
+	procQueryFullProcessImageNameW = kernel32.NewProc("QueryFullProcessImageNameW")
+	procModule32FirstW             = kernel32.NewProc("Module32FirstW")
+	procModule32NextW              = kernel32.NewProc("Module32NextW")
+)
+
+// moduleEntry32 is MODULEENTRY32W
+type moduleEntry32 struct {
+	Size         uint32
+	ModuleID     uint32
+	ProcessID    uint32
+	GlblcntUsage uint32
+	ProccntUsage uint32
+	ModBaseAddr  uintptr
+	ModBaseSize  uint32
+	ModuleHandle syscall.Handle
+	Module       [maxModuleName]uint16
+	ExePath      [syscall.MAX_PATH]uint16
+}
+
+// listProcesses lists the running processes with a Toolhelp snapshot
+func listProcesses(modules bool) ([]process, error) {
+	snapshot, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.CloseHandle(snapshot)
+	var procs []process
+	entry := syscall.ProcessEntry32{Size: uint32(unsafe.Sizeof(syscall.ProcessEntry32{}))}
+	for err = syscall.Process32First(snapshot, &entry); err == nil; err = syscall.Process32Next(snapshot, &entry) {
+		p := process{pid: int(entry.ProcessID), name: syscall.UTF16ToString(entry.ExeFile[:])}
+		if exe, err := imagePath(entry.ProcessID); err == nil {
+			p.exe = procFile{path: exe, open: exe}
+		}
+		if modules && p.exe.path != "" {
+			p.modules = loadedModules(entry.ProcessID, p.exe.path)
+		}
+		procs = append(procs, p)
+	}
+	if err != syscall.ERROR_NO_MORE_FILES {
+		return nil, err
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].pid < procs[j].pid })
+	return procs, nil
+}
+
+// imagePath returns the path of the executable of the process
+func imagePath(pid uint32) (string, error) {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, pid)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.CloseHandle(h)
+	buf := make([]uint16, syscall.MAX_LONG_PATH)
+	size := uint32(len(buf))
+	r, _, err := procQueryFullProcessImageNameW.Call(uintptr(h), 0, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)))
+	if r == 0 {
+		return "", err
+	}
+	return syscall.UTF16ToString(buf[:size]), nil
+}
+
+// loadedModules returns the DLLs loaded by the process other than its executable
+func loadedModules(pid uint32, exe string) []procFile {
+	snapshot, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPMODULE|th32csSnapModule32, pid)
+	if err != nil {
+		return nil
+	}
+	defer syscall.CloseHandle(snapshot)
+	seen := map[string]bool{strings.ToLower(exe): true}
+	var files []procFile
+	entry := moduleEntry32{Size: uint32(unsafe.Sizeof(moduleEntry32{}))}
+	r, _, _ := procModule32FirstW.Call(uintptr(snapshot), uintptr(unsafe.Pointer(&entry)))
+	for ; r != 0; r, _, _ = procModule32NextW.Call(uintptr(snapshot), uintptr(unsafe.Pointer(&entry))) {
+		path := syscall.UTF16ToString(entry.ExePath[:])
+		if key := strings.ToLower(path); !seen[key] {
+			seen[key] = true
+			files = append(files, procFile{path: path, open: path})
+		}
+	}
+	return files
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/demisto/infinigo/quarantine"
+)
+
+var quarantineDir string
+
+// defaultQuarantineDir returns the location of the quarantine directory
+func defaultQuarantineDir() string {
+	if env := os.Getenv("INFINITY_QUARANTINE"); env != "" {
+		return env
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".infinigo", "quarantine")
+}
+
+// quarantineFlags registers the flags of the quarantine commands
+func quarantineFlags(fs *flag.FlagSet) {
+	fs.StringVar(&quarantineDir, "dir", defaultQuarantineDir(), "The quarantine directory, as set in the quarantine action of the policy. Can be provided as an environment variable INFINITY_QUARANTINE.")
+}
+
+// openVault opens the quarantine directory
+func openVault() *quarantine.Vault {
+	if quarantineDir == "" {
+		fmt.Fprintf(os.Stderr, "No quarantine directory specified\n")
+		os.Exit(1)
+	}
+	vault, err := quarantine.Open(quarantineDir)
+	check(err)
+	return vault
+}
+
+// quarantineList prints the quarantined files
+func quarantineList(fs *flag.FlagSet) func(args []string) {
+	quarantineFlags(fs)
+	fs.BoolVar(&jsonFormat, "json", false, "Should we print replies as JSON or formatted")
+	return func(args []string) {
+		list, err := openVault().List()
+		check(err)
+		if jsonFormat {
+			printJSON(list)
+			return
+		}
+		for _, e := range list {
+			encrypted := ""
+			if e.Encrypted {
+				encrypted = "encrypted"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.Quarantined.Local().Format("2006-01-02 15:04:05"), e.Hash, e.Reason, encrypted, e.Path)
+		}
+	}
+}
+
+// quarantineRestore moves quarantined files back to where they were
+func quarantineRestore(fs *flag.FlagSet) func(args []string) {
+	quarantineFlags(fs)
+	to := fs.String("to", "", "Restore the file to this path instead of its original one. Only with a single id.")
+	return func(args []string) {
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "Please specify the ids of the files to restore, see quarantine list\n")
+			os.Exit(1)
+		}
+		if *to != "" && len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "-to can only be used with a single id\n")
+			os.Exit(1)
+		}
+		vault := openVault()
+		password := os.Getenv("INFINITY_QUARANTINE_PASSWORD")
+		failed := false
+		for _, id := range args {
+			e, err := vault.Restore(id, password, *to)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed restoring %s - %v\n", id, err)
+				failed = true
+				continue
+			}
+			path := e.Path
+			if *to != "" {
+				path = *to
+			}
+			fmt.Fprintf(os.Stderr, "Restored %s\n", path)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/demisto/infinigo/archive/s3"
+	"github.com/demisto/infinigo/drop"
+	"github.com/demisto/infinigo/imap"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/s3watch"
+	notifyrules "github.com/demisto/infinigo/sink/notify"
+	"github.com/demisto/infinigo/sqs"
+	"github.com/demisto/infinigo/store"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var pipelineDir string
+
+// defaultPipelineDir returns the location of the pipeline queue
+func defaultPipelineDir() string {
+	if env := os.Getenv("INFINITY_PIPELINE"); env != "" {
+		return env
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".infinigo", "pipeline")
+}
+
+// pipelineFlags registers the flags of the queue commands
+func pipelineFlags(fs *flag.FlagSet) {
+	fs.StringVar(&pipelineDir, "dir", defaultPipelineDir(), "The directory of the pipeline queue. Can be provided as an environment variable INFINITY_PIPELINE.")
+}
+
+// openPipelineQueue opens the pipeline queue
+func openPipelineQueue() *pipeline.Queue {
+	if pipelineDir == "" {
+		fmt.Fprintf(os.Stderr, "No pipeline directory specified\n")
+		os.Exit(1)
+	}
+	return openSubmissionQueue(pipelineDir)
+}
+
+// openDeadLetterQueue opens the queue of submissions that ran out of attempts
+func openDeadLetterQueue() *pipeline.Queue {
+	return openSubmissionQueue(filepath.Join(pipelineDir, "dead"))
+}
+
+// openSubmissionQueue opens the pipeline queue in the directory, reporting the submissions that were corrupt
+func openSubmissionQueue(dir string) *pipeline.Queue {
+	queue, err := pipeline.OpenQueue(dir)
+	if errors.Is(err, pipeline.ErrCorruptSubmission) {
+		fmt.Fprintf(os.Stderr, "Skipped corrupt submissions: %v\n", err)
+		err = nil
+	}
+	check(err)
+	return queue
+}
+
+// queueAdd submits files and hashes to the pipeline queue
+func queueAdd(fs *flag.FlagSet) func(args []string) {
+	pipelineFlags(fs)
+	upload := fs.Bool("upload", false, "Upload files Infinity requests a confirmation for")
+	priority := newChoiceFlag("normal", "high", "bulk")
+	fs.Var(priority, "priority", "Priority of the submissions, high for incident response and bulk for background sweeps: "+priority.choices())
+	var opts walkOptions
+	fs.BoolVar(&opts.follow, "follow-symlinks", false, "Follow symbolic links (and junctions on Windows), skipping cycles and files already visited")
+	fs.Var((*byteSizeFlag)(&opts.maxSize), "max-file-size", "Skip files larger than this size, e.g. 100M. 0 for no limit.")
+	in := fs.String("i", "", "A file with hashes to submit, one per line or a checksum manifest as printed by the hash command. - for standard input.")
+	return func(args []string) {
+		if len(args) == 0 && *in == "" {
+			fmt.Fprintf(os.Stderr, "Please specify the files, directories or hashes to submit\n")
+			os.Exit(1)
+		}
+		prio, err := pipeline.ParsePriority(priority.String())
+		check(err)
+		template := pipeline.Submission{Upload: *upload, Priority: prio}
+		var paths, hashes []string
+		for _, arg := range args {
+			if _, err := os.Stat(arg); err == nil {
+				paths = append(paths, arg)
+			} else {
+				hashes = append(hashes, arg)
+			}
+		}
+		sources := []pipeline.Source{pipeline.Hashes(strings.NewReader(strings.Join(hashes, "\n")), template), walkSource(paths, opts, template)}
+		if *in != "" {
+			r := os.Stdin
+			if *in != "-" {
+				r, err = os.Open(*in)
+				check(err)
+				defer r.Close()
+			}
+			sources = append(sources, pipeline.Hashes(r, template))
+		}
+		queue := openPipelineQueue()
+		added := 0
+		for _, src := range sources {
+			n, err := pipeline.Feed(context.Background(), src, queue.Submit)
+			added += n
+			check(err)
+		}
+		fmt.Fprintf(os.Stderr, "Queued %d submissions, %d in the queue\n", added, queue.Len())
+	}
+}
+
+// walkSource is a source of the files walk finds under the paths, reporting the skipped ones
+func walkSource(paths []string, opts walkOptions, template pipeline.Submission) pipeline.Source {
+	entries, skipped := walk(paths, opts)
+	for _, sk := range skipped {
+		fmt.Fprintf(os.Stderr, "Skipped %s: %s\n", sk.Path, sk.Reason)
+	}
+	return pipeline.SourceFunc(func(ctx context.Context) (pipeline.Submission, error) {
+		if len(entries) == 0 {
+			return pipeline.Submission{}, io.EOF
+		}
+		sub := template
+		sub.Hash, sub.Path = entries[0].Hash, entries[0].Path
+		entries = entries[1:]
+		return sub, nil
+	})
+}
+
+// queueRun processes the pipeline queue, recording results in the local database
+func queueRun(fs *flag.FlagSet) func(args []string) {
+	clientFlags(fs)
+	pipelineFlags(fs)
+	sinkFlags(fs)
+	follow := fs.Bool("follow", false, "Keep running and process new submissions instead of exiting once the queue is empty")
+	attempts := fs.Int("max-attempts", pipeline.DefaultMaxAttempts, "Move submissions to the dead letter queue after failing this many times")
+	rescanInterval := durationFlag(0)
+	fs.Var(&rescanInterval, "rescan-interval", "Query hashes with an unknown verdict again after this duration (e.g. 15m), doubling it after every rescan. 0 to not rescan.")
+	maxRescanInterval := durationFlag(pipeline.DefaultMaxRescanInterval)
+	fs.Var(&maxRescanInterval, "max-rescan-interval", "The longest delay between rescans of an unknown verdict")
+	rescans := fs.Int("max-rescans", pipeline.DefaultMaxRescans, "Give up on a verdict after this many rescans")
+	quota := fs.Int64("daily-quota", 0, "The number of hashes the API key may query per day, so lower priority work slows down and pauses as it depletes. 0 to infer it from rate limit errors.")
+	sqsIn := fs.String("sqs-in", "", "Also process the submissions received from the SQS queue at this URL, implying -follow")
+	imapURL := fs.String("imap", "", "Also process the attachments of new messages of the IMAP mailbox at this URL, e.g. imaps://abuse@mail.example.com/INBOX, implying -follow")
+	imapPassword := fs.String("imap-password", os.Getenv("IMAP_PASSWORD"), "The IMAP password. Can be provided as an environment variable IMAP_PASSWORD.")
+	imapInterval := durationFlag(imap.DefaultPollInterval)
+	fs.Var(&imapInterval, "imap-interval", "The interval between searches for new IMAP messages")
+	imapPlaintext := fs.Bool("imap-plaintext", false, "Log in to imap:// servers that do not support STARTTLS, sending the IMAP password in clear text")
+	var imapMoves stringsFlag
+	fs.Var(&imapMoves, "imap-move", "Move IMAP messages with an attachment with a verdict to a folder, e.g. malicious=Quarantine. Can be repeated.")
+	s3Watch := fs.String("s3-watch", "", "Also process the objects landing in the S3 bucket at this URL, e.g. s3://uploads/incoming/, implying -follow")
+	s3WatchInterval := durationFlag(s3watch.DefaultPollInterval)
+	fs.Var(&s3WatchInterval, "s3-watch-interval", "The interval between listings of the watched S3 bucket")
+	s3WatchQueue := fs.String("s3-watch-queue", "", "Follow the event notifications of the watched S3 bucket sent to the SQS queue at this URL instead of listing it")
+	s3WatchNew := fs.Bool("s3-watch-new", false, "Only process the objects landing in the watched S3 bucket after its first listing, instead of every object")
+	dropURL := fs.String("drop", "", "Also process the files landing in the SFTP or FTP directory at this URL, e.g. sftp://partner@files.example.com/incoming, implying -follow")
+	dropPassword := fs.String("drop-password", os.Getenv("DROP_PASSWORD"), "The SFTP or FTP password. Can be provided as an environment variable DROP_PASSWORD.")
+	dropKey := fs.String("drop-key", "", "The private key file to authenticate to SFTP with, decrypted with the environment variable DROP_KEY_PASSPHRASE if set")
+	dropKnownHosts := fs.String("drop-known-hosts", defaultKnownHosts(), "The known_hosts file verifying the host key of the SFTP server")
+	dropInterval := durationFlag(drop.DefaultPollInterval)
+	fs.Var(&dropInterval, "drop-interval", "The interval between listings of the SFTP or FTP directory")
+	dropSettle := durationFlag(drop.DefaultSettleTime)
+	fs.Var(&dropSettle, "drop-settle", "How long after they were last modified files in the SFTP or FTP directory are downloaded, so uploads in progress are left alone")
+	var dropMoves stringsFlag
+	fs.Var(&dropMoves, "drop-move", "Move files of the SFTP or FTP directory with a verdict to a folder, relative to the directory unless absolute, e.g. malicious=quarantine. Can be repeated.")
+	downloadDir := fs.String("download-dir", os.TempDir(), "The directory the S3 objects, the IMAP attachments and the SFTP or FTP files are downloaded to")
+	upload := fs.Bool("upload", false, "Upload the files received from SQS, S3, IMAP, SFTP or FTP if Infinity requests it, unless an SQS message says otherwise")
+	return func(args []string) {
+		queue := openPipelineQueue()
+		options := []pipeline.OptionFunc{pipeline.SetMaxAttempts(*attempts), pipeline.SetErrorLog(newLogger()),
+			pipeline.SetDeadLetterQueue(openDeadLetterQueue()), pipeline.SetDailyQuota(*quota)}
+		if rescanInterval > 0 {
+			options = append(options, pipeline.SetRescan(time.Duration(rescanInterval), time.Duration(maxRescanInterval), *rescans))
+		}
+		if s := openDB(); s != nil {
+			options = append(options, pipeline.AddSink(store.Sink(s)))
+		}
+		options = append(options, pipeline.AddSink(printSink()))
+		if sinks := openSinks(); len(sinks) > 0 {
+			options = append(options, pipeline.AddSink(sinks))
+		}
+		var mailbox *imap.Mailbox
+		if *imapURL != "" {
+			imapOptions := []imap.OptionFunc{imap.SetPassword(*imapPassword), imap.SetPollInterval(time.Duration(imapInterval)), imap.SetErrorLog(newLogger()), imap.SetPlaintextLogin(*imapPlaintext)}
+			for _, move := range imapMoves {
+				verdict, folder, err := notifyrules.ParseRoute(move)
+				check(err)
+				imapOptions = append(imapOptions, imap.SetFolder(verdict, folder))
+			}
+			var err error
+			mailbox, err = imap.New(*imapURL, filepath.Join(*downloadDir, "imap"), imapOptions...)
+			check(err)
+			defer mailbox.Close()
+			options = append(options, pipeline.AddSink(mailbox))
+		}
+		var dir *drop.Dir
+		if *dropURL != "" {
+			var dropOptions []drop.OptionFunc
+			for _, move := range dropMoves {
+				verdict, folder, err := notifyrules.ParseRoute(move)
+				check(err)
+				dropOptions = append(dropOptions, drop.SetFolder(verdict, folder))
+			}
+			dir = openDrop(*dropURL, *dropPassword, *dropKey, *dropKnownHosts, time.Duration(dropInterval), time.Duration(dropSettle), *downloadDir, dropOptions...)
+			defer dir.Close()
+			options = append(options, pipeline.AddSink(dir))
+		}
+		p, err := pipeline.New(newClient(), queue, options...)
+		check(err)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if mailbox != nil {
+			*follow = true
+			go func() {
+				_, err := p.Feed(ctx, mailbox.Source(pipeline.Submission{Upload: *upload}))
+				if err != nil && !errors.Is(err, context.Canceled) {
+					fmt.Fprintf(os.Stderr, "Stopped receiving from IMAP - %v\n", err)
+					stop()
+				}
+			}()
+		}
+		if dir != nil {
+			*follow = true
+			go func() {
+				_, err := p.Feed(ctx, dir.Source(pipeline.Submission{Upload: *upload}))
+				if err != nil && !errors.Is(err, context.Canceled) {
+					fmt.Fprintf(os.Stderr, "Stopped receiving from %s - %v\n", *dropURL, err)
+					stop()
+				}
+			}()
+		}
+		if *s3Watch != "" {
+			*follow = true
+			w := openS3Watch(*s3Watch, *s3WatchQueue, time.Duration(s3WatchInterval), *s3WatchNew, *downloadDir)
+			go func() {
+				_, err := p.Feed(ctx, w.Source(pipeline.Submission{Upload: *upload}))
+				if err != nil && !errors.Is(err, context.Canceled) {
+					fmt.Fprintf(os.Stderr, "Stopped watching S3 - %v\n", err)
+					stop()
+				}
+			}()
+		}
+		if *sqsIn != "" {
+			*follow = true
+			in, err := sqs.New(*sqsIn, sqs.SetDownloadDir(*downloadDir), sqs.SetS3Endpoint(s3Endpoint), sqs.SetErrorLog(newLogger()))
+			check(err)
+			go func() {
+				_, err := p.Feed(ctx, in.Source(pipeline.Submission{Upload: *upload}))
+				if err != nil && !errors.Is(err, context.Canceled) {
+					fmt.Fprintf(os.Stderr, "Stopped receiving from SQS - %v\n", err)
+					stop()
+				}
+			}()
+		}
+		infof("Processing %d queued submissions", queue.Len())
+		err = p.Run(ctx, !*follow)
+		closeSinks()
+		if err != nil && !errors.Is(err, context.Canceled) {
+			check(err)
+		}
+		if queue.Len() > 0 {
+			fmt.Fprintf(os.Stderr, "%d submissions still queued, including scheduled rescans and work waiting for the daily quota\n", queue.Len())
+		}
+		if n := openDeadLetterQueue().Len(); n > 0 {
+			fmt.Fprintf(os.Stderr, "%d submissions failed, see queue list -dead and queue retry\n", n)
+		}
+	}
+}
+
+// openS3Watch creates the watcher of the bucket at the s3:// URL, remembering the objects it submitted in
+// the pipeline directory
+func openS3Watch(bucketURL, queueURL string, interval time.Duration, skipExisting bool, downloadDir string) *s3watch.Watcher {
+	u, err := neturl.Parse(bucketURL)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		fmt.Fprintf(os.Stderr, "Unsupported S3 bucket [%s], expected s3://bucket/prefix\n", bucketURL)
+		os.Exit(1)
+	}
+	var options []s3.OptionFunc
+	if s3Region != "" {
+		options = append(options, s3.SetRegion(s3Region))
+	}
+	if s3Endpoint != "" {
+		options = append(options, s3.SetEndpoint(s3Endpoint))
+	}
+	bucket, err := s3.New(u.Host, options...)
+	check(err)
+	dir := filepath.Join(downloadDir, "s3")
+	check(os.MkdirAll(dir, 0700))
+	stateDir := filepath.Join(pipelineDir, "s3watch")
+	check(os.MkdirAll(stateDir, 0700))
+	watchOptions := []s3watch.OptionFunc{s3watch.SetPollInterval(interval), s3watch.SetSkipExisting(skipExisting), s3watch.SetErrorLog(newLogger()),
+		s3watch.SetStateFile(filepath.Join(stateDir, u.Host+".json"))}
+	if queueURL != "" {
+		q, err := sqs.New(queueURL, sqs.SetDownloadDir(dir), sqs.SetS3Endpoint(s3Endpoint), sqs.SetErrorLog(newLogger()))
+		check(err)
+		watchOptions = append(watchOptions, s3watch.SetNotifications(q))
+	}
+	w, err := s3watch.New(bucket, u.Path, dir, watchOptions...)
+	check(err)
+	return w
+}
+
+// defaultKnownHosts returns the known_hosts file of the user
+func defaultKnownHosts() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// openDrop creates the SFTP or FTP drop directory at the URL, downloading files under the download directory
+func openDrop(dirURL, password, keyFile, knownHosts string, interval, settle time.Duration, downloadDir string, options ...drop.OptionFunc) *drop.Dir {
+	options = append(options, drop.SetPassword(password), drop.SetPollInterval(interval), drop.SetSettleTime(settle), drop.SetErrorLog(newLogger()))
+	if strings.HasPrefix(dirURL, "sftp:") {
+		hostKey, err := knownhosts.New(knownHosts)
+		check(err)
+		options = append(options, drop.SetHostKeyCallback(hostKey))
+	}
+	if keyFile != "" {
+		pemBytes, err := os.ReadFile(keyFile)
+		check(err)
+		options = append(options, drop.SetPrivateKey(pemBytes, os.Getenv("DROP_KEY_PASSPHRASE")))
+	}
+	dir := filepath.Join(downloadDir, "drop")
+	check(os.MkdirAll(dir, 0700))
+	d, err := drop.New(dirURL, dir, options...)
+	check(err)
+	return d
+}
+
+// queueRetry moves submissions from the dead letter queue back to the pipeline queue
+func queueRetry(fs *flag.FlagSet) func(args []string) {
+	pipelineFlags(fs)
+	return func(args []string) {
+		queue, dead := openPipelineQueue(), openDeadLetterQueue()
+		ids := make(map[string]bool, len(args))
+		for _, id := range args {
+			ids[id] = true
+		}
+		moved := 0
+		for _, sub := range dead.List() {
+			if len(ids) > 0 && !ids[sub.ID] {
+				continue
+			}
+			sub.Retry()
+			check(dead.Move(sub, queue))
+			moved++
+		}
+		fmt.Fprintf(os.Stderr, "Requeued %d submissions, %d still failed\n", moved, dead.Len())
+	}
+}
+
+// queueList prints the submissions in the pipeline queue
+func queueList(fs *flag.FlagSet) func(args []string) {
+	pipelineFlags(fs)
+	fs.BoolVar(&jsonFormat, "json", false, "Should we print replies as JSON or formatted")
+	dead := fs.Bool("dead", false, "List the submissions that ran out of attempts instead")
+	return func(args []string) {
+		queue := openPipelineQueue()
+		if *dead {
+			queue = openDeadLetterQueue()
+		}
+		list := queue.List()
+		if jsonFormat {
+			printJSON(list)
+			return
+		}
+		for _, sub := range list {
+			state := "query"
+			if sub.ConfirmCode != "" {
+				state = "upload"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%d\t%s\t%s\n", sub.ID, sub.Hash, state, sub.Priority, sub.Attempts, sub.Path, sub.Error)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// repoEntry is the result of scanning a single blob of a git repository
+type repoEntry struct {
+	Blob     string                 `json:"blob"`
+	Path     string                 `json:"path"`
+	Hash     string                 `json:"hash"`
+	Size     int64                  `json:"size"`
+	Response infinigo.QueryResponse `json:"response"`
+	file     string                 // file the blob was extracted to
+}
+
+// repoBlob is a blob listed in a repository
+type repoBlob struct {
+	id   string
+	path string
+	size int64
+}
+
+// binarySniffLen is how much of a blob is checked for a NUL byte, as git does to tell binary files
+const binarySniffLen = 8000
+
+// isBinary returns whether the start of a blob looks like a binary file or an executable script
+func isBinary(head []byte) bool {
+	return bytes.IndexByte(head, 0) >= 0 || bytes.HasPrefix(head, []byte("#!")) || bytes.HasPrefix(head, []byte("MZ"))
+}
+
+// scanRepo checks the binary blobs of a git repository, optionally of its whole history
+func scanRepo(fs *flag.FlagSet) func(args []string) {
+	clientFlags(fs)
+	cacheFlags(fs)
+	classifierFlags(fs)
+	sinkFlags(fs)
+	formatFlags(fs)
+	upload := fs.Bool("upload", false, "Upload files Infinity requests a confirmation for")
+	history := fs.Bool("history", false, "Scan the blobs of every commit of every branch and tag instead of only the tree of -ref, reporting each blob with one of its paths")
+	ref := fs.String("ref", "HEAD", "The branch, tag or commit whose tree is scanned")
+	all := fs.Bool("all-files", false, "Scan every blob instead of only binary files and scripts")
+	maxSize := int64(100 << 20)
+	fs.Var((*byteSizeFlag)(&maxSize), "max-file-size", "Skip blobs larger than this size, e.g. 100M. 0 for no limit.")
+	return func(args []string) {
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "Please specify the path or URL of the git repository to scan\n")
+			os.Exit(1)
+		}
+		dir, err := os.MkdirTemp("", "infinigo-repo")
+		check(err)
+		repo := args[0]
+		if _, err := os.Stat(repo); err != nil {
+			clone := []string{"clone", "--bare", "--quiet"}
+			if *history {
+				clone[1] = "--mirror"
+			} else {
+				clone = append(clone, "--depth", "1")
+			}
+			if *ref != "HEAD" && !*history {
+				clone = append(clone, "--branch", *ref)
+				*ref = "HEAD"
+			}
+			infof("Cloning %s", repo)
+			cmd := exec.Command("git", append(clone, repo, dir+"/git")...)
+			cmd.Stderr = os.Stderr
+			check(cmd.Run())
+			repo = dir + "/git"
+		}
+		blobs, err := listBlobs(repo, *ref, *history)
+		check(err)
+		infof("Listed %d blobs", len(blobs))
+		entries, err := extractBlobs(repo, blobs, dir, maxSize, *all)
+		check(err)
+		infof("Extracted %d binary blobs", len(entries))
+		inf := newClient()
+		if len(entries) > 0 {
+			var hashes []string
+			paths := make(map[string]string, len(entries))
+			for _, e := range entries {
+				if _, ok := paths[e.Hash]; !ok {
+					hashes = append(hashes, e.Hash)
+					paths[e.Hash] = e.Path
+				}
+			}
+			s := openDB()
+			res, err := queryCached(inf, s, hashes, paths)
+			enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddQuery("", hashes...) })
+			if s != nil {
+				check(saveDB(s))
+			}
+			notify(res, paths)
+			closeSinks()
+			for i := range entries {
+				entries[i].Response = res[entries[i].Hash]
+			}
+		}
+		queued := false
+		if *upload {
+			uploaded := make(map[string]bool, len(entries))
+			for _, e := range entries {
+				if e.Response.ConfirmCode == "" || uploaded[e.Hash] {
+					continue
+				}
+				uploaded[e.Hash] = true
+				infof("Uploading %s (%d bytes)", e.Path, e.Size)
+				_, err := inf.UploadFile(e.Response.ConfirmCode, e.file)
+				if err != nil && infinigo.Unreachable(err) {
+					queued = true
+				}
+				enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddUpload(e.Response.ConfirmCode, e.file) })
+				if err == nil {
+					fmt.Fprintf(os.Stderr, "Uploaded %s\n", e.Path)
+				}
+			}
+		}
+		if queued {
+			fmt.Fprintf(os.Stderr, "Keeping the extracted blobs in %s for the queued uploads\n", dir)
+		} else {
+			os.RemoveAll(dir)
+		}
+		matched := make([]repoEntry, 0, len(entries))
+		for _, e := range entries {
+			if matchClassifiers(e.Response) {
+				matched = append(matched, e)
+			}
+		}
+		entries = matched
+		results := make([]pipeline.Result, len(entries))
+		for i, e := range entries {
+			results[i] = pipeline.Result{Submission: pipeline.Submission{Hash: e.Hash, Path: e.Path}, Response: e.Response}
+		}
+		if printFormatted(results) {
+			return
+		}
+		if jsonFormat {
+			printJSON(entries)
+			return
+		}
+		for _, e := range entries {
+			fmt.Printf("%s\t%s\t%s\t%s\t%v\n", e.Blob[:12], e.Path, e.Hash, e.Response.Verdict(), e.Response.GeneralScore)
+		}
+	}
+}
+
+// listBlobs lists the blobs of the tree of the ref, or of every commit reachable from a branch or tag,
+// once per blob and path
+func listBlobs(repo, ref string, history bool) ([]repoBlob, error) {
+	var objects []repoBlob
+	if history {
+		out, err := exec.Command("git", "-C", repo, "rev-list", "--objects", "--all").Output()
+		if err != nil {
+			return nil, gitError(err)
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			// Commits have no path, and trees are told apart by their type below
+			if id, p, ok := strings.Cut(line, " "); ok && p != "" {
+				objects = append(objects, repoBlob{id: id, path: p})
+			}
+		}
+	} else {
+		out, err := exec.Command("git", "-C", repo, "ls-tree", "-r", "-z", "--full-tree", ref).Output()
+		if err != nil {
+			return nil, gitError(err)
+		}
+		for _, line := range strings.Split(string(out), "\x00") {
+			// <mode> SP <type> SP <id> TAB <path>, symbolic links have mode 120000 and submodules type commit
+			meta, p, ok := strings.Cut(line, "\t")
+			if fields := strings.Fields(meta); ok && len(fields) == 3 && fields[1] == "blob" && fields[0] != "120000" {
+				objects = append(objects, repoBlob{id: fields[2], path: p})
+			}
+		}
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+	// Find the types and sizes of the objects
+	cmd := exec.Command("git", "-C", repo, "cat-file", "--batch-check=%(objecttype) %(objectsize)")
+	var ids bytes.Buffer
+	for _, o := range objects {
+		ids.WriteString(o.id + "\n")
+	}
+	cmd.Stdin = &ids
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, gitError(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(lines) != len(objects) {
+		return nil, fmt.Errorf("git cat-file described %d of %d objects", len(lines), len(objects))
+	}
+	blobs := objects[:0]
+	for i, line := range lines {
+		typ, size, _ := strings.Cut(line, " ")
+		if typ != "blob" {
+			continue
+		}
+		objects[i].size, _ = strconv.ParseInt(size, 10, 64)
+		blobs = append(blobs, objects[i])
+	}
+	return blobs, nil
+}
+
+// extractBlobs extracts the binary blobs to files in dir and hashes them
+func extractBlobs(repo string, blobs []repoBlob, dir string, maxSize int64, all bool) ([]repoEntry, error) {
+	cmd := exec.Command("git", "-C", repo, "cat-file", "--batch")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+	r := bufio.NewReaderSize(stdout, binarySniffLen)
+	extracted := make(map[string]repoEntry, len(blobs))
+	var entries []repoEntry
+	for _, b := range blobs {
+		if maxSize > 0 && b.size > maxSize {
+			fmt.Fprintf(os.Stderr, "Skipped %s (%d bytes): larger than %d bytes\n", b.path, b.size, maxSize)
+			continue
+		}
+		if e, ok := extracted[b.id]; ok {
+			if e.file != "" {
+				e.Path = b.path
+				entries = append(entries, e)
+			}
+			continue
+		}
+		if _, err = io.WriteString(stdin, b.id+"\n"); err != nil {
+			return nil, err
+		}
+		// <id> SP <type> SP <size> LF <content> LF
+		if _, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		content := io.LimitReader(r, b.size)
+		head, _ := r.Peek(int(min(b.size, binarySniffLen)))
+		e := repoEntry{Blob: b.id, Path: b.path}
+		if all || isBinary(head) {
+			f, err := os.CreateTemp(dir, "blob-*")
+			if err != nil {
+				return nil, err
+			}
+			h := sha256.New()
+			e.Size, err = io.Copy(io.MultiWriter(f, h), content)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return nil, err
+			}
+			e.Hash, e.file = hex.EncodeToString(h.Sum(nil)), f.Name()
+			entries = append(entries, e)
+		}
+		if _, err = io.Copy(io.Discard, content); err != nil {
+			return nil, err
+		}
+		if _, err = r.Discard(1); err != nil {
+			return nil, err
+		}
+		extracted[b.id] = e
+	}
+	return entries, nil
+}
+
+// gitError returns the error of a git command with what it printed
+func gitError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("git failed - %s", strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
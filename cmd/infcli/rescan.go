@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/store"
+)
+
+// change describes a hash whose verdict or score changed between two queries
+type change struct {
+	Hash     string                 `json:"hash"`
+	Previous infinigo.QueryResponse `json:"previous"`
+	Current  infinigo.QueryResponse `json:"current"`
+}
+
+// rescan re-queries known hashes and reports only the ones whose verdict or score changed
+func rescan(fs *flag.FlagSet) func(args []string) {
+	clientFlags(fs)
+	sinkFlags(fs)
+	in := fs.String("i", "", "A prior JSON result file (as printed with -json) to rescan instead of the local database")
+	tag := fs.String("tag", "", "Only rescan hashes with the given tag in the local database")
+	return func(args []string) {
+
+		s := openDB()
+		previous := make(map[string]infinigo.QueryResponse)
+		if *in != "" {
+			f, err := os.Open(*in)
+			check(err)
+			err = json.NewDecoder(f).Decode(&previous)
+			f.Close()
+			check(err)
+		} else {
+			if s == nil {
+				fmt.Fprintf(os.Stderr, "Either a local database or a prior result file is required\n")
+				os.Exit(1)
+			}
+			records, err := s.ListTagged(context.Background(), *tag)
+			check(err)
+			for _, r := range records {
+				previous[r.Hash] = r.Response
+			}
+		}
+		if len(previous) == 0 {
+			fmt.Fprintf(os.Stderr, "Nothing to rescan\n")
+			return
+		}
+		hashes := make([]string, 0, len(previous))
+		for k := range previous {
+			hashes = append(hashes, k)
+		}
+		sort.Strings(hashes)
+
+		res, err := newClient().QueryAll("", hashes...)
+		check(err)
+		record(s, res)
+		notify(res, nil)
+		closeSinks()
+
+		changes := []change{}
+		for _, h := range hashes {
+			cur, ok := res[h]
+			if !ok {
+				continue
+			}
+			if prev := previous[h]; store.Changed(prev, cur) {
+				changes = append(changes, change{Hash: h, Previous: prev, Current: cur})
+			}
+		}
+		if jsonFormat {
+			printJSON(changes)
+			return
+		}
+		for _, ch := range changes {
+			fmt.Printf("%s\t%s (%v) -> %s (%v)\n", ch.Hash, ch.Previous.Verdict(), ch.Previous.GeneralScore, ch.Current.Verdict(), ch.Current.GeneralScore)
+		}
+		fmt.Fprintf(os.Stderr, "%d of %d hashes changed\n", len(changes), len(hashes))
+	}
+}
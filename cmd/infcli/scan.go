@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// scanEntry is the result of scanning a single file
+type scanEntry struct {
+	Path     string                 `json:"path"`
+	Hash     string                 `json:"hash"`
+	Size     int64                  `json:"size"`
+	Response infinigo.QueryResponse `json:"response"`
+	remote   *shareFile             // remote is the file of a network share the entry was found in
+}
+
+// scan hashes the files under the given paths and network shares, queries them and optionally uploads unknown ones
+func scan(fs *flag.FlagSet) func(args []string) {
+	clientFlags(fs)
+	cacheFlags(fs)
+	classifierFlags(fs)
+	sinkFlags(fs)
+	formatFlags(fs)
+	upload := fs.Bool("upload", false, "Upload files Infinity requests a confirmation for")
+	baseline := fs.String("baseline", "", "Compare the scan against the given baseline file")
+	writeBaseline := fs.String("write-baseline", "", "Store the scan as a baseline in the given file")
+	var opts walkOptions
+	fs.BoolVar(&opts.streams, "ads", false, "Also scan alternate data streams (Windows only)")
+	fs.BoolVar(&opts.follow, "follow-symlinks", false, "Follow symbolic links (and junctions on Windows), skipping cycles and files already visited")
+	fs.IntVar(&opts.maxInodes, "max-inodes", 1000000, "Stop after visiting this many files and directories. 0 for no limit.")
+	fs.Var((*byteSizeFlag)(&opts.maxSize), "max-file-size", "Skip files larger than this size, e.g. 100M. 0 for no limit.")
+	shareOpts := shareFlags(fs)
+	return func(args []string) {
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "Please specify the paths to scan, which can be SMB shares such as \\\\server\\share\\path or NFS exports such as server:/export/path\n")
+			os.Exit(1)
+		}
+
+		local, locations := splitShares(args)
+		entries, skipped := walk(local, opts)
+		for _, location := range locations {
+			infof("Connecting to %s", location)
+			s, err := shareOpts.open(location)
+			if err != nil {
+				skipped = append(skipped, skippedFile{Path: location, Reason: err.Error()})
+				continue
+			}
+			defer s.Close()
+			shareEntries, shareSkipped := walkShare(s, opts)
+			entries, skipped = append(entries, shareEntries...), append(skipped, shareSkipped...)
+		}
+		infof("Hashed %d files", len(entries))
+		for _, sk := range skipped {
+			if sk.Size > 0 {
+				fmt.Fprintf(os.Stderr, "Skipped %s (%d bytes): %s\n", sk.Path, sk.Size, sk.Reason)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "Skipped %s: %s\n", sk.Path, sk.Reason)
+		}
+		if len(skipped) > 0 {
+			fmt.Fprintf(os.Stderr, "Skipped %d files\n", len(skipped))
+		}
+		inf := newClient()
+		if len(entries) > 0 {
+			hashes := make([]string, len(entries))
+			paths := make(map[string]string, len(entries))
+			for i := range entries {
+				hashes[i] = entries[i].Hash
+				paths[entries[i].Hash] = entries[i].Path
+			}
+			s := openDB()
+			res, err := queryCached(inf, s, hashes, paths)
+			enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddQuery("", hashes...) })
+			if s != nil {
+				check(saveDB(s))
+			}
+			notify(res, paths)
+			closeSinks()
+			for i := range entries {
+				entries[i].Response = res[entries[i].Hash]
+			}
+		}
+		if *upload {
+			// Files of network shares are copied locally first, and kept if their upload is queued
+			dir, queued := "", false
+			for _, e := range entries {
+				if e.Response.ConfirmCode == "" {
+					continue
+				}
+				infof("Uploading %s (%d bytes)", e.Path, e.Size)
+				file := e.Path
+				if e.remote != nil {
+					var err error
+					if dir == "" {
+						dir, err = os.MkdirTemp("", "infinigo-share")
+						check(err)
+					}
+					if file, err = e.remote.copyTo(dir); err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to copy %s - %v\n", e.Path, err)
+						continue
+					}
+				}
+				_, err := inf.UploadFile(e.Response.ConfirmCode, file)
+				if err != nil && infinigo.Unreachable(err) && e.remote != nil {
+					queued = true
+				}
+				enqueueOnError(err, func(queue *infinigo.Queue) error { return queue.AddUpload(e.Response.ConfirmCode, file) })
+				if err == nil {
+					fmt.Fprintf(os.Stderr, "Uploaded %s\n", e.Path)
+				}
+			}
+			if queued {
+				fmt.Fprintf(os.Stderr, "Keeping the copied files in %s for the queued uploads\n", dir)
+			} else if dir != "" {
+				os.RemoveAll(dir)
+			}
+		}
+		if *writeBaseline != "" {
+			check(saveBaseline(*writeBaseline, entries))
+		}
+		if *baseline != "" {
+			base, err := loadBaseline(*baseline)
+			check(err)
+			printBaselineDiff(diffBaseline(base, entries))
+			return
+		}
+		matched := entries[:0]
+		for _, e := range entries {
+			if matchClassifiers(e.Response) {
+				matched = append(matched, e)
+			}
+		}
+		entries = matched
+		results := make([]pipeline.Result, len(entries))
+		for i, e := range entries {
+			results[i] = pipeline.Result{Submission: pipeline.Submission{Hash: e.Hash, Path: e.Path}, Response: e.Response}
+		}
+		if printFormatted(results) {
+			return
+		}
+		if jsonFormat {
+			printJSON(entries)
+			return
+		}
+		var names []string
+		if classifierColumns {
+			responses := make([]infinigo.QueryResponse, len(entries))
+			for i := range entries {
+				responses[i] = entries[i].Response
+			}
+			names = classifierNames(responses)
+			fmt.Printf("path\thash\tverdict\tscore\t%s\n", strings.Join(names, "\t"))
+		}
+		for _, e := range entries {
+			if classifierColumns {
+				fmt.Printf("%s\t%s\t%s\t%v\t%s\n", e.Path, e.Hash, e.Response.Verdict(), e.Response.GeneralScore, classifierCells(e.Response, names))
+				continue
+			}
+			fmt.Printf("%s\t%s\t%s\t%v\n", e.Path, e.Hash, e.Response.Verdict(), e.Response.GeneralScore)
+		}
+	}
+}
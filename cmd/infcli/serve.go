@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/rpc"
+	"github.com/demisto/infinigo/server"
+	notifyrules "github.com/demisto/infinigo/sink/notify"
+	"github.com/demisto/infinigo/store"
+	"google.golang.org/grpc"
+)
+
+// serve runs a caching and rate limiting HTTP proxy in front of Infinity, and optionally gRPC, ICAP and milter services
+func serve(fs *flag.FlagSet) func(args []string) {
+	clientFlags(fs)
+	cacheTTL = durationFlag(server.DefaultCacheTTL)
+	unknownTTL = durationFlag(server.DefaultUnknownCacheTTL)
+	cacheFlags(fs)
+	listen := fs.String("listen", "127.0.0.1:8080", "The address to listen on")
+	cacheSize := fs.Int("cache-size", server.DefaultMaxCacheEntries, "The maximum number of cached responses")
+	rate := fs.Float64("rate", 10, "The maximum number of requests per second sent to Infinity. 0 for no limit.")
+	burst := fs.Int("burst", 20, "The number of requests allowed to exceed the rate in bursts")
+	maxUpload := byteSizeFlag(server.DefaultMaxUploadSize)
+	fs.Var(&maxUpload, "max-upload-size", "The largest upload accepted, e.g. 100M")
+	grpcListen := fs.String("grpc-listen", "", "Also serve the gRPC service on this address")
+	icapListen := fs.String("icap-listen", "", "Also serve the ICAP service on this address, e.g. 127.0.0.1:1344")
+	milterListen := fs.String("milter-listen", "", "Also serve the milter for mail servers on this address, e.g. 127.0.0.1:8891")
+	milterTimeout := durationFlag(server.DefaultMilterTimeout)
+	fs.Var(&milterTimeout, "milter-timeout", "How long the milter waits for the verdicts of the attachments of a message")
+	milterFallback := newChoiceFlag("accept", "quarantine", "reject")
+	fs.Var(milterFallback, "milter-fallback", "What the milter does with messages it could not check in time: "+milterFallback.choices())
+	var milterActions stringsFlag
+	fs.Var(&milterActions, "milter-action", "What the milter does with messages with an attachment with a verdict, e.g. suspicious=reject. Can be repeated. Defaults to malicious=reject and suspicious=quarantine.")
+	submitTimeout := durationFlag(server.DefaultSubmitTimeout)
+	fs.Var(&submitTimeout, "submit-timeout", "How long /submit waits for the verdict of uploaded files")
+	adminToken := fs.String("admin-token", os.Getenv("INFINITY_ADMIN_TOKEN"), "Enables the admin API for this bearer token. Can be provided as an environment variable INFINITY_ADMIN_TOKEN.")
+	pprofListen := fs.String("pprof-listen", "", "Serve the Go profiler under /debug/pprof/ on this address. Keep it on a private address.")
+	var clientTokens stringsFlag
+	fs.Var(&clientTokens, "client-token", "Require clients to authenticate, allowing a token given as name:token:scopes[:tenant] where scopes are query, upload or query,upload. Can be repeated.")
+	tokenFile := fs.String("client-token-file", "", "Require clients to authenticate with the tokens in this JSON file, with optional daily quotas, reloaded when it changes")
+	tenantFile := fs.String("tenant-file", "", "A JSON file with the names, API keys and optional daily quotas of tenants client tokens can belong to")
+	var icapBlock stringsFlag
+	fs.Var(&icapBlock, "icap-block", "A verdict the ICAP service blocks - malicious, suspicious, unknown or error. Can be repeated. Defaults to malicious.")
+	return func(args []string) {
+		if len(icapBlock) == 0 {
+			icapBlock = stringsFlag{string(infinigo.VerdictMalicious)}
+		}
+		verdicts := make([]infinigo.Verdict, len(icapBlock))
+		for i, v := range icapBlock {
+			verdicts[i] = infinigo.Verdict(v)
+			switch verdicts[i] {
+			case infinigo.VerdictMalicious, infinigo.VerdictSuspicious, infinigo.VerdictUnknown, infinigo.VerdictError:
+			default:
+				fmt.Fprintf(os.Stderr, "Unsupported verdict [%s] for -icap-block\n", v)
+				os.Exit(1)
+			}
+		}
+		var tokens []server.Token
+		for _, ct := range clientTokens {
+			parts := strings.Split(ct, ":")
+			if len(parts) != 3 && len(parts) != 4 {
+				fmt.Fprintf(os.Stderr, "Client token [%s] should be name:token:scopes[:tenant]\n", ct)
+				os.Exit(1)
+			}
+			tok := server.Token{Name: parts[0], Token: parts[1], Scopes: strings.Split(parts[2], ",")}
+			if len(parts) == 4 {
+				tok.Tenant = parts[3]
+			}
+			tokens = append(tokens, tok)
+		}
+		options := []server.OptionFunc{
+			server.SetCache(time.Duration(cacheTTL), time.Duration(unknownTTL), *cacheSize),
+			server.SetRateLimit(*rate, *burst),
+			server.SetMaxUploadSize(int64(maxUpload)),
+			server.SetBlockVerdicts(verdicts...),
+			server.SetMilterTimeout(time.Duration(milterTimeout), milterAction(milterFallback.String())),
+			server.SetSubmitWait(infinigo.DefaultPollInterval, time.Duration(submitTimeout)),
+			server.SetQueue(queuePath),
+			server.SetAdminToken(*adminToken),
+			server.SetTokens(tokens...),
+			server.SetErrorLog(newLogger()),
+		}
+		if s := openDB(); s != nil {
+			options = append(options, server.SetSink(store.Sink(s)))
+		}
+		for _, a := range milterActions {
+			verdict, action, err := notifyrules.ParseRoute(a)
+			check(err)
+			options = append(options, server.SetMilterAction(verdict, milterAction(action)))
+		}
+		if *tokenFile != "" {
+			options = append(options, server.SetTokenFile(*tokenFile))
+		}
+		if *tenantFile != "" {
+			tenants, err := server.LoadTenants(*tenantFile)
+			check(err)
+			options = append(options, server.SetTenants(tenants...))
+		}
+		inf := newClient()
+		srv, err := server.New(inf, options...)
+		check(err)
+		var gs *grpc.Server
+		if *grpcListen != "" {
+			svc, err := rpc.New(inf, rpc.SetMaxUploadSize(int64(maxUpload)), rpc.SetAuthorizer(srv.AuthorizeClient), rpc.SetMeter(srv))
+			check(err)
+			l, err := net.Listen("tcp", *grpcListen)
+			check(err)
+			gs = grpc.NewServer()
+			rpc.RegisterInfinityServer(gs, svc)
+			infof("Serving gRPC on %s", *grpcListen)
+			go func() { check(gs.Serve(l)) }()
+		}
+		var icap net.Listener
+		if *icapListen != "" {
+			icap, err = net.Listen("tcp", *icapListen)
+			check(err)
+			infof("Serving ICAP on %s", *icapListen)
+			go srv.ServeICAP(icap)
+		}
+		var milter net.Listener
+		if *milterListen != "" {
+			milter, err = net.Listen("tcp", *milterListen)
+			check(err)
+			infof("Serving the milter on %s", *milterListen)
+			go srv.ServeMilter(milter)
+		}
+		if *pprofListen != "" {
+			infof("Serving pprof on %s", *pprofListen)
+			go servePprof(*pprofListen)
+		}
+		hs := &http.Server{Addr: *listen, Handler: srv, ErrorLog: newLogger()}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if icap != nil {
+				icap.Close()
+			}
+			if milter != nil {
+				milter.Close()
+			}
+			if gs != nil {
+				gs.GracefulStop()
+			}
+			hs.Shutdown(shutdown)
+		}()
+		infof("Listening on %s", *listen)
+		if err := hs.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			check(err)
+		}
+	}
+}
+
+// milterAction returns the milter action with the name, exiting if it is unknown
+func milterAction(name string) server.MilterAction {
+	action, err := server.ParseMilterAction(name)
+	check(err)
+	return action
+}
+
+// servePprof serves the profiling endpoints on their own address so they are never exposed with the proxy
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	check(http.ListenAndServe(addr, mux))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/demisto/infinigo/share"
+)
+
+// shareOptions are the flags of connecting to network shares
+type shareOptions struct {
+	user, password, domain string
+	credentials            string
+	uid, gid               uint
+}
+
+// shareFlags adds the flags of connecting to network shares
+func shareFlags(fs *flag.FlagSet) *shareOptions {
+	o := &shareOptions{}
+	fs.StringVar(&o.user, "share-user", "", `The user name of SMB shares, can be given as DOMAIN\user`)
+	fs.StringVar(&o.password, "share-password", os.Getenv("SHARE_PASSWORD"), "The password of SMB shares. Can be provided as an environment variable SHARE_PASSWORD.")
+	fs.StringVar(&o.domain, "share-domain", "", "The domain of the SMB user")
+	fs.StringVar(&o.credentials, "share-credentials", "", "A credentials file of SMB shares in the format of mount.cifs (username=, password= and domain= lines)")
+	fs.UintVar(&o.uid, "nfs-uid", 65534, "The user ID NFS exports are read as")
+	fs.UintVar(&o.gid, "nfs-gid", 65534, "The group ID NFS exports are read as")
+	return o
+}
+
+// open connects to the share at the location
+func (o *shareOptions) open(location string) (*share.Share, error) {
+	c := share.Credentials{User: o.user, Password: o.password, Domain: o.domain}
+	if o.credentials != "" {
+		var err error
+		if c, err = share.LoadCredentials(o.credentials); err != nil {
+			return nil, err
+		}
+	}
+	if domain, user, ok := strings.Cut(c.User, `\`); ok && c.Domain == "" {
+		c.Domain, c.User = domain, user
+	}
+	return share.Open(context.Background(), location, share.SetCredentials(c), share.SetIdentity(uint32(o.uid), uint32(o.gid)))
+}
+
+// shareFile is a file of a network share
+type shareFile struct {
+	share *share.Share
+	name  string
+}
+
+// copyTo copies the file to a file in dir, returning its path
+func (f *shareFile) copyTo(dir string) (string, error) {
+	src, err := f.share.Open(f.name)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := os.CreateTemp(dir, "file-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return dst.Name(), err
+}
+
+// walkShare hashes the regular files of the share. Files that cannot be read are reported as skipped,
+// and symbolic links are not followed since their targets are resolved by the server.
+func walkShare(s *share.Share, opts walkOptions) ([]scanEntry, []skippedFile) {
+	var entries []scanEntry
+	var skipped []skippedFile
+	visits, capped := 0, false
+	fs.WalkDir(s, ".", func(name string, d fs.DirEntry, err error) error {
+		p := s.Name(name)
+		if err != nil {
+			skipped = append(skipped, skippedFile{Path: p, Reason: err.Error()})
+			return nil
+		}
+		if opts.maxInodes > 0 && visits >= opts.maxInodes {
+			if !capped {
+				capped = true
+				skipped = append(skipped, skippedFile{Path: p, Reason: "limit of visited files reached, remaining files not scanned"})
+			}
+			return fs.SkipAll
+		}
+		visits++
+		if d.Type()&fs.ModeSymlink != 0 {
+			skipped = append(skipped, skippedFile{Path: p, Reason: "symbolic link not followed"})
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			skipped = append(skipped, skippedFile{Path: p, Reason: err.Error()})
+			return nil
+		}
+		if opts.maxSize > 0 && info.Size() > opts.maxSize {
+			skipped = append(skipped, skippedFile{Path: p, Size: info.Size(), Reason: "larger than the maximum file size"})
+			return nil
+		}
+		hash, err := hashShareFile(s, name)
+		if err != nil {
+			skipped = append(skipped, skippedFile{Path: p, Reason: err.Error()})
+			return nil
+		}
+		entries = append(entries, scanEntry{Path: p, Hash: hash, Size: info.Size(), remote: &shareFile{share: s, name: name}})
+		return nil
+	})
+	return entries, skipped
+}
+
+// hashShareFile returns the SHA256 of the file of the share
+func hashShareFile(s *share.Share, name string) (string, error) {
+	f, err := s.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// splitShares splits the paths to scan into local paths and network share locations
+func splitShares(paths []string) (local, shares []string) {
+	for _, p := range paths {
+		if _, ok := share.ParseLocation(p); ok {
+			if _, err := os.Lstat(p); err != nil {
+				shares = append(shares, p)
+				continue
+			}
+		}
+		local = append(local, p)
+	}
+	return local, shares
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tag attaches tags and notes to hashes in the local database
+func tag(fs *flag.FlagSet) func(args []string) {
+	dbFlags(fs)
+	var tags, untags stringsFlag
+	fs.Var(&tags, "tag", "Tag to add. Can be repeated.")
+	fs.Var(&untags, "untag", "Tag to remove. Can be repeated.")
+	note := fs.String("note", "", "A note to attach")
+	return func(hashes []string) {
+		if len(hashes) == 0 || len(tags) == 0 && len(untags) == 0 && *note == "" {
+			fmt.Fprintf(os.Stderr, "Usage: %s tag <hash>... [-tag tag] [-untag tag] [-note note]\n", os.Args[0])
+			os.Exit(1)
+		}
+		s := mustOpenDB()
+		ctx := context.Background()
+		for _, h := range hashes {
+			check(s.AddTags(ctx, h, tags, *note))
+			check(s.RemoveTags(ctx, h, untags))
+		}
+		check(saveDB(s))
+		for _, h := range hashes {
+			r, _, err := s.GetByHash(ctx, h)
+			check(err)
+			fmt.Printf("%s\t%s\n", r.Hash, strings.Join(r.Tags, ","))
+		}
+	}
+}
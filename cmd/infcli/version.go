@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/demisto/infinigo"
+)
+
+// Build metadata, set at build time with:
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// When not set, the commit and date are taken from the VCS information embedded by the go tool if available.
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo is the version information printed by the version command
+type buildInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit"`
+	Date       string `json:"date"`
+	APIVersion string `json:"api_version"`
+	GoVersion  string `json:"go_version"`
+	Platform   string `json:"platform"`
+}
+
+// currentBuildInfo returns the build metadata, falling back to the embedded VCS information
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:    version,
+		Commit:     commit,
+		Date:       date,
+		APIVersion: infinigo.APIVersion,
+		GoVersion:  runtime.Version(),
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.Date == "":
+				info.Date = s.Value
+			}
+		}
+	}
+	return info
+}
+
+// versionCmd prints the version and build metadata
+func versionCmd(fs *flag.FlagSet) func(args []string) {
+	fs.BoolVar(&jsonFormat, "json", false, "Should we print replies as JSON or formatted")
+	return func(args []string) {
+		info := currentBuildInfo()
+		if jsonFormat {
+			printJSON(info)
+			return
+		}
+		fmt.Printf("infcli %s\ncommit:\t\t%s\nbuilt:\t\t%s\napi version:\t%s\ngo:\t\t%s %s\n",
+			info.Version, info.Commit, info.Date, info.APIVersion, info.GoVersion, info.Platform)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// skippedFile is a file the scan could not process
+type skippedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// hashFile returns the SHA256 of the file at path
+func hashFile(path string) (string, error) {
+	r := sha256Hasher().File(context.Background(), path)
+	return r.SHA256, r.Err
+}
+
+// walkOptions control how walk traverses the file system
+type walkOptions struct {
+	streams   bool  // streams hashes alternate data streams as separate entries where supported
+	follow    bool  // follow symbolic links (and junctions on Windows)
+	maxInodes int   // maxInodes caps the number of visited files and directories, 0 for no limit
+	maxSize   int64 // maxSize skips files larger than this many bytes, 0 for no limit
+}
+
+// fileKey uniquely identifies a file on the system, regardless of the path used to reach it
+type fileKey struct {
+	dev uint64
+	ino uint64
+}
+
+// walker traverses directory trees guarding against link cycles
+type walker struct {
+	walkOptions
+	visits int
+	capped bool
+	seen   map[fileKey]string
+	onFile func(path string, info os.FileInfo)
+	skip   func(path, reason string)
+}
+
+// visit processes a path given its Lstat info, descending into directories
+func (w *walker) visit(path string, info os.FileInfo, root bool) {
+	if w.maxInodes > 0 && w.visits >= w.maxInodes {
+		if !w.capped {
+			w.capped = true
+			w.skip(path, "limit of visited files reached, remaining files not scanned")
+		}
+		return
+	}
+	w.visits++
+	if kind := linkKind(info); kind != "" {
+		// Links given explicitly as roots are always followed
+		if !w.follow && !root {
+			w.skip(path, kind+" not followed")
+			return
+		}
+		target, err := os.Stat(path)
+		if err != nil {
+			w.skip(path, "broken "+kind+": "+errorReason(err))
+			return
+		}
+		info = target
+	}
+	if w.follow {
+		if id, ok := fileID(path, info); ok {
+			if first, dup := w.seen[id]; dup {
+				w.skip(path, "already visited as "+first)
+				return
+			}
+			w.seen[id] = path
+		}
+	}
+	if info.IsDir() {
+		children, err := os.ReadDir(path)
+		if err != nil {
+			w.skip(path, errorReason(err))
+			return
+		}
+		for _, child := range children {
+			childPath := filepath.Join(path, child.Name())
+			childInfo, err := os.Lstat(childPath)
+			if err != nil {
+				w.skip(childPath, errorReason(err))
+				continue
+			}
+			w.visit(childPath, childInfo, false)
+		}
+		return
+	}
+	if info.Mode().IsRegular() {
+		w.onFile(path, info)
+	}
+}
+
+// walk hashes all the regular files under the given paths, several at once once the paths are walked.
+// Files that cannot be processed are reported as skipped with the reason instead of aborting the walk.
+func walk(paths []string, opts walkOptions) ([]scanEntry, []skippedFile) {
+	var entries, pending []scanEntry
+	var skipped []skippedFile
+	skip := func(path, reason string) {
+		skipped = append(skipped, skippedFile{Path: path, Reason: reason})
+	}
+	add := func(path string, size int64) {
+		if opts.maxSize > 0 && size > opts.maxSize {
+			skipped = append(skipped, skippedFile{Path: path, Size: size, Reason: "larger than the maximum file size"})
+			return
+		}
+		pending = append(pending, scanEntry{Path: path, Size: size})
+	}
+	w := &walker{walkOptions: opts, seen: make(map[fileKey]string), skip: skip}
+	w.onFile = func(path string, info os.FileInfo) {
+		add(path, info.Size())
+		if !opts.streams {
+			return
+		}
+		ads, err := alternateStreams(path)
+		if err != nil {
+			skip(path, "listing streams: "+errorReason(err))
+		}
+		for _, s := range ads {
+			add(path+s.name, s.size)
+		}
+	}
+	for _, root := range paths {
+		info, err := os.Lstat(root)
+		if err != nil {
+			skip(root, errorReason(err))
+			continue
+		}
+		w.visit(root, info, true)
+	}
+	files := make([]string, len(pending))
+	for i, e := range pending {
+		files[i] = e.Path
+	}
+	for i, r := range sha256Hasher().Files(context.Background(), files) {
+		if r.Err != nil {
+			skip(r.Path, errorReason(r.Err))
+			continue
+		}
+		pending[i].Hash = r.SHA256
+		entries = append(entries, pending[i])
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, skipped
+}
+
+// stream is an alternate data stream of a file
+type stream struct {
+	name string // name including the leading colon, e.g. ":Zone.Identifier"
+	size int64
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// openFile opens a file for hashing or upload
+func openFile(path string) (*os.File, error) {
+	return os.Open(path)
+}
+
+// linkKind returns the kind of link for symbolic links or an empty string for other files
+func linkKind(info os.FileInfo) string {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return "symbolic link"
+	}
+	return ""
+}
+
+// fileID returns the device and inode of the file
+func fileID(path string, info os.FileInfo) (fileKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// errorReason describes an error accessing a file
+func errorReason(err error) string {
+	if os.IsPermission(err) {
+		return "access denied"
+	}
+	return err.Error()
+}
+
+// alternateStreams is only supported on Windows
+func alternateStreams(path string) ([]stream, error) {
+	return nil, nil
+}
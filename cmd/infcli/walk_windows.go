@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	errorHandleEOF        syscall.Errno = 38
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+	maxPath                             = 248 // The limit for directory paths, files are limited at 260
+)
+
+var (
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procFindFirstStreamW = kernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = kernel32.NewProc("FindNextStreamW")
+)
+
+// win32FindStreamData is WIN32_FIND_STREAM_DATA
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [syscall.MAX_PATH + 36]uint16
+}
+
+// extendedPath converts long paths to the \\?\ form which lifts the MAX_PATH limit
+func extendedPath(path string) string {
+	if len(path) < maxPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
+
+// openFile opens a file for hashing or upload, supporting long paths
+func openFile(path string) (*os.File, error) {
+	return os.Open(extendedPath(path))
+}
+
+// linkKind returns the kind of link for symbolic links and junctions (or other directory reparse points)
+// or an empty string for other files
+func linkKind(info os.FileInfo) string {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return "symbolic link"
+	}
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if ok && attrs.FileAttributes&syscall.FILE_ATTRIBUTE_REPARSE_POINT != 0 && info.IsDir() {
+		return "junction"
+	}
+	return ""
+}
+
+// fileID returns the volume serial number and file index of the file the path resolves to
+func fileID(path string, info os.FileInfo) (fileKey, bool) {
+	p, err := syscall.UTF16PtrFromString(extendedPath(path))
+	if err != nil {
+		return fileKey{}, false
+	}
+	h, err := syscall.CreateFile(p, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return fileKey{}, false
+	}
+	defer syscall.CloseHandle(h)
+	var d syscall.ByHandleFileInformation
+	if err = syscall.GetFileInformationByHandle(h, &d); err != nil {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(d.VolumeSerialNumber), ino: uint64(d.FileIndexHigh)<<32 | uint64(d.FileIndexLow)}, true
+}
+
+// errorReason describes an error accessing a file
+func errorReason(err error) string {
+	switch {
+	case errors.Is(err, errorSharingViolation), errors.Is(err, errorLockViolation):
+		return "locked by another process"
+	case os.IsPermission(err):
+		return "access denied"
+	}
+	return err.Error()
+}
+
+// alternateStreams lists the alternate data streams of the file, excluding the default one
+func alternateStreams(path string) ([]stream, error) {
+	p, err := syscall.UTF16PtrFromString(extendedPath(path))
+	if err != nil {
+		return nil, err
+	}
+	var data win32FindStreamData
+	h, _, e := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		if e == errorHandleEOF {
+			return nil, nil
+		}
+		return nil, e
+	}
+	defer syscall.FindClose(syscall.Handle(h))
+	var streams []stream
+	for {
+		// Stream names look like :name:$DATA with the default stream being ::$DATA
+		name := strings.TrimSuffix(syscall.UTF16ToString(data.StreamName[:]), ":$DATA")
+		if name != ":" {
+			streams = append(streams, stream{name: name, size: data.StreamSize})
+		}
+		r, _, e := procFindNextStreamW.Call(h, uintptr(unsafe.Pointer(&data)))
+		if r == 0 {
+			if e == errorHandleEOF {
+				return streams, nil
+			}
+			return streams, e
+		}
+	}
+}
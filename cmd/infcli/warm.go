@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// cacheWarm queries a list of hashes only to store their results ahead of an enrichment surge, in the
+// local database or in the cache of an infcli serve instance given as the URL
+func cacheWarm(fs *flag.FlagSet) func(args []string) {
+	clientFlags(fs)
+	cacheFlags(fs)
+	fs.Var(classifiers, "classifiers", "The classifiers to request - "+classifiers.choices())
+	in := fs.String("i", "", "A file with the hashes to warm, one per line or a checksum manifest as printed by the hash command. - for standard input.")
+	rate := fs.Float64("rate", 1, "The maximum number of queries per second, each of up to the batch size hashes. 0 for no limit.")
+	batchSize := fs.Int("batch", infinigo.QueryBatchSize, "The number of hashes sent per query")
+	state := fs.String("state", "", "The file recording how many hashes were warmed, so an interrupted run resumes where it stopped. Defaults to the input file with a .warm suffix.")
+	return func(args []string) {
+		if *in == "" {
+			fmt.Fprintf(os.Stderr, "Please specify the file with the hashes to warm with -i\n")
+			os.Exit(1)
+		}
+		if *batchSize < 1 {
+			fmt.Fprintf(os.Stderr, "The batch size must be positive\n")
+			os.Exit(1)
+		}
+		var r io.Reader = os.Stdin
+		if *in != "-" {
+			f, err := os.Open(*in)
+			check(err)
+			defer f.Close()
+			r = f
+			if *state == "" {
+				*state = *in + ".warm"
+			}
+		}
+		all, err := readHashesFrom(r)
+		check(err)
+		seen := make(map[string]bool, len(all))
+		hashes := all[:0]
+		for _, h := range all {
+			if h = strings.ToLower(h); !seen[h] {
+				seen[h] = true
+				hashes = append(hashes, h)
+			}
+		}
+		done := readWarmState(*state)
+		if done > len(hashes) {
+			done = 0
+		}
+		if done > 0 {
+			fmt.Fprintf(os.Stderr, "Resuming after %d of %d hashes\n", done, len(hashes))
+		}
+		inf := newClient()
+		s := openDB()
+		var tick <-chan time.Time
+		if *rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		checkpoint := func() {
+			if s != nil {
+				check(saveDB(s))
+			}
+			if *state != "" {
+				check(os.WriteFile(*state, []byte(strconv.Itoa(done)+"\n"), 0644))
+			}
+		}
+		for batches := 1; done < len(hashes); batches++ {
+			batch := hashes[done:min(done+*batchSize, len(hashes))]
+			if _, err := queryCached(inf, s, batch, nil); err != nil {
+				checkpoint()
+				fmt.Fprintf(os.Stderr, "Warmed %d of %d hashes, run again to resume - %v\n", done, len(hashes), err)
+				os.Exit(2)
+			}
+			done += len(batch)
+			infof("Warmed %d of %d hashes", done, len(hashes))
+			if batches%10 == 0 {
+				checkpoint()
+			}
+			if tick != nil && done < len(hashes) {
+				<-tick
+			}
+		}
+		if s != nil {
+			check(saveDB(s))
+		}
+		if *state != "" {
+			os.Remove(*state)
+		}
+		fmt.Fprintf(os.Stderr, "Warmed %d hashes\n", len(hashes))
+	}
+}
+
+// readWarmState returns the number of hashes a previous run warmed, 0 if there is none
+func readWarmState(path string) int {
+	if path == "" {
+		return 0
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return n
+}
@@ -0,0 +1,104 @@
+package infinigo
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxConfirmCodeLength bounds confirm codes, which are short tokens
+const maxConfirmCodeLength = 256
+
+// SetConfirmCodeFormat sets the pattern confirm codes must match, failing uploads with other codes
+// before the data is read. By default codes only need to be at most 256 printable ASCII characters,
+// other than a space and / ? # %, which would change the URL they are sent to.
+func SetConfirmCodeFormat(format *regexp.Regexp) OptionFunc {
+	return func(c *Client) error {
+		if format == nil {
+			err := &Error{ID: "bad_option", Details: "Confirm code format is required"}
+			c.errorf("%v\n", err)
+			return err
+		}
+		c.confirmFormat = format
+		return nil
+	}
+}
+
+// SetConfirmCodeExpiry fails uploads with a confirm code the client received in a query response longer
+// than expiry ago, as Infinity would reject them. Codes the client did not receive are not checked.
+// It is 0, no check, by default.
+func SetConfirmCodeExpiry(expiry time.Duration) OptionFunc {
+	return func(c *Client) error {
+		if expiry < 0 {
+			err := &Error{ID: "bad_option", Details: "Confirm code expiry cannot be negative"}
+			c.errorf("%v\n", err)
+			return err
+		}
+		c.confirmCodes = nil
+		if expiry > 0 {
+			c.confirmCodes = &confirmCodes{expiry: expiry, received: make(map[string]time.Time), prune: 1024}
+		}
+		return nil
+	}
+}
+
+// checkConfirmCode returns an InvalidConfirmCodeError if the code cannot be uploaded with
+func (c *Client) checkConfirmCode(code string) error {
+	if len(code) > maxConfirmCodeLength {
+		return &InvalidConfirmCodeError{Code: code, Reason: "it is longer than 256 characters"}
+	}
+	for i := 0; i < len(code); i++ {
+		if ch := code[i]; ch <= ' ' || ch >= 0x7f || strings.IndexByte("/?#%", ch) >= 0 {
+			return &InvalidConfirmCodeError{Code: code, Reason: "it has characters confirm codes do not have"}
+		}
+	}
+	if c.confirmFormat != nil && !c.confirmFormat.MatchString(code) {
+		return &InvalidConfirmCodeError{Code: code, Reason: "it does not match " + c.confirmFormat.String()}
+	}
+	if age, ok := c.confirmCodes.age(code); ok && age > c.confirmCodes.expiry {
+		return &InvalidConfirmCodeError{Code: code, Reason: "it was received " + age.Round(time.Millisecond).String() + " ago, past its expiry", Expired: true}
+	}
+	return nil
+}
+
+// confirmCodes remembers when the confirm codes were received, shared by the clones of a client
+type confirmCodes struct {
+	expiry   time.Duration
+	mu       sync.Mutex
+	received map[string]time.Time
+	prune    int // prune is the number of codes past which the expired ones are dropped
+}
+
+// add records the confirm code of the response, if any
+func (s *confirmCodes) add(r QueryResponse) {
+	if s == nil || r.ConfirmCode == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if _, ok := s.received[r.ConfirmCode]; !ok {
+		s.received[r.ConfirmCode] = now
+	}
+	if len(s.received) < s.prune {
+		return
+	}
+	for code, t := range s.received {
+		if now.Sub(t) > s.expiry {
+			delete(s.received, code)
+		}
+	}
+	s.prune = max(1024, 2*len(s.received))
+}
+
+// age returns how long ago the code was received, false if it was not
+func (s *confirmCodes) age(code string) (time.Duration, bool) {
+	if s == nil {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.received[code]
+	return time.Since(t), ok
+}
@@ -0,0 +1,340 @@
+package infinigo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// maxInterned caps the distinct strings interned while decoding a response
+const maxInterned = 256
+
+// responseBuffers hold the bodies of query responses while they are decoded
+var responseBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// queryDecoder decodes query responses without the reflection of encoding/json. It parses the body in
+// place, allocating the hashes and the values that differ across the entries, while the values
+// repeated, e.g. the status and the classifier names, share one copy. The body is checked with
+// json.Valid first, so the decoder only handles valid JSON and decodes it as json.Unmarshal does.
+type queryDecoder struct {
+	data   []byte
+	pos    int
+	intern map[string]string
+}
+
+// decodeQueryResponses decodes a JSON object of query responses keyed by hash into resp, creating the
+// map if needed. Null sets it to nil.
+func decodeQueryResponses(r io.Reader, resp *map[string]QueryResponse) error {
+	buf := responseBuffers.Get().(*bytes.Buffer)
+	defer responseBuffers.Put(buf)
+	buf.Reset()
+	if _, err := buf.ReadFrom(r); err != nil {
+		return err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return invalidJSON(buf.Bytes())
+	}
+	d := &queryDecoder{data: buf.Bytes(), intern: make(map[string]string)}
+	if d.null() {
+		*resp = nil
+		return nil
+	}
+	if *resp == nil {
+		*resp = make(map[string]QueryResponse)
+	}
+	return d.object("an object of responses", func(hash []byte) error {
+		var r QueryResponse
+		if err := d.response(&r); err != nil {
+			return err
+		}
+		(*resp)[string(hash)] = r
+		return nil
+	})
+}
+
+// response decodes a single response
+func (d *queryDecoder) response(r *QueryResponse) error {
+	if d.null() {
+		return nil
+	}
+	return d.object("a response object", func(key []byte) error {
+		// encoding/json matches the fields case insensitively
+		switch {
+		case equalFold(key, "status"):
+			return d.string(&r.Status, true)
+		case equalFold(key, "statuscode"):
+			return d.number(&r.StatusCode)
+		case equalFold(key, "error"):
+			return d.string(&r.Error, true)
+		case equalFold(key, "generalscore"):
+			return d.number(&r.GeneralScore)
+		case equalFold(key, "confirmcode"):
+			return d.string(&r.ConfirmCode, false)
+		case equalFold(key, "classifiers"):
+			return d.classifiers(r)
+		}
+		return d.skip()
+	})
+}
+
+// classifiers decodes the scores per classifier. Null clears them, as it does maps in encoding/json.
+func (d *queryDecoder) classifiers(r *QueryResponse) error {
+	if d.null() {
+		r.Classifiers = nil
+		return nil
+	}
+	if r.Classifiers == nil {
+		r.Classifiers = make(map[string]float32)
+	}
+	return d.object("an object of classifier scores", func(name []byte) error {
+		var score float32
+		if err := d.number(&score); err != nil {
+			return err
+		}
+		r.Classifiers[d.interned(name)] = score
+		return nil
+	})
+}
+
+// object decodes the members of an object, calling member with each key positioned on its value.
+// The key is only valid during the call.
+func (d *queryDecoder) object(expected string, member func(key []byte) error) error {
+	if d.peek() != '{' {
+		return d.unexpected(expected)
+	}
+	d.pos++
+	if d.peek() == '}' {
+		d.pos++
+		return nil
+	}
+	for {
+		if d.peek() != '"' {
+			return d.unexpected("a key")
+		}
+		key, err := d.str()
+		if err != nil {
+			return err
+		}
+		if d.peek() != ':' {
+			return d.unexpected("a colon")
+		}
+		d.pos++
+		if err = member(key); err != nil {
+			return err
+		}
+		switch d.peek() {
+		case ',':
+			d.pos++
+		case '}':
+			d.pos++
+			return nil
+		default:
+			return d.unexpected("a comma or the end of the object")
+		}
+	}
+}
+
+// string decodes a string value into s, interned if intern. Null leaves s as it is.
+func (d *queryDecoder) string(s *string, intern bool) error {
+	if d.null() {
+		return nil
+	}
+	if d.peek() != '"' {
+		return d.unexpected("a string")
+	}
+	v, err := d.str()
+	if err != nil {
+		return err
+	}
+	if intern {
+		*s = d.interned(v)
+	} else {
+		*s = string(v)
+	}
+	return nil
+}
+
+// number decodes a number value into f. Null leaves f as it is.
+func (d *queryDecoder) number(f *float32) error {
+	if d.null() {
+		return nil
+	}
+	d.peek()
+	start := d.pos
+	for d.pos < len(d.data) && isNumberByte(d.data[d.pos]) {
+		d.pos++
+	}
+	v, err := strconv.ParseFloat(string(d.data[start:d.pos]), 32)
+	if err != nil {
+		d.pos = start
+		return d.unexpected("a number")
+	}
+	*f = float32(v)
+	return nil
+}
+
+// str decodes the string at the position. Strings without escapes are returned in place, others are
+// unquoted by encoding/json, which also replaces invalid UTF-8.
+func (d *queryDecoder) str() ([]byte, error) {
+	start := d.pos
+	end, escaped := d.stringEnd()
+	if end < 0 {
+		d.pos = len(d.data)
+		return nil, d.unexpected("the end of the string")
+	}
+	d.pos = end + 1
+	if !escaped && utf8.Valid(d.data[start+1:end]) {
+		return d.data[start+1 : end], nil
+	}
+	var s string
+	if err := json.Unmarshal(d.data[start:d.pos], &s); err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+// stringEnd returns the index of the closing quote of the string at the position, -1 if there is none,
+// and whether the string has escapes
+func (d *queryDecoder) stringEnd() (int, bool) {
+	escaped := false
+	for i := d.pos + 1; i < len(d.data); i++ {
+		switch d.data[i] {
+		case '\\':
+			escaped = true
+			i++
+		case '"':
+			return i, escaped
+		}
+	}
+	return -1, escaped
+}
+
+// skip skips a value of any type
+func (d *queryDecoder) skip() error {
+	depth := 0
+	for {
+		switch d.peek() {
+		case '{', '[':
+			depth++
+			d.pos++
+		case '}', ']':
+			if depth == 0 {
+				return d.unexpected("a value")
+			}
+			depth--
+			d.pos++
+		case ',', ':':
+			if depth == 0 {
+				return d.unexpected("a value")
+			}
+			d.pos++
+		case '"':
+			end, _ := d.stringEnd()
+			if end < 0 {
+				d.pos = len(d.data)
+				return d.unexpected("the end of the string")
+			}
+			d.pos = end + 1
+		case 0:
+			return d.unexpected("a value")
+		default:
+			// Numbers, true, false and null
+			for d.pos < len(d.data) && !isDelimiter(d.data[d.pos]) {
+				d.pos++
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// null consumes a null value, returning whether there was one
+func (d *queryDecoder) null() bool {
+	if d.peek() == 'n' && bytes.HasPrefix(d.data[d.pos:], []byte("null")) {
+		d.pos += len("null")
+		return true
+	}
+	return false
+}
+
+// peek skips white space and returns the byte at the position, 0 at the end of the data
+func (d *queryDecoder) peek() byte {
+	for d.pos < len(d.data) {
+		switch c := d.data[d.pos]; c {
+		case ' ', '\t', '\r', '\n':
+			d.pos++
+		default:
+			return c
+		}
+	}
+	return 0
+}
+
+// interned returns the copy of s shared by the response. The lookup does not allocate.
+func (d *queryDecoder) interned(s []byte) string {
+	if v, ok := d.intern[string(s)]; ok {
+		return v
+	}
+	v := string(s)
+	if len(d.intern) < maxInterned {
+		d.intern[v] = v
+	}
+	return v
+}
+
+// unexpected returns the error of unexpected data at the position
+func (d *queryDecoder) unexpected(expected string) error {
+	if d.pos >= len(d.data) {
+		// Told from a response that is not valid by do, which knows if the whole body was received
+		return fmt.Errorf("%w, expected %s", io.ErrUnexpectedEOF, expected)
+	}
+	return &Error{ID: "bad_response", Details: fmt.Sprintf("Unexpected %q at offset %d, expected %s", d.data[d.pos], d.pos, expected)}
+}
+
+// equalFold reports whether the key equals the lowercase field name ignoring case, as bytes.EqualFold
+// does for encoding/json
+func equalFold(key []byte, field string) bool {
+	for i, c := range key {
+		if c >= utf8.RuneSelf {
+			// Other letters fold to ASCII ones too, e.g. the long s and the Kelvin sign
+			return bytes.EqualFold(key, []byte(field))
+		}
+		if i >= len(field) {
+			return false
+		}
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != field[i] {
+			return false
+		}
+	}
+	return len(key) == len(field)
+}
+
+// invalidJSON returns the error of a body that is not valid JSON, wrapping io.ErrUnexpectedEOF if it
+// ends before its JSON does
+func invalidJSON(data []byte) error {
+	err := json.NewDecoder(bytes.NewReader(data)).Decode(new(any))
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w, expected the end of the JSON", io.ErrUnexpectedEOF)
+	}
+	return &Error{ID: "bad_response", Details: fmt.Sprintf("Response is not valid JSON - %v", json.Unmarshal(data, new(any)))}
+}
+
+func isNumberByte(c byte) bool {
+	return c >= '0' && c <= '9' || c == '-' || c == '+' || c == '.' || c == 'e' || c == 'E'
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', ',', ':', '{', '}', '[', ']', '"':
+		return true
+	}
+	return false
+}
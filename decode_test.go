@@ -0,0 +1,148 @@
+package infinigo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// queryResponses are response bodies covering what the decoder must decode as encoding/json does
+var queryResponses = []struct {
+	name string
+	body string
+}{
+	{"empty", `{}`},
+	{"null", `null`},
+	{"response", `{"abc":{"status":"ok","statuscode":200,"error":"","generalscore":0.75,"confirmcode":"c0de","classifiers":{"ml":0.5,"human":1}}}`},
+	{"white space", " \t\r\n{ \"abc\" :\n{ \"status\" : \"ok\" , \"classifiers\" : { \"ml\" : 1 } } }\n"},
+	{"escapes", `{"a\"b\\c\/d\b\f\n\r\t":{"status":"été","error":"line\nbreak","confirmcode":"\"quoted\""}}`},
+	{"surrogate pair", `{"abc":{"error":"😀"}}`},
+	{"lone surrogate", `{"abc":{"error":"\ud83d","confirmcode":"\ude00x"}}`},
+	{"invalid utf8", "{\"ab\xffc\":{\"status\":\"o\xc3k\",\"classifiers\":{\"m\xe2l\":1}}}"},
+	{"null values", `{"abc":{"status":null,"statuscode":null,"generalscore":null,"confirmcode":null,"classifiers":null}}`},
+	{"null classifier", `{"abc":{"classifiers":{"ml":null}}}`},
+	{"null response", `{"abc":null}`},
+	{"classifiers cleared", `{"abc":{"classifiers":{"ml":1},"classifiers":null}}`},
+	{"classifiers merged", `{"abc":{"classifiers":{"ml":1},"classifiers":{"human":2}}}`},
+	{"duplicate hash", `{"abc":{"status":"first","generalscore":1},"abc":{"error":"second"}}`},
+	{"duplicate field", `{"abc":{"status":"first","status":"second"}}`},
+	{"unknown fields", `{"abc":{"extra":{"nested":[1,{"a":[true,false,null]},"}"]},"status":"ok","more":[],"x":-1.5e3}}`},
+	{"case insensitive", `{"abc":{"STATUS":"ok","StatusCode":404,"GeneralScore":1,"ConfirmCode":"c","CLASSIFIERS":{"ML":1}}}`},
+	{"unicode folding", "{\"abc\":{\"ſtatus\":\"long s\",\"claſſifiers\":{\"ml\":1}}}"},
+	{"escaped key", `{"abc":{"st\u0061tus":"ok","\u0063lassifiers":{"m\u006c":1}}}`},
+	{"exponents", `{"abc":{"statuscode":2E2,"generalscore":1e-3,"classifiers":{"ml":-0.5E+1,"zero":-0}}}`},
+	{"float32 range", `{"abc":{"generalscore":3.4028235e38}}`},
+	{"float32 overflow", `{"abc":{"generalscore":1e39}}`},
+	{"string for number", `{"abc":{"statuscode":"200"}}`},
+	{"number for string", `{"abc":{"status":200}}`},
+	{"bool for string", `{"abc":{"status":true}}`},
+	{"object for string", `{"abc":{"status":{}}}`},
+	{"array for classifiers", `{"abc":{"classifiers":[1]}}`},
+	{"string for response", `{"abc":"ok"}`},
+	{"array", `[]`},
+	{"string", `"abc"`},
+	{"number", `1`},
+	{"empty body", ``},
+	{"truncated", `{"abc":{"status":"o`},
+	{"truncated literal", `{"abc":{"status":nu`},
+	{"trailing data", `{"abc":{}} x`},
+	{"two values", `{}{}`},
+	{"bad literal", `{"abc":{"extra":tru}}`},
+	{"mismatched brackets", `{"abc":{"extra":[1}}}`},
+	{"leading zero", `{"abc":{"statuscode":01}}`},
+	{"plus sign", `{"abc":{"statuscode":+1}}`},
+	{"trailing comma", `{"abc":{"status":"ok",}}`},
+	{"missing colon", `{"abc" {}}`},
+	{"control character", "{\"abc\":{\"status\":\"o\nk\"}}"},
+	{"bad escape", `{"abc":{"status":"\x"}}`},
+	{"single quotes", `{'abc':{}}`},
+}
+
+// checkDecoder decodes the body with the decoder and encoding/json, failing if the results differ
+func checkDecoder(t *testing.T, body []byte) {
+	t.Helper()
+	want := map[string]QueryResponse{"prior": {GeneralScore: 1}}
+	wantErr := json.Unmarshal(body, &want)
+	got := map[string]QueryResponse{"prior": {GeneralScore: 1}}
+	gotErr := decodeQueryResponses(bytes.NewReader(body), &got)
+	if (gotErr == nil) != (wantErr == nil) {
+		t.Fatalf("Decoding %q returned %v, encoding/json %v", body, gotErr, wantErr)
+	}
+	if wantErr == nil && !reflect.DeepEqual(got, want) {
+		t.Fatalf("Decoding %q returned %+v, encoding/json %+v", body, got, want)
+	}
+}
+
+func TestDecodeQueryResponsesAsEncodingJSON(t *testing.T) {
+	for _, test := range queryResponses {
+		t.Run(test.name, func(t *testing.T) {
+			checkDecoder(t, []byte(test.body))
+		})
+	}
+}
+
+func TestDecodeQueryResponsesTruncated(t *testing.T) {
+	body := queryResponses[2].body
+	for n := range len(body) {
+		resp := map[string]QueryResponse{}
+		err := decodeQueryResponses(strings.NewReader(body[:n]), &resp)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("Expected %q to be reported as cut short, got %v", body[:n], err)
+		}
+	}
+	var e *Error
+	resp := map[string]QueryResponse{}
+	if err := decodeQueryResponses(strings.NewReader(`{"abc":x}`), &resp); !errors.As(err, &e) || e.ID != "bad_response" {
+		t.Fatalf("Expected JSON that is not valid to be a bad response, got %v", err)
+	}
+}
+
+func FuzzDecodeQueryResponses(f *testing.F) {
+	for _, test := range queryResponses {
+		f.Add([]byte(test.body))
+	}
+	f.Fuzz(checkDecoder)
+}
+
+// benchmarkResponse returns the response to a query of n hashes with all the classifiers
+func benchmarkResponse(n int) []byte {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := range n {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `"%064x":{"status":"ok","statuscode":200,"error":"","generalscore":0.%d,"confirmcode":"","classifiers":{"ml":0.25,"industry":0.5,"human":0.75}}`, i, i%10)
+	}
+	b.WriteByte('}')
+	return []byte(b.String())
+}
+
+func BenchmarkDecodeQueryResponses(b *testing.B) {
+	body := benchmarkResponse(1000)
+	b.Run("decoder", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for b.Loop() {
+			var resp map[string]QueryResponse
+			if err := decodeQueryResponses(bytes.NewReader(body), &resp); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for b.Loop() {
+			var resp map[string]QueryResponse
+			if err := json.NewDecoder(bytes.NewReader(body)).Decode(&resp); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,229 @@
+/*
+Package digest summarizes a period of results as an HTML report emailed over SMTP, for readers who
+will not look at a SIEM.
+
+A digest lists the malicious files first seen in the period and the hashes whose verdict or score
+changed from the local results database, and the hashes queried and files uploaded every day from the
+audit log if there is one.
+*/
+package digest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/store"
+)
+
+// Report is the digest of a period
+type Report struct {
+	From         time.Time
+	To           time.Time
+	Host         string
+	NewMalicious []store.Record // NewMalicious are the malicious hashes first seen in the period
+	Changed      []store.Record // Changed are the hashes seen before whose verdict or score changed in the period
+	Usage        []DailyUsage   // Usage of the API key every day of the period, if known
+	DailyQuota   int64          // DailyQuota of the API key, 0 if unknown
+}
+
+// DailyUsage is the use of Infinity on a day
+type DailyUsage struct {
+	Date        string // Date in UTC, e.g. 2016-01-02
+	Queries     int64  // Queries is the number of hashes queried
+	Uploads     int64  // Uploads is the number of files uploaded
+	UploadBytes int64  // UploadBytes is the number of bytes uploaded
+}
+
+// New returns the digest of the records of the database from one time to another
+func New(records []store.Record, from, to time.Time) *Report {
+	r := &Report{From: from, To: to}
+	r.Host, _ = os.Hostname()
+	in := func(t time.Time) bool { return !t.Before(from) && t.Before(to) }
+	for _, rec := range records {
+		switch {
+		case in(rec.FirstSeen):
+			if rec.Response.Verdict() == infinigo.VerdictMalicious {
+				r.NewMalicious = append(r.NewMalicious, rec)
+			}
+		case in(rec.Changed):
+			r.Changed = append(r.Changed, rec)
+		}
+	}
+	sort.Slice(r.NewMalicious, func(i, j int) bool { return r.NewMalicious[i].FirstSeen.Before(r.NewMalicious[j].FirstSeen) })
+	sort.Slice(r.Changed, func(i, j int) bool { return r.Changed[i].Changed.Before(r.Changed[j].Changed) })
+	return r
+}
+
+// AddUsage counts the requests of the period in an audit log, see infinigo.SetAuditLog
+func (r *Report) AddUsage(auditLog io.Reader) error {
+	days := make(map[string]*DailyUsage)
+	scanner := bufio.NewScanner(auditLog)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var e infinigo.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return &infinigo.Error{ID: "bad_audit_log", Details: fmt.Sprintf("Bad audit entry - %v", err)}
+		}
+		if e.Time.Before(r.From) || !e.Time.Before(r.To) || e.Error != "" {
+			continue
+		}
+		date := e.Time.UTC().Format("2006-01-02")
+		day, ok := days[date]
+		if !ok {
+			day = &DailyUsage{Date: date}
+			days[date] = day
+		}
+		switch e.Action {
+		case infinigo.AuditQuery:
+			day.Queries += int64(len(e.Hashes))
+		case infinigo.AuditUpload:
+			day.Uploads++
+			day.UploadBytes += e.Size
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	r.Usage = r.Usage[:0]
+	for _, day := range days {
+		r.Usage = append(r.Usage, *day)
+	}
+	sort.Slice(r.Usage, func(i, j int) bool { return r.Usage[i].Date < r.Usage[j].Date })
+	return nil
+}
+
+// Subject returns the subject of the digest email
+func (r *Report) Subject() string {
+	return fmt.Sprintf("Infinity digest %s - %s: %d new malicious files, %d changed verdicts",
+		r.From.Format("2006-01-02"), r.To.Format("2006-01-02"), len(r.NewMalicious), len(r.Changed))
+}
+
+// HTML writes the digest as an HTML page
+func (r *Report) HTML(w io.Writer) error {
+	return page.Execute(w, r)
+}
+
+var page = template.Must(template.New("digest").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("2006-01-02 15:04 MST") },
+	"percent": func(used, quota int64) string {
+		if quota == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.0f%%", float64(used)/float64(quota)*100)
+	},
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Infinity digest</title></head>
+<body style="font-family: sans-serif; font-size: 14px">
+<h2>Infinity digest</h2>
+<p>{{date .From}} to {{date .To}}{{if .Host}} on {{.Host}}{{end}}</p>
+<h3>New malicious files ({{len .NewMalicious}})</h3>
+{{if .NewMalicious}}<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>First seen</th><th>Hash</th><th>Path</th><th>Score</th></tr>
+{{range .NewMalicious}}<tr><td>{{date .FirstSeen}}</td><td><code>{{.Hash}}</code></td><td>{{.Path}}</td><td>{{.Response.GeneralScore}}</td></tr>
+{{end}}</table>{{else}}<p>None</p>{{end}}
+<h3>Verdict changes ({{len .Changed}})</h3>
+{{if .Changed}}<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Changed</th><th>Hash</th><th>Path</th><th>Verdict</th><th>Score</th></tr>
+{{range .Changed}}<tr><td>{{date .Changed}}</td><td><code>{{.Hash}}</code></td><td>{{.Path}}</td><td>{{.Response.Verdict}}</td><td>{{.Response.GeneralScore}}</td></tr>
+{{end}}</table>{{else}}<p>None</p>{{end}}
+{{if .Usage}}<h3>Quota usage</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Date</th><th>Hashes queried</th><th>Of daily quota</th><th>Files uploaded</th><th>Bytes uploaded</th></tr>
+{{range .Usage}}<tr><td>{{.Date}}</td><td>{{.Queries}}</td><td>{{percent .Queries $.DailyQuota}}</td><td>{{.Uploads}}</td><td>{{.UploadBytes}}</td></tr>
+{{end}}</table>{{end}}
+</body></html>
+`))
+
+// Mailer sends digests over SMTP
+type Mailer struct {
+	Addr     string   // Addr of the SMTP server, e.g. smtp.example.com:587
+	User     string   // User to authenticate as, none if empty
+	Password string   // Password of the user
+	From     string   // From address
+	To       []string // To addresses
+	TLS      bool     // TLS connects with implicit TLS as on port 465, otherwise STARTTLS is used if the server supports it
+}
+
+// Send emails the digest
+func (m *Mailer) Send(r *Report) error {
+	if m.Addr == "" || m.From == "" || len(m.To) == 0 {
+		return &infinigo.Error{ID: "missing_arg", Details: "SMTP server, from and to addresses are required"}
+	}
+	var html bytes.Buffer
+	if err := r.HTML(&html); err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMessage-ID: <%s@infinigo>\r\n",
+		m.From, strings.Join(m.To, ", "), mime.QEncoding.Encode("utf-8", r.Subject()), time.Now().Format(time.RFC1123Z), messageID())
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write(html.Bytes())
+	qp.Close()
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad SMTP server [%s], expected host:port", m.Addr)}
+	}
+	var auth smtp.Auth
+	if m.User != "" {
+		auth = smtp.PlainAuth("", m.User, m.Password, host)
+	}
+	if !m.TLS {
+		return smtp.SendMail(m.Addr, auth, m.From, m.To, msg.Bytes())
+	}
+	conn, err := tls.Dial("tcp", m.Addr, &tls.Config{ServerName: host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err = c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(m.From); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func messageID() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%x.%d", b, time.Now().UnixNano())
+}
@@ -0,0 +1,45 @@
+package infinigo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Do sends a request to an endpoint of the Infinity API the client has no method for, e.g. a new or
+// undocumented one, with the key, logging, audit log and error handling of the client, and decodes
+// the JSON response into a T. The path is relative to the URL of the client, e.g. "q".
+//
+// The body, if not nil, is read fully as Infinity requires the content length, and is sent as is with
+// the content type and bandwidth limit of uploads. If *T is an io.Writer, e.g. a bytes.Buffer, the
+// response is copied into it as is rather than decoded.
+//
+// Do does not retry: SetQueryRetries and SetUploadRetries do not apply, as sending a request to an
+// unknown endpoint again may not be safe. The method, path and params are recorded in the audit log.
+func Do[T any](ctx context.Context, c *Client, method, path string, params map[string]string, body io.Reader) (T, error) {
+	var result T
+	if c == nil {
+		return result, &Error{ID: "missing_arg", Details: "Client is required"}
+	}
+	if method == "" {
+		method = http.MethodGet
+	}
+	length := 0
+	if body != nil {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return result, err
+		}
+		length = len(data)
+		body = bytes.NewReader(data)
+		if c.uploadLimit > 0 {
+			body = &throttledReader{r: body, limit: c.uploadLimit}
+		}
+	}
+	path = strings.TrimPrefix(path, "/")
+	entry := &AuditEntry{Action: AuditRequest, Endpoint: path, Params: params}
+	err := c.do(ctx, method, path, params, body, length, &result, entry)
+	return result, err
+}
@@ -0,0 +1,409 @@
+/*
+Package drop scans the files landing in a drop directory of an SFTP or FTP server, e.g. of a partner
+file exchange or the exports of an appliance, as a pipeline source, and moves them to the folders of
+their verdicts, e.g. clean and quarantine, as a pipeline sink.
+
+The directory is listed every poll interval and the regular files directly in it are downloaded to the
+download directory under their names, which the sink tells the remote file of a result from, so it works
+across restarts. Files modified within the settle time are left for a later poll, as they may still be
+uploading. Files whose verdict has no folder stay in the directory and are not downloaded again unless
+they change or the source restarts.
+*/
+package drop
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	DefaultPollInterval = time.Minute      // DefaultPollInterval between listings of the directory
+	DefaultSettleTime   = 30 * time.Second // DefaultSettleTime since a file was last modified before it is downloaded
+)
+
+// File is a file listed in the directory
+type File struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// remote is a connection to the server of the directory
+type remote interface {
+	// list returns the regular files of the directory
+	list(dir string) ([]File, error)
+	// retrieve copies the file to w
+	retrieve(p string, w io.Writer) error
+	// move renames the file to the path, replacing any file with that path, and creates its directory if needed
+	move(from, to string) error
+	close() error
+}
+
+// Dir is a drop directory of an SFTP or FTP server
+type Dir struct {
+	scheme      string
+	addr        string
+	user        string
+	password    string
+	dir         string
+	signers     []ssh.Signer
+	hostKey     ssh.HostKeyCallback
+	tlsConfig   *tls.Config
+	downloadDir string
+	interval    time.Duration
+	settle      time.Duration
+	drain       bool
+	folders     map[infinigo.Verdict]string
+	timeout     time.Duration
+	errorlog    *log.Logger
+	mu          sync.Mutex
+	conn        remote
+	pulled      map[string]File // files downloaded and still in the directory, by name
+}
+
+// OptionFunc is a function that configures a Dir.
+// It is used in New
+type OptionFunc func(*Dir) error
+
+// New creates a drop directory for the URL sftp://user@host/path, ftp://user@host/path or ftps:// for FTP
+// with explicit TLS, downloading files to dir
+func New(dirURL, dir string, options ...OptionFunc) (*Dir, error) {
+	u, err := url.Parse(dirURL)
+	if err != nil || (u.Scheme != "sftp" && u.Scheme != "ftp" && u.Scheme != "ftps") || u.Host == "" {
+		return nil, &infinigo.Error{ID: "bad_option", Details: "Bad drop directory URL, expected e.g. sftp://partner@files.example.com/incoming"}
+	}
+	if dir == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Download directory is required"}
+	}
+	d := &Dir{
+		scheme:      u.Scheme,
+		addr:        u.Host,
+		dir:         u.Path,
+		tlsConfig:   &tls.Config{ServerName: u.Hostname()},
+		downloadDir: dir,
+		interval:    DefaultPollInterval,
+		settle:      DefaultSettleTime,
+		folders:     map[infinigo.Verdict]string{},
+		timeout:     time.Minute,
+		pulled:      map[string]File{},
+	}
+	if u.Port() == "" {
+		port := "21"
+		if d.scheme == "sftp" {
+			port = "22"
+		}
+		d.addr = u.Hostname() + ":" + port
+	}
+	if d.dir == "" {
+		d.dir = "."
+	}
+	if u.User != nil {
+		d.user = u.User.Username()
+		d.password, _ = u.User.Password()
+	}
+	if d.user == "" && d.scheme != "sftp" {
+		d.user = "anonymous"
+	}
+	for _, option := range options {
+		if err := option(d); err != nil {
+			return nil, err
+		}
+	}
+	if d.scheme == "sftp" {
+		if d.user == "" || d.password == "" && len(d.signers) == 0 {
+			return nil, &infinigo.Error{ID: "missing_arg", Details: "SFTP user and password or private key are required"}
+		}
+		if d.hostKey == nil {
+			return nil, &infinigo.Error{ID: "missing_arg", Details: "SFTP host key callback is required"}
+		}
+	}
+	return d, nil
+}
+
+// SetPassword sets the password instead of the one of the URL
+func SetPassword(password string) OptionFunc {
+	return func(d *Dir) error {
+		if password != "" {
+			d.password = password
+		}
+		return nil
+	}
+}
+
+// SetPrivateKey authenticates to SFTP servers with the PEM encoded private key, decrypted with the
+// passphrase if not empty
+func SetPrivateKey(pemBytes []byte, passphrase string) OptionFunc {
+	return func(d *Dir) error {
+		var signer ssh.Signer
+		var err error
+		if passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pemBytes)
+		}
+		if err != nil {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad SFTP private key - %v", err)}
+		}
+		d.signers = append(d.signers, signer)
+		return nil
+	}
+}
+
+// SetHostKeyCallback sets how the host keys of SFTP servers are verified, e.g. with the knownhosts package.
+// It is required for SFTP.
+func SetHostKeyCallback(callback ssh.HostKeyCallback) OptionFunc {
+	return func(d *Dir) error {
+		d.hostKey = callback
+		return nil
+	}
+}
+
+// SetTLSConfig sets the TLS configuration of FTPS, e.g. to trust a private CA
+func SetTLSConfig(config *tls.Config) OptionFunc {
+	return func(d *Dir) error {
+		if config == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "TLS configuration is required"}
+		}
+		d.tlsConfig = config
+		return nil
+	}
+}
+
+// SetPollInterval sets the interval between listings of the directory. It is DefaultPollInterval by default.
+func SetPollInterval(interval time.Duration) OptionFunc {
+	return func(d *Dir) error {
+		if interval <= 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Poll interval must be positive"}
+		}
+		d.interval = interval
+		return nil
+	}
+}
+
+// SetSettleTime sets how long after it was last modified a file is downloaded, measured with the clock of
+// the server. It is DefaultSettleTime by default, 0 to download files as soon as they are listed.
+func SetSettleTime(settle time.Duration) OptionFunc {
+	return func(d *Dir) error {
+		if settle < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Settle time must not be negative"}
+		}
+		d.settle = settle
+		return nil
+	}
+}
+
+// SetDrain makes the source end once a listing finds no new file. The source polls until its context is done by default.
+func SetDrain(drain bool) OptionFunc {
+	return func(d *Dir) error {
+		d.drain = drain
+		return nil
+	}
+}
+
+// SetFolder moves the files with the verdict to the folder, e.g. quarantine. A relative folder is in the directory.
+func SetFolder(verdict infinigo.Verdict, folder string) OptionFunc {
+	return func(d *Dir) error {
+		d.folders[verdict] = folder
+		return nil
+	}
+}
+
+// SetErrorLog sets the logger of the files the source skips because they cannot be downloaded
+func SetErrorLog(logger *log.Logger) OptionFunc {
+	return func(d *Dir) error {
+		d.errorlog = logger
+		return nil
+	}
+}
+
+// Source returns a source of the new files of the directory. Submissions are copies of the template with
+// the path of every downloaded file, so the template sets whether to upload them.
+func (d *Dir) Source(template pipeline.Submission) pipeline.Source {
+	var pending []pipeline.Submission
+	var lastPoll time.Time
+	return pipeline.SourceFunc(func(ctx context.Context) (pipeline.Submission, error) {
+		for len(pending) == 0 {
+			if wait := time.Until(lastPoll.Add(d.interval)); !lastPoll.IsZero() && !d.drain {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return pipeline.Submission{}, ctx.Err()
+				}
+			}
+			lastPoll = time.Now()
+			paths, err := d.poll(ctx)
+			if err != nil {
+				return pipeline.Submission{}, err
+			}
+			if len(paths) == 0 && d.drain {
+				return pipeline.Submission{}, io.EOF
+			}
+			for _, p := range paths {
+				sub := template
+				sub.Path = p
+				pending = append(pending, sub)
+			}
+		}
+		sub := pending[0]
+		pending = pending[1:]
+		return sub, nil
+	})
+}
+
+// poll downloads the new files of the directory and returns their paths
+func (d *Dir) poll(ctx context.Context) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var paths []string
+	err := d.session(ctx, func() error {
+		files, err := d.conn.list(d.dir)
+		if err != nil {
+			return err
+		}
+		listed := make(map[string]bool, len(files))
+		for _, f := range files {
+			listed[f.Name] = true
+			if prev, ok := d.pulled[f.Name]; ok && prev.Size == f.Size && prev.Modified.Equal(f.Modified) {
+				continue
+			}
+			if d.settle > 0 && !f.Modified.IsZero() && time.Since(f.Modified) < d.settle {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			p, err := d.download(f.Name)
+			if err != nil {
+				if !fileError(err) || d.errorlog == nil {
+					return err
+				}
+				d.errorlog.Printf("Skipped %s - %v", f.Name, err)
+				continue
+			}
+			d.pulled[f.Name] = f
+			paths = append(paths, p)
+		}
+		for name := range d.pulled {
+			if !listed[name] {
+				delete(d.pulled, name)
+			}
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// download copies the file to the download directory and returns its path
+func (d *Dir) download(name string) (string, error) {
+	p := filepath.Join(d.downloadDir, name)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	err = d.conn.retrieve(path.Join(d.dir, name), f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(p)
+		return "", err
+	}
+	return p, nil
+}
+
+// OnResult moves the file of the result to the folder of its verdict if set. Results of files that were
+// not downloaded from the directory are ignored.
+func (d *Dir) OnResult(ctx context.Context, r pipeline.Result) error {
+	if r.Submission.Path == "" || filepath.Dir(r.Submission.Path) != filepath.Clean(d.downloadDir) {
+		return nil
+	}
+	folder := d.folders[r.Response.Verdict()]
+	if folder == "" {
+		return nil
+	}
+	if !path.IsAbs(folder) {
+		folder = path.Join(d.dir, folder)
+	}
+	name := filepath.Base(r.Submission.Path)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.session(ctx, func() error {
+		if err := d.conn.move(path.Join(d.dir, name), path.Join(folder, name)); err != nil {
+			return err
+		}
+		delete(d.pulled, name)
+		return nil
+	})
+}
+
+// Close disconnects from the server
+func (d *Dir) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn == nil {
+		return nil
+	}
+	err := d.conn.close()
+	d.conn = nil
+	return err
+}
+
+// session runs fn, connecting first if needed, and once more on a new connection if the connection failed
+func (d *Dir) session(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		reused := d.conn != nil
+		if !reused {
+			if err := d.connect(ctx); err != nil {
+				return err
+			}
+		}
+		err := fn()
+		if err == nil || fileError(err) || ctx.Err() != nil {
+			return err
+		}
+		d.conn.close()
+		d.conn = nil
+		if !reused || attempt > 0 {
+			return err
+		}
+	}
+}
+
+// fileError returns whether the error is of a file rather than of the connection, which can be used further
+func fileError(err error) bool {
+	switch err.(type) {
+	case *infinigo.Error, *os.PathError:
+		return true
+	}
+	return false
+}
+
+// connect connects and logs in
+func (d *Dir) connect(ctx context.Context) error {
+	var err error
+	if d.scheme == "sftp" {
+		d.conn, err = dialSFTP(ctx, d)
+	} else {
+		d.conn, err = dialFTP(ctx, d)
+	}
+	return err
+}
+
+// trimName returns the last element of a listed name, as some servers list paths
+func trimName(name string) string {
+	return path.Base(strings.ReplaceAll(name, "\\", "/"))
+}
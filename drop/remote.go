@@ -0,0 +1,168 @@
+package drop
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+
+	"github.com/demisto/infinigo"
+	"github.com/jlaffaye/ftp"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpRemote is a connection to an SFTP server
+type sftpRemote struct {
+	ssh *ssh.Client
+	c   *sftp.Client
+}
+
+// dialSFTP connects to the SFTP server of the directory
+func dialSFTP(ctx context.Context, d *Dir) (remote, error) {
+	auth := []ssh.AuthMethod{}
+	if len(d.signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(d.signers...))
+	}
+	if d.password != "" {
+		auth = append(auth, ssh.Password(d.password))
+	}
+	dialer := &net.Dialer{Timeout: d.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ClientConfig{User: d.user, Auth: auth, HostKeyCallback: d.hostKey, Timeout: d.timeout}
+	sc, chans, reqs, err := ssh.NewClientConn(conn, d.addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client := ssh.NewClient(sc, chans, reqs)
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &sftpRemote{ssh: client, c: c}, nil
+}
+
+func (r *sftpRemote) list(dir string) ([]File, error) {
+	infos, err := r.c.ReadDir(dir)
+	if err != nil {
+		return nil, sftpError(err)
+	}
+	var files []File
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			files = append(files, File{Name: trimName(info.Name()), Size: info.Size(), Modified: info.ModTime()})
+		}
+	}
+	return files, nil
+}
+
+func (r *sftpRemote) retrieve(p string, w io.Writer) error {
+	f, err := r.c.Open(p)
+	if err != nil {
+		return sftpError(err)
+	}
+	defer f.Close()
+	_, err = f.WriteTo(w)
+	return sftpError(err)
+}
+
+func (r *sftpRemote) move(from, to string) error {
+	if err := r.c.MkdirAll(path.Dir(to)); err != nil {
+		return sftpError(err)
+	}
+	// Plain renames fail if the target exists
+	if _, ok := r.c.HasExtension("posix-rename@openssh.com"); ok {
+		return sftpError(r.c.PosixRename(from, to))
+	}
+	r.c.Remove(to)
+	return sftpError(r.c.Rename(from, to))
+}
+
+func (r *sftpRemote) close() error {
+	r.c.Close()
+	return r.ssh.Close()
+}
+
+// sftpError returns the errors the server replied with as an infinigo.Error, leaving those of the connection
+func sftpError(err error) error {
+	var status *sftp.StatusError
+	if errors.As(err, &status) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return &infinigo.Error{ID: "sftp_error", Details: err.Error()}
+	}
+	return err
+}
+
+// ftpRemote is a connection to an FTP server
+type ftpRemote struct {
+	c *ftp.ServerConn
+}
+
+// dialFTP connects to the FTP server of the directory
+func dialFTP(ctx context.Context, d *Dir) (remote, error) {
+	options := []ftp.DialOption{ftp.DialWithContext(ctx), ftp.DialWithTimeout(d.timeout)}
+	if d.scheme == "ftps" {
+		options = append(options, ftp.DialWithExplicitTLS(d.tlsConfig))
+	}
+	c, err := ftp.Dial(d.addr, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.Login(d.user, d.password); err != nil {
+		c.Quit()
+		return nil, err
+	}
+	return &ftpRemote{c: c}, nil
+}
+
+func (r *ftpRemote) list(dir string) ([]File, error) {
+	entries, err := r.c.List(dir)
+	if err != nil {
+		return nil, ftpError(err)
+	}
+	var files []File
+	for _, e := range entries {
+		if e.Type == ftp.EntryTypeFile {
+			files = append(files, File{Name: trimName(e.Name), Size: int64(e.Size), Modified: e.Time})
+		}
+	}
+	return files, nil
+}
+
+func (r *ftpRemote) retrieve(p string, w io.Writer) error {
+	resp, err := r.c.Retr(p)
+	if err != nil {
+		return ftpError(err)
+	}
+	_, err = io.Copy(w, resp)
+	if cerr := resp.Close(); err == nil {
+		err = cerr
+	}
+	return ftpError(err)
+}
+
+func (r *ftpRemote) move(from, to string) error {
+	// Fails if the directory exists, which the rename tells apart from other failures
+	r.c.MakeDir(path.Dir(to))
+	return ftpError(r.c.Rename(from, to))
+}
+
+func (r *ftpRemote) close() error {
+	return r.c.Quit()
+}
+
+// ftpError returns the errors the server replied with as an infinigo.Error, leaving those of the connection
+func ftpError(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return &infinigo.Error{ID: "ftp_error", Details: err.Error()}
+	}
+	return err
+}
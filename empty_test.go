@@ -0,0 +1,100 @@
+package infinigo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// emptyServer answers every request with no body, the way the mode says
+func emptyServer(t *testing.T, mode string) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch mode {
+		case "204":
+			w.WriteHeader(http.StatusNoContent)
+		case "content-length-0":
+			w.Header().Set(ContentLengthHeader, "0")
+			w.WriteHeader(http.StatusOK)
+		case "chunked":
+			// Flushing before writing anything sends the headers without a length, as a chunked body
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	c, err := New(SetKey("key"), SetURL(srv.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEmptyResponses(t *testing.T) {
+	endpoints := map[string]func(c *Client) (int, error){
+		"Query": func(c *Client) (int, error) {
+			resp, err := c.Query("", "abc")
+			return len(resp), err
+		},
+		"QueryAll": func(c *Client) (int, error) {
+			hashes := make([]string, QueryBatchSize+1)
+			for i := range hashes {
+				hashes[i] = strings.Repeat("a", i+1)
+			}
+			resp, err := c.QueryAll("", hashes...)
+			return len(resp), err
+		},
+		"QueryStream": func(c *Client) (int, error) {
+			n := 0
+			err := c.QueryStream(context.Background(), "", []string{"abc"}, func(string, QueryResponse) error {
+				n++
+				return nil
+			})
+			return n, err
+		},
+		"Upload": func(c *Client) (int, error) {
+			resp, err := c.Upload("code", strings.NewReader("data"))
+			return len(resp), err
+		},
+		"Do": func(c *Client) (int, error) {
+			resp, err := Do[map[string]QueryResponse](context.Background(), c, http.MethodGet, "q", nil, nil)
+			return len(resp), err
+		},
+	}
+	for _, mode := range []string{"204", "content-length-0", "chunked"} {
+		for name, call := range endpoints {
+			t.Run(mode+"/"+name, func(t *testing.T) {
+				n, err := call(emptyServer(t, mode))
+				if err != nil {
+					t.Fatalf("Expected an empty result, got error %v", err)
+				}
+				if n != 0 {
+					t.Fatalf("Expected an empty result, got %d entries", n)
+				}
+			})
+		}
+	}
+}
+
+func TestTruncatedIsNotEmpty(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Promises a body, then closes the connection without sending it
+		w.Header().Set(ContentLengthHeader, "100")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+	c, err := New(SetKey("key"), SetURL(srv.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Query("", "abc"); err == nil {
+		t.Fatal("Expected a missing body to fail")
+	}
+}
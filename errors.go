@@ -0,0 +1,225 @@
+package infinigo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrHTTPStatus matches, with errors.Is, the StatusError of responses with an unexpected status code
+var ErrHTTPStatus = &Error{ID: "http_error", Details: "Unexpected status code"}
+
+// StatusError is returned when Infinity answers with a status code the client has no other error for
+type StatusError struct {
+	Status int `json:"status"` // Status code of the response
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: Unexpected status code: %d (%s)", ErrHTTPStatus.ID, e.Status, http.StatusText(e.Status))
+}
+
+// Unwrap returns ErrHTTPStatus, so errors.As finds the *Error with its ID
+func (e *StatusError) Unwrap() error {
+	return ErrHTTPStatus
+}
+
+// ErrPayloadTooLarge matches, with errors.Is, the PayloadTooLargeError of uploads Infinity rejected
+var ErrPayloadTooLarge = &Error{ID: "payload_too_large", Details: "Upload is larger than Infinity accepts"}
+
+// PayloadTooLargeError is returned when Infinity rejects an upload with 413 Payload Too Large.
+// Retrying the same data fails again.
+type PayloadTooLargeError struct {
+	Sent int64 `json:"sent"`          // Sent is the compressed size of the upload in bytes
+	Max  int64 `json:"max,omitempty"` // Max is the size Infinity accepts in bytes, 0 if it did not say
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	if e.Max > 0 {
+		return fmt.Sprintf("%s: Upload of %d compressed bytes is larger than the %d bytes Infinity accepts", ErrPayloadTooLarge.ID, e.Sent, e.Max)
+	}
+	return fmt.Sprintf("%s: Upload of %d compressed bytes is larger than Infinity accepts", ErrPayloadTooLarge.ID, e.Sent)
+}
+
+// Unwrap returns ErrPayloadTooLarge, so errors.As finds the *Error with its ID
+func (e *PayloadTooLargeError) Unwrap() error {
+	return ErrPayloadTooLarge
+}
+
+// maxSizeHeaders may carry the size Infinity accepts on a 413
+var maxSizeHeaders = []string{"X-Max-Content-Length", "X-Max-Upload-Size", "X-Upload-Limit"}
+
+// maxSizeFields may carry the size Infinity accepts in the JSON body of a 413, matched case insensitively
+var maxSizeFields = []string{"max", "max_size", "maxsize", "max_content_length", "limit"}
+
+// payloadTooLarge returns the error of a 413 response to a request of sent bytes
+func payloadTooLarge(resp *http.Response, sent int64) *PayloadTooLargeError {
+	e := &PayloadTooLargeError{Sent: sent}
+	for _, h := range maxSizeHeaders {
+		if n, err := strconv.ParseInt(strings.TrimSpace(resp.Header.Get(h)), 10, 64); err == nil && n > 0 {
+			e.Max = n
+			return e
+		}
+	}
+	if resp.Body == nil {
+		return e
+	}
+	var body map[string]any
+	dec := json.NewDecoder(io.LimitReader(resp.Body, 4096))
+	dec.UseNumber()
+	if dec.Decode(&body) != nil {
+		return e
+	}
+	for k, v := range body {
+		n, ok := v.(json.Number)
+		if !ok || !slices.Contains(maxSizeFields, strings.ToLower(k)) {
+			continue
+		}
+		if max, err := n.Int64(); err == nil && max > 0 {
+			e.Max = max
+			break
+		}
+	}
+	return e
+}
+
+// ErrTruncatedResponse matches, with errors.Is, the TruncatedResponseError of responses cut short
+var ErrTruncatedResponse = &Error{ID: "truncated_response", Details: "Response ended before it was complete"}
+
+// TruncatedResponseError is returned when the connection ended before the whole response was received,
+// e.g. dropped by a proxy. Unlike a bad_response, the request can be sent again.
+type TruncatedResponseError struct {
+	Received int64 `json:"received"` // Received is the number of bytes of the body received
+	Expected int64 `json:"expected"` // Expected is the Content-Length of the response, -1 if it had none
+}
+
+func (e *TruncatedResponseError) Error() string {
+	if e.Expected >= 0 {
+		return fmt.Sprintf("%s: Response ended after %d of its %d bytes", ErrTruncatedResponse.ID, e.Received, e.Expected)
+	}
+	return fmt.Sprintf("%s: Response ended after %d bytes, before the end of its JSON", ErrTruncatedResponse.ID, e.Received)
+}
+
+// Unwrap returns ErrTruncatedResponse, so errors.As finds the *Error with its ID
+func (e *TruncatedResponseError) Unwrap() error {
+	return ErrTruncatedResponse
+}
+
+// ErrInvalidConfirmCode matches, with errors.Is, the InvalidConfirmCodeError of uploads not sent
+var ErrInvalidConfirmCode = &Error{ID: "invalid_confirm_code", Details: "Confirm code is not valid"}
+
+// InvalidConfirmCodeError is returned by uploads with a confirm code Infinity would reject, before the
+// data is read
+type InvalidConfirmCodeError struct {
+	Code    string `json:"code"`              // Code is the confirm code
+	Reason  string `json:"reason"`            // Reason the code is not valid
+	Expired bool   `json:"expired,omitempty"` // Expired if the code was received longer than SetConfirmCodeExpiry ago
+}
+
+func (e *InvalidConfirmCodeError) Error() string {
+	return fmt.Sprintf("%s: Confirm code %q is not valid as %s", ErrInvalidConfirmCode.ID, e.Code, e.Reason)
+}
+
+// Unwrap returns ErrInvalidConfirmCode, so errors.As finds the *Error with its ID
+func (e *InvalidConfirmCodeError) Unwrap() error {
+	return ErrInvalidConfirmCode
+}
+
+// ErrUploadUnconfirmed matches, with errors.Is, the UploadUnconfirmedError of uploads that may have
+// been received
+var ErrUploadUnconfirmed = &Error{ID: "upload_unconfirmed", Details: "Upload failed after its data was sent"}
+
+// UploadUnconfirmedError is returned instead of sending an upload again when it failed after its data
+// was sent, as Infinity may have received it. Err is the failure of the last attempt.
+type UploadUnconfirmedError struct {
+	Sent int64 `json:"sent"` // Sent is the number of compressed bytes sent before the upload failed
+	Err  error `json:"-"`
+}
+
+func (e *UploadUnconfirmedError) Error() string {
+	return fmt.Sprintf("%s: Upload failed after sending %d bytes, Infinity may have received it - %v", ErrUploadUnconfirmed.ID, e.Sent, e.Err)
+}
+
+// Unwrap returns ErrUploadUnconfirmed and the failure of the upload
+func (e *UploadUnconfirmedError) Unwrap() []error {
+	return []error{ErrUploadUnconfirmed, e.Err}
+}
+
+// ErrQueueItemDropped matches, with errors.Is, the DroppedError of queued requests removed by Queue.Flush
+var ErrQueueItemDropped = &Error{ID: "queue_item_dropped", Details: "Queued request failed and was dropped"}
+
+// DroppedError is returned by Queue.Flush for a queued request that failed in a way sending it again
+// would not fix, so it was removed from the queue. Err is its failure.
+type DroppedError struct {
+	Item QueueItem `json:"item"` // Item is the dropped request
+	Err  error     `json:"-"`
+}
+
+func (e *DroppedError) Error() string {
+	return fmt.Sprintf("%s: Dropped queued %s added %v - %v", ErrQueueItemDropped.ID, e.Item.Kind, e.Item.Added, e.Err)
+}
+
+// Unwrap returns ErrQueueItemDropped and the failure of the request
+func (e *DroppedError) Unwrap() []error {
+	return []error{ErrQueueItemDropped, e.Err}
+}
+
+// ErrServiceUnavailable matches, with errors.Is, the ServiceUnavailableError of requests Infinity could
+// not serve
+var ErrServiceUnavailable = &Error{ID: "service_unavailable", Details: "Infinity is unavailable"}
+
+// ServiceUnavailableError is returned when Infinity answers 502 or 503, or with a maintenance page.
+// Every request fails until it is back, so callers should pause rather than retry each request.
+type ServiceUnavailableError struct {
+	Status      int           `json:"status"`                // Status code of the response
+	Maintenance bool          `json:"maintenance,omitempty"` // Maintenance if the response says Infinity is under maintenance
+	RetryAfter  time.Duration `json:"-"`                     // RetryAfter is how long Infinity asked to wait, 0 if it did not say
+}
+
+func (e *ServiceUnavailableError) Error() string {
+	msg := fmt.Sprintf("%s: Infinity is unavailable (%d %s)", ErrServiceUnavailable.ID, e.Status, http.StatusText(e.Status))
+	if e.Maintenance {
+		msg += ", under maintenance"
+	}
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(", retry after %v", e.RetryAfter)
+	}
+	return msg
+}
+
+// Unwrap returns ErrServiceUnavailable, so errors.As finds the *Error with its ID
+func (e *ServiceUnavailableError) Unwrap() error {
+	return ErrServiceUnavailable
+}
+
+// ErrHashFailed matches, with errors.Is, the HashError of responses with an error
+var ErrHashFailed = &Error{ID: "hash_error", Details: "Infinity returned an error for the hash"}
+
+// HashError is the error Infinity returned for a single hash of a query, which was answered, unlike
+// the hashes of a request that failed
+type HashError struct {
+	Status     string  `json:"status"`     // Status of the response
+	StatusCode float32 `json:"statuscode"` // StatusCode of the response
+	Message    string  `json:"error"`      // Message is the error of the response
+}
+
+func (e *HashError) Error() string {
+	return fmt.Sprintf("%s: Infinity returned an error for the hash - %s (status %s, code %v)", ErrHashFailed.ID, e.Message, e.Status, e.StatusCode)
+}
+
+// Unwrap returns ErrHashFailed, so errors.As finds the *Error with its ID
+func (e *HashError) Unwrap() error {
+	return ErrHashFailed
+}
+
+// Err returns the error Infinity returned for the hash as a *HashError, nil if there is none
+func (r QueryResponse) Err() error {
+	if r.Error == "" {
+		return nil
+	}
+	return &HashError{Status: r.Status, StatusCode: r.StatusCode, Message: r.Error}
+}
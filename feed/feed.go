@@ -0,0 +1,183 @@
+/*
+Package feed writes the hashes of malicious files as indicator feeds EDR products import, a plain hash
+list, CSV with metadata, a Carbon Black threat feed, SentinelOne IOCs or Microsoft Defender indicators.
+
+Entries expire after a window since they were last checked, so a feed regenerated on a schedule rolls
+off hashes nobody has seen for a while. WriteFile replaces the feed atomically so products polling it
+never read a partial file.
+*/
+package feed
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a malicious hash of the feed
+type Entry struct {
+	Hash        string    `json:"hash"`
+	Path        string    `json:"path,omitempty"`
+	Score       float32   `json:"score"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastChecked time.Time `json:"last_checked"`
+	Expires     time.Time `json:"expires"`
+}
+
+// Format writes the entries of a feed
+type Format func(w io.Writer, entries []Entry) error
+
+// Formats are the supported formats by name
+var Formats = map[string]Format{
+	"plain":       Plain,
+	"csv":         CSV,
+	"carbonblack": CarbonBlack,
+	"sentinelone": SentinelOne,
+	"defender":    Defender,
+}
+
+// Plain writes a hash per line
+func Plain(w io.Writer, entries []Entry) error {
+	for _, e := range entries {
+		if _, err := fmt.Fprintln(w, e.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CSV writes the entries with their metadata
+func CSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"hash", "score", "path", "first_seen", "last_checked", "expires"})
+	for _, e := range entries {
+		cw.Write([]string{e.Hash, formatScore(e.Score), e.Path, e.FirstSeen.Format(time.RFC3339), e.LastChecked.Format(time.RFC3339), e.Expires.Format(time.RFC3339)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// CarbonBlack writes a Carbon Black EDR threat feed with a report per hash
+func CarbonBlack(w io.Writer, entries []Entry) error {
+	reports := make([]map[string]interface{}, 0, len(entries))
+	for _, e := range entries {
+		reports = append(reports, map[string]interface{}{
+			"id":        "infinigo-" + e.Hash,
+			"timestamp": e.LastChecked.Unix(),
+			"title":     fmt.Sprintf("Infinity scored %s as malicious (%s)", name(e), formatScore(e.Score)),
+			"score":     confidence(e.Score),
+			"link":      "https://github.com/demisto/infinigo",
+			"iocs":      map[string][]string{hashType(e.Hash): {e.Hash}},
+		})
+	}
+	return encode(w, map[string]interface{}{
+		"feedinfo": map[string]interface{}{
+			"name":         "infinigo",
+			"display_name": "Cylance Infinity malicious files",
+			"provider_url": "https://github.com/demisto/infinigo",
+			"summary":      "Hashes Cylance Infinity scored as malicious",
+			"tech_data":    "Generated by infinigo from its local results database",
+		},
+		"reports": reports,
+	})
+}
+
+// SentinelOne writes the body of the SentinelOne threat intelligence IOC API
+func SentinelOne(w io.Writer, entries []Entry) error {
+	iocs := make([]map[string]interface{}, 0, len(entries))
+	for _, e := range entries {
+		iocs = append(iocs, map[string]interface{}{
+			"type":         strings.ToUpper(hashType(e.Hash)),
+			"value":        e.Hash,
+			"method":       "EQUALS",
+			"source":       "infinigo",
+			"name":         name(e),
+			"description":  fmt.Sprintf("Cylance Infinity score %s", formatScore(e.Score)),
+			"creationTime": e.FirstSeen.UTC().Format(time.RFC3339),
+			"validUntil":   e.Expires.UTC().Format(time.RFC3339),
+		})
+	}
+	return encode(w, map[string]interface{}{"data": iocs})
+}
+
+// Defender writes the CSV Microsoft Defender for Endpoint imports as file indicators blocking the hashes
+func Defender(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"IndicatorType", "IndicatorValue", "ExpirationTime", "Action", "Severity", "Title", "Description",
+		"RecommendedActions", "RbacGroups", "Category", "MitreTechniques", "GenerateAlert"})
+	for _, e := range entries {
+		indicatorType := map[string]string{"md5": "FileMd5", "sha1": "FileSha1", "sha256": "FileSha256"}[hashType(e.Hash)]
+		severity := "Medium"
+		if e.Score <= -0.9 {
+			severity = "High"
+		}
+		cw.Write([]string{indicatorType, e.Hash, e.Expires.UTC().Format("2006-01-02T15:04:05Z"), "BlockAndRemediate", severity,
+			"Cylance Infinity malicious file " + name(e), fmt.Sprintf("Cylance Infinity score %s", formatScore(e.Score)),
+			"", "", "Malware", "", "TRUE"})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteFile writes the feed to the path, replacing the previous feed atomically
+func WriteFile(path string, format Format, entries []Entry) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err = format(f, entries); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err = os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// hashType returns the algorithm of the hash by its length
+func hashType(hash string) string {
+	switch len(hash) {
+	case 32:
+		return "md5"
+	case 40:
+		return "sha1"
+	}
+	return "sha256"
+}
+
+// name returns the file name of the entry, or its hash
+func name(e Entry) string {
+	if e.Path == "" {
+		return e.Hash
+	}
+	return filepath.Base(e.Path)
+}
+
+// confidence returns the score as a confidence from 0 to 100
+func confidence(score float32) int {
+	return int(math.Round(math.Abs(float64(score)) * 100))
+}
+
+func formatScore(score float32) string {
+	return strconv.FormatFloat(float64(score), 'f', -1, 32)
+}
+
+func encode(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(v)
+}
@@ -0,0 +1,284 @@
+/*
+Package hashing computes the MD5, SHA1 and SHA256 digests of files, reading each file once with a
+large buffer and hashing several files at once over a bounded pool of workers. Progress can be
+reported while the files are read, e.g. to show how far a scan of large files is.
+*/
+package hashing
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/demisto/infinigo"
+)
+
+// Algorithm is a set of digests to compute
+type Algorithm int
+
+// Algorithms supported
+const (
+	MD5    Algorithm             = 1 << iota // MD5 digest
+	SHA1                                     // SHA1 digest
+	SHA256                                   // SHA256 digest
+	All    = MD5 | SHA1 | SHA256             // All the digests
+)
+
+// DefaultBufferSize is the size of the buffer each worker reads files with
+const DefaultBufferSize = 1 << 20
+
+// Digests are the hex encoded digests of a file, empty for the algorithms not computed
+type Digests struct {
+	MD5    string `json:"md5,omitempty"`
+	SHA1   string `json:"sha1,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Result is the outcome of hashing a file
+type Result struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"` // Size is the number of bytes read
+	Digests
+	Err error `json:"-"` // Err is the error opening or reading the file, with no digests
+}
+
+// Progress reports how much of a file was read
+type Progress struct {
+	Path string
+	Read int64 // Read is the number of bytes read so far
+	Size int64 // Size of the file when it was opened, -1 if unknown
+	Done bool  // Done once the file is read or failed
+}
+
+// Hasher hashes files. It is safe for concurrent use.
+type Hasher struct {
+	workers    int
+	bufferSize int
+	algorithms Algorithm
+	progress   func(Progress)
+	open       func(path string) (io.ReadCloser, error)
+	buffers    sync.Pool
+}
+
+// OptionFunc is a function that configures a Hasher.
+// It is used in New
+type OptionFunc func(*Hasher) error
+
+// New creates a hasher computing all the digests with a worker per CPU
+func New(options ...OptionFunc) (*Hasher, error) {
+	h := &Hasher{
+		workers:    runtime.NumCPU(),
+		bufferSize: DefaultBufferSize,
+		algorithms: All,
+		open:       func(path string) (io.ReadCloser, error) { return os.Open(path) },
+	}
+	for _, option := range options {
+		if err := option(h); err != nil {
+			return nil, err
+		}
+	}
+	h.buffers.New = func() any {
+		b := make([]byte, h.bufferSize)
+		return &b
+	}
+	return h, nil
+}
+
+// SetWorkers sets the number of files hashed at once. It is the number of CPUs by default.
+func SetWorkers(n int) OptionFunc {
+	return func(h *Hasher) error {
+		if n < 1 {
+			return &infinigo.Error{ID: "bad_option", Details: "At least one worker is required"}
+		}
+		h.workers = n
+		return nil
+	}
+}
+
+// SetBufferSize sets the size of the buffer files are read with. It is DefaultBufferSize by default.
+func SetBufferSize(size int) OptionFunc {
+	return func(h *Hasher) error {
+		if size < 1 {
+			return &infinigo.Error{ID: "bad_option", Details: "Buffer size must be positive"}
+		}
+		h.bufferSize = size
+		return nil
+	}
+}
+
+// SetAlgorithms sets the digests computed. It is All by default.
+func SetAlgorithms(a Algorithm) OptionFunc {
+	return func(h *Hasher) error {
+		if a&All == 0 || a&^All != 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Unknown hash algorithms"}
+		}
+		h.algorithms = a
+		return nil
+	}
+}
+
+// SetProgress sets a function called after every buffer read from a file and once the file is done.
+// It is called from the workers concurrently, and should return quickly.
+func SetProgress(fn func(Progress)) OptionFunc {
+	return func(h *Hasher) error {
+		h.progress = fn
+		return nil
+	}
+}
+
+// SetOpen sets how files are opened. It is os.Open by default.
+func SetOpen(open func(path string) (io.ReadCloser, error)) OptionFunc {
+	return func(h *Hasher) error {
+		if open == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "Open function is required"}
+		}
+		h.open = open
+		return nil
+	}
+}
+
+// File hashes a single file
+func (h *Hasher) File(ctx context.Context, path string) Result {
+	res := Result{Path: path}
+	if res.Err = ctx.Err(); res.Err != nil {
+		return res
+	}
+	f, err := h.open(path)
+	if err != nil {
+		res.Err = err
+		h.report(Progress{Path: path, Size: -1, Done: true})
+		return res
+	}
+	defer f.Close()
+	size := int64(-1)
+	if s, ok := f.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		if info, err := s.Stat(); err == nil {
+			size = info.Size()
+		}
+	}
+	res.Size, res.Digests, res.Err = h.sum(ctx, path, f, size)
+	if res.Err != nil {
+		res.Digests = Digests{}
+	}
+	h.report(Progress{Path: path, Read: res.Size, Size: size, Done: true})
+	return res
+}
+
+// Reader hashes the content of the reader, without reporting progress
+func (h *Hasher) Reader(ctx context.Context, r io.Reader) (Digests, int64, error) {
+	n, d, err := h.sum(ctx, "", r, -1)
+	return d, n, err
+}
+
+// Files hashes the files with the workers, returning the results in the order of the paths. Files
+// not hashed yet when the context is done fail with its error.
+func (h *Hasher) Files(ctx context.Context, paths []string) []Result {
+	results := make([]Result, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < h.workers && i < len(paths); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j] = h.File(ctx, paths[j])
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// Stream hashes the files sent on paths with the workers, sending the results as the files are done.
+// The results are closed once paths is closed and the files sent are hashed. Files received once the
+// context is done fail with its error.
+func (h *Hasher) Stream(ctx context.Context, paths <-chan string) <-chan Result {
+	results := make(chan Result)
+	var wg sync.WaitGroup
+	for i := 0; i < h.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				results <- h.File(ctx, path)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// sum reads r in a single pass into the digests
+func (h *Hasher) sum(ctx context.Context, path string, r io.Reader, size int64) (int64, Digests, error) {
+	var hashes []hash.Hash
+	var m, s1, s256 hash.Hash
+	if h.algorithms&MD5 != 0 {
+		m = md5.New()
+		hashes = append(hashes, m)
+	}
+	if h.algorithms&SHA1 != 0 {
+		s1 = sha1.New()
+		hashes = append(hashes, s1)
+	}
+	if h.algorithms&SHA256 != 0 {
+		s256 = sha256.New()
+		hashes = append(hashes, s256)
+	}
+	buf := h.buffers.Get().(*[]byte)
+	defer h.buffers.Put(buf)
+	var read int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return read, Digests{}, err
+		}
+		n, err := r.Read(*buf)
+		if n > 0 {
+			for _, d := range hashes {
+				d.Write((*buf)[:n])
+			}
+			read += int64(n)
+			if path != "" {
+				h.report(Progress{Path: path, Read: read, Size: size})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return read, Digests{}, err
+		}
+	}
+	var d Digests
+	if m != nil {
+		d.MD5 = hex.EncodeToString(m.Sum(nil))
+	}
+	if s1 != nil {
+		d.SHA1 = hex.EncodeToString(s1.Sum(nil))
+	}
+	if s256 != nil {
+		d.SHA256 = hex.EncodeToString(s256.Sum(nil))
+	}
+	return read, d, nil
+}
+
+// report calls the progress function if there is one
+func (h *Hasher) report(p Progress) {
+	if h.progress != nil {
+		h.progress(p)
+	}
+}
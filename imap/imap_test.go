@@ -0,0 +1,173 @@
+package imap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// imapServer is a fake IMAP server with an empty mailbox, supporting STARTTLS if it has a TLS config
+type imapServer struct {
+	l        net.Listener
+	config   *tls.Config
+	mu       sync.Mutex
+	commands []string // commands are the commands received, prefixed with TLS if sent over TLS
+}
+
+// newIMAPServer starts a server and returns it with the TLS config of its clients
+func newIMAPServer(t *testing.T, startTLS bool) (*imapServer, *tls.Config) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	// The certificate of a test HTTPS server, which its client trusts
+	https := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(https.Close)
+	s := &imapServer{l: l}
+	if startTLS {
+		s.config = https.TLS
+	}
+	go s.serve()
+	config := https.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	config.ServerName = "127.0.0.1"
+	return s, config
+}
+
+func (s *imapServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *imapServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	io.WriteString(conn, "* OK IMAP4rev1 ready\r\n")
+	r := bufio.NewReader(conn)
+	secure := false
+	for {
+		cmd, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, cmd, _ := strings.Cut(strings.TrimRight(cmd, "\r\n"), " ")
+		s.mu.Lock()
+		if secure {
+			s.commands = append(s.commands, "TLS "+cmd)
+		} else {
+			s.commands = append(s.commands, cmd)
+		}
+		s.mu.Unlock()
+		var reply string
+		switch name, _, _ := strings.Cut(cmd, " "); name {
+		case "CAPABILITY":
+			reply = "* CAPABILITY IMAP4rev1 MOVE"
+			if s.config != nil && !secure {
+				reply += " STARTTLS"
+			}
+			reply += "\r\n"
+		case "STARTTLS":
+			io.WriteString(conn, tag+" OK Begin TLS negotiation now\r\n")
+			c := tls.Server(conn, s.config)
+			if c.Handshake() != nil {
+				return
+			}
+			conn, r, secure = c, bufio.NewReader(c), true
+			continue
+		case "SELECT":
+			reply = "* 0 EXISTS\r\n* OK [UIDVALIDITY 42] UIDs valid\r\n"
+		case "UID":
+			reply = "* SEARCH\r\n"
+		}
+		io.WriteString(conn, reply+tag+" OK done\r\n")
+	}
+}
+
+func (s *imapServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// poll polls the mailbox once, returning the error of the source if it is not the end of the mailbox
+func poll(t *testing.T, mailboxURL string, options ...OptionFunc) error {
+	t.Helper()
+	m, err := New(mailboxURL, t.TempDir(), append(options, SetPassword("secret"), SetDrain(true))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if _, err = m.Source(pipeline.Submission{}).Next(context.Background()); err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func TestStartTLS(t *testing.T) {
+	s, config := newIMAPServer(t, true)
+	if err := poll(t, "imap://user@"+s.l.Addr().String()+"/INBOX", SetTLSConfig(config)); err != nil {
+		t.Fatal(err)
+	}
+	commands := s.received()
+	if len(commands) < 3 || commands[1] != "STARTTLS" || commands[2] != `TLS LOGIN "user" "secret"` {
+		t.Fatalf("Expected to log in after STARTTLS, got %q", commands)
+	}
+}
+
+func TestPlaintextLogin(t *testing.T) {
+	s, _ := newIMAPServer(t, false)
+	mailboxURL := "imap://user@" + s.l.Addr().String() + "/INBOX"
+	var ierr *infinigo.Error
+	if err := poll(t, mailboxURL); !errors.As(err, &ierr) || ierr.ID != "bad_option" {
+		t.Fatalf("Expected logging in without TLS to be refused, got %v", err)
+	}
+	for _, cmd := range s.received() {
+		if strings.HasPrefix(cmd, "LOGIN") {
+			t.Fatalf("Expected the password not to be sent, got %q", s.received())
+		}
+	}
+	if err := poll(t, mailboxURL, SetPlaintextLogin(true)); err != nil {
+		t.Fatalf("Expected logging in without TLS to be allowed, got %v", err)
+	}
+}
+
+func TestNewRejectsLineBreaks(t *testing.T) {
+	tests := map[string][]OptionFunc{
+		"password": {SetPassword("secret\r\nA1 DELETE INBOX")},
+		"folder":   {SetPassword("secret"), SetFolder(infinigo.VerdictMalicious, "Junk\nA1 DELETE INBOX")},
+		"NUL":      {SetPassword("sec\x00ret")},
+	}
+	for name, options := range tests {
+		t.Run(name, func(t *testing.T) {
+			var ierr *infinigo.Error
+			if _, err := New("imaps://user@mail.example.com/INBOX", t.TempDir(), options...); !errors.As(err, &ierr) || ierr.ID != "bad_option" {
+				t.Fatalf("Expected the %s to be rejected, got %v", name, err)
+			}
+		})
+	}
+	if _, err := New("imaps://user@mail.example.com/IN%0D%0ABOX", t.TempDir(), SetPassword("secret")); err == nil {
+		t.Fatal("Expected the mailbox to be rejected")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got := quote(`a "b" \c`); got != `"a \"b\" \\c"` {
+		t.Fatalf("Expected quotes and backslashes to be escaped, got %s", got)
+	}
+}
@@ -6,27 +6,36 @@ Written by Slavik Markovich at Demisto
 package infinigo
 
 import (
-	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
+	APIVersion          = "2.0"                            // APIVersion of the Infinity API implemented by this library
 	DefaultURL          = "https://api.cylance.com/apiv2/" // DefaultURL is the URL for the API endpoint
 	AuthHeader          = "X-IAUTH"                        // AuthHeader for the API key
 	ContentTypeHeader   = "Content-Type"                   // Header for Content-Type
 	ContentLengthHeader = "Content-Length"                 // Header for Content-Length
 	GzipContentType     = "application/xgzip"
+	QueryBatchSize      = 100 // QueryBatchSize is the number of hashes QueryAll sends in a single request
 )
 
 // Error structs are returned from this library for known error conditions
@@ -50,7 +59,29 @@ type Client struct {
 	url      string       // Infinity URL
 	errorlog *log.Logger  // Optional logger to write errors to
 	tracelog *log.Logger  // Optional logger to write trace and debug data to
+	nobodies bool         // Do not dump response bodies to the trace log
 	c        *http.Client // The client to use for requests
+
+	uploadLimit int64         // Upload bandwidth limit in bytes per second, 0 for unlimited
+	audit       *AuditLog     // Optional log of every request sent
+	logger      *slog.Logger  // Optional structured logger of errors and traces
+	timeout     time.Duration // Request timeout set with SetTimeout
+
+	queryRetries int           // Number of times hashes answered with an error are queried again
+	retryDelay   time.Duration // Delay before querying them again
+
+	stripPrefixes bool // Strip the algorithm prefixes of the hashes queried
+	warmConns     int  // Number of connections New opens ahead of the requests
+
+	adaptive       *adaptive // Optional controller of the requests in flight
+	spoolThreshold int64     // Size of the compressed upload bodies spilled to a temporary file, 0 to never spill
+
+	uploadRetries    int           // Number of times failed uploads are sent again
+	uploadRetryDelay time.Duration // Delay before sending them again
+	uploadVerify     bool          // Query whether Infinity received an upload before sending it again
+
+	confirmFormat *regexp.Regexp // Optional pattern of the confirm codes uploaded with
+	confirmCodes  *confirmCodes  // Confirm codes received, to fail uploads with expired ones
 }
 
 // OptionFunc is a function that configures a Client.
@@ -59,15 +90,46 @@ type OptionFunc func(*Client) error
 
 // errorf logs to the error log.
 func (c *Client) errorf(format string, args ...interface{}) {
-	if c.errorlog != nil {
-		c.errorlog.Printf(format, args...)
-	}
+	c.logf(context.Background(), slog.LevelError, format, args...)
 }
 
 // tracef logs to the trace log.
 func (c *Client) tracef(format string, args ...interface{}) {
-	if c.tracelog != nil {
-		c.tracelog.Printf(format, args...)
+	c.logf(context.Background(), slog.LevelDebug, format, args...)
+}
+
+// errorContext logs to the error log with the log fields of the context.
+func (c *Client) errorContext(ctx context.Context, format string, args ...interface{}) {
+	c.logf(ctx, slog.LevelError, format, args...)
+}
+
+// traceContext logs to the trace log with the log fields of the context.
+func (c *Client) traceContext(ctx context.Context, format string, args ...interface{}) {
+	c.logf(ctx, slog.LevelDebug, format, args...)
+}
+
+// logf logs to the error log or the trace log depending on the level, and to the structured logger.
+// The log fields of the context prefix the lines of the error and trace logs, and are attributes of
+// the records of the structured logger.
+func (c *Client) logf(ctx context.Context, level slog.Level, format string, args ...interface{}) {
+	logger := c.tracelog
+	if level >= slog.LevelError {
+		logger = c.errorlog
+	}
+	if logger == nil && c.logger == nil {
+		return
+	}
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	fields := LogFields(ctx)
+	if logger != nil {
+		if len(fields) > 0 {
+			logger.Print(formatFields(fields) + " " + msg)
+		} else {
+			logger.Print(msg)
+		}
+	}
+	if c.logger != nil {
+		c.logger.LogAttrs(ctx, level, msg, fields...)
 	}
 }
 
@@ -87,7 +149,8 @@ func (c *Client) tracef(format string, args ...interface{}) {
 // If no HttpClient is configured, then http.DefaultClient is used.
 // You can use your own http.Client with some http.Transport for advanced scenarios.
 //
-// An error is also returned when some configuration option is invalid.
+// An error is also returned when some configuration options are invalid or inconsistent with each
+// other. All the options are checked, and the errors of several are returned joined.
 func New(options ...OptionFunc) (*Client, error) {
 	// Set up the client
 	c := &Client{
@@ -96,18 +159,60 @@ func New(options ...OptionFunc) (*Client, error) {
 	}
 
 	// Run the options on it
+	err := c.apply(options)
+	c.tracef("Using URL [%s]\n", c.url)
+	if err != nil {
+		return nil, err
+	}
+	if c.warmConns > 0 {
+		c.prewarm()
+	}
+	return c, nil
+}
+
+// apply runs the options on the client and validates the resulting configuration. All the options
+// run even if some fail.
+func (c *Client) apply(options []OptionFunc) error {
+	var errs []error
 	for _, option := range options {
 		if err := option(c); err != nil {
-			return nil, err
+			errs = append(errs, err)
 		}
 	}
-	c.tracef("Using URL [%s]\n", c.url)
-
-	if c.key == "" {
+	if c.key == "" && !slices.Contains(errs, error(ErrMissingCredentials)) {
 		c.errorf("Missing credentials")
+		errs = append(errs, ErrMissingCredentials)
+	}
+	if c.timeout > 0 && c.c.Timeout != c.timeout {
+		err := &Error{ID: "bad_option", Details: "SetHTTPClient after SetTimeout drops the timeout, SetTimeout must come after it"}
+		c.errorf("%v\n", err)
+		errs = append(errs, err)
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// WithKey returns a copy of the client with the same configuration using another API key
+func (c *Client) WithKey(key string) (*Client, error) {
+	if key == "" {
 		return nil, ErrMissingCredentials
 	}
-	return c, nil
+	clone := *c
+	clone.key = key
+	return &clone, nil
+}
+
+// With returns a copy of the client with the options applied over its configuration, e.g. another key,
+// logger or timeout. The client itself is not changed, and what the options do not override, including
+// the http.Client and the audit log, is shared with the copy.
+func (c *Client) With(options ...OptionFunc) (*Client, error) {
+	clone := *c
+	if err := clone.apply(options); err != nil {
+		return nil, err
+	}
+	return &clone, nil
 }
 
 // Initialization functions
@@ -138,6 +243,24 @@ func SetHTTPClient(httpClient *http.Client) OptionFunc {
 	}
 }
 
+// SetTimeout sets the time limit of requests, including reading the response. It applies to a copy of
+// the http.Client, so the one passed to SetHTTPClient, or http.DefaultClient, is not changed, and it
+// must come after SetHTTPClient in the options, New fails otherwise. 0 means no limit.
+func SetTimeout(timeout time.Duration) OptionFunc {
+	return func(c *Client) error {
+		if timeout < 0 {
+			err := &Error{ID: "bad_option", Details: "Timeout cannot be negative"}
+			c.errorf("%v\n", err)
+			return err
+		}
+		hc := *c.c
+		hc.Timeout = timeout
+		c.c = &hc
+		c.timeout = timeout
+		return nil
+	}
+}
+
 // SetURL defines the URL endpoint for Infinity
 func SetURL(rawurl string) OptionFunc {
 	return func(c *Client) error {
@@ -179,40 +302,69 @@ func SetTraceLog(logger *log.Logger) func(*Client) error {
 	}
 }
 
+// SetTraceBodies controls whether response bodies are written to the trace log along with
+// the headers. It is true by default.
+func SetTraceBodies(dump bool) func(*Client) error {
+	return func(c *Client) error {
+		c.nobodies = !dump
+		return nil
+	}
+}
+
 // dumpRequest dumps a request to the debug logger if it was defined
-func (c *Client) dumpRequest(req *http.Request) {
-	if c.tracelog != nil {
+func (c *Client) dumpRequest(ctx context.Context, req *http.Request) {
+	if c.tracing(ctx) {
 		out, err := httputil.DumpRequestOut(req, false)
 		if err == nil {
-			c.tracef("%s\n", string(out))
+			c.traceContext(ctx, "%s\n", string(out))
 		}
 	}
 }
 
 // dumpResponse dumps a response to the debug logger if it was defined
-func (c *Client) dumpResponse(resp *http.Response) {
-	if c.tracelog != nil {
-		out, err := httputil.DumpResponse(resp, true)
+func (c *Client) dumpResponse(ctx context.Context, resp *http.Response) {
+	if c.tracing(ctx) {
+		out, err := httputil.DumpResponse(resp, !c.nobodies)
 		if err == nil {
-			c.tracef("%s\n", string(out))
+			c.traceContext(ctx, "%s\n", string(out))
 		}
 	}
 }
 
+// tracing returns whether traces are logged
+func (c *Client) tracing(ctx context.Context) bool {
+	return c.tracelog != nil || c.logger != nil && c.logger.Enabled(ctx, slog.LevelDebug)
+}
+
+// logging returns whether errors are logged
+func (c *Client) logging(ctx context.Context) bool {
+	return c.errorlog != nil || c.logger != nil && c.logger.Enabled(ctx, slog.LevelError)
+}
+
 // Request handling functions
 
-// handleError will handle responses with status code different from success
-func (c *Client) handleError(resp *http.Response) error {
+// handleError will handle responses with status code different from success, and maintenance pages
+// returned instead of the JSON expected
+func (c *Client) handleError(ctx context.Context, resp *http.Response, expectJSON bool) error {
+	if err := serviceUnavailable(resp, expectJSON); err != nil {
+		c.errorContext(ctx, "%v\n", err)
+		return err
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		if c.errorlog != nil {
+		if c.logging(ctx) {
 			out, err := httputil.DumpResponse(resp, true)
 			if err == nil {
-				c.errorf("%s\n", string(out))
+				c.errorContext(ctx, "%s\n", string(out))
 			}
 		}
-		msg := fmt.Sprintf("Unexpected status code: %d (%s)", resp.StatusCode, http.StatusText(resp.StatusCode))
-		c.errorf(msg)
-		return &Error{ID: "http_error", Details: msg}
+		if resp.StatusCode == http.StatusRequestEntityTooLarge && resp.Request != nil {
+			err := payloadTooLarge(resp, resp.Request.ContentLength)
+			c.errorContext(ctx, "%v\n", err)
+			return err
+		}
+		err := &StatusError{Status: resp.StatusCode}
+		c.errorContext(ctx, "%v\n", err)
+		return err
 	}
 	return nil
 }
@@ -220,7 +372,8 @@ func (c *Client) handleError(resp *http.Response) error {
 // do executes the API request.
 // Returns the response if the status code is between 200 and 299
 // `body` is an optional body for the POST requests.
-func (c *Client) do(method, rawurl string, params map[string]string, body io.Reader, bodyLength int, result interface{}) error {
+// `entry` describes the request in the audit log, if there is one.
+func (c *Client) do(ctx context.Context, method, rawurl string, params map[string]string, body io.Reader, bodyLength int, result interface{}, entry *AuditEntry) (err error) {
 	if len(params) > 0 {
 		values := url.Values{}
 		for k, v := range params {
@@ -229,7 +382,7 @@ func (c *Client) do(method, rawurl string, params map[string]string, body io.Rea
 		rawurl += "?" + values.Encode()
 	}
 
-	req, err := http.NewRequest(method, c.url+rawurl, body)
+	req, err := http.NewRequestWithContext(ctx, method, c.url+rawurl, body)
 	if err != nil {
 		return err
 	}
@@ -238,40 +391,92 @@ func (c *Client) do(method, rawurl string, params map[string]string, body io.Rea
 	if body != nil {
 		req.Header.Set(ContentTypeHeader, GzipContentType)
 		req.Header.Set(ContentLengthHeader, strconv.Itoa(bodyLength))
+		req.ContentLength = int64(bodyLength)
+	}
+	t := time.Now()
+	if c.tracing(ctx) {
+		c.dumpRequest(ctx, req)
+		c.traceContext(ctx, "Start request %s at %v", rawurl, t)
+	}
+	var resp *http.Response
+	var recorder *recordingBody
+	if c.audit != nil && entry != nil {
+		defer func() {
+			err = c.record(entry, req, resp, recorder, t, err)
+		}()
 	}
-	var t time.Time
-	if c.tracelog != nil {
-		c.dumpRequest(req)
-		t = time.Now()
-		c.tracef("Start request %s at %v", rawurl, t)
+	if c.adaptive != nil {
+		if err = c.adaptive.acquire(ctx); err != nil {
+			return err
+		}
+		sent := time.Now()
+		defer func() {
+			c.adaptive.release(time.Since(sent), resp, err)
+		}()
 	}
-	resp, err := c.c.Do(req)
-	if c.tracelog != nil {
-		c.tracef("End request %s at %v - took %v", rawurl, time.Now(), time.Since(t))
+	resp, err = c.c.Do(req)
+	if c.tracing(ctx) {
+		c.traceContext(ctx, "End request %s at %v - took %v", rawurl, time.Now(), time.Since(t))
 	}
 	if err != nil {
 		return err
 	}
 	if resp.Body != nil {
 		defer resp.Body.Close()
+		if c.audit != nil && entry != nil {
+			// Keep the response for the audit log
+			recorder = &recordingBody{ReadCloser: resp.Body}
+			resp.Body = recorder
+		}
 	}
-	if err = c.handleError(resp); err != nil {
+	// Bodies dumped to writers are not necessarily JSON
+	_, raw := result.(io.Writer)
+	if err = c.handleError(ctx, resp, !raw); err != nil {
 		return err
 	}
-	c.dumpResponse(resp)
+	c.dumpResponse(ctx, resp)
+	if resp.Body != nil {
+		body := &checkedBody{ReadCloser: resp.Body, expected: resp.ContentLength}
+		resp.Body = body
+		defer func() {
+			err = body.check(err)
+		}()
+		// Gateways may strip the bodies of responses, which are then empty results rather than bad JSON
+		if resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		if empty, err := body.empty(); empty || err != nil {
+			return err
+		}
+	}
 	if result != nil {
 		switch result := result.(type) {
+		// Decoded by the caller as it is read
+		case func(io.Reader) error:
+			if err = result(resp.Body); err != nil {
+				return err
+			}
 		// Should we just dump the response body
 		case io.Writer:
 			if _, err = io.Copy(result, resp.Body); err != nil {
 				return err
 			}
+		case *map[string]QueryResponse:
+			if err = decodeQueryResponses(resp.Body, result); err != nil {
+				if c.logging(ctx) {
+					out, err := httputil.DumpResponse(resp, true)
+					if err == nil {
+						c.errorContext(ctx, "%s\n", string(out))
+					}
+				}
+				return err
+			}
 		default:
 			if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
-				if c.errorlog != nil {
+				if c.logging(ctx) {
 					out, err := httputil.DumpResponse(resp, true)
 					if err == nil {
-						c.errorf("%s\n", string(out))
+						c.errorContext(ctx, "%s\n", string(out))
 					}
 				}
 				return err
@@ -305,47 +510,174 @@ type UploadResponse struct {
 
 // Query the Infinity API for a given list of endpoints
 // If classifier is not provided, "all" will be selected. Options are none, ml, industry, human, all.
-// Hashes can be any MD5, SHA1 and SHA256
+// Hashes can be any MD5, SHA1 and SHA256. They are trimmed and lowercased before they are sent, and the
+// responses are keyed by the hashes as given.
 func (c *Client) Query(classifiers string, hash ...string) (resp map[string]QueryResponse, err error) {
+	return c.QueryContext(context.Background(), classifiers, hash...)
+}
+
+// QueryContext queries as Query does with the context of the request, whose log fields are logged
+// along with the errors and traces of the request
+func (c *Client) QueryContext(ctx context.Context, classifiers string, hash ...string) (resp map[string]QueryResponse, err error) {
 	if len(hash) == 0 {
 		return nil, &Error{ID: "missing_arg", Details: "hash is required"}
 	}
 	if classifiers == "" {
 		classifiers = "all"
 	}
-	resp = make(map[string]QueryResponse)
-	err = c.do("GET", "q", map[string]string{"c": classifiers, "h": strings.Join(hash, ",")}, nil, 0, &resp)
+	normalized, originals := c.normalizeHashes(hash)
+	resp, err = c.query(ctx, classifiers, normalized)
+	if err != nil {
+		return
+	}
+	if c.queryRetries > 0 {
+		c.retryFailed(ctx, classifiers, resp)
+	}
+	return originalKeys(resp, originals), nil
+}
+
+// query sends a single query request
+func (c *Client) query(ctx context.Context, classifiers string, hash []string) (resp map[string]QueryResponse, err error) {
+	resp = make(map[string]QueryResponse, len(hash))
+	entry := &AuditEntry{Action: AuditQuery, Classifiers: classifiers, Hashes: hash}
+	err = c.do(ctx, "GET", "q", map[string]string{"c": classifiers, "h": strings.Join(hash, ",")}, nil, 0, &resp, entry)
+	if err == nil && c.confirmCodes != nil {
+		for _, r := range resp {
+			c.confirmCodes.add(r)
+		}
+	}
 	return
 }
 
+// QueryAll queries any number of hashes by splitting them into batches of QueryBatchSize
+// and merging the responses. It stops on the first failed batch.
+func (c *Client) QueryAll(classifiers string, hash ...string) (resp map[string]QueryResponse, err error) {
+	return c.QueryAllContext(context.Background(), classifiers, hash...)
+}
+
+// QueryAllContext queries as QueryAll does with the context of the requests
+func (c *Client) QueryAllContext(ctx context.Context, classifiers string, hash ...string) (resp map[string]QueryResponse, err error) {
+	if len(hash) == 0 {
+		return nil, &Error{ID: "missing_arg", Details: "hash is required"}
+	}
+	if c.adaptive != nil && len(hash) > QueryBatchSize {
+		return c.queryParallel(ctx, classifiers, hash)
+	}
+	resp = make(map[string]QueryResponse, len(hash))
+	for start := 0; start < len(hash); start += QueryBatchSize {
+		end := start + QueryBatchSize
+		if end > len(hash) {
+			end = len(hash)
+		}
+		batch, err := c.QueryContext(ctx, classifiers, hash[start:end]...)
+		if err != nil {
+			return resp, err
+		}
+		for k, v := range batch {
+			resp[k] = v
+		}
+	}
+	return resp, nil
+}
+
 // Upload a file to Infinity API
 func (c *Client) Upload(confirmCode string, data io.Reader) (resp map[string]UploadResponse, err error) {
+	return c.upload(context.Background(), confirmCode, "", data)
+}
+
+// UploadContext uploads as Upload does with the context of the request
+func (c *Client) UploadContext(ctx context.Context, confirmCode string, data io.Reader) (resp map[string]UploadResponse, err error) {
+	return c.upload(ctx, confirmCode, "", data)
+}
+
+// gzipWriters are reused across uploads, as each writer allocates large buffers
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// upload sends the data, recording the path it was read from in the audit log
+func (c *Client) upload(ctx context.Context, confirmCode, path string, data io.Reader) (resp map[string]UploadResponse, err error) {
 	if confirmCode == "" {
 		return nil, &Error{ID: "missing_arg", Details: "Confirmation code is required"}
 	}
 	if data == nil {
 		return nil, &Error{ID: "missing_arg", Details: "Data is required"}
 	}
+	if err = c.checkConfirmCode(confirmCode); err != nil {
+		c.errorContext(ctx, "%v\n", err)
+		return nil, err
+	}
 	// Looks like Infinity API is really particular regarding the content length so need to actually specify it
-	// and cannot stream the body - bad for memory but current workaround
-	buf := &bytes.Buffer{}
-	gw := gzip.NewWriter(buf)
-	defer gw.Close()
-	if _, err = io.Copy(gw, data); err != nil {
+	// and cannot stream the body - it is held in memory, or spooled to a file past SetUploadSpoolThreshold
+	buf := &spool{threshold: c.spoolThreshold}
+	defer buf.Close()
+	gw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(gw)
+	gw.Reset(buf)
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(gw, h), data)
+	if err != nil {
 		return
 	}
-	gw.Close()
-	resp = make(map[string]UploadResponse)
-	err = c.do("PUT", "u/"+confirmCode, nil, buf, buf.Len(), &resp)
-	return
+	if err = gw.Close(); err != nil {
+		return
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	// The compressed body is kept until the upload succeeds or runs out of retries, which send it again
+	for attempt := 0; ; attempt++ {
+		sent := &countingReader{r: buf.reader()}
+		var body io.Reader = sent
+		if c.uploadLimit > 0 {
+			body = &throttledReader{r: body, limit: c.uploadLimit}
+		}
+		resp = make(map[string]UploadResponse)
+		entry := &AuditEntry{Action: AuditUpload, Hashes: []string{digest}, ConfirmCode: confirmCode, Path: path, Size: size}
+		err = c.do(ctx, "PUT", "u/"+confirmCode, nil, body, int(buf.size), &resp, entry)
+		if err == nil || attempt >= c.uploadRetries || !retryable(err) {
+			return
+		}
+		// Infinity may have received an upload failing after its data was sent, sending it again would submit it twice
+		unconfirmed := sent.n > 0 && !rejected(err)
+		if unconfirmed && !c.uploadVerify {
+			err = &UploadUnconfirmedError{Sent: sent.n, Err: err}
+			c.errorContext(ctx, "%v\n", err)
+			return
+		}
+		// Waiting as long as Infinity asked to when it is unavailable
+		delay := c.uploadRetryDelay
+		var unavailable *ServiceUnavailableError
+		if errors.As(err, &unavailable) {
+			delay = max(delay, unavailable.RetryAfter)
+		}
+		c.traceContext(ctx, "Retrying upload %s in %v, attempt %d of %d - %v\n", confirmCode, delay, attempt+1, c.uploadRetries, err)
+		select {
+		case <-ctx.Done():
+			return resp, ctx.Err()
+		case <-time.After(delay):
+		}
+		if unconfirmed {
+			received, verr := c.verifyUpload(ctx, digest)
+			if verr != nil {
+				err = &UploadUnconfirmedError{Sent: sent.n, Err: err}
+				c.errorContext(ctx, "%v, checking whether it was received failed - %v\n", err, verr)
+				return
+			}
+			if received != nil {
+				return received, nil
+			}
+		}
+	}
 }
 
 // UploadFile to the Infinity API
 func (c *Client) UploadFile(confirmCode, path string) (resp map[string]UploadResponse, err error) {
+	return c.UploadFileContext(context.Background(), confirmCode, path)
+}
+
+// UploadFileContext uploads as UploadFile does with the context of the request
+func (c *Client) UploadFileContext(ctx context.Context, confirmCode, path string) (resp map[string]UploadResponse, err error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return
 	}
 	defer f.Close()
-	return c.Upload(confirmCode, f)
+	return c.upload(ctx, confirmCode, path, f)
 }
@@ -0,0 +1,27 @@
+// Package backoff retries requests to other services with an exponential backoff
+package backoff
+
+import (
+	"context"
+	"time"
+)
+
+// Retry calls try until it succeeds, fails in a way not worth retrying or was retried retries times.
+// It waits delay before the first retry, doubling it before every other one, and stops waiting when the
+// context is done, returning its error.
+func Retry(ctx context.Context, retries int, delay time.Duration, try func() (retry bool, err error)) error {
+	for attempt := 0; ; attempt++ {
+		retry, err := try()
+		if err == nil || !retry || attempt >= retries {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
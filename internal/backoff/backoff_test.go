@@ -0,0 +1,43 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	fail := errors.New("fail")
+	tests := []struct {
+		name    string
+		retries int
+		retry   bool
+		want    int
+	}{
+		{"retried", 2, true, 3},
+		{"not worth retrying", 2, false, 1},
+		{"no retries", 0, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := Retry(context.Background(), tt.retries, time.Millisecond, func() (bool, error) {
+				calls++
+				return tt.retry, fail
+			})
+			if err != fail || calls != tt.want {
+				t.Fatalf("Expected %d calls failing, got %d calls with %v", tt.want, calls, err)
+			}
+		})
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := Retry(ctx, 5, time.Hour, func() (bool, error) { return true, errors.New("fail") })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the wait to stop with the context, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+// Package sigv4 signs requests to AWS services with AWS Signature Version 4
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Credentials of an AWS account
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string // SessionToken of temporary credentials, empty otherwise
+}
+
+// FromEnv returns the credentials of the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables
+func FromEnv() Credentials {
+	return Credentials{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// Region returns the region of the AWS_REGION or AWS_DEFAULT_REGION environment variables, or def if neither is set
+func Region(def string) string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	if region := os.Getenv("AWS_DEFAULT_REGION"); region != "" {
+		return region
+	}
+	return def
+}
+
+// PayloadHash returns the hex SHA-256 of the body, as signed
+func PayloadHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign adds the authorization of the request to the service in the region at the time, signing its host,
+// content type and x-amz-* headers. payloadHash is the hex SHA-256 of the body.
+func Sign(req *http.Request, c Credentials, region, service, payloadHash string, now time.Time) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+	authorize(req, c, region, service, payloadHash, now)
+}
+
+// authorize sets the Authorization header of the request, whose X-Amz-Date header is set to now in UTC
+func authorize(req *http.Request, c Credentials, region, service, payloadHash string, now time.Time) {
+	date := now.Format("20060102")
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); k == "content-type" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, k := range names {
+		canonical.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	request := strings.Join([]string{req.Method, req.URL.EscapedPath(), canonicalQuery(req.URL.RawQuery), canonical.String(), signed, payloadHash}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.AccessKey, scope, signed, signature))
+}
+
+// canonicalQuery returns the query with its parameters sorted and escaped as signed, and an equal sign
+// after the parameters without a value
+func canonicalQuery(rawQuery string) string {
+	// A malformed parameter is signed as parsed, and the service refuses the signature
+	query, _ := url.ParseQuery(rawQuery)
+	params := make([][2]string, 0, len(query))
+	for k, values := range query {
+		for _, v := range values {
+			params = append(params, [2]string{escape(k), escape(v)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		return params[i][0] < params[j][0] || params[i][0] == params[j][0] && params[i][1] < params[j][1]
+	})
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(pairs, "&")
+}
+
+// escape escapes all but the unreserved characters of the string
+func escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
@@ -0,0 +1,57 @@
+package infinigo
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// logFieldsKey is the key of the log fields of a context
+type logFieldsKey struct{}
+
+// WithLogFields returns a copy of the context with log fields, such as the tenant, job ID or source path
+// of a multi-tenant pipeline, given as alternating keys and values or as slog.Attr as slog.Logger.Info
+// takes them. The client includes the fields in the errors and traces it logs for the requests made with
+// the context, e.g. with QueryContext. Fields already in the context are kept.
+//
+// Example:
+//
+//	ctx = infinigo.WithLogFields(ctx, "tenant", "acme", "job", jobID)
+//	resp, err := client.QueryContext(ctx, "", hash)
+func WithLogFields(ctx context.Context, args ...any) context.Context {
+	// A record parses the arguments as slog does
+	r := slog.NewRecord(time.Time{}, 0, "", 0)
+	r.Add(args...)
+	fields := append([]slog.Attr(nil), LogFields(ctx)...)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = append(fields, a)
+		return true
+	})
+	return context.WithValue(ctx, logFieldsKey{}, fields)
+}
+
+// LogFields returns the log fields of the context
+func LogFields(ctx context.Context) []slog.Attr {
+	fields, _ := ctx.Value(logFieldsKey{}).([]slog.Attr)
+	return fields
+}
+
+// formatFields formats the fields as key=value pairs prefixing the lines of the error and trace logs
+func formatFields(fields []slog.Attr) string {
+	pairs := make([]string, len(fields))
+	for i, a := range fields {
+		pairs[i] = a.String()
+	}
+	return strings.Join(pairs, " ")
+}
+
+// SetLogger sets a structured logger of the errors, at the error level, and traces, at the debug level,
+// of the client, with the log fields of the context of the requests as attributes. It is nil by default,
+// and is used along with the loggers of SetErrorLog and SetTraceLog.
+func SetLogger(logger *slog.Logger) OptionFunc {
+	return func(c *Client) error {
+		c.logger = logger
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+package infinigo
+
+import "math"
+
+// MergeFunc picks the response to keep when two results being merged have one for the same hash,
+// current being the response kept so far and next the one of the result merged into it
+type MergeFunc func(current, next QueryResponse) QueryResponse
+
+// LaterWins keeps the response of the result merged last, e.g. to apply overrides over fresh
+// responses over cached ones
+func LaterWins(current, next QueryResponse) QueryResponse {
+	return next
+}
+
+// BestScore keeps the most conclusive response: a response without an error over one with an error,
+// a scored response over an unscored one, and the score furthest from 0 over a weaker one. The
+// response kept so far wins a tie.
+func BestScore(current, next QueryResponse) QueryResponse {
+	if rank, nextRank := scoreRank(current), scoreRank(next); nextRank != rank {
+		if nextRank > rank {
+			return next
+		}
+		return current
+	}
+	if math.Abs(float64(next.GeneralScore)) > math.Abs(float64(current.GeneralScore)) {
+		return next
+	}
+	return current
+}
+
+// scoreRank orders responses from failed to scored
+func scoreRank(r QueryResponse) int {
+	switch {
+	case r.Error != "":
+		return 0
+	case r.GeneralScore == 0:
+		return 1
+	}
+	return 2
+}
+
+// Merge combines the results, in order, into a new map with the responses of every hash. The
+// responses for a hash in several results are picked by pick, LaterWins if nil.
+func Merge(pick MergeFunc, results ...map[string]QueryResponse) map[string]QueryResponse {
+	if pick == nil {
+		pick = LaterWins
+	}
+	size := 0
+	for _, result := range results {
+		size += len(result)
+	}
+	merged := make(map[string]QueryResponse, size)
+	for _, result := range results {
+		for h, r := range result {
+			if current, ok := merged[h]; ok {
+				r = pick(current, r)
+			}
+			merged[h] = r
+		}
+	}
+	return merged
+}
@@ -0,0 +1,61 @@
+package infinigo
+
+import "strings"
+
+// hashPrefixes are the algorithm prefixes tools write hashes with, e.g. sha256:<hex> in container
+// image digests
+var hashPrefixes = []string{"sha256:", "sha1:", "md5:"}
+
+// SetStripHashPrefixes strips the algorithm prefixes sha256:, sha1: and md5: from the hashes queried.
+// It is false by default.
+func SetStripHashPrefixes(strip bool) OptionFunc {
+	return func(c *Client) error {
+		c.stripPrefixes = strip
+		return nil
+	}
+}
+
+// normalizeHash trims and lowercases the hash, and strips its prefix if the client is configured to
+func (c *Client) normalizeHash(hash string) string {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if c.stripPrefixes {
+		for _, prefix := range hashPrefixes {
+			if strings.HasPrefix(hash, prefix) {
+				return strings.TrimSpace(hash[len(prefix):])
+			}
+		}
+	}
+	return hash
+}
+
+// normalizeHashes returns the normalized hashes to query, without duplicates, and the hashes of the
+// caller each one was normalized from
+func (c *Client) normalizeHashes(hash []string) ([]string, map[string][]string) {
+	normalized := make([]string, 0, len(hash))
+	originals := make(map[string][]string, len(hash))
+	for _, h := range hash {
+		n := c.normalizeHash(h)
+		if _, ok := originals[n]; !ok {
+			normalized = append(normalized, n)
+		}
+		originals[n] = append(originals[n], h)
+	}
+	return normalized, originals
+}
+
+// originalKeys keys the responses by the hashes of the caller. Infinity may key them in another case,
+// and responses for hashes that were not asked for are kept under their own key.
+func originalKeys(resp map[string]QueryResponse, originals map[string][]string) map[string]QueryResponse {
+	keyed := make(map[string]QueryResponse, len(resp))
+	for k, v := range resp {
+		hashes, ok := originals[strings.ToLower(k)]
+		if !ok {
+			keyed[k] = v
+			continue
+		}
+		for _, h := range hashes {
+			keyed[h] = v
+		}
+	}
+	return keyed
+}
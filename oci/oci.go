@@ -0,0 +1,519 @@
+/*
+Package oci reads the layers of container images, pulled from a registry with the Docker Registry HTTP
+API V2 that OCI distribution registries implement, or saved as a tarball by docker save.
+
+A registry image is resolved to the manifest of one platform, linux/amd64 by default, and its layers are
+downloaded and verified against their digests as they are read. Anonymous pulls get a token from the
+registry, as Docker Hub requires, and SetCredentials authenticates for private repositories.
+*/
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/demisto/infinigo"
+)
+
+const (
+	DefaultRegistry = "docker.io"   // DefaultRegistry of references without one
+	DefaultPlatform = "linux/amd64" // DefaultPlatform of the image pulled from a multi platform index
+)
+
+// Media types of manifests
+const (
+	mediaTypeOCIIndex        = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest     = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList      = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest  = "application/vnd.docker.distribution.manifest.v2+json"
+	acceptedManifestTypes    = mediaTypeOCIIndex + ", " + mediaTypeOCIManifest + ", " + mediaTypeDockerList + ", " + mediaTypeDockerManifest
+	maxManifestSize          = 4 << 20
+	dockerHubRegistryAddress = "registry-1.docker.io"
+)
+
+// Layer is a layer of an image
+type Layer struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// Image is an image whose layers can be read
+type Image interface {
+	// Layers returns the layers of the image, from the base one up
+	Layers(ctx context.Context) ([]Layer, error)
+	// OpenLayer returns the layer as a tar stream, decompressed
+	OpenLayer(ctx context.Context, layer Layer) (io.ReadCloser, error)
+}
+
+// Reference is a parsed image reference such as alpine:3.19 or ghcr.io/org/app@sha256:...
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses the image reference as docker does, defaulting to Docker Hub and the latest tag
+func ParseReference(ref string) (Reference, error) {
+	r := Reference{Registry: DefaultRegistry}
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.Digest = name[:i], name[i+1:]
+		if !strings.Contains(r.Digest, ":") {
+			return r, &infinigo.Error{ID: "bad_request", Details: fmt.Sprintf("Bad digest in image reference [%s]", ref)}
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i:], "/") {
+		name, r.Tag = name[:i], name[i+1:]
+	}
+	if i := strings.Index(name, "/"); i >= 0 {
+		if first := name[:i]; strings.ContainsAny(first, ".:") || first == "localhost" {
+			r.Registry, name = first, name[i+1:]
+		}
+	}
+	if name == "" || name != strings.ToLower(name) {
+		return r, &infinigo.Error{ID: "bad_request", Details: fmt.Sprintf("Bad image reference [%s]", ref)}
+	}
+	if r.Registry == DefaultRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	r.Repository = name
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	return r, nil
+}
+
+// String returns the reference in its canonical form
+func (r Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// Registry pulls images from registries
+type Registry struct {
+	c         *http.Client
+	username  string
+	password  string
+	platform  string
+	plainHTTP bool
+}
+
+// OptionFunc is a function that configures a Registry.
+// It is used in New
+type OptionFunc func(*Registry) error
+
+// New creates a registry client
+func New(options ...OptionFunc) (*Registry, error) {
+	r := &Registry{c: http.DefaultClient, platform: DefaultPlatform}
+	for _, option := range options {
+		if err := option(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// SetHTTPClient sets the client of the requests to registries and their token services, e.g. to trust the
+// CA of a private registry. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(r *Registry) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		r.c = c
+		return nil
+	}
+}
+
+// SetCredentials sets the user name and password or access token of the registries
+func SetCredentials(username, password string) OptionFunc {
+	return func(r *Registry) error {
+		r.username, r.password = username, password
+		return nil
+	}
+}
+
+// SetPlatform sets the os/architecture[/variant] of the image pulled from a multi platform index.
+// It is DefaultPlatform by default.
+func SetPlatform(platform string) OptionFunc {
+	return func(r *Registry) error {
+		if strings.Count(platform, "/") < 1 || strings.Count(platform, "/") > 2 {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad platform [%s], expected e.g. linux/arm64", platform)}
+		}
+		r.platform = platform
+		return nil
+	}
+}
+
+// SetPlainHTTP talks to registries over plain HTTP, e.g. to a local registry in a build pipeline
+func SetPlainHTTP(plain bool) OptionFunc {
+	return func(r *Registry) error {
+		r.plainHTTP = plain
+		return nil
+	}
+}
+
+// remoteImage is an image of a registry
+type remoteImage struct {
+	r     *Registry
+	ref   Reference
+	base  string // base URL of the repository
+	token string // token of the bearer challenge of the registry
+}
+
+// Image returns the image of the reference
+func (r *Registry) Image(ref Reference) Image {
+	host := ref.Registry
+	if host == DefaultRegistry {
+		host = dockerHubRegistryAddress
+	}
+	scheme := "https"
+	if r.plainHTTP {
+		scheme = "http"
+	}
+	return &remoteImage{r: r, ref: ref, base: scheme + "://" + host + "/v2/" + ref.Repository}
+}
+
+// descriptor describes a manifest or a layer
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	Platform  *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+		Variant      string `json:"variant"`
+	} `json:"platform"`
+}
+
+// manifest is an image manifest or an index of manifests
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Manifests []descriptor `json:"manifests"`
+	Layers    []descriptor `json:"layers"`
+}
+
+func (img *remoteImage) Layers(ctx context.Context) ([]Layer, error) {
+	reference := img.ref.Digest
+	if reference == "" {
+		reference = img.ref.Tag
+	}
+	m, err := img.manifest(ctx, reference)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Manifests) > 0 {
+		digest := ""
+		for _, d := range m.Manifests {
+			if d.Platform == nil {
+				continue
+			}
+			p := d.Platform.OS + "/" + d.Platform.Architecture
+			if p == img.r.platform || d.Platform.Variant != "" && p+"/"+d.Platform.Variant == img.r.platform {
+				digest = d.Digest
+				break
+			}
+		}
+		if digest == "" {
+			return nil, &infinigo.Error{ID: "not_found", Details: fmt.Sprintf("No %s image in %s", img.r.platform, img.ref)}
+		}
+		if m, err = img.manifest(ctx, digest); err != nil {
+			return nil, err
+		}
+	}
+	layers := make([]Layer, len(m.Layers))
+	for i, d := range m.Layers {
+		layers[i] = Layer{Digest: d.Digest, Size: d.Size}
+	}
+	return layers, nil
+}
+
+// manifest gets the manifest or index of the tag or digest
+func (img *remoteImage) manifest(ctx context.Context, reference string) (*manifest, error) {
+	resp, err := img.get(ctx, "/manifests/"+reference, acceptedManifestTypes)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var m manifest
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&m); err != nil {
+		return nil, &infinigo.Error{ID: "bad_response", Details: fmt.Sprintf("Bad manifest of %s - %v", img.ref, err)}
+	}
+	return &m, nil
+}
+
+func (img *remoteImage) OpenLayer(ctx context.Context, layer Layer) (io.ReadCloser, error) {
+	resp, err := img.get(ctx, "/blobs/"+layer.Digest, "")
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = resp.Body
+	if algorithm, expected, ok := strings.Cut(layer.Digest, ":"); ok && algorithm == "sha256" {
+		r = &verifyingReader{r: resp.Body, h: sha256.New(), expected: expected, digest: layer.Digest}
+	}
+	return decompress(r, resp.Body)
+}
+
+// get sends a GET request for the path of the repository, answering the authentication challenge of the
+// registry if there is one
+func (img *remoteImage) get(ctx context.Context, path, accept string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, img.base+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if img.token != "" {
+			req.Header.Set("Authorization", "Bearer "+img.token)
+		} else if img.r.username != "" {
+			req.SetBasicAuth(img.r.username, img.r.password)
+		}
+		resp, err := img.r.c.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		challenge := resp.Header.Get("WWW-Authenticate")
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 && strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+			if err = img.authenticate(ctx, challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return nil, &infinigo.Error{ID: "unauthorized", Details: fmt.Sprintf("Registry refused access to %s - %s", img.ref, strings.TrimSpace(string(msg)))}
+		case http.StatusNotFound:
+			return nil, &infinigo.Error{ID: "not_found", Details: fmt.Sprintf("Registry has no %s in %s", strings.TrimPrefix(path, "/"), img.ref)}
+		}
+		return nil, &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("Registry returned %d for %s - %s", resp.StatusCode, img.ref, strings.TrimSpace(string(msg)))}
+	}
+}
+
+// authenticate gets a token for the bearer challenge, e.g.
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/alpine:pull"
+func (img *remoteImage) authenticate(ctx context.Context, challenge string) error {
+	params := map[string]string{}
+	for _, p := range strings.Split(challenge[len("bearer "):], ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok {
+			params[strings.ToLower(k)] = strings.Trim(v, `"`)
+		}
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme == "" {
+		return &infinigo.Error{ID: "bad_response", Details: fmt.Sprintf("Bad registry authentication challenge [%s]", challenge)}
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + img.ref.Repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if img.r.username != "" {
+		req.SetBasicAuth(img.r.username, img.r.password)
+	}
+	resp, err := img.r.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &infinigo.Error{ID: "unauthorized", Details: fmt.Sprintf("Registry token service returned %d for %s", resp.StatusCode, img.ref)}
+	}
+	var out struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return &infinigo.Error{ID: "bad_response", Details: fmt.Sprintf("Bad registry token - %v", err)}
+	}
+	img.token = out.Token
+	if img.token == "" {
+		img.token = out.AccessToken
+	}
+	return nil
+}
+
+// verifyingReader fails the read reaching the end of a blob whose digest does not match
+type verifyingReader struct {
+	r        io.Reader
+	h        hash.Hash
+	expected string
+	digest   string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(v.h.Sum(nil)) != v.expected {
+		return n, &infinigo.Error{ID: "bad_response", Details: fmt.Sprintf("Layer %s does not match its digest", v.digest)}
+	}
+	return n, err
+}
+
+// readCloser reads from a reader and closes a closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// decompress returns the tar stream of a layer that is either gzipped or not compressed
+func decompress(r io.Reader, c io.Closer) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		return readCloser{gz, c}, nil
+	case len(magic) == 4 && magic[0] == 0x28 && magic[1] == 0xb5 && magic[2] == 0x2f && magic[3] == 0xfd:
+		c.Close()
+		return nil, &infinigo.Error{ID: "bad_request", Details: "Layers compressed with zstd are not supported"}
+	}
+	return readCloser{br, c}, nil
+}
+
+// archiveImage is an image saved by docker save
+type archiveImage struct {
+	path   string
+	layers []string // paths of the layers in the tarball
+}
+
+// OpenArchive opens the tarball of the image saved by docker save, or the first one if it has many
+func OpenArchive(path string) (Image, error) {
+	img := &archiveImage{path: path}
+	var entries []struct {
+		Layers []string `json:"Layers"`
+	}
+	err := img.find("manifest.json", func(r io.Reader) error {
+		return json.NewDecoder(io.LimitReader(r, maxManifestSize)).Decode(&entries)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, &infinigo.Error{ID: "bad_request", Details: fmt.Sprintf("%s has no image", path)}
+	}
+	img.layers = entries[0].Layers
+	return img, nil
+}
+
+func (img *archiveImage) Layers(ctx context.Context) ([]Layer, error) {
+	layers := make([]Layer, len(img.layers))
+	for i, p := range img.layers {
+		// OCI layouts name blobs by their digest, blobs/sha256/<hex>
+		digest := p
+		if rest, ok := strings.CutPrefix(p, "blobs/"); ok {
+			digest = strings.Replace(rest, "/", ":", 1)
+		}
+		layers[i] = Layer{Digest: digest}
+	}
+	return layers, nil
+}
+
+func (img *archiveImage) OpenLayer(ctx context.Context, layer Layer) (io.ReadCloser, error) {
+	for i, l := range img.layers {
+		if digest := strings.Replace(strings.TrimPrefix(l, "blobs/"), "/", ":", 1); l != layer.Digest && digest != layer.Digest {
+			continue
+		}
+		f, err := os.Open(img.path)
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(f)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				f.Close()
+				if err == io.EOF {
+					return nil, &infinigo.Error{ID: "not_found", Details: fmt.Sprintf("%s has no layer %s", img.path, img.layers[i])}
+				}
+				return nil, err
+			}
+			if hdr.Name == l {
+				return decompress(tr, f)
+			}
+		}
+	}
+	return nil, &infinigo.Error{ID: "not_found", Details: fmt.Sprintf("%s has no layer %s", img.path, layer.Digest)}
+}
+
+// find calls fn with the file of the tarball with the name
+func (img *archiveImage) find(name string, fn func(r io.Reader) error) error {
+	f, err := os.Open(img.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return &infinigo.Error{ID: "bad_request", Details: fmt.Sprintf("%s is not an image saved by docker save, it has no %s", img.path, name)}
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name == name {
+			return fn(tr)
+		}
+	}
+}
+
+// Walk calls fn with the header and content of every regular file of the layer tar stream, stopping at the
+// first error it returns. The rest of the stream is read so the digest of a pulled layer is verified.
+func Walk(layer io.Reader, fn func(hdr *tar.Header, r io.Reader) error) error {
+	tr := tar.NewReader(layer)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			_, err = io.Copy(io.Discard, layer)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Package openioc writes the hashes of malicious files as an OpenIOC 1.0 document, for legacy endpoint
+sweep tools that consume IOC XML.
+
+The document has a single OR indicator with an item per hash, matching the MD5, SHA-1 or SHA-256 of
+FileItem depending on the length of the hash.
+*/
+package openioc
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// Namespace of OpenIOC 1.0 documents
+const Namespace = "http://schemas.mandiant.com/2010/ioc"
+
+// IOC is an OpenIOC document
+type IOC struct {
+	XMLName          xml.Name  `xml:"ioc"`
+	Xmlns            string    `xml:"xmlns,attr"`
+	ID               string    `xml:"id,attr"`
+	LastModified     string    `xml:"last-modified,attr"`
+	ShortDescription string    `xml:"short_description"`
+	Description      string    `xml:"description"`
+	AuthoredBy       string    `xml:"authored_by"`
+	AuthoredDate     string    `xml:"authored_date"`
+	Links            struct{}  `xml:"links"`
+	Definition       Indicator `xml:"definition>Indicator"`
+}
+
+// Indicator combines its items with the operator, AND or OR
+type Indicator struct {
+	ID       string          `xml:"id,attr"`
+	Operator string          `xml:"operator,attr"`
+	Items    []IndicatorItem `xml:"IndicatorItem"`
+}
+
+// IndicatorItem matches a term of the context
+type IndicatorItem struct {
+	ID        string  `xml:"id,attr"`
+	Condition string  `xml:"condition,attr"`
+	Context   Context `xml:"Context"`
+	Content   Content `xml:"Content"`
+}
+
+// Context is the term an item matches
+type Context struct {
+	Document string `xml:"document,attr"`
+	Search   string `xml:"search,attr"`
+	Type     string `xml:"type,attr"`
+}
+
+// Content is the value an item matches
+type Content struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// New returns the document of the hashes of the malicious results, created at now
+func New(results []pipeline.Result, now time.Time) *IOC {
+	t := now.UTC().Format("2006-01-02T15:04:05")
+	ioc := &IOC{
+		Xmlns:            Namespace,
+		ID:               uuid4(),
+		LastModified:     t,
+		ShortDescription: "Infinity malicious files",
+		AuthoredBy:       "infinigo",
+		AuthoredDate:     t,
+		Definition:       Indicator{ID: uuid4(), Operator: "OR"},
+	}
+	seen := make(map[string]bool)
+	for _, r := range results {
+		if r.Response.Verdict() != infinigo.VerdictMalicious || seen[r.Submission.Hash] {
+			continue
+		}
+		term, contentType, ok := search(r.Submission.Hash)
+		if !ok {
+			continue
+		}
+		seen[r.Submission.Hash] = true
+		ioc.Definition.Items = append(ioc.Definition.Items, IndicatorItem{
+			ID:        uuid4(),
+			Condition: "is",
+			Context:   Context{Document: "FileItem", Search: term, Type: "mir"},
+			Content:   Content{Type: contentType, Value: r.Submission.Hash},
+		})
+	}
+	ioc.Description = fmt.Sprintf("%d files Cylance Infinity scored as malicious", len(ioc.Definition.Items))
+	return ioc
+}
+
+// Write writes the document as indented XML
+func (ioc *IOC) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(ioc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// search returns the FileItem term of the hash and its content type by the length of the hash
+func search(hash string) (string, string, bool) {
+	switch len(hash) {
+	case 32:
+		return "FileItem/Md5sum", "md5", true
+	case 40:
+		return "FileItem/Sha1sum", "sha1", true
+	case 64:
+		return "FileItem/Sha256sum", "sha256", true
+	}
+	return "", "", false
+}
+
+func uuid4() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,137 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// reserves are the fractions of the daily quota kept for higher priorities
+var reserves = map[Priority]float64{PriorityBulk: 0.25, PriorityNormal: 0.05}
+
+// capStrikes is the number of rate limit errors in a row at the same usage after which the inferred
+// quota is taken as the daily quota, rather than a rate limit that passes
+const capStrikes = 5
+
+// budget tracks the hashes queried today against the daily quota of the API key. Days start at midnight UTC.
+type budget struct {
+	path     string
+	mu       sync.Mutex
+	quota    int64                   // quota is the configured daily quota, 0 if unknown
+	backoff  func(int) time.Duration // backoff returns how long the inferred quota holds after rate limit errors in a row
+	Date     string                  `json:"date"`
+	Used     int64                   `json:"used"`               // Used is the number of hashes queried today
+	Limited  bool                    `json:"limited,omitempty"`  // Limited is true if the quota was inferred from rate limit errors
+	Inferred int64                   `json:"inferred,omitempty"` // Inferred is the quota inferred from rate limit errors today
+	Strikes  int                     `json:"strikes,omitempty"`  // Strikes is the number of rate limit errors in a row at the inferred quota
+	Until    time.Time               `json:"until,omitempty"`    // Until is when lower priorities probe the inferred quota again
+}
+
+// SetDailyQuota sets the number of hashes the API key may query per day. As the quota depletes, bulk
+// submissions are paced over the day and stop at 25% remaining, normal ones at 5% remaining, and high
+// priority ones can use the rest. Work resumes when the quota resets at midnight UTC.
+//
+// Whether or not it is set, the quota is also inferred when Infinity starts rate limiting the pipeline,
+// pausing all but high priority submissions for a backoff, after which they probe it again. After 5 rate
+// limit errors in a row at the same usage, the inferred quota holds until the quota resets.
+func SetDailyQuota(queries int64) OptionFunc {
+	return func(p *Pipeline) error {
+		if queries < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Daily quota cannot be negative"}
+		}
+		p.budget.quota = queries
+		return nil
+	}
+}
+
+// loadBudget reads the usage of the day stored in the queue directory
+func loadBudget(dir string, backoff func(int) time.Duration) *budget {
+	b := &budget{path: filepath.Join(dir, "budget"), backoff: backoff}
+	if data, err := os.ReadFile(b.path); err == nil {
+		json.Unmarshal(data, b)
+	}
+	return b
+}
+
+// today resets the usage on a new day. Must be called with the lock held.
+func (b *budget) today(now time.Time) {
+	if date := now.UTC().Format("2006-01-02"); b.Date != date {
+		b.Date, b.Used, b.Limited, b.Inferred, b.Strikes, b.Until = date, 0, false, 0, 0, time.Time{}
+	}
+}
+
+// inferred returns true if the inferred quota holds for the priority. High priorities ignore it so they
+// probe whether it was the daily quota, and lower ones once its backoff passed. Must be called with the
+// lock held.
+func (b *budget) inferred(prio Priority, now time.Time) bool {
+	return b.Limited && prio < PriorityHigh && now.Before(b.Until)
+}
+
+// limit returns the quota in effect for the priority, 0 if unknown. Must be called with the lock held.
+func (b *budget) limit(prio Priority, now time.Time) int64 {
+	if b.inferred(prio, now) && (b.quota == 0 || b.Inferred < b.quota) {
+		return b.Inferred
+	}
+	return b.quota
+}
+
+// allowance returns how many hashes of the priority can be queried now, -1 for no limit
+func (b *budget) allowance(prio Priority, now time.Time) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.today(now)
+	limit := b.limit(prio, now)
+	if limit == 0 {
+		if b.inferred(prio, now) {
+			return 0
+		}
+		return -1
+	}
+	n := limit - int64(float64(limit)*reserves[prio]) - b.Used
+	if prio <= PriorityBulk {
+		// Pace bulk work so it does not use the quota faster than the day goes by
+		day := now.UTC().Sub(now.UTC().Truncate(24 * time.Hour))
+		if paced := int64(float64(limit)*day.Hours()/24) - b.Used; paced < n {
+			n = paced
+		}
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// spend records queried hashes. A rate limited query infers the quota from today's usage for a backoff
+// that grows with every rate limited query in a row, until capStrikes of them make it the quota for the
+// rest of the day. A successful query clears the inferred quota, as the rate limit was not the daily quota.
+func (b *budget) spend(hashes int, limited bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.today(now)
+	switch {
+	case limited:
+		// Successful queries clear the inferred quota, so a limited budget is still at the same usage
+		if b.Limited {
+			b.Strikes++
+		} else {
+			b.Limited, b.Inferred, b.Strikes = true, b.Used, 1
+		}
+		if b.Strikes >= capStrikes {
+			b.Until = now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		} else {
+			b.Until = now.Add(b.backoff(b.Strikes))
+		}
+	default:
+		b.Used += int64(hashes)
+		if b.Limited && b.Used > b.Inferred {
+			b.Limited, b.Inferred, b.Strikes, b.Until = false, 0, 0, time.Time{}
+		}
+	}
+	if data, err := json.Marshal(b); err == nil {
+		os.WriteFile(b.path, data, 0600)
+	}
+}
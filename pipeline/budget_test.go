@@ -0,0 +1,50 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetInferredQuotaExpires(t *testing.T) {
+	b := loadBudget(t.TempDir(), func(strikes int) time.Duration { return time.Duration(strikes) * time.Minute })
+	now := time.Date(2016, 1, 2, 12, 0, 0, 0, time.UTC)
+	b.spend(100, false, now)
+	b.spend(0, true, now)
+	if n := b.allowance(PriorityNormal, now); n != 0 {
+		t.Fatalf("Expected no allowance while rate limited, got %d", n)
+	}
+	if n := b.allowance(PriorityHigh, now); n != -1 {
+		t.Fatalf("Expected high priorities to probe the inferred quota, got %d", n)
+	}
+	if n := b.allowance(PriorityNormal, now.Add(time.Minute)); n != -1 {
+		t.Fatalf("Expected the inferred quota to expire after its backoff, got %d", n)
+	}
+	// Rate limited again at the same usage, the backoff grows
+	b.spend(0, true, now.Add(time.Minute))
+	if n := b.allowance(PriorityNormal, now.Add(2*time.Minute)); n != 0 {
+		t.Fatalf("Expected a longer backoff, got %d", n)
+	}
+	if n := b.allowance(PriorityNormal, now.Add(3*time.Minute)); n != -1 {
+		t.Fatalf("Expected the inferred quota to expire after the longer backoff, got %d", n)
+	}
+	b.spend(10, false, now.Add(3*time.Minute))
+	if b.Limited || b.Strikes != 0 {
+		t.Fatal("Expected a successful query to clear the inferred quota")
+	}
+}
+
+func TestBudgetInferredQuotaHoldsForTheDay(t *testing.T) {
+	b := loadBudget(t.TempDir(), func(int) time.Duration { return time.Minute })
+	now := time.Date(2016, 1, 2, 12, 0, 0, 0, time.UTC)
+	b.spend(100, false, now)
+	for i := 0; i < capStrikes; i++ {
+		now = now.Add(time.Minute)
+		b.spend(0, true, now)
+	}
+	if n := b.allowance(PriorityNormal, now.Add(11*time.Hour)); n != 0 {
+		t.Fatalf("Expected the inferred quota to hold for the rest of the day, got %d", n)
+	}
+	if n := b.allowance(PriorityNormal, time.Date(2016, 1, 3, 0, 0, 0, 0, time.UTC)); n != -1 {
+		t.Fatalf("Expected the quota to reset on a new day, got %d", n)
+	}
+}
@@ -0,0 +1,486 @@
+/*
+Package pipeline sends hashes and files to Infinity through a durable queue.
+
+Submissions are stored on disk before they are processed and removed only once their result was
+sent to the sinks, so bursts of submissions and Infinity outages do not lose any of them. Queued
+submissions are replayed when the pipeline starts again, so a sink may get a result twice after a crash.
+
+Submissions are processed by priority, then in the order they were added. While Infinity rate limits
+the pipeline, bulk submissions yield to the others for longer.
+
+Queries are sent in batches. Files are uploaded when Infinity requests them and the submission asks
+for it, and queried again after the upload. Failed submissions are retried with an exponential backoff,
+while unreachable API errors pause the whole pipeline without counting as attempts. Submissions
+running out of attempts are dropped, or moved to a dead letter queue with their last error to be
+requeued once the problem is fixed.
+
+With SetRescan, submissions with an unknown verdict stay queued and are queried again with a backoff
+until a verdict appears.
+
+The hashes queried every day are counted against the daily quota of the API key, see SetDailyQuota,
+so lower priority work slows down and pauses as the quota depletes.
+*/
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// Defaults for the pipeline
+const (
+	DefaultMaxAttempts = 5                // DefaultMaxAttempts before a submission is dropped or dead lettered
+	DefaultMinBackoff  = time.Second      // DefaultMinBackoff is the delay before the first retry
+	DefaultMaxBackoff  = 10 * time.Minute // DefaultMaxBackoff bounds the delay between retries
+	DefaultBatchSize   = 100              // DefaultBatchSize is the number of hashes queried together
+)
+
+// Priority orders submissions, higher priorities are processed first
+type Priority int
+
+// Priorities of submissions
+const (
+	PriorityBulk   Priority = -1 // PriorityBulk is for background sweeps, yielding to other work under rate limits
+	PriorityNormal Priority = 0  // PriorityNormal is the default priority
+	PriorityHigh   Priority = 1  // PriorityHigh is for incident response, jumping the queue
+)
+
+var priorityNames = map[Priority]string{PriorityBulk: "bulk", PriorityNormal: "normal", PriorityHigh: "high"}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", int(p))
+}
+
+// ParsePriority returns the priority with the given name - bulk, normal or high
+func ParsePriority(name string) (Priority, error) {
+	for p, n := range priorityNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return PriorityNormal, &infinigo.Error{ID: "bad_priority", Details: "Unknown priority [" + name + "]"}
+}
+
+// Submission is a hash or file to check with Infinity
+type Submission struct {
+	ID          string    `json:"id"`
+	Hash        string    `json:"hash"`                  // Hash to query, computed from Path if empty
+	Path        string    `json:"path,omitempty"`        // Path of the file, required for uploads
+	Classifiers string    `json:"classifiers,omitempty"` // Classifiers to query, all if empty
+	Upload      bool      `json:"upload,omitempty"`      // Upload the file if Infinity requests it
+	Priority    Priority  `json:"priority,omitempty"`    // Priority of the submission, PriorityNormal by default
+	ConfirmCode string    `json:"confirmcode,omitempty"` // ConfirmCode of a pending upload
+	Uploaded    bool      `json:"uploaded,omitempty"`    // Uploaded is true once the file was uploaded
+	Added       time.Time `json:"added"`                 // Added is when the submission was queued
+	Attempts    int       `json:"attempts,omitempty"`    // Attempts is the number of failed attempts
+	Error       string    `json:"error,omitempty"`       // Error of the last failed attempt
+	NotBefore   time.Time `json:"not_before,omitempty"`  // NotBefore is when the next attempt is due
+	Failed      time.Time `json:"failed,omitempty"`      // Failed is when the submission ran out of attempts
+	Rescans     int       `json:"rescans,omitempty"`     // Rescans is the number of queries after an unknown verdict
+}
+
+// Retry resets the attempts of a failed submission so it is processed again
+func (s *Submission) Retry() {
+	s.Attempts, s.Error, s.NotBefore, s.Failed = 0, "", time.Time{}, time.Time{}
+}
+
+// Result is the response of Infinity to a submission
+type Result struct {
+	Submission Submission             `json:"submission"`
+	Response   infinigo.QueryResponse `json:"response"`
+	Resolved   bool                   `json:"resolved,omitempty"` // Resolved is true when a rescan found the verdict
+}
+
+// Pipeline processes the submissions in a queue
+type Pipeline struct {
+	client      *infinigo.Client
+	queue       *Queue
+	dead        *Queue // dead receives the submissions that ran out of attempts
+	batchSize   int
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	sinks       Sinks
+	rescan      *scheduler // rescan schedules queries of unknown verdicts, nil to report them as final
+	budget      *budget    // budget tracks the daily quota
+	errorlog    *log.Logger
+	wake        chan struct{}
+	outages     int       // outages is the number of consecutive unreachable, unavailable or rate limited errors
+	resume      time.Time // resume is when to try again after Infinity was unreachable, unavailable or rate limited
+	resumeBulk  time.Time // resumeBulk is when bulk submissions are processed again after rate limits
+}
+
+// OptionFunc is a function that configures a Pipeline.
+// It is used in New
+type OptionFunc func(*Pipeline) error
+
+// New creates a pipeline processing the queue with the given client
+func New(client *infinigo.Client, queue *Queue, options ...OptionFunc) (*Pipeline, error) {
+	if client == nil || queue == nil {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "client and queue are required"}
+	}
+	p := &Pipeline{client: client, queue: queue, batchSize: DefaultBatchSize, maxAttempts: DefaultMaxAttempts,
+		minBackoff: DefaultMinBackoff, maxBackoff: DefaultMaxBackoff, wake: make(chan struct{}, 1)}
+	// Lower priorities wait longer than the pause of the whole pipeline before probing the inferred quota
+	p.budget = loadBudget(queue.dir, func(strikes int) time.Duration { return p.backoff(strikes + 2) })
+	for _, option := range options {
+		if err := option(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// SetMaxAttempts sets how many times a failing submission is tried before it is dropped or dead lettered
+func SetMaxAttempts(attempts int) OptionFunc {
+	return func(p *Pipeline) error {
+		if attempts < 1 {
+			return &infinigo.Error{ID: "bad_option", Details: "Max attempts must be at least 1"}
+		}
+		p.maxAttempts = attempts
+		return nil
+	}
+}
+
+// SetDeadLetterQueue moves submissions that ran out of attempts to the given queue instead of dropping them
+func SetDeadLetterQueue(dead *Queue) OptionFunc {
+	return func(p *Pipeline) error {
+		p.dead = dead
+		return nil
+	}
+}
+
+// SetBackoff sets the delay before the first retry, doubled on every retry up to max
+func SetBackoff(min, max time.Duration) OptionFunc {
+	return func(p *Pipeline) error {
+		if min <= 0 || max < min {
+			return &infinigo.Error{ID: "bad_option", Details: "Backoff must be positive and min cannot exceed max"}
+		}
+		p.minBackoff, p.maxBackoff = min, max
+		return nil
+	}
+}
+
+// SetBatchSize sets how many hashes are queried together
+func SetBatchSize(size int) OptionFunc {
+	return func(p *Pipeline) error {
+		if size < 1 {
+			return &infinigo.Error{ID: "bad_option", Details: "Batch size must be at least 1"}
+		}
+		p.batchSize = size
+		return nil
+	}
+}
+
+// SetErrorLog sets the logger for failed submissions
+func SetErrorLog(logger *log.Logger) OptionFunc {
+	return func(p *Pipeline) error {
+		p.errorlog = logger
+		return nil
+	}
+}
+
+// errorf logs to the error log.
+func (p *Pipeline) errorf(format string, args ...interface{}) {
+	if p.errorlog != nil {
+		p.errorlog.Printf(format, args...)
+	}
+}
+
+// Submit queues a submission, see Queue.Submit
+func (p *Pipeline) Submit(sub Submission) (Submission, error) {
+	sub, err := p.queue.Submit(sub)
+	if err != nil {
+		return sub, err
+	}
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return sub, nil
+}
+
+// Run processes the queue until the context is done. With drain, it returns once the queue is empty
+// but for scheduled rescans, which are left for later runs.
+func (p *Pipeline) Run(ctx context.Context, drain bool) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch, wait := p.next(time.Now())
+		if len(batch) == 0 {
+			if drain && p.drained() {
+				return nil
+			}
+			if wait <= 0 || wait > time.Second {
+				// Poll so submissions added by other processes are picked up
+				wait = time.Second
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-p.wake:
+				timer.Stop()
+			case <-timer.C:
+				if err := p.queue.Reload(); err != nil {
+					p.errorf("Failed reloading the queue - %v\n", err)
+				}
+			}
+			continue
+		}
+		if batch[0].ConfirmCode != "" {
+			p.upload(ctx, batch[0])
+		} else {
+			p.query(ctx, batch)
+		}
+	}
+}
+
+// drained returns true if the queue only holds scheduled rescans and queries waiting for the daily quota
+func (p *Pipeline) drained() bool {
+	now := time.Now()
+	for _, sub := range p.queue.List() {
+		if sub.Rescans == 0 && (sub.ConfirmCode != "" || p.budget.allowance(sub.Priority, now) != 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// next returns the submissions due for processing, either a batch of queries with the same classifiers
+// or a single upload, and otherwise how long until the next one is due
+func (p *Pipeline) next(now time.Time) ([]Submission, time.Duration) {
+	if now.Before(p.resume) {
+		return nil, p.resume.Sub(now)
+	}
+	var batch []Submission
+	var wait time.Duration
+	allowance := make(map[Priority]int64)
+	all := p.queue.List()
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Priority > all[j].Priority })
+	for _, sub := range all {
+		due := sub.NotBefore
+		if sub.Priority <= PriorityBulk && due.Before(p.resumeBulk) {
+			due = p.resumeBulk
+		}
+		if now.Before(due) {
+			if d := due.Sub(now); wait == 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		if sub.ConfirmCode == "" {
+			n, ok := allowance[sub.Priority]
+			if !ok {
+				n = p.budget.allowance(sub.Priority, now)
+				allowance[sub.Priority] = n
+			}
+			if n == 0 {
+				// Waiting for the quota to reset, or for bulk work, for the pace to allow more
+				continue
+			}
+		}
+		switch {
+		case len(batch) == 0 && sub.ConfirmCode != "":
+			return []Submission{sub}, 0
+		case len(batch) == 0 || sub.ConfirmCode == "" && sub.Classifiers == batch[0].Classifiers:
+			batch = append(batch, sub)
+			if n := allowance[sub.Priority]; n > 0 {
+				allowance[sub.Priority] = n - 1
+			}
+		}
+		if len(batch) == p.batchSize {
+			break
+		}
+	}
+	return batch, wait
+}
+
+// query sends a batch of queries and sends their results to the sinks
+func (p *Pipeline) query(ctx context.Context, batch []Submission) {
+	hashes := make([]string, len(batch))
+	for i := range batch {
+		hashes[i] = batch[i].Hash
+	}
+	// Requests in flight are completed when the pipeline stops, the context only carries the log fields
+	report := p.client.QueryAllReport(context.WithoutCancel(ctx), batch[0].Classifiers, hashes...)
+	err := report.Err()
+	unanswered := report.Unanswered()
+	if answered := len(hashes) - len(unanswered); answered > 0 {
+		p.budget.spend(answered, false, time.Now())
+	}
+	if rateLimited(err) {
+		p.budget.spend(0, true, time.Now())
+	}
+	// Only the submissions of the failed requests are retried, the others got their responses
+	failed := make(map[string]error, len(unanswered))
+	for _, f := range report.Failures {
+		for _, h := range f.Hashes {
+			failed[h] = f.Err
+		}
+	}
+	if err == nil {
+		p.outages = 0
+	}
+	for _, sub := range batch {
+		if err, ok := failed[sub.Hash]; ok {
+			p.fail(sub, err)
+			continue
+		}
+		r, ok := lookup(report.Responses, sub.Hash)
+		if !ok {
+			p.fail(sub, &infinigo.Error{ID: "bad_response", Details: "No response for " + sub.Hash})
+			continue
+		}
+		if r.ConfirmCode != "" && sub.Upload && !sub.Uploaded && sub.Path != "" {
+			sub.ConfirmCode, sub.Attempts, sub.Error = r.ConfirmCode, 0, ""
+			if err = p.queue.Update(sub); err != nil {
+				p.errorf("Failed queuing the upload of %s - %v\n", sub.Path, err)
+			}
+			continue
+		}
+		if next, ok := p.rescan.reschedule(sub, r, time.Now()); ok {
+			if err = p.queue.Update(next); err != nil {
+				p.errorf("Failed scheduling the rescan of %s - %v\n", sub.Hash, err)
+			}
+			continue
+		}
+		p.done(ctx, Result{Submission: sub, Response: r, Resolved: sub.Rescans > 0 && r.Verdict() != infinigo.VerdictUnknown})
+	}
+}
+
+// upload sends the file of the submission and queues it to be queried again
+func (p *Pipeline) upload(ctx context.Context, sub Submission) {
+	ctx = infinigo.WithLogFields(context.WithoutCancel(ctx), "submission", sub.ID, "path", sub.Path)
+	if _, err := p.client.UploadFileContext(ctx, sub.ConfirmCode, sub.Path); err != nil {
+		p.fail(sub, err)
+		return
+	}
+	p.outages = 0
+	sub.ConfirmCode, sub.Uploaded, sub.Attempts, sub.Error = "", true, 0, ""
+	if err := p.queue.Update(sub); err != nil {
+		p.errorf("Failed queuing the query of uploaded %s - %v\n", sub.Path, err)
+	}
+}
+
+// done sends the result to the sinks and removes the submission from the queue
+func (p *Pipeline) done(ctx context.Context, r Result) {
+	if err := p.sinks.OnResult(ctx, r); err != nil {
+		p.errorf("Failed sending the result of %s - %v\n", r.Submission.Hash, err)
+	}
+	if err := p.queue.Remove(r.Submission.ID); err != nil {
+		p.errorf("Failed removing submission %s - %v\n", r.Submission.ID, err)
+	}
+}
+
+// fail records a failed attempt, pausing the pipeline if Infinity is unreachable, unavailable or rate
+// limited, as long as it asked to if it did, and dropping or dead lettering the submission once it ran
+// out of attempts
+func (p *Pipeline) fail(sub Submission, err error) {
+	sub.Error = err.Error()
+	var unavailable *infinigo.ServiceUnavailableError
+	if limited := rateLimited(err); limited || infinigo.Unreachable(err) || errors.As(err, &unavailable) {
+		if p.outages < 32 {
+			p.outages++
+		}
+		p.resume = time.Now().Add(p.backoff(p.outages))
+		if unavailable != nil && time.Now().Add(unavailable.RetryAfter).After(p.resume) {
+			p.resume = time.Now().Add(unavailable.RetryAfter)
+		}
+		if limited {
+			p.resumeBulk = time.Now().Add(p.backoff(p.outages + 2))
+		}
+	} else {
+		sub.Attempts++
+		// Uploads too large for Infinity fail again however many times they are retried
+		if sub.Attempts >= p.maxAttempts || errors.Is(err, infinigo.ErrPayloadTooLarge) {
+			if p.dead == nil {
+				p.errorf("Dropping submission %s of %s after %d attempts - %v\n", sub.ID, sub.Hash, sub.Attempts, err)
+				if err := p.queue.Remove(sub.ID); err != nil {
+					p.errorf("Failed removing submission %s - %v\n", sub.ID, err)
+				}
+				return
+			}
+			p.errorf("Dead lettering submission %s of %s after %d attempts - %v\n", sub.ID, sub.Hash, sub.Attempts, err)
+			sub.Failed = time.Now().UTC()
+			if err := p.queue.Move(sub, p.dead); err != nil {
+				p.errorf("Failed dead lettering submission %s - %v\n", sub.ID, err)
+			}
+			return
+		}
+		sub.NotBefore = time.Now().UTC().Add(p.backoff(sub.Attempts))
+	}
+	if err := p.queue.Update(sub); err != nil {
+		p.errorf("Failed updating submission %s - %v\n", sub.ID, err)
+	}
+}
+
+// backoff returns the delay before the given retry
+func (p *Pipeline) backoff(retry int) time.Duration {
+	d := p.minBackoff
+	for i := 1; i < retry && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return d
+}
+
+// rateLimited returns true if Infinity rejected the request for exceeding its rate limit
+func rateLimited(err error) bool {
+	var e *infinigo.StatusError
+	return errors.As(err, &e) && e.Status == http.StatusTooManyRequests
+}
+
+// lookup finds the response of a hash, ignoring the case Infinity returned it in
+func lookup(resp map[string]infinigo.QueryResponse, hash string) (infinigo.QueryResponse, bool) {
+	if r, ok := resp[hash]; ok {
+		return r, true
+	}
+	for k, r := range resp {
+		if strings.EqualFold(k, hash) {
+			return r, true
+		}
+	}
+	return infinigo.QueryResponse{}, false
+}
+
+// hashFile returns the hex encoded SHA256 of the file
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newID returns a unique ID sorting by the time it was created
+func newID(t time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%019d-%s", t.UnixNano(), hex.EncodeToString(b))
+}
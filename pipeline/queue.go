@@ -0,0 +1,219 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// ErrCorruptSubmission matches, with errors.Is, the CorruptError of queued submissions that can not be read
+var ErrCorruptSubmission = &infinigo.Error{ID: "corrupt_submission", Details: "Queued submission is not valid JSON"}
+
+// CorruptError is returned by Queue.Reload for a submission file that is not valid JSON, e.g. one
+// truncated by a crash. The file is renamed to Path so it is not read again and can be inspected.
+type CorruptError struct {
+	Path string `json:"path"` // Path of the file, with a .corrupt suffix unless renaming it failed
+	Err  error  `json:"-"`
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("%s: Moved queued submission to %s - %v", ErrCorruptSubmission.ID, e.Path, e.Err)
+}
+
+// Unwrap returns ErrCorruptSubmission and the decoding error
+func (e *CorruptError) Unwrap() []error {
+	return []error{ErrCorruptSubmission, e.Err}
+}
+
+// Queue is a durable queue of submissions. Every submission is stored as a JSON file in the queue
+// directory until it is removed, so the queue survives restarts and can be fed by other processes.
+// It is safe for concurrent use.
+type Queue struct {
+	dir   string
+	mu    sync.Mutex
+	items map[string]*Submission
+}
+
+// OpenQueue loads the queue from the given directory, creating it if needed. If submissions were
+// corrupt, the queue is returned with the errors of Reload.
+func OpenQueue(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	q := &Queue{dir: dir, items: make(map[string]*Submission)}
+	if err := q.Reload(); err != nil {
+		if errors.Is(err, ErrCorruptSubmission) {
+			return q, err
+		}
+		return nil, err
+	}
+	return q, nil
+}
+
+// Reload picks up submissions added to or removed from the directory by other processes. Submissions
+// that are not valid JSON are moved aside and returned as CorruptError, joined with errors.Join,
+// after the rest are loaded.
+func (q *Queue) Reload() error {
+	names, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	seen := make(map[string]bool, len(names))
+	var errs []error
+	for _, name := range names {
+		id := strings.TrimSuffix(filepath.Base(name), ".json")
+		seen[id] = true
+		if _, ok := q.items[id]; ok {
+			continue
+		}
+		b, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		sub := &Submission{}
+		if err = json.Unmarshal(b, sub); err != nil {
+			// Moved out of the way rather than blocking the queue, the .corrupt suffix is not reloaded
+			corrupt := name + ".corrupt"
+			rerr := os.Rename(name, corrupt)
+			if os.IsNotExist(rerr) {
+				continue
+			}
+			if rerr != nil {
+				corrupt, err = name, errors.Join(err, rerr)
+			}
+			errs = append(errs, &CorruptError{Path: corrupt, Err: err})
+			delete(seen, id)
+			continue
+		}
+		sub.ID = id
+		q.items[id] = sub
+	}
+	for id := range q.items {
+		if !seen[id] {
+			delete(q.items, id)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Add stores a new submission, assigning its ID and time added
+func (q *Queue) Add(sub Submission) (Submission, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	sub.Added = time.Now().UTC()
+	sub.ID = newID(sub.Added)
+	if err := q.save(&sub); err != nil {
+		return sub, err
+	}
+	q.items[sub.ID] = &sub
+	return sub, nil
+}
+
+// Submit adds a new submission, hashing its file if it has no hash
+func (q *Queue) Submit(sub Submission) (Submission, error) {
+	if sub.Hash == "" {
+		if sub.Path == "" {
+			return sub, &infinigo.Error{ID: "missing_arg", Details: "Hash or path is required"}
+		}
+		h, err := hashFile(sub.Path)
+		if err != nil {
+			return sub, err
+		}
+		sub.Hash = h
+	}
+	if sub.Path != "" {
+		if abs, err := filepath.Abs(sub.Path); err == nil {
+			sub.Path = abs
+		}
+	}
+	sub.Hash = strings.ToLower(sub.Hash)
+	return q.Add(sub)
+}
+
+// Update stores the changes to a queued submission
+func (q *Queue) Update(sub Submission) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.items[sub.ID]; !ok {
+		return os.ErrNotExist
+	}
+	if err := q.save(&sub); err != nil {
+		return err
+	}
+	q.items[sub.ID] = &sub
+	return nil
+}
+
+// Remove deletes a submission from the queue
+func (q *Queue) Remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.items, id)
+	if err := os.Remove(q.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Len returns the number of queued submissions
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// List returns copies of the queued submissions in the order they were added
+func (q *Queue) List() []Submission {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	all := make([]Submission, 0, len(q.items))
+	for _, sub := range q.items {
+		all = append(all, *sub)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all
+}
+
+// Move stores the submission in the other queue, keeping its ID, and removes it from this one
+func (q *Queue) Move(sub Submission, to *Queue) error {
+	to.mu.Lock()
+	err := to.save(&sub)
+	if err == nil {
+		to.items[sub.ID] = &sub
+	}
+	to.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return q.Remove(sub.ID)
+}
+
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+// save writes the submission atomically. Must be called with the lock held.
+func (q *Queue) save(sub *Submission) error {
+	b, err := json.MarshalIndent(sub, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := q.path(sub.ID) + ".tmp"
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path(sub.ID))
+}
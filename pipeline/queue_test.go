@@ -0,0 +1,37 @@
+package pipeline
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueueReloadSkipsCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = q.Add(Submission{Hash: "aa"}); err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "0000000000000000001-truncated.json")
+	if err = os.WriteFile(truncated, []byte(`{"hash": "b`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	q, err = OpenQueue(dir)
+	var corrupt *CorruptError
+	if !errors.Is(err, ErrCorruptSubmission) || !errors.As(err, &corrupt) || corrupt.Path != truncated+".corrupt" {
+		t.Fatalf("Expected the truncated submission to be reported, got %v", err)
+	}
+	if q == nil || q.Len() != 1 || q.List()[0].Hash != "aa" {
+		t.Fatalf("Expected the valid submission to be loaded, got %v", q)
+	}
+	if _, err = os.Stat(truncated + ".corrupt"); err != nil {
+		t.Fatalf("Expected the truncated submission to be moved aside - %v", err)
+	}
+	if err = q.Reload(); err != nil {
+		t.Fatalf("Expected the truncated submission not to be read again, got %v", err)
+	}
+}
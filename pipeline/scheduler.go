@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// Defaults for rescans of unknown verdicts
+const (
+	DefaultRescanInterval    = 15 * time.Minute // DefaultRescanInterval is the delay before the first rescan
+	DefaultMaxRescanInterval = 24 * time.Hour   // DefaultMaxRescanInterval bounds the delay between rescans
+	DefaultMaxRescans        = 10               // DefaultMaxRescans before giving up on a verdict
+)
+
+// scheduler decides when submissions with an unknown verdict are queried again
+type scheduler struct {
+	interval    time.Duration // interval before the first rescan, doubled after every rescan
+	maxInterval time.Duration
+	maxRescans  int
+}
+
+// SetRescan keeps submissions with an unknown verdict in the queue and queries them again after the interval,
+// doubling it up to maxInterval, until Infinity has a verdict or maxRescans ran out. The result is sent to
+// the sinks once it is final, with Resolved set if the verdict appeared on a rescan.
+func SetRescan(interval, maxInterval time.Duration, maxRescans int) OptionFunc {
+	return func(p *Pipeline) error {
+		if interval <= 0 || maxInterval < interval || maxRescans < 1 {
+			return &infinigo.Error{ID: "bad_option", Details: "Rescan interval must be positive, not above the max interval, with at least one rescan"}
+		}
+		p.rescan = &scheduler{interval: interval, maxInterval: maxInterval, maxRescans: maxRescans}
+		return nil
+	}
+}
+
+// reschedule returns the submission to query again if its verdict is unknown and it has rescans left
+func (s *scheduler) reschedule(sub Submission, resp infinigo.QueryResponse, now time.Time) (Submission, bool) {
+	if s == nil || resp.Verdict() != infinigo.VerdictUnknown || sub.Rescans >= s.maxRescans {
+		return sub, false
+	}
+	d := s.interval
+	for i := 0; i < sub.Rescans && d < s.maxInterval; i++ {
+		d *= 2
+	}
+	if d > s.maxInterval {
+		d = s.maxInterval
+	}
+	sub.Rescans++
+	sub.Attempts, sub.Error = 0, ""
+	sub.NotBefore = now.UTC().Add(d)
+	return sub, true
+}
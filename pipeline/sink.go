@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/demisto/infinigo"
+)
+
+// Sink receives the final result of every submission, for example to forward it to a SIEM,
+// store it in a database or post it to a chat
+type Sink interface {
+	OnResult(ctx context.Context, r Result) error
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(ctx context.Context, r Result) error
+
+// OnResult calls the function
+func (f SinkFunc) OnResult(ctx context.Context, r Result) error {
+	return f(ctx, r)
+}
+
+// Sinks sends results to all of its sinks
+type Sinks []Sink
+
+// OnResult sends the result to every sink, even if some fail, and returns their errors joined
+func (s Sinks) OnResult(ctx context.Context, r Result) error {
+	var errs []error
+	for _, sink := range s {
+		if err := sink.OnResult(ctx, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the sinks that are io.Closers, for example to send the results they batch,
+// and returns their errors joined
+func (s Sinks) Close() error {
+	var errs []error
+	for _, sink := range s {
+		if c, ok := sink.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AddSink adds a sink receiving the result of every submission before it leaves the queue.
+// It can be used more than once, and sinks are called in the order they were added.
+// Sink errors are logged and do not keep the submission in the queue.
+func AddSink(sink Sink) OptionFunc {
+	return func(p *Pipeline) error {
+		if sink == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "Sink is required"}
+		}
+		p.sinks = append(p.sinks, sink)
+		return nil
+	}
+}
@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// Source produces submissions for the pipeline, for example from directories, message queues, mailboxes
+// or buckets. Next blocks until a submission is available and returns io.EOF once the source is exhausted.
+type Source interface {
+	Next(ctx context.Context) (Submission, error)
+}
+
+// SourceFunc adapts a function to a Source
+type SourceFunc func(ctx context.Context) (Submission, error)
+
+// Next calls the function
+func (f SourceFunc) Next(ctx context.Context) (Submission, error) {
+	return f(ctx)
+}
+
+// Feed submits everything the source produces until it is exhausted, the context is done or submitting
+// fails, and returns the number of submissions
+func Feed(ctx context.Context, src Source, submit func(Submission) (Submission, error)) (int, error) {
+	n := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		sub, err := src.Next(ctx)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if _, err = submit(sub); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// Feed submits everything the source produces to the pipeline, see Feed. It can run along Run.
+func (p *Pipeline) Feed(ctx context.Context, src Source) (int, error) {
+	return Feed(ctx, src, p.Submit)
+}
+
+// Files is a source of the regular files under the given paths. Submissions are copies of the template
+// with the path of every file, so the template sets their priority and whether to upload them.
+func Files(paths []string, template Submission) Source {
+	files := make(chan string)
+	errc := make(chan error, 1)
+	var started bool
+	start := func(ctx context.Context) {
+		go func() {
+			defer close(files)
+			for _, root := range paths {
+				err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+					if err != nil || !d.Type().IsRegular() {
+						return err
+					}
+					select {
+					case files <- path:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				})
+				if err != nil {
+					errc <- err
+					return
+				}
+			}
+		}()
+	}
+	return SourceFunc(func(ctx context.Context) (Submission, error) {
+		if !started {
+			started = true
+			start(ctx)
+		}
+		select {
+		case path, ok := <-files:
+			if !ok {
+				select {
+				case err := <-errc:
+					return Submission{}, err
+				default:
+					return Submission{}, io.EOF
+				}
+			}
+			sub := template
+			sub.Path = path
+			return sub, nil
+		case <-ctx.Done():
+			return Submission{}, ctx.Err()
+		}
+	})
+}
+
+// Hashes is a source of the hashes read from r, one per line. Only the first field of every line is used,
+// so checksum manifests work too, and empty lines and lines starting with # are skipped.
+// Submissions are copies of the template with the hash of every line.
+func Hashes(r io.Reader, template Submission) Source {
+	scanner := bufio.NewScanner(r)
+	return SourceFunc(func(ctx context.Context) (Submission, error) {
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			sub := template
+			sub.Hash = fields[0]
+			return sub, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return Submission{}, err
+		}
+		return Submission{}, io.EOF
+	})
+}
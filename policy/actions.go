@@ -0,0 +1,137 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/quarantine"
+)
+
+// builtins are the action types every policy can use
+var builtins = map[string]Factory{
+	"log":        newLogAction,
+	"exec":       newExecAction,
+	"quarantine": newQuarantineAction,
+}
+
+// newLogAction creates an action appending a line for every result to a file, or standard error by default
+//
+//	type: log
+//	file: /var/log/infinigo-policy.log
+func newLogAction(decode func(v interface{}) error) (Action, error) {
+	var opts struct {
+		File string `yaml:"file"`
+	}
+	if err := decode(&opts); err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	return ActionFunc(func(ctx context.Context, rule string, r pipeline.Result) error {
+		line := fmt.Sprintf("%s\t%s\t%s\t%v\t%s\t%s\n", time.Now().UTC().Format(time.RFC3339), rule, r.Response.Verdict(),
+			r.Response.GeneralScore, r.Submission.Hash, r.Submission.Path)
+		if opts.File == "" {
+			_, err := os.Stderr.WriteString(line)
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err = f.WriteString(line); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}), nil
+}
+
+// newExecAction creates an action running a command for every result. The result is written to its
+// standard input as JSON, and the INFINIGO_RULE, INFINIGO_HASH, INFINIGO_PATH, INFINIGO_VERDICT and
+// INFINIGO_SCORE environment variables describe it.
+//
+//	type: exec
+//	command: [/usr/local/bin/alert, --severity, high]
+//	timeout: 30s
+func newExecAction(decode func(v interface{}) error) (Action, error) {
+	opts := struct {
+		Command []string      `yaml:"command"`
+		Timeout time.Duration `yaml:"timeout"`
+	}{Timeout: time.Minute}
+	if err := decode(&opts); err != nil {
+		return nil, err
+	}
+	if len(opts.Command) == 0 {
+		return nil, fmt.Errorf("command is required")
+	}
+	return ActionFunc(func(ctx context.Context, rule string, r pipeline.Result) error {
+		in, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, opts.Command[0], opts.Command[1:]...)
+		cmd.Stdin = bytes.NewReader(in)
+		cmd.Env = append(os.Environ(), "INFINIGO_RULE="+rule, "INFINIGO_HASH="+r.Submission.Hash, "INFINIGO_PATH="+r.Submission.Path,
+			"INFINIGO_VERDICT="+string(r.Response.Verdict()), fmt.Sprintf("INFINIGO_SCORE=%v", r.Response.GeneralScore))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%v - %s", err, bytes.TrimSpace(out))
+		}
+		return nil
+	}), nil
+}
+
+// newQuarantineAction creates an action moving the file of every result to a quarantine directory, see
+// infcli quarantine restore to undo it. The file is encrypted if the environment variable named by
+// password_env is set.
+//
+//	type: quarantine
+//	dir: /var/lib/infinigo/quarantine
+//	password_env: INFINITY_QUARANTINE_PASSWORD
+func newQuarantineAction(decode func(v interface{}) error) (Action, error) {
+	var opts struct {
+		Dir         string `yaml:"dir"`
+		PasswordEnv string `yaml:"password_env"`
+	}
+	if err := decode(&opts); err != nil {
+		return nil, err
+	}
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("dir is required")
+	}
+	vault, err := quarantine.Open(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	return ActionFunc(func(ctx context.Context, rule string, r pipeline.Result) error {
+		if r.Submission.Path == "" {
+			return fmt.Errorf("no file to quarantine for %s", r.Submission.Hash)
+		}
+		password := ""
+		if opts.PasswordEnv != "" {
+			password = os.Getenv(opts.PasswordEnv)
+		}
+		e, err := vault.Quarantine(r.Submission.Path, rule, password)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(e.Hash, r.Submission.Hash) {
+			// The file changed since it was checked, so the verdict is not about it
+			if _, err = vault.Restore(e.ID, password, ""); err != nil {
+				return err
+			}
+			return fmt.Errorf("%s changed since it was checked, not quarantined", r.Submission.Path)
+		}
+		return nil
+	}), nil
+}
@@ -0,0 +1,260 @@
+// Package policy evaluates rules on every result and runs the actions of the rules that match, so results
+// drive a response rather than only a report.
+//
+// Policies are YAML files with named actions and an ordered list of rules, e.g.
+//
+//	actions:
+//	  isolate:
+//	    type: quarantine
+//	    dir: /var/lib/infinigo/quarantine
+//	  alert:
+//	    type: exec
+//	    command: [/usr/local/bin/page-oncall]
+//	rules:
+//	  - name: malware-in-downloads
+//	    if:
+//	      verdict: [malicious]
+//	      path: ["/home/*/Downloads/**"]
+//	    then: [isolate, alert]
+//
+// The built-in action types are log, exec and quarantine. A rule matches when all of its conditions do.
+// Rules are evaluated in order and evaluation stops at the first matching rule unless it sets continue.
+// The Engine is a pipeline.Sink, so it can be added to the pipeline or fed results directly.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is the YAML configuration of rules and actions
+type Policy struct {
+	Actions map[string]yaml.Node `yaml:"actions"` // Actions by name, each with a type and the options of the type
+	Rules   []Rule               `yaml:"rules"`
+}
+
+// Rule runs actions on the results matching its conditions
+type Rule struct {
+	Name     string    `yaml:"name"`
+	If       Condition `yaml:"if"`
+	Then     []string  `yaml:"then"`     // Then are the names of the actions to run
+	Continue bool      `yaml:"continue"` // Continue evaluating the next rules after a match
+}
+
+// Condition matches results. Empty fields match any result.
+type Condition struct {
+	Verdict    []infinigo.Verdict `yaml:"verdict"`     // Verdict is one of the given verdicts
+	Path       []string           `yaml:"path"`        // Path matches one of the glob patterns, with ** matching directories
+	ScoreBelow *float32           `yaml:"score_below"` // ScoreBelow requires a general score lower than the value
+	ScoreAbove *float32           `yaml:"score_above"` // ScoreAbove requires a general score higher than the value
+	Uploaded   *bool              `yaml:"uploaded"`    // Uploaded requires the file was or was not uploaded
+	paths      []*regexp.Regexp
+}
+
+// Action is run for the results matching a rule
+type Action interface {
+	Run(ctx context.Context, rule string, r pipeline.Result) error
+}
+
+// ActionFunc adapts a function to an Action
+type ActionFunc func(ctx context.Context, rule string, r pipeline.Result) error
+
+// Run calls the function
+func (f ActionFunc) Run(ctx context.Context, rule string, r pipeline.Result) error {
+	return f(ctx, rule, r)
+}
+
+// Factory creates an action of a type. decode reads the options of the action from the policy into a struct.
+type Factory func(decode func(v interface{}) error) (Action, error)
+
+// Engine evaluates a policy
+type Engine struct {
+	rules     []Rule
+	actions   map[string]Action
+	factories map[string]Factory
+}
+
+// OptionFunc is a function that configures an Engine.
+// It is used in New
+type OptionFunc func(*Engine) error
+
+// SetActionType adds a type of action policies can use, or replaces a built-in one
+func SetActionType(name string, factory Factory) OptionFunc {
+	return func(e *Engine) error {
+		e.factories[name] = factory
+		return nil
+	}
+}
+
+// Load reads a policy from a YAML file and creates its engine
+func Load(path string, options ...OptionFunc) (*Engine, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err = yaml.Unmarshal(b, &p); err != nil {
+		return nil, &infinigo.Error{ID: "bad_policy", Details: fmt.Sprintf("%s - %v", path, err)}
+	}
+	return New(p, options...)
+}
+
+// New creates the engine of a policy, checking its rules only use defined actions
+func New(p Policy, options ...OptionFunc) (*Engine, error) {
+	e := &Engine{actions: make(map[string]Action), factories: make(map[string]Factory)}
+	for name, factory := range builtins {
+		e.factories[name] = factory
+	}
+	for _, option := range options {
+		if err := option(e); err != nil {
+			return nil, err
+		}
+	}
+	for name, node := range p.Actions {
+		var kind struct {
+			Type string `yaml:"type"`
+		}
+		if err := node.Decode(&kind); err != nil {
+			return nil, badPolicy("action [%s] - %v", name, err)
+		}
+		factory, ok := e.factories[kind.Type]
+		if !ok {
+			return nil, badPolicy("action [%s] has unknown type [%s]", name, kind.Type)
+		}
+		node := node
+		action, err := factory(node.Decode)
+		if err != nil {
+			return nil, badPolicy("action [%s] - %v", name, err)
+		}
+		e.actions[name] = action
+	}
+	for i, r := range p.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		for _, v := range r.If.Verdict {
+			switch v {
+			case infinigo.VerdictUnknown, infinigo.VerdictBenign, infinigo.VerdictSuspicious, infinigo.VerdictMalicious, infinigo.VerdictError:
+			default:
+				return nil, badPolicy("rule [%s] has unknown verdict [%s]", r.Name, v)
+			}
+		}
+		for _, a := range r.Then {
+			if _, ok := e.actions[a]; !ok {
+				return nil, badPolicy("rule [%s] uses undefined action [%s]", r.Name, a)
+			}
+		}
+		for _, pattern := range r.If.Path {
+			re, err := GlobRegexp(pattern)
+			if err != nil {
+				return nil, badPolicy("rule [%s] has bad path [%s] - %v", r.Name, pattern, err)
+			}
+			r.If.paths = append(r.If.paths, re)
+		}
+		e.rules = append(e.rules, r)
+	}
+	return e, nil
+}
+
+func badPolicy(format string, args ...interface{}) error {
+	return &infinigo.Error{ID: "bad_policy", Details: fmt.Sprintf(format, args...)}
+}
+
+// Match returns the rules matching the result, stopping at the first one that does not continue
+func (e *Engine) Match(r pipeline.Result) []Rule {
+	var matched []Rule
+	for _, rule := range e.rules {
+		if !rule.If.matches(r) {
+			continue
+		}
+		matched = append(matched, rule)
+		if !rule.Continue {
+			break
+		}
+	}
+	return matched
+}
+
+// OnResult runs the actions of the rules matching the result. All actions run even if some fail,
+// and their errors are returned joined.
+func (e *Engine) OnResult(ctx context.Context, r pipeline.Result) error {
+	var errs []error
+	for _, rule := range e.Match(r) {
+		for _, name := range rule.Then {
+			if err := e.actions[name].Run(ctx, rule.Name, r); err != nil {
+				errs = append(errs, fmt.Errorf("rule [%s] action [%s] - %w", rule.Name, name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// matches returns true if the result meets all the conditions
+func (c *Condition) matches(r pipeline.Result) bool {
+	if len(c.Verdict) > 0 {
+		found := false
+		for _, v := range c.Verdict {
+			found = found || v == r.Response.Verdict()
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(c.paths) > 0 {
+		path := filepath.ToSlash(r.Submission.Path)
+		found := false
+		for _, re := range c.paths {
+			found = found || path != "" && re.MatchString(path)
+		}
+		if !found {
+			return false
+		}
+	}
+	switch {
+	case c.ScoreBelow != nil && !(r.Response.GeneralScore < *c.ScoreBelow):
+		return false
+	case c.ScoreAbove != nil && !(r.Response.GeneralScore > *c.ScoreAbove):
+		return false
+	case c.Uploaded != nil && *c.Uploaded != r.Submission.Uploaded:
+		return false
+	}
+	return true
+}
+
+// GlobRegexp converts a glob pattern to a regular expression matching slash separated paths.
+// * and ? do not match /, while ** matches any number of directories.
+func GlobRegexp(pattern string) (*regexp.Regexp, error) {
+	pattern = filepath.ToSlash(pattern)
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			i++
+			if i+1 < len(pattern) && pattern[i+1] == '/' {
+				// **/ also matches no directory at all
+				i++
+				b.WriteString("(.*/)?")
+			} else {
+				b.WriteString(".*")
+			}
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
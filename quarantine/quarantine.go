@@ -0,0 +1,432 @@
+/*
+Package quarantine moves flagged files out of reach into a locked down directory and restores them
+when they turn out to be false positives.
+
+Quarantined files are stored read only under a random name in a directory only the owner can access,
+so they can not be run or opened by their original name. A manifest in the directory keeps the original
+path, permissions and modification time of every file to restore it as it was. Files are optionally
+encrypted with AES-GCM using a key derived from a password, so scanners and users browsing the directory
+do not see the original content. Encrypted files are written and read in chunks, so files of any size
+are quarantined and restored without holding them in memory.
+*/
+package quarantine
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// manifestFile is the name of the manifest in the quarantine directory
+const manifestFile = "manifest.json"
+
+// keyIterations is the PBKDF2 work factor of the encryption key
+const keyIterations = 600000
+
+// chunkSize is the size of the content encrypted at a time
+const chunkSize = 64 << 10
+
+// noncePrefix is the size of the random start of the nonces, followed by the chunk number and whether it is the last
+const noncePrefix = 7
+
+// errModified is returned when an encrypted chunk fails authentication or is missing
+var errModified = errors.New("modified")
+
+// Entry describes a quarantined file
+type Entry struct {
+	ID          string      `json:"id"`
+	Path        string      `json:"path"` // Path is the original absolute path of the file
+	Hash        string      `json:"hash"` // Hash is the SHA256 of the original content
+	Size        int64       `json:"size"`
+	Mode        fs.FileMode `json:"mode"`
+	ModTime     time.Time   `json:"mod_time"`
+	Quarantined time.Time   `json:"quarantined"`
+	Reason      string      `json:"reason,omitempty"` // Reason is why the file was quarantined, e.g. the policy rule
+	Encrypted   bool        `json:"encrypted,omitempty"`
+	Salt        []byte      `json:"salt,omitempty"` // Salt of the encryption key
+}
+
+// Vault is a quarantine directory
+type Vault struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// Open opens the quarantine directory, creating it if needed
+func Open(dir string) (*Vault, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// Lock down directories created by others too
+	if err := os.Chmod(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Vault{dir: dir}, nil
+}
+
+// Dir returns the quarantine directory
+func (v *Vault) Dir() string {
+	return v.dir
+}
+
+// List returns the quarantined files ordered by the time they were quarantined
+func (v *Vault) List() ([]Entry, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	entries, err := v.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// Quarantine moves the file at path into the vault, encrypting it if a password is given
+func (v *Vault) Quarantine(path, reason, password string) (Entry, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return Entry{}, &infinigo.Error{ID: "bad_request", Details: fmt.Sprintf("%s is not a regular file", path)}
+	}
+	now := time.Now()
+	e := Entry{ID: newID(now), Path: path, Size: info.Size(), Mode: info.Mode(), ModTime: info.ModTime(), Quarantined: now.UTC(), Reason: reason}
+	var key []byte
+	if password != "" {
+		e.Encrypted = true
+		e.Salt = make([]byte, 16)
+		rand.Read(e.Salt)
+		if key, err = deriveKey(password, e.Salt); err != nil {
+			return Entry{}, err
+		}
+	}
+	if e.Hash, err = v.store(path, e.ID, key); err != nil {
+		return Entry{}, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	entries, err := v.load()
+	if err == nil {
+		entries[e.ID] = e
+		err = v.save(entries)
+	}
+	if err == nil {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		// Leave the original in place rather than a copy nobody knows how to restore
+		delete(entries, e.ID)
+		v.save(entries)
+		os.Remove(v.path(e.ID))
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// Restore moves a quarantined file back to its original path, or to the given path if not empty.
+// It fails rather than overwrite an existing file.
+func (v *Vault) Restore(id, password, to string) (Entry, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	entries, err := v.load()
+	if err != nil {
+		return Entry{}, err
+	}
+	e, ok := entries[id]
+	if !ok {
+		return Entry{}, &infinigo.Error{ID: "not_found", Details: fmt.Sprintf("No quarantined file %s", id)}
+	}
+	if to == "" {
+		to = e.Path
+	}
+	if e.Encrypted && password == "" {
+		return Entry{}, &infinigo.Error{ID: "missing_arg", Details: fmt.Sprintf("%s is encrypted and requires the password", id)}
+	}
+	content, err := v.read(e, password)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer content.Close()
+	if err = os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return Entry{}, err
+	}
+	f, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, e.Mode.Perm())
+	if err != nil {
+		return Entry{}, err
+	}
+	_, err = io.Copy(f, content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(to, e.ModTime, e.ModTime)
+	}
+	if err != nil {
+		// Also removes content that turned out to be modified once read
+		os.Remove(to)
+		return Entry{}, err
+	}
+	delete(entries, id)
+	if err = v.save(entries); err != nil {
+		return Entry{}, err
+	}
+	os.Chmod(v.path(id), 0600)
+	return e, os.Remove(v.path(id))
+}
+
+// store copies the file into the vault, encrypting it with the key if set, and returns the hash of its content
+func (v *Vault) store(path, id string, key []byte) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	h := sha256.New()
+	tmp := v.path(id) + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if key == nil {
+		_, err = io.Copy(io.MultiWriter(dst, h), src)
+	} else {
+		err = seal(dst, io.TeeReader(src, h), key, id)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0400)
+	}
+	if err == nil {
+		err = os.Rename(tmp, v.path(id))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// read opens the original content of a quarantined file. Reading it fails at the end if it was modified.
+func (v *Vault) read(e Entry, password string) (io.ReadCloser, error) {
+	f, err := os.Open(v.path(e.ID))
+	if err != nil {
+		return nil, err
+	}
+	c := &content{f: f, r: f, h: sha256.New(), e: e}
+	if e.Encrypted {
+		key, err := deriveKey(password, e.Salt)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		// The first chunk is decrypted here, so a wrong password fails before anything is restored
+		if c.r, err = open(f, key, e.ID); err != nil {
+			f.Close()
+			if err == errModified {
+				err = &infinigo.Error{ID: "forbidden", Details: fmt.Sprintf("Wrong password for %s or the file was modified", e.ID)}
+			}
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// content is the original content of a quarantined file, checked against its hash
+type content struct {
+	f *os.File
+	r io.Reader
+	h hash.Hash
+	e Entry
+}
+
+func (c *content) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	if err == errModified || (err == io.EOF && hex.EncodeToString(c.h.Sum(nil)) != c.e.Hash) {
+		err = &infinigo.Error{ID: "bad_response", Details: fmt.Sprintf("Quarantined file %s was modified", c.e.ID)}
+	}
+	return n, err
+}
+
+func (c *content) Close() error {
+	return c.f.Close()
+}
+
+// load reads the manifest
+func (v *Vault) load() (map[string]Entry, error) {
+	entries := make(map[string]Entry)
+	b, err := os.ReadFile(filepath.Join(v.dir, manifestFile))
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("quarantine manifest %s - %v", v.dir, err)
+	}
+	return entries, nil
+}
+
+// save writes the manifest atomically
+func (v *Vault) save(entries map[string]Entry) error {
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(v.dir, manifestFile)
+	if err = os.WriteFile(path+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (v *Vault) path(id string) string {
+	return filepath.Join(v.dir, id)
+}
+
+func deriveKey(password string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, password, salt, keyIterations, 32)
+}
+
+// seal writes the random nonce prefix and the content encrypted in chunks, authenticating the ID so files
+// can not be swapped in the manifest. Only the last chunk is shorter than chunkSize, and the nonces
+// number the chunks and mark the last, so chunks can not be reordered, dropped or added.
+func seal(w io.Writer, r io.Reader, key []byte, id string) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce[:noncePrefix])
+	if _, err = w.Write(nonce[:noncePrefix]); err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize, chunkSize+gcm.Overhead())
+	for i := uint32(0); ; i++ {
+		n, err := io.ReadFull(r, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		chunkNonce(nonce, i, last)
+		if _, err = w.Write(gcm.Seal(buf[:0], nonce, buf[:n], []byte(id))); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// chunkNonce sets the number of the chunk and whether it is the last after the nonce prefix
+func chunkNonce(nonce []byte, i uint32, last bool) {
+	binary.BigEndian.PutUint32(nonce[noncePrefix:], i)
+	nonce[len(nonce)-1] = 0
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+}
+
+// opener decrypts the chunks written by seal
+type opener struct {
+	r     io.Reader
+	gcm   cipher.AEAD
+	id    []byte
+	nonce []byte
+	i     uint32
+	buf   []byte
+	plain []byte // plain is the decrypted content not read yet
+	last  bool
+}
+
+// open reads the nonce prefix and decrypts the first chunk of r
+func open(r io.Reader, key []byte, id string) (*opener, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	o := &opener{r: r, gcm: gcm, id: []byte(id), nonce: make([]byte, gcm.NonceSize()), buf: make([]byte, chunkSize+gcm.Overhead())}
+	if _, err = io.ReadFull(r, o.nonce[:noncePrefix]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errModified
+		}
+		return nil, err
+	}
+	return o, o.next()
+}
+
+// next decrypts the next chunk
+func (o *opener) next() error {
+	n, err := io.ReadFull(o.r, o.buf)
+	o.last = err == io.ErrUnexpectedEOF
+	if err == io.EOF {
+		// The last chunk was removed
+		return errModified
+	}
+	if err != nil && !o.last {
+		return err
+	}
+	chunkNonce(o.nonce, o.i, o.last)
+	o.i++
+	if o.plain, err = o.gcm.Open(o.buf[:0], o.nonce, o.buf[:n], o.id); err != nil {
+		return errModified
+	}
+	return nil
+}
+
+func (o *opener) Read(p []byte) (int, error) {
+	for len(o.plain) == 0 {
+		if o.last {
+			return 0, io.EOF
+		}
+		if err := o.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.plain)
+	o.plain = o.plain[n:]
+	return n, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newID(t time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%019d-%s", t.UnixNano(), hex.EncodeToString(b))
+}
@@ -0,0 +1,123 @@
+package quarantine
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/demisto/infinigo"
+)
+
+func quarantineFile(t *testing.T, v *Vault, content []byte, password string) (Entry, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sample.exe")
+	if err := os.WriteFile(path, content, 0750); err != nil {
+		t.Fatal(err)
+	}
+	e, err := v.Quarantine(path, "test", password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, path
+}
+
+func TestRestore(t *testing.T) {
+	v, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 5, chunkSize, 2*chunkSize + 3} {
+		for _, password := range []string{"", "secret"} {
+			content := bytes.Repeat([]byte{'x'}, size)
+			e, path := quarantineFile(t, v, content, password)
+			if _, err = os.Stat(path); !os.IsNotExist(err) {
+				t.Fatalf("Expected %s to be moved to the vault, got %v", path, err)
+			}
+			stored, err := os.ReadFile(v.path(e.ID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if password != "" && bytes.Contains(stored, []byte("xxxx")) {
+				t.Fatalf("Expected %d bytes to be encrypted", size)
+			}
+			if _, err = v.Restore(e.ID, password, ""); err != nil {
+				t.Fatalf("Restoring %d bytes - %v", size, err)
+			}
+			restored, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(restored, content) {
+				t.Fatalf("Expected %d bytes to be restored, got %d", size, len(restored))
+			}
+			if info, _ := os.Stat(path); info.Mode().Perm() != 0750 {
+				t.Fatalf("Expected the mode to be restored, got %v", info.Mode())
+			}
+		}
+	}
+}
+
+func TestRestoreWrongPassword(t *testing.T) {
+	v, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, path := quarantineFile(t, v, []byte("content"), "secret")
+	var ierr *infinigo.Error
+	if _, err = v.Restore(e.ID, "wrong", ""); !errors.As(err, &ierr) || ierr.ID != "forbidden" {
+		t.Fatalf("Expected a wrong password to be forbidden, got %v", err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected nothing to be restored, got %v", err)
+	}
+}
+
+func TestRestoreModified(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), chunkSize*7/20)
+	tests := []struct {
+		name     string
+		password string
+		modify   func([]byte) []byte
+	}{
+		{"changed", "", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"encrypted changed", "secret", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"second chunk changed", "secret", func(b []byte) []byte { b[noncePrefix+chunkSize+20] ^= 1; return b }},
+		{"last chunk removed", "secret", func(b []byte) []byte { return b[:noncePrefix+3*(chunkSize+16)] }},
+		{"chunks swapped", "secret", func(b []byte) []byte {
+			second, third := noncePrefix+chunkSize+16, noncePrefix+2*(chunkSize+16)
+			chunk := append([]byte(nil), b[second:third]...)
+			copy(b[second:], b[third:third+chunkSize+16])
+			copy(b[third:], chunk)
+			return b
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := Open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, path := quarantineFile(t, v, content, test.password)
+			stored, err := os.ReadFile(v.path(e.ID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			os.Chmod(v.path(e.ID), 0600)
+			if err = os.WriteFile(v.path(e.ID), test.modify(stored), 0600); err != nil {
+				t.Fatal(err)
+			}
+			var ierr *infinigo.Error
+			if _, err = v.Restore(e.ID, test.password, ""); !errors.As(err, &ierr) || ierr.ID != "bad_response" {
+				t.Fatalf("Expected the modification to be found, got %v", err)
+			}
+			if _, err = os.Stat(path); !os.IsNotExist(err) {
+				t.Fatalf("Expected nothing to be restored, got %v", err)
+			}
+			if list, _ := v.List(); len(list) != 1 {
+				t.Fatalf("Expected the file to stay quarantined, got %v", list)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package infinigo
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// QueryRequest describes a query for Lookup. Fields may be added to it as the API grows.
+type QueryRequest struct {
+	Hashes      []string          // Hashes are any number of MD5, SHA1 and SHA256 hashes
+	Classifiers string            // Classifiers are none, ml, industry, human or all, the default
+	Metadata    map[string]string // Metadata is logged with the errors and traces of the requests, e.g. the job the query is for. It is not sent to Infinity.
+}
+
+// QueryResult holds the responses of a Lookup
+type QueryResult struct {
+	Responses map[string]QueryResponse // Responses keyed by the hashes of the request as given
+}
+
+// Lookup queries Infinity for the hashes of the request, in batches of QueryBatchSize as QueryAll does.
+// On error, the result holds the responses of the batches that succeeded.
+func (c *Client) Lookup(ctx context.Context, req QueryRequest) (QueryResult, error) {
+	if len(req.Metadata) > 0 {
+		keys := make([]string, 0, len(req.Metadata))
+		for k := range req.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fields := make([]any, 0, 2*len(keys))
+		for _, k := range keys {
+			fields = append(fields, k, req.Metadata[k])
+		}
+		ctx = WithLogFields(ctx, fields...)
+	}
+	resp, err := c.QueryAllContext(ctx, req.Classifiers, req.Hashes...)
+	return QueryResult{Responses: resp}, err
+}
+
+// Get returns the response for the hash, which may differ in case or surrounding spaces from the one
+// in the request
+func (r QueryResult) Get(hash string) (QueryResponse, bool) {
+	if resp, ok := r.Responses[hash]; ok {
+		return resp, true
+	}
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	for h, resp := range r.Responses {
+		if strings.ToLower(strings.TrimSpace(h)) == hash {
+			return resp, true
+		}
+	}
+	return QueryResponse{}, false
+}
+
+// Verdict returns the verdict for the hash, VerdictUnknown if there is no response for it
+func (r QueryResult) Verdict(hash string) Verdict {
+	resp, ok := r.Get(hash)
+	if !ok {
+		return VerdictUnknown
+	}
+	return resp.Verdict()
+}
+
+// Verdicts returns the verdicts keyed by hash
+func (r QueryResult) Verdicts() map[string]Verdict {
+	verdicts := make(map[string]Verdict, len(r.Responses))
+	for h, resp := range r.Responses {
+		verdicts[h] = resp.Verdict()
+	}
+	return verdicts
+}
+
+// WithVerdict returns the hashes with the verdict, sorted
+func (r QueryResult) WithVerdict(verdict Verdict) []string {
+	var hashes []string
+	for h, resp := range r.Responses {
+		if resp.Verdict() == verdict {
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// Failed returns the hashes Infinity returned an error for, sorted
+func (r QueryResult) Failed() []string {
+	return r.WithVerdict(VerdictError)
+}
+
+// Requested returns the confirmation codes of the hashes Infinity asks the samples of, keyed by hash
+func (r QueryResult) Requested() map[string]string {
+	codes := make(map[string]string)
+	for h, resp := range r.Responses {
+		if resp.ConfirmCode != "" {
+			codes[h] = resp.ConfirmCode
+		}
+	}
+	return codes
+}
@@ -0,0 +1,161 @@
+package infinigo
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Kinds of queued requests
+const (
+	QueueQuery  = "query"  // QueueQuery is a queued hash query
+	QueueUpload = "upload" // QueueUpload is a queued file upload
+)
+
+// QueueItem is a request waiting to be sent to Infinity
+type QueueItem struct {
+	Kind        string    `json:"kind"`                  // Kind of request - QueueQuery or QueueUpload
+	Classifiers string    `json:"classifiers,omitempty"` // Classifiers for queries
+	Hashes      []string  `json:"hashes,omitempty"`      // Hashes for queries
+	ConfirmCode string    `json:"confirmcode,omitempty"` // ConfirmCode for uploads
+	Path        string    `json:"path,omitempty"`        // Path of the file to upload
+	Added       time.Time `json:"added"`                 // Added is when the request was queued
+}
+
+// Queue persists queries and uploads that could not be sent, for example when the API is unreachable,
+// so they can be sent later with Flush. It is safe for concurrent use.
+type Queue struct {
+	path  string
+	mu    sync.Mutex
+	items []QueueItem
+}
+
+// OpenQueue loads the queue from the given path. A missing file results in an empty queue.
+func OpenQueue(path string) (*Queue, error) {
+	q := &Queue{path: path}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return q, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &q.items); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Unreachable returns true if the error is a transport level error, meaning the request
+// did not get a response from the API and should be queued for later.
+func Unreachable(err error) bool {
+	var urlErr *url.Error
+	var netErr net.Error
+	var pathErr *fs.PathError
+	// Errors opening files wrap system errors that also implement net.Error
+	if errors.As(err, &pathErr) && !errors.As(err, &urlErr) {
+		return false
+	}
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
+
+// AddQuery queues a query for the given hashes
+func (q *Queue) AddQuery(classifiers string, hash ...string) error {
+	return q.add(QueueItem{Kind: QueueQuery, Classifiers: classifiers, Hashes: hash})
+}
+
+// AddUpload queues an upload of the file at path. Relative paths are made absolute so the
+// queue can be flushed from another directory.
+func (q *Queue) AddUpload(confirmCode, path string) error {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return q.add(QueueItem{Kind: QueueUpload, ConfirmCode: confirmCode, Path: path})
+}
+
+func (q *Queue) add(item QueueItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item.Added = time.Now().UTC()
+	q.items = append(q.items, item)
+	return q.save()
+}
+
+// Len returns the number of queued requests
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// Items returns a copy of the queued requests
+func (q *Queue) Items() []QueueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]QueueItem(nil), q.items...)
+}
+
+// Flush sends the queued requests in order and removes them from the queue.
+// onQuery, if provided, is called with the responses of every queued query.
+// Flush stops on the first failure that may pass, e.g. Infinity being unreachable or rate limiting, or
+// refusing the API key, keeping the failed request and the ones after it. Requests that fail again however many times they are
+// sent, e.g. uploads of missing files or rejected by Infinity, are removed and returned as DroppedErrors,
+// joined with the failure Flush stopped on. It returns the number of requests sent.
+func (q *Queue) Flush(c *Client, onQuery func(map[string]QueryResponse)) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	sent := 0
+	var errs []error
+	for len(q.items) > 0 {
+		item := q.items[0]
+		var err error
+		switch item.Kind {
+		case QueueQuery:
+			var resp map[string]QueryResponse
+			if resp, err = c.QueryAll(item.Classifiers, item.Hashes...); err == nil && onQuery != nil {
+				onQuery(resp)
+			}
+		case QueueUpload:
+			_, err = c.UploadFile(item.ConfirmCode, item.Path)
+		default:
+			err = &Error{ID: "bad_queue_item", Details: "Unknown kind [" + item.Kind + "]"}
+		}
+		if err != nil && (retryable(err) || unauthorized(err)) {
+			errs = append(errs, err)
+			break
+		}
+		if err != nil {
+			c.errorf("Dropping queued %s added %v - %v\n", item.Kind, item.Added, err)
+			errs = append(errs, &DroppedError{Item: item, Err: err})
+		} else {
+			sent++
+		}
+		q.items = q.items[1:]
+		if err := q.save(); err != nil {
+			return sent, errors.Join(append(errs, err)...)
+		}
+	}
+	return sent, errors.Join(errs...)
+}
+
+// save writes the queue to disk atomically. Must be called with the lock held.
+func (q *Queue) save() error {
+	b, err := json.MarshalIndent(q.items, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(q.path), 0700); err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
@@ -0,0 +1,98 @@
+package infinigo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlushDropsFailuresThatRepeat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ContentTypeHeader, "application/json")
+		w.Write([]byte(`{"abc":{"verdict":"benign"}}`))
+	}))
+	defer srv.Close()
+	c, err := New(SetKey("key"), SetURL(srv.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := OpenQueue(filepath.Join(t.TempDir(), "queue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.AddUpload("code", filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.AddQuery("", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	sent, err := q.Flush(c, nil)
+	var dropped *DroppedError
+	if !errors.As(err, &dropped) || dropped.Item.Kind != QueueUpload {
+		t.Fatalf("Expected the upload of the missing file to be dropped, got %v", err)
+	}
+	if sent != 1 || q.Len() != 0 {
+		t.Fatalf("Expected the query after it to be sent, sent %d with %d queued", sent, q.Len())
+	}
+}
+
+func TestFlushStopsWhenUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	c, err := New(SetKey("key"), SetURL(url+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := OpenQueue(filepath.Join(t.TempDir(), "queue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err = q.AddQuery("", "abc"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent, err := q.Flush(c, nil)
+	if !Unreachable(err) || errors.Is(err, ErrQueueItemDropped) {
+		t.Fatalf("Expected Flush to stop as Infinity is unreachable, got %v", err)
+	}
+	if sent != 0 || q.Len() != 2 {
+		t.Fatalf("Expected the queries to stay queued, sent %d with %d queued", sent, q.Len())
+	}
+}
+
+func TestFlushStopsWhenUnauthorized(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		c, err := New(SetKey("expired"), SetURL(srv.URL+"/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := OpenQueue(filepath.Join(t.TempDir(), "queue.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 2 {
+			if err = q.AddQuery("", "abc"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sent, err := q.Flush(c, nil)
+		srv.Close()
+		if httpStatus(err) != status || errors.Is(err, ErrQueueItemDropped) {
+			t.Fatalf("Expected Flush to stop on %d, got %v", status, err)
+		}
+		if sent != 0 || q.Len() != 2 {
+			t.Fatalf("Expected the queries to stay queued on %d, sent %d with %d queued", status, sent, q.Len())
+		}
+		// The queue on disk keeps them for the next run too
+		if q, err = OpenQueue(q.path); err != nil || q.Len() != 2 {
+			t.Fatalf("Expected the queries to stay queued on disk on %d, got %v", status, err)
+		}
+	}
+}
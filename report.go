@@ -0,0 +1,108 @@
+package infinigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// QueryReport is the outcome of QueryAllReport. It tells the hashes Infinity answered with an error,
+// which querying again may not fix, from the hashes of the requests that failed, which can be sent again.
+type QueryReport struct {
+	Responses  map[string]QueryResponse // Responses of the hashes answered, including with an error
+	HashErrors map[string]*HashError    // HashErrors of the hashes answered with an error, keyed by hash
+	Failures   []BatchFailure           // Failures of the requests, in the order of the hashes
+}
+
+// BatchFailure is a batch of hashes that were not answered as their request failed
+type BatchFailure struct {
+	Hashes []string
+	Err    error
+}
+
+// Err returns the errors of the failed requests joined, nil if every request succeeded
+func (r *QueryReport) Err() error {
+	errs := make([]error, len(r.Failures))
+	for i, f := range r.Failures {
+		errs[i] = f.Err
+	}
+	return errors.Join(errs...)
+}
+
+// Unanswered returns the hashes of the failed requests, in the order they were given
+func (r *QueryReport) Unanswered() []string {
+	var hashes []string
+	for _, f := range r.Failures {
+		hashes = append(hashes, f.Hashes...)
+	}
+	return hashes
+}
+
+// QueryAllReport queries any number of hashes in batches of QueryBatchSize as QueryAll does, without
+// stopping on the first failed batch. Once a request fails as Infinity is unreachable, unavailable or
+// rate limited the batches not sent yet fail with the same error, as they would.
+func (c *Client) QueryAllReport(ctx context.Context, classifiers string, hash ...string) *QueryReport {
+	report := &QueryReport{Responses: make(map[string]QueryResponse, len(hash)), HashErrors: make(map[string]*HashError)}
+	if len(hash) == 0 {
+		report.Failures = append(report.Failures, BatchFailure{Err: &Error{ID: "missing_arg", Details: "hash is required"}})
+		return report
+	}
+	var batches [][]string
+	for start := 0; start < len(hash); start += QueryBatchSize {
+		batches = append(batches, hash[start:min(start+QueryBatchSize, len(hash))])
+	}
+	failures := make([]error, len(batches))
+	var mu sync.Mutex
+	var stop error
+	query := func(i int) {
+		mu.Lock()
+		err := stop
+		mu.Unlock()
+		var resp map[string]QueryResponse
+		if err == nil {
+			resp, err = c.QueryContext(ctx, classifiers, batches[i]...)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failures[i] = err
+			if stop == nil && failsAll(err) {
+				stop = err
+			}
+			return
+		}
+		for h, r := range resp {
+			report.Responses[h] = r
+			if r.Error != "" {
+				report.HashErrors[h] = r.Err().(*HashError)
+			}
+		}
+	}
+	// Batches are sent in parallel when the adaptive concurrency controls how many are in flight
+	var wg sync.WaitGroup
+	for i := range batches {
+		if c.adaptive == nil || len(batches) == 1 {
+			query(i)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			query(i)
+		}()
+	}
+	wg.Wait()
+	for i, err := range failures {
+		if err != nil {
+			report.Failures = append(report.Failures, BatchFailure{Hashes: batches[i], Err: err})
+		}
+	}
+	return report
+}
+
+// failsAll returns true if the error of a request would fail the requests that follow it
+func failsAll(err error) bool {
+	return Unreachable(err) || errors.Is(err, ErrServiceUnavailable) || httpStatus(err) == http.StatusTooManyRequests ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
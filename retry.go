@@ -0,0 +1,158 @@
+package infinigo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SetQueryRetries re-queries the hashes Infinity answered with an error, up to retries times and
+// waiting delay before each attempt, while the responses of the other hashes are kept. The responses
+// recovered replace the failed ones. It is 0, no retries, by default.
+func SetQueryRetries(retries int, delay time.Duration) OptionFunc {
+	return func(c *Client) error {
+		if retries < 0 || delay < 0 {
+			err := &Error{ID: "bad_option", Details: "Query retries and their delay cannot be negative"}
+			c.errorf("%v\n", err)
+			return err
+		}
+		c.queryRetries, c.retryDelay = retries, delay
+		return nil
+	}
+}
+
+// retryFailed re-queries the hashes of the responses with an error, merging the recovered responses.
+// A failed retry leaves the responses as they are.
+func (c *Client) retryFailed(ctx context.Context, classifiers string, resp map[string]QueryResponse) {
+	for attempt := 1; attempt <= c.queryRetries; attempt++ {
+		var failed []string
+		for h, r := range resp {
+			if r.Error != "" {
+				failed = append(failed, h)
+			}
+		}
+		if len(failed) == 0 {
+			return
+		}
+		sort.Strings(failed)
+		c.traceContext(ctx, "Retrying %d hashes Infinity returned an error for, attempt %d of %d\n", len(failed), attempt, c.queryRetries)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.retryDelay):
+		}
+		again, err := c.query(ctx, classifiers, failed)
+		if err != nil {
+			c.errorContext(ctx, "Failed to retry %d hashes - %v\n", len(failed), err)
+			return
+		}
+		for h, r := range again {
+			if _, ok := resp[h]; ok && r.Error == "" {
+				resp[h] = r
+			}
+		}
+	}
+}
+
+// SetUploadRetries sends uploads that failed as Infinity was unreachable, rate limited, failing with
+// a 5xx status or cut short again, up to retries times and waiting delay before each attempt. The
+// compressed body is reused, so the data is not read and compressed again. It is 0, no retries, by default.
+//
+// An upload is only sent again if Infinity cannot have received it: no data was sent, or Infinity
+// answered 429 or 503 as it did not process it. Otherwise, e.g. timing out waiting for the response,
+// sending it again could submit the sample twice and the upload fails with an UploadUnconfirmedError,
+// unless SetUploadVerify checks whether Infinity received it.
+func SetUploadRetries(retries int, delay time.Duration) OptionFunc {
+	return func(c *Client) error {
+		if retries < 0 || delay < 0 {
+			err := &Error{ID: "bad_option", Details: "Upload retries and their delay cannot be negative"}
+			c.errorf("%v\n", err)
+			return err
+		}
+		c.uploadRetries, c.uploadRetryDelay = retries, delay
+		return nil
+	}
+}
+
+// SetUploadVerify queries the SHA256 of an upload that failed after its data was sent before sending
+// it again. If Infinity no longer asks for the file, the upload succeeds with the response of the query
+// without being sent again. It is false by default.
+func SetUploadVerify(verify bool) OptionFunc {
+	return func(c *Client) error {
+		c.uploadVerify = verify
+		return nil
+	}
+}
+
+// verifyUpload returns the response of the query for the digest keyed by it if Infinity received the
+// upload, nil if it did not
+func (c *Client) verifyUpload(ctx context.Context, digest string) (map[string]UploadResponse, error) {
+	resp, err := c.query(ctx, "", []string{digest})
+	if err != nil {
+		return nil, err
+	}
+	for h, r := range resp {
+		// Infinity keys the response with the hash in any case
+		if !strings.EqualFold(h, digest) || r.Error != "" {
+			continue
+		}
+		if r.ConfirmCode == "" || r.GeneralScore != 0 {
+			c.traceContext(ctx, "Infinity received upload %s, not sending it again\n", digest)
+			return map[string]UploadResponse{digest: {Common: r.Common}}, nil
+		}
+	}
+	return nil, nil
+}
+
+// countingReader counts the bytes read, which may have reached Infinity
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// httpStatus returns the status code of a StatusError, 0 for other errors
+func httpStatus(err error) int {
+	var e *StatusError
+	if !errors.As(err, &e) {
+		return 0
+	}
+	return e.Status
+}
+
+// rejected returns true if Infinity answered that it did not process the request
+func rejected(err error) bool {
+	var unavailable *ServiceUnavailableError
+	if errors.As(err, &unavailable) {
+		return unavailable.Status == http.StatusServiceUnavailable || unavailable.Maintenance
+	}
+	return httpStatus(err) == http.StatusTooManyRequests
+}
+
+// unauthorized returns true if Infinity refused the API key, so every request fails until the key is fixed
+func unauthorized(err error) bool {
+	if status := httpStatus(err); status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return true
+	}
+	var e *Error
+	return errors.As(err, &e) && (e.ID == "unauthorized" || e.ID == "forbidden")
+}
+
+// retryable returns true if the request failed as Infinity was unreachable, unavailable, rate limited
+// or failing, or its response was cut short
+func retryable(err error) bool {
+	if Unreachable(err) || errors.Is(err, ErrServiceUnavailable) || errors.Is(err, ErrTruncatedResponse) {
+		return true
+	}
+	status := httpStatus(err)
+	return status == http.StatusTooManyRequests || status >= 500
+}
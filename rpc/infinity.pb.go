@@ -0,0 +1,434 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: infinity.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type QueryRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Classifiers to score with - none, ml, industry, human or all. Defaults to all.
+	Classifiers string `protobuf:"bytes,1,opt,name=classifiers,proto3" json:"classifiers,omitempty"`
+	// Hashes to query, any of MD5, SHA1 and SHA256.
+	Hashes        []string `protobuf:"bytes,2,rep,name=hashes,proto3" json:"hashes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryRequest) Reset() {
+	*x = QueryRequest{}
+	mi := &file_infinity_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryRequest) ProtoMessage() {}
+
+func (x *QueryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_infinity_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryRequest.ProtoReflect.Descriptor instead.
+func (*QueryRequest) Descriptor() ([]byte, []int) {
+	return file_infinity_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *QueryRequest) GetClassifiers() string {
+	if x != nil {
+		return x.Classifiers
+	}
+	return ""
+}
+
+func (x *QueryRequest) GetHashes() []string {
+	if x != nil {
+		return x.Hashes
+	}
+	return nil
+}
+
+// Result is the response of Infinity for a single hash.
+type Result struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Hash         string                 `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	Status       string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	StatusCode   float32                `protobuf:"fixed32,3,opt,name=status_code,json=statusCode,proto3" json:"status_code,omitempty"`
+	Error        string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	GeneralScore float32                `protobuf:"fixed32,5,opt,name=general_score,json=generalScore,proto3" json:"general_score,omitempty"`
+	// Confirmation code to upload the file with, if Infinity requests it.
+	ConfirmCode string             `protobuf:"bytes,6,opt,name=confirm_code,json=confirmCode,proto3" json:"confirm_code,omitempty"`
+	Classifiers map[string]float32 `protobuf:"bytes,7,rep,name=classifiers,proto3" json:"classifiers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed32,2,opt,name=value"`
+	// Verdict derived from the score - unknown, benign, suspicious, malicious or error.
+	Verdict       string `protobuf:"bytes,8,opt,name=verdict,proto3" json:"verdict,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Result) Reset() {
+	*x = Result{}
+	mi := &file_infinity_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Result) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Result) ProtoMessage() {}
+
+func (x *Result) ProtoReflect() protoreflect.Message {
+	mi := &file_infinity_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Result.ProtoReflect.Descriptor instead.
+func (*Result) Descriptor() ([]byte, []int) {
+	return file_infinity_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Result) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *Result) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Result) GetStatusCode() float32 {
+	if x != nil {
+		return x.StatusCode
+	}
+	return 0
+}
+
+func (x *Result) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Result) GetGeneralScore() float32 {
+	if x != nil {
+		return x.GeneralScore
+	}
+	return 0
+}
+
+func (x *Result) GetConfirmCode() string {
+	if x != nil {
+		return x.ConfirmCode
+	}
+	return ""
+}
+
+func (x *Result) GetClassifiers() map[string]float32 {
+	if x != nil {
+		return x.Classifiers
+	}
+	return nil
+}
+
+func (x *Result) GetVerdict() string {
+	if x != nil {
+		return x.Verdict
+	}
+	return ""
+}
+
+type QueryResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*Result              `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryResponse) Reset() {
+	*x = QueryResponse{}
+	mi := &file_infinity_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryResponse) ProtoMessage() {}
+
+func (x *QueryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_infinity_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryResponse.ProtoReflect.Descriptor instead.
+func (*QueryResponse) Descriptor() ([]byte, []int) {
+	return file_infinity_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *QueryResponse) GetResults() []*Result {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type UploadRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Confirmation code from the query response. Only read from the first message.
+	ConfirmCode string `protobuf:"bytes,1,opt,name=confirm_code,json=confirmCode,proto3" json:"confirm_code,omitempty"`
+	// Chunk of the file contents.
+	Chunk         []byte `protobuf:"bytes,2,opt,name=chunk,proto3" json:"chunk,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadRequest) Reset() {
+	*x = UploadRequest{}
+	mi := &file_infinity_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadRequest) ProtoMessage() {}
+
+func (x *UploadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_infinity_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadRequest.ProtoReflect.Descriptor instead.
+func (*UploadRequest) Descriptor() ([]byte, []int) {
+	return file_infinity_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *UploadRequest) GetConfirmCode() string {
+	if x != nil {
+		return x.ConfirmCode
+	}
+	return ""
+}
+
+func (x *UploadRequest) GetChunk() []byte {
+	if x != nil {
+		return x.Chunk
+	}
+	return nil
+}
+
+type UploadResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	StatusCode    float32                `protobuf:"fixed32,2,opt,name=status_code,json=statusCode,proto3" json:"status_code,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadResponse) Reset() {
+	*x = UploadResponse{}
+	mi := &file_infinity_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadResponse) ProtoMessage() {}
+
+func (x *UploadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_infinity_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadResponse.ProtoReflect.Descriptor instead.
+func (*UploadResponse) Descriptor() ([]byte, []int) {
+	return file_infinity_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UploadResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *UploadResponse) GetStatusCode() float32 {
+	if x != nil {
+		return x.StatusCode
+	}
+	return 0
+}
+
+func (x *UploadResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_infinity_proto protoreflect.FileDescriptor
+
+const file_infinity_proto_rawDesc = "" +
+	"\n" +
+	"\x0einfinity.proto\x12\vinfinigo.v1\"H\n" +
+	"\fQueryRequest\x12 \n" +
+	"\vclassifiers\x18\x01 \x01(\tR\vclassifiers\x12\x16\n" +
+	"\x06hashes\x18\x02 \x03(\tR\x06hashes\"\xd5\x02\n" +
+	"\x06Result\x12\x12\n" +
+	"\x04hash\x18\x01 \x01(\tR\x04hash\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x1f\n" +
+	"\vstatus_code\x18\x03 \x01(\x02R\n" +
+	"statusCode\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x12#\n" +
+	"\rgeneral_score\x18\x05 \x01(\x02R\fgeneralScore\x12!\n" +
+	"\fconfirm_code\x18\x06 \x01(\tR\vconfirmCode\x12F\n" +
+	"\vclassifiers\x18\a \x03(\v2$.infinigo.v1.Result.ClassifiersEntryR\vclassifiers\x12\x18\n" +
+	"\averdict\x18\b \x01(\tR\averdict\x1a>\n" +
+	"\x10ClassifiersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x02R\x05value:\x028\x01\">\n" +
+	"\rQueryResponse\x12-\n" +
+	"\aresults\x18\x01 \x03(\v2\x13.infinigo.v1.ResultR\aresults\"H\n" +
+	"\rUploadRequest\x12!\n" +
+	"\fconfirm_code\x18\x01 \x01(\tR\vconfirmCode\x12\x14\n" +
+	"\x05chunk\x18\x02 \x01(\fR\x05chunk\"_\n" +
+	"\x0eUploadResponse\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x1f\n" +
+	"\vstatus_code\x18\x02 \x01(\x02R\n" +
+	"statusCode\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error2\xd3\x01\n" +
+	"\bInfinity\x12>\n" +
+	"\x05Query\x12\x19.infinigo.v1.QueryRequest\x1a\x1a.infinigo.v1.QueryResponse\x12C\n" +
+	"\x06Upload\x12\x1a.infinigo.v1.UploadRequest\x1a\x1b.infinigo.v1.UploadResponse(\x01\x12B\n" +
+	"\rUploadAndWait\x12\x1a.infinigo.v1.UploadRequest\x1a\x13.infinigo.v1.Result(\x01B!Z\x1fgithub.com/demisto/infinigo/rpcb\x06proto3"
+
+var (
+	file_infinity_proto_rawDescOnce sync.Once
+	file_infinity_proto_rawDescData []byte
+)
+
+func file_infinity_proto_rawDescGZIP() []byte {
+	file_infinity_proto_rawDescOnce.Do(func() {
+		file_infinity_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_infinity_proto_rawDesc), len(file_infinity_proto_rawDesc)))
+	})
+	return file_infinity_proto_rawDescData
+}
+
+var file_infinity_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_infinity_proto_goTypes = []any{
+	(*QueryRequest)(nil),   // 0: infinigo.v1.QueryRequest
+	(*Result)(nil),         // 1: infinigo.v1.Result
+	(*QueryResponse)(nil),  // 2: infinigo.v1.QueryResponse
+	(*UploadRequest)(nil),  // 3: infinigo.v1.UploadRequest
+	(*UploadResponse)(nil), // 4: infinigo.v1.UploadResponse
+	nil,                    // 5: infinigo.v1.Result.ClassifiersEntry
+}
+var file_infinity_proto_depIdxs = []int32{
+	5, // 0: infinigo.v1.Result.classifiers:type_name -> infinigo.v1.Result.ClassifiersEntry
+	1, // 1: infinigo.v1.QueryResponse.results:type_name -> infinigo.v1.Result
+	0, // 2: infinigo.v1.Infinity.Query:input_type -> infinigo.v1.QueryRequest
+	3, // 3: infinigo.v1.Infinity.Upload:input_type -> infinigo.v1.UploadRequest
+	3, // 4: infinigo.v1.Infinity.UploadAndWait:input_type -> infinigo.v1.UploadRequest
+	2, // 5: infinigo.v1.Infinity.Query:output_type -> infinigo.v1.QueryResponse
+	4, // 6: infinigo.v1.Infinity.Upload:output_type -> infinigo.v1.UploadResponse
+	1, // 7: infinigo.v1.Infinity.UploadAndWait:output_type -> infinigo.v1.Result
+	5, // [5:8] is the sub-list for method output_type
+	2, // [2:5] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_infinity_proto_init() }
+func file_infinity_proto_init() {
+	if File_infinity_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_infinity_proto_rawDesc), len(file_infinity_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_infinity_proto_goTypes,
+		DependencyIndexes: file_infinity_proto_depIdxs,
+		MessageInfos:      file_infinity_proto_msgTypes,
+	}.Build()
+	File_infinity_proto = out.File
+	file_infinity_proto_goTypes = nil
+	file_infinity_proto_depIdxs = nil
+}
@@ -0,0 +1,198 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: infinity.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Infinity_Query_FullMethodName         = "/infinigo.v1.Infinity/Query"
+	Infinity_Upload_FullMethodName        = "/infinigo.v1.Infinity/Upload"
+	Infinity_UploadAndWait_FullMethodName = "/infinigo.v1.Infinity/UploadAndWait"
+)
+
+// InfinityClient is the client API for Infinity service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Infinity proxies requests to the Infinity API with the server's API key.
+type InfinityClient interface {
+	// Query the verdicts of the given hashes.
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+	// Upload a file Infinity requested a confirmation for. The first message must
+	// contain the confirmation code and the following ones the file contents.
+	Upload(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadRequest, UploadResponse], error)
+	// UploadAndWait uploads a file like Upload and waits for its verdict.
+	// The wait is bounded by the call deadline.
+	UploadAndWait(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadRequest, Result], error)
+}
+
+type infinityClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInfinityClient(cc grpc.ClientConnInterface) InfinityClient {
+	return &infinityClient{cc}
+}
+
+func (c *infinityClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, Infinity_Query_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *infinityClient) Upload(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadRequest, UploadResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Infinity_ServiceDesc.Streams[0], Infinity_Upload_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UploadRequest, UploadResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Infinity_UploadClient = grpc.ClientStreamingClient[UploadRequest, UploadResponse]
+
+func (c *infinityClient) UploadAndWait(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadRequest, Result], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Infinity_ServiceDesc.Streams[1], Infinity_UploadAndWait_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UploadRequest, Result]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Infinity_UploadAndWaitClient = grpc.ClientStreamingClient[UploadRequest, Result]
+
+// InfinityServer is the server API for Infinity service.
+// All implementations must embed UnimplementedInfinityServer
+// for forward compatibility.
+//
+// Infinity proxies requests to the Infinity API with the server's API key.
+type InfinityServer interface {
+	// Query the verdicts of the given hashes.
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
+	// Upload a file Infinity requested a confirmation for. The first message must
+	// contain the confirmation code and the following ones the file contents.
+	Upload(grpc.ClientStreamingServer[UploadRequest, UploadResponse]) error
+	// UploadAndWait uploads a file like Upload and waits for its verdict.
+	// The wait is bounded by the call deadline.
+	UploadAndWait(grpc.ClientStreamingServer[UploadRequest, Result]) error
+	mustEmbedUnimplementedInfinityServer()
+}
+
+// UnimplementedInfinityServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedInfinityServer struct{}
+
+func (UnimplementedInfinityServer) Query(context.Context, *QueryRequest) (*QueryResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedInfinityServer) Upload(grpc.ClientStreamingServer[UploadRequest, UploadResponse]) error {
+	return status.Error(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedInfinityServer) UploadAndWait(grpc.ClientStreamingServer[UploadRequest, Result]) error {
+	return status.Error(codes.Unimplemented, "method UploadAndWait not implemented")
+}
+func (UnimplementedInfinityServer) mustEmbedUnimplementedInfinityServer() {}
+func (UnimplementedInfinityServer) testEmbeddedByValue()                  {}
+
+// UnsafeInfinityServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InfinityServer will
+// result in compilation errors.
+type UnsafeInfinityServer interface {
+	mustEmbedUnimplementedInfinityServer()
+}
+
+func RegisterInfinityServer(s grpc.ServiceRegistrar, srv InfinityServer) {
+	// If the following call panics, it indicates UnimplementedInfinityServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Infinity_ServiceDesc, srv)
+}
+
+func _Infinity_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InfinityServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Infinity_Query_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InfinityServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Infinity_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(InfinityServer).Upload(&grpc.GenericServerStream[UploadRequest, UploadResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Infinity_UploadServer = grpc.ClientStreamingServer[UploadRequest, UploadResponse]
+
+func _Infinity_UploadAndWait_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(InfinityServer).UploadAndWait(&grpc.GenericServerStream[UploadRequest, Result]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Infinity_UploadAndWaitServer = grpc.ClientStreamingServer[UploadRequest, Result]
+
+// Infinity_ServiceDesc is the grpc.ServiceDesc for Infinity service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Infinity_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "infinigo.v1.Infinity",
+	HandlerType: (*InfinityServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Query",
+			Handler:    _Infinity_Query_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Upload",
+			Handler:       _Infinity_Upload_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "UploadAndWait",
+			Handler:       _Infinity_UploadAndWait_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "infinity.proto",
+}
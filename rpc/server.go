@@ -0,0 +1,278 @@
+/*
+Package rpc implements a gRPC service in front of the Infinity API.
+
+The service is defined in infinity.proto. Uploads are streamed in chunks, with the confirmation code
+in the first message. Go clients are created with NewInfinityClient.
+*/
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative infinity.proto
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DefaultMaxUploadSize = 100 << 20        // DefaultMaxUploadSize is the largest accepted upload in bytes
+	DefaultMaxWait       = 10 * time.Minute // DefaultMaxWait bounds UploadAndWait calls without a deadline
+)
+
+// Server implements InfinityServer with an Infinity client
+type Server struct {
+	UnimplementedInfinityServer
+	client        *infinigo.Client
+	maxUploadSize int64
+	pollInterval  time.Duration
+	maxWait       time.Duration
+	authorize     Authorizer
+	meter         Meter
+}
+
+// Authorizer checks the token sent by a client allows the scope - "query" or "upload".
+// It returns the Infinity client to use for the call, nil for the server's client.
+type Authorizer func(token, scope string) (*infinigo.Client, error)
+
+// Meter accounts the use of Infinity by the client with the token. Charge fails if the use would exceed
+// the client's quota, and Record accounts use that already happened, like the bytes of a streamed upload.
+type Meter interface {
+	Charge(token string, queries, uploads, uploadBytes int64) error
+	Record(token string, queries, uploads, uploadBytes int64)
+}
+
+// OptionFunc is a function that configures a Server.
+// It is used in New
+type OptionFunc func(*Server) error
+
+// New creates a gRPC service using the given client. Register it with RegisterInfinityServer.
+func New(client *infinigo.Client, options ...OptionFunc) (*Server, error) {
+	if client == nil {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "client is required"}
+	}
+	s := &Server{client: client, maxUploadSize: DefaultMaxUploadSize, pollInterval: infinigo.DefaultPollInterval, maxWait: DefaultMaxWait}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SetMaxUploadSize limits the size of uploads accepted by the server
+func SetMaxUploadSize(size int64) OptionFunc {
+	return func(s *Server) error {
+		s.maxUploadSize = size
+		return nil
+	}
+}
+
+// SetPollInterval sets how often UploadAndWait queries for the verdict
+func SetPollInterval(interval time.Duration) OptionFunc {
+	return func(s *Server) error {
+		s.pollInterval = interval
+		return nil
+	}
+}
+
+// SetMaxWait bounds how long UploadAndWait waits for a verdict, even if the call deadline is later
+func SetMaxWait(wait time.Duration) OptionFunc {
+	return func(s *Server) error {
+		if wait <= 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Max wait must be positive"}
+		}
+		s.maxWait = wait
+		return nil
+	}
+}
+
+// SetAuthorizer requires clients to send a token allowed by the authorizer in the authorization
+// metadata, as "Bearer <token>"
+func SetAuthorizer(authorize Authorizer) OptionFunc {
+	return func(s *Server) error {
+		s.authorize = authorize
+		return nil
+	}
+}
+
+// SetMeter accounts the use of Infinity by clients with the meter, rejecting calls exceeding their quota
+func SetMeter(meter Meter) OptionFunc {
+	return func(s *Server) error {
+		s.meter = meter
+		return nil
+	}
+}
+
+// token returns the token sent by the client
+func token(ctx context.Context) string {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, v := range md.Get("authorization") {
+			token, _ = strings.CutPrefix(v, "Bearer ")
+		}
+	}
+	return token
+}
+
+// charge accounts the use to the client of the call, failing if it exceeds its quota
+func (s *Server) charge(ctx context.Context, queries, uploads int64) error {
+	if s.meter == nil {
+		return nil
+	}
+	if err := s.meter.Charge(token(ctx), queries, uploads, 0); err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// record accounts the bytes uploaded by the client of the call
+func (s *Server) record(ctx context.Context, uploadBytes int64) {
+	if s.meter != nil {
+		s.meter.Record(token(ctx), 0, 0, uploadBytes)
+	}
+}
+
+// check authorizes the call for the scope and returns the client to use
+func (s *Server) check(ctx context.Context, scope string) (*infinigo.Client, error) {
+	if s.authorize == nil {
+		return s.client, nil
+	}
+	c, err := s.authorize(token(ctx), scope)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if c == nil {
+		c = s.client
+	}
+	return c, nil
+}
+
+// Query the verdicts of the given hashes
+func (s *Server) Query(ctx context.Context, req *QueryRequest) (*QueryResponse, error) {
+	c, err := s.check(ctx, "query")
+	if err != nil {
+		return nil, err
+	}
+	if err = s.charge(ctx, int64(len(req.GetHashes())), 0); err != nil {
+		return nil, err
+	}
+	resp, err := c.QueryAllContext(ctx, req.GetClassifiers(), req.GetHashes()...)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	out := &QueryResponse{}
+	for _, h := range req.GetHashes() {
+		if r, ok := lookup(resp, h); ok {
+			out.Results = append(out.Results, toResult(h, r))
+		}
+	}
+	return out, nil
+}
+
+// Upload a file streamed by the client
+func (s *Server) Upload(stream Infinity_UploadServer) error {
+	c, err := s.check(stream.Context(), "upload")
+	if err != nil {
+		return err
+	}
+	if err = s.charge(stream.Context(), 0, 1); err != nil {
+		return err
+	}
+	r, err := s.newStreamReader(stream)
+	if err != nil {
+		return err
+	}
+	resp, err := c.UploadContext(stream.Context(), r.confirmCode, r)
+	s.record(stream.Context(), r.read)
+	if err != nil {
+		return toStatus(err)
+	}
+	out := &UploadResponse{}
+	for _, v := range resp {
+		out.Status, out.StatusCode, out.Error = v.Status, v.StatusCode, v.Error
+	}
+	return stream.SendAndClose(out)
+}
+
+// UploadAndWait uploads a file streamed by the client and waits for its verdict
+func (s *Server) UploadAndWait(stream Infinity_UploadAndWaitServer) error {
+	c, err := s.check(stream.Context(), "upload")
+	if err != nil {
+		return err
+	}
+	if err = s.charge(stream.Context(), 0, 1); err != nil {
+		return err
+	}
+	r, err := s.newStreamReader(stream)
+	if err != nil {
+		return err
+	}
+	wait := s.maxWait
+	if deadline, ok := stream.Context().Deadline(); ok && time.Until(deadline) < wait {
+		wait = time.Until(deadline)
+	}
+	resp, err := c.UploadAndWaitContext(stream.Context(), r.confirmCode, r, s.pollInterval, wait)
+	s.record(stream.Context(), r.read)
+	if err != nil {
+		return toStatus(err)
+	}
+	return stream.SendAndClose(toResult(r.hash(), resp))
+}
+
+// lookup finds the response of a hash, ignoring the case Infinity returned it in
+func lookup(resp map[string]infinigo.QueryResponse, hash string) (infinigo.QueryResponse, bool) {
+	if r, ok := resp[hash]; ok {
+		return r, true
+	}
+	for k, r := range resp {
+		if strings.EqualFold(k, hash) {
+			return r, true
+		}
+	}
+	return infinigo.QueryResponse{}, false
+}
+
+// toResult converts a query response
+func toResult(hash string, r infinigo.QueryResponse) *Result {
+	return &Result{
+		Hash:         hash,
+		Status:       r.Status,
+		StatusCode:   r.StatusCode,
+		Error:        r.Error,
+		GeneralScore: r.GeneralScore,
+		ConfirmCode:  r.ConfirmCode,
+		Classifiers:  r.Classifiers,
+		Verdict:      string(r.Verdict()),
+	}
+}
+
+// toStatus converts errors to gRPC status errors with a code matching their cause
+func toStatus(err error) error {
+	var e *infinigo.Error
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.As(err, &e) && (e.ID == "missing_arg" || e.ID == "invalid_confirm_code"):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &e) && e.ID == "unauthorized":
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.As(err, &e) && e.ID == "forbidden":
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.As(err, &e) && (e.ID == "too_large" || e.ID == "payload_too_large" || e.ID == "quota_exceeded"):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, infinigo.ErrTimeout):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case infinigo.Unreachable(err), errors.Is(err, infinigo.ErrServiceUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
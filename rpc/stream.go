@@ -0,0 +1,66 @@
+package rpc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/demisto/infinigo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// uploadStream is the server side of the Upload and UploadAndWait streams
+type uploadStream interface {
+	Recv() (*UploadRequest, error)
+}
+
+// streamReader reads the file contents from an upload stream
+type streamReader struct {
+	stream      uploadStream
+	confirmCode string
+	buf         []byte
+	read        int64
+	max         int64
+	digest      hash.Hash
+}
+
+// newStreamReader receives the first message of the stream with the confirmation code
+func (s *Server) newStreamReader(stream uploadStream) (*streamReader, error) {
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return nil, status.Error(codes.InvalidArgument, "The stream is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if first.GetConfirmCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, "The first message must contain the confirmation code")
+	}
+	return &streamReader{stream: stream, confirmCode: first.GetConfirmCode(), buf: first.GetChunk(), max: s.maxUploadSize, digest: sha256.New()}, nil
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		msg, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = msg.GetChunk()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.read += int64(n)
+	if r.max > 0 && r.read > r.max {
+		return 0, &infinigo.Error{ID: "too_large", Details: fmt.Sprintf("Upload is larger than %d bytes", r.max)}
+	}
+	r.digest.Write(p[:n])
+	return n, nil
+}
+
+// hash returns the SHA256 of the contents read so far
+func (r *streamReader) hash() string {
+	return hex.EncodeToString(r.digest.Sum(nil))
+}
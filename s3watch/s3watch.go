@@ -0,0 +1,268 @@
+/*
+Package s3watch scans the objects landing in an Amazon S3 bucket, or a bucket of a compatible service,
+as a pipeline source, for the pattern of scanning everything users upload to a bucket.
+
+The bucket is either listed every poll interval, submitting the objects under the prefix that are new
+or changed since they were last seen, or, with SetNotifications, followed through the SQS queue its
+event notifications are sent to, which scales to busy buckets without listing them. New objects are
+downloaded to the download directory and submitted as files.
+
+Listing remembers the key and ETag of the objects it submitted, in the state file if set so restarts
+do not submit the bucket again. Without a state file, or on its first run, every object under the
+prefix is submitted unless SetSkipExisting is set.
+*/
+package s3watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/archive/s3"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/sqs"
+)
+
+const DefaultPollInterval = time.Minute // DefaultPollInterval between listings of the bucket
+
+// Watcher watches the objects of a bucket
+type Watcher struct {
+	bucket       *s3.Archiver
+	prefix       string
+	interval     time.Duration
+	stateFile    string
+	skipExisting bool
+	drain        bool
+	downloadDir  string
+	queue        *sqs.Queue
+	errorlog     *log.Logger
+	seen         map[string]string // ETags of the submitted objects by key
+}
+
+// OptionFunc is a function that configures a Watcher.
+// It is used in New
+type OptionFunc func(*Watcher) error
+
+// New creates a watcher of the objects under the prefix of the bucket, downloading them to the directory
+func New(bucket *s3.Archiver, prefix, downloadDir string, options ...OptionFunc) (*Watcher, error) {
+	if bucket == nil {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "S3 bucket is required"}
+	}
+	if downloadDir == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Download directory is required"}
+	}
+	w := &Watcher{
+		bucket:      bucket,
+		prefix:      strings.TrimPrefix(prefix, "/"),
+		interval:    DefaultPollInterval,
+		downloadDir: downloadDir,
+	}
+	for _, option := range options {
+		if err := option(w); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// SetPollInterval sets the interval between listings of the bucket. It is DefaultPollInterval by default.
+func SetPollInterval(interval time.Duration) OptionFunc {
+	return func(w *Watcher) error {
+		if interval <= 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Poll interval must be positive"}
+		}
+		w.interval = interval
+		return nil
+	}
+}
+
+// SetStateFile sets the file remembering the objects already submitted across restarts
+func SetStateFile(path string) OptionFunc {
+	return func(w *Watcher) error {
+		w.stateFile = path
+		return nil
+	}
+}
+
+// SetSkipExisting only submits the objects landing after the first listing, when there is no state
+// from a previous run. Every object under the prefix is submitted by default.
+func SetSkipExisting(skip bool) OptionFunc {
+	return func(w *Watcher) error {
+		w.skipExisting = skip
+		return nil
+	}
+}
+
+// SetDrain makes the source end once a listing finds no new object, or a receive from the notification
+// queue finds no message. The source watches the bucket until its context is done by default.
+func SetDrain(drain bool) OptionFunc {
+	return func(w *Watcher) error {
+		w.drain = drain
+		return nil
+	}
+}
+
+// SetNotifications follows the event notifications of the bucket sent to the SQS queue instead of listing
+// it. Which objects are submitted is then up to the notification configuration of the bucket.
+func SetNotifications(q *sqs.Queue) OptionFunc {
+	return func(w *Watcher) error {
+		w.queue = q
+		return nil
+	}
+}
+
+// SetErrorLog sets the logger of the objects the source skips because they cannot be downloaded
+func SetErrorLog(logger *log.Logger) OptionFunc {
+	return func(w *Watcher) error {
+		w.errorlog = logger
+		return nil
+	}
+}
+
+// Source returns a source of the new objects of the bucket. Submissions are copies of the template with
+// the path of the downloaded object. An object is remembered as submitted when the submission after it
+// is asked for, once Feed submitted it, and the state is saved before every listing.
+func (w *Watcher) Source(template pipeline.Submission) pipeline.Source {
+	if w.queue != nil {
+		return w.queue.Source(template)
+	}
+	var pending []s3.Object
+	var last *s3.Object // last is the object handed out last, remembered on the next call
+	listed := false
+	return pipeline.SourceFunc(func(ctx context.Context) (pipeline.Submission, error) {
+		if last != nil {
+			w.seen[last.Key] = last.ETag
+			last = nil
+		}
+		for {
+			for len(pending) > 0 {
+				o := pending[0]
+				pending = pending[1:]
+				path, err := w.download(ctx, o.Key)
+				if err != nil {
+					if ctx.Err() != nil {
+						return pipeline.Submission{}, ctx.Err()
+					}
+					if w.errorlog != nil {
+						w.errorlog.Printf("Skipped S3 object %s - %v", o.Key, err)
+					}
+					continue
+				}
+				last = &o
+				sub := template
+				sub.Path = path
+				return sub, nil
+			}
+			if listed {
+				if err := w.save(); err != nil && w.errorlog != nil {
+					w.errorlog.Printf("Failed saving the S3 watch state, objects will be submitted again - %v", err)
+				}
+				if w.drain {
+					return pipeline.Submission{}, io.EOF
+				}
+				select {
+				case <-time.After(w.interval):
+				case <-ctx.Done():
+					return pipeline.Submission{}, ctx.Err()
+				}
+			}
+			var err error
+			if pending, err = w.list(ctx); err != nil {
+				return pipeline.Submission{}, err
+			}
+			listed = true
+		}
+	})
+}
+
+// list lists the bucket and returns the objects not seen yet, forgetting the seen objects that are gone
+func (w *Watcher) list(ctx context.Context) ([]s3.Object, error) {
+	first := w.seen == nil
+	if first {
+		if err := w.load(); err != nil {
+			return nil, err
+		}
+		first = w.seen == nil
+		if first {
+			w.seen = make(map[string]string)
+		}
+	}
+	present := make(map[string]string, len(w.seen))
+	var objects []s3.Object
+	err := w.bucket.List(ctx, w.prefix, func(o s3.Object) error {
+		if strings.HasSuffix(o.Key, "/") && o.Size == 0 {
+			// Folder placeholders of consoles
+			return nil
+		}
+		if etag, ok := w.seen[o.Key]; ok && etag == o.ETag {
+			present[o.Key] = etag
+			return nil
+		}
+		if first && w.skipExisting {
+			present[o.Key] = o.ETag
+			return nil
+		}
+		objects = append(objects, o)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	w.seen = present
+	return objects, nil
+}
+
+// load reads the seen objects of the state file, leaving them nil if there is none
+func (w *Watcher) load() error {
+	if w.stateFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(w.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &w.seen)
+}
+
+// save writes the seen objects to the state file, replacing it atomically
+func (w *Watcher) save() error {
+	if w.stateFile == "" {
+		return nil
+	}
+	b, err := json.Marshal(w.seen)
+	if err != nil {
+		return err
+	}
+	tmp := w.stateFile + ".tmp"
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.stateFile)
+}
+
+// download downloads the object to the download directory and returns its path
+func (w *Watcher) download(ctx context.Context, key string) (string, error) {
+	f, err := os.CreateTemp(w.downloadDir, "s3-*-"+filepath.Base(key))
+	if err != nil {
+		return "", err
+	}
+	err = w.bucket.Get(ctx, key, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
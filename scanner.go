@@ -0,0 +1,30 @@
+package infinigo
+
+import (
+	"context"
+	"io"
+)
+
+// Scanner looks up the verdicts of file hashes and submits the samples it has no verdict for.
+// Client implements it with Infinity. Applications that code against Scanner can swap in other
+// engines, or mocks in tests.
+type Scanner interface {
+	// LookupHashes returns the responses for the MD5, SHA1 or SHA256 hashes, keyed by hash.
+	// A response with a ConfirmCode asks for the sample.
+	LookupHashes(ctx context.Context, hash ...string) (map[string]QueryResponse, error)
+	// SubmitSample sends the sample asked for by the ConfirmCode of a response
+	SubmitSample(ctx context.Context, confirmCode string, sample io.Reader) error
+}
+
+var _ Scanner = (*Client)(nil)
+
+// LookupHashes queries Infinity for any number of hashes with all the classifiers, as QueryAllContext does
+func (c *Client) LookupHashes(ctx context.Context, hash ...string) (map[string]QueryResponse, error) {
+	return c.QueryAllContext(ctx, "all", hash...)
+}
+
+// SubmitSample uploads the sample to Infinity, as UploadContext does
+func (c *Client) SubmitSample(ctx context.Context, confirmCode string, sample io.Reader) error {
+	_, err := c.UploadContext(ctx, confirmCode, sample)
+	return err
+}
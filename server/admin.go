@@ -0,0 +1,146 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// PendingUpload is an upload in progress or a submitted file waiting for its verdict
+type PendingUpload struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"` // Kind is "upload" for proxied uploads or "submit" for submissions
+	Tenant      string    `json:"tenant"`
+	Filename    string    `json:"filename,omitempty"`
+	SHA256      string    `json:"sha256,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	ConfirmCode string    `json:"confirmcode,omitempty"`
+	Started     time.Time `json:"started"`
+}
+
+// pendingUploads tracks the uploads in progress
+type pendingUploads struct {
+	mu      sync.Mutex
+	uploads map[string]PendingUpload
+}
+
+// add tracks the upload until the returned function is called
+func (p *pendingUploads) add(u PendingUpload) func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.uploads == nil {
+		p.uploads = make(map[string]PendingUpload)
+	}
+	u.Started = time.Now().UTC()
+	p.uploads[u.ID] = u
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.uploads, u.ID)
+	}
+}
+
+// list returns the uploads in progress, oldest first
+func (p *pendingUploads) list() []PendingUpload {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	all := make([]PendingUpload, 0, len(p.uploads))
+	for _, u := range p.uploads {
+		all = append(all, u)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Started.Before(all[j].Started) })
+	return all
+}
+
+// RateLimit is the rate limit of requests sent to Infinity
+type RateLimit struct {
+	PerSecond float64 `json:"rate"`  // PerSecond is the number of requests allowed per second, 0 for no limit
+	Burst     int     `json:"burst"` // Burst is the number of requests allowed to exceed the rate in bursts
+}
+
+// Usage reports the requests sent to Infinity
+type Usage struct {
+	Since   time.Time              `json:"since"`   // Since is when the server started counting
+	Tenants map[string]TenantUsage `json:"tenants"` // Tenants maps tenant names to their usage
+	Clients map[string]DailyUsage  `json:"clients"` // Clients maps client names to their usage today
+}
+
+// TenantUsage reports the requests sent to Infinity with the API key of a tenant
+type TenantUsage struct {
+	Upstream map[string]map[string]uint64 `json:"upstream"` // Upstream maps operations to their counts by result
+	Today    DailyUsage                   `json:"today"`    // Today is the usage counted against the tenant's quota
+}
+
+// SetAdminToken enables the admin API under /admin/ for requests with the bearer token
+func SetAdminToken(token string) OptionFunc {
+	return func(s *Server) error {
+		s.adminToken = token
+		return nil
+	}
+}
+
+// handleAdmin authenticates admin requests and routes them.
+//
+//	GET    /admin/pending      Lists the uploads in progress
+//	DELETE /admin/cache[?h=]   Drops the cached responses of a hash, or all of them
+//	GET    /admin/usage        Reports the requests sent to Infinity and the daily usage of clients and tenants
+//	GET    /admin/ratelimit    Returns the rate limit
+//	PUT    /admin/ratelimit    Sets the rate limit from a JSON body, e.g. {"rate": 5, "burst": 10}
+func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if s.adminToken == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="infinigo admin"`)
+		s.writeJSON(w, http.StatusUnauthorized, &infinigo.Error{ID: "unauthorized", Details: "A valid admin token is required"})
+		return
+	}
+	route := r.Method + " " + strings.TrimPrefix(r.URL.Path, "/admin")
+	switch route {
+	case "GET /pending":
+		s.writeJSON(w, http.StatusOK, s.pending.list())
+	case "DELETE /cache":
+		removed := 0
+		for _, c := range s.caches() {
+			if h := r.URL.Query().Get("h"); h != "" {
+				removed += c.remove(strings.ToLower(h))
+			} else {
+				removed += c.clear()
+			}
+		}
+		s.writeJSON(w, http.StatusOK, map[string]int{"removed": removed})
+	case "GET /usage":
+		usage := s.metrics.usage()
+		var today map[string]DailyUsage
+		usage.Clients, today = s.accounting.usage()
+		for name, u := range today {
+			t, ok := usage.Tenants[name]
+			if !ok {
+				t.Upstream = make(map[string]map[string]uint64)
+			}
+			t.Today = u
+			usage.Tenants[name] = t
+		}
+		s.writeJSON(w, http.StatusOK, usage)
+	case "GET /ratelimit":
+		s.writeJSON(w, http.StatusOK, s.limiter.config())
+	case "PUT /ratelimit":
+		var rl RateLimit
+		if err := json.NewDecoder(r.Body).Decode(&rl); err != nil {
+			s.writeError(w, &infinigo.Error{ID: "bad_request", Details: err.Error()})
+			return
+		}
+		if rl.PerSecond < 0 || rl.Burst < 0 {
+			s.writeError(w, &infinigo.Error{ID: "bad_request", Details: "Rate limit cannot be negative"})
+			return
+		}
+		s.limiter.set(rl.PerSecond, rl.Burst)
+		s.writeJSON(w, http.StatusOK, s.limiter.config())
+	default:
+		s.writeJSON(w, http.StatusNotFound, &infinigo.Error{ID: "not_found", Details: "Unknown admin request " + route})
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// Scopes of client tokens
+const (
+	ScopeQuery  = "query"  // ScopeQuery allows querying hashes
+	ScopeUpload = "upload" // ScopeUpload allows uploading and submitting files
+)
+
+// Token authenticates a client of the server
+type Token struct {
+	Name   string   `json:"name"`             // Name of the client, used in logs and usage reports
+	Token  string   `json:"token"`            // Token the client sends as a bearer token
+	Scopes []string `json:"scopes"`           // Scopes the client is allowed
+	Tenant string   `json:"tenant,omitempty"` // Tenant whose API key is used for the client, DefaultTenant if empty
+	Quota  Quota    `json:"quota"`            // Quota is the daily limit of the client
+}
+
+// allows returns true if the token has the scope
+func (t *Token) allows(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// tokens holds the client tokens, reloading them from a file when it changes
+type tokens struct {
+	mu      sync.Mutex
+	static  []Token
+	path    string
+	modTime time.Time
+	loaded  []Token
+}
+
+// enabled returns true if clients must authenticate
+func (t *tokens) enabled() bool {
+	return len(t.static) > 0 || t.path != ""
+}
+
+// find returns the token matching the given secret, nil if there is none.
+// If the token file changed but cannot be loaded, the previous tokens are used and the error is returned.
+func (t *tokens) find(secret string) (*Token, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var err error
+	if t.path != "" {
+		var info os.FileInfo
+		if info, err = os.Stat(t.path); err == nil && !info.ModTime().Equal(t.modTime) {
+			var loaded []Token
+			if loaded, err = LoadTokens(t.path); err == nil {
+				t.loaded, t.modTime = loaded, info.ModTime()
+			}
+		}
+	}
+	for _, list := range [][]Token{t.static, t.loaded} {
+		for i := range list {
+			if subtle.ConstantTimeCompare([]byte(list[i].Token), []byte(secret)) == 1 {
+				tok := list[i]
+				return &tok, err
+			}
+		}
+	}
+	return nil, err
+}
+
+// LoadTokens reads client tokens from a JSON file with a list of tokens, e.g.
+//
+//	[{"name": "soc", "token": "...", "scopes": ["query", "upload"], "quota": {"queries": 10000}}]
+func LoadTokens(path string) ([]Token, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []Token
+	if err = json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	for _, t := range list {
+		if err = validToken(t); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func validToken(t Token) error {
+	if t.Token == "" {
+		return &infinigo.Error{ID: "bad_option", Details: "Token of client [" + t.Name + "] is empty"}
+	}
+	for _, s := range t.Scopes {
+		if s != ScopeQuery && s != ScopeUpload {
+			return &infinigo.Error{ID: "bad_option", Details: "Unknown scope [" + s + "] for client [" + t.Name + "]"}
+		}
+	}
+	return nil
+}
+
+// SetTokens requires clients to authenticate with one of the given tokens.
+// Without tokens, the query, upload and submit endpoints are open to anyone who can reach the server.
+func SetTokens(list ...Token) OptionFunc {
+	return func(s *Server) error {
+		for _, t := range list {
+			if err := validToken(t); err != nil {
+				return err
+			}
+		}
+		s.tokens.static = append(s.tokens.static, list...)
+		return nil
+	}
+}
+
+// SetTokenFile requires clients to authenticate with one of the tokens in the file, see LoadTokens.
+// The file is read again when it changes, so tokens can be added and revoked without a restart.
+func SetTokenFile(path string) OptionFunc {
+	return func(s *Server) error {
+		if _, err := LoadTokens(path); err != nil {
+			return err
+		}
+		s.tokens.path = path
+		return nil
+	}
+}
+
+// Authorize checks the client token allows the scope. It returns an error with the unauthorized
+// or forbidden ID if it does not. Any token is allowed if no tokens are set.
+func (s *Server) Authorize(secret, scope string) (*Token, error) {
+	if !s.tokens.enabled() {
+		return nil, nil
+	}
+	if secret == "" {
+		return nil, &infinigo.Error{ID: "unauthorized", Details: "A client token is required"}
+	}
+	t, err := s.tokens.find(secret)
+	if err != nil {
+		s.errorf("Failed reloading client tokens, using the previous ones - %v\n", err)
+	}
+	if t == nil {
+		return nil, &infinigo.Error{ID: "unauthorized", Details: "Invalid client token"}
+	}
+	if !t.allows(scope) {
+		return nil, &infinigo.Error{ID: "forbidden", Details: "Client [" + t.Name + "] is not allowed to " + scope}
+	}
+	return t, nil
+}
+
+// authorize wraps a handler so it requires a client token with the scope
+func (s *Server) authorize(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		tok, err := s.Authorize(secret, scope)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="infinigo"`)
+			s.writeError(w, err)
+			return
+		}
+		t, err := s.tenantOfToken(tok)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, newCaller(tok, t))))
+	}
+}
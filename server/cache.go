@@ -0,0 +1,117 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// cacheEntry is a cached response with its expiry
+type cacheEntry struct {
+	resp    infinigo.QueryResponse
+	expires time.Time
+}
+
+// cache is an in memory TTL cache of query responses keyed by classifiers and hash
+type cache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	unknownTTL time.Duration
+	maxEntries int
+	entries    map[string]cacheEntry
+	hits       uint64
+	misses     uint64
+}
+
+func newCache(ttl, unknownTTL time.Duration, maxEntries int) *cache {
+	return &cache{ttl: ttl, unknownTTL: unknownTTL, maxEntries: maxEntries, entries: make(map[string]cacheEntry)}
+}
+
+func cacheKey(classifiers, hash string) string {
+	return classifiers + ":" + hash
+}
+
+// get returns the cached response if it did not expire
+func (c *cache) get(classifiers, hash string) (infinigo.QueryResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[cacheKey(classifiers, hash)]
+	if !ok || time.Now().After(e.expires) {
+		c.misses++
+		return infinigo.QueryResponse{}, false
+	}
+	c.hits++
+	return e.resp, true
+}
+
+// put caches the response. Errors are not cached and unknown verdicts expire sooner.
+func (c *cache) put(classifiers, hash string, resp infinigo.QueryResponse) {
+	ttl := c.ttl
+	switch resp.Verdict() {
+	case infinigo.VerdictError:
+		return
+	case infinigo.VerdictUnknown:
+		ttl = c.unknownTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[cacheKey(classifiers, hash)] = cacheEntry{resp: resp, expires: now.Add(ttl)}
+}
+
+// evict removes expired entries, and if the cache is still full the entries expiring first.
+// Must be called with the lock held.
+func (c *cache) evict(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+			continue
+		}
+		if oldestKey == "" || e.expires.Before(oldest) {
+			oldestKey, oldest = k, e.expires
+		}
+	}
+	if len(c.entries) >= c.maxEntries && oldestKey != "" {
+		delete(c.entries, oldestKey)
+	}
+}
+
+// remove drops all the cached responses for the hash and returns how many were dropped
+func (c *cache) remove(hash string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for k := range c.entries {
+		if strings.HasSuffix(k, ":"+hash) {
+			delete(c.entries, k)
+			removed++
+		}
+	}
+	return removed
+}
+
+// clear drops all the cached responses and returns how many were dropped
+func (c *cache) clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := len(c.entries)
+	c.entries = make(map[string]cacheEntry)
+	return removed
+}
+
+// stats returns the cache hits, misses and number of entries
+func (c *cache) stats() (hits, misses uint64, entries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, len(c.entries)
+}
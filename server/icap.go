@@ -0,0 +1,285 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// istag identifies the ICAP service state to clients caching its responses
+const istag = `"infinigo-1"`
+
+// icapRequest is a parsed ICAP request
+type icapRequest struct {
+	method   string
+	uri      string
+	header   textproto.MIMEHeader
+	sections map[string][]byte // encapsulated HTTP headers by section name, e.g. req-hdr
+	bodyName string            // name of the encapsulated body section, e.g. res-body
+}
+
+// SetBlockVerdicts sets the verdicts the ICAP service blocks. The default is malicious only.
+// Include infinigo.VerdictError to block payloads that could not be checked.
+func SetBlockVerdicts(verdicts ...infinigo.Verdict) OptionFunc {
+	return func(s *Server) error {
+		s.block = make(map[infinigo.Verdict]bool, len(verdicts))
+		for _, v := range verdicts {
+			s.block[v] = true
+		}
+		return nil
+	}
+}
+
+// ServeICAP accepts ICAP (RFC 3507) connections on the listener until it is closed.
+// REQMOD and RESPMOD bodies are hashed and checked with Infinity, and payloads with a blocked
+// verdict are replaced with a 403 Forbidden response.
+func (s *Server) ServeICAP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serveICAPConn(conn)
+	}
+}
+
+// serveICAPConn handles the requests of a persistent ICAP connection
+func (s *Server) serveICAPConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		req, err := readICAPRequest(r)
+		if err != nil {
+			if err != io.EOF {
+				s.errorf("Bad ICAP request from %s - %v\n", conn.RemoteAddr(), err)
+				writeICAPStatus(w, 400, "Bad Request")
+				w.Flush()
+			}
+			return
+		}
+		if err = s.handleICAP(req, r, w); err != nil {
+			s.errorf("Failed ICAP %s from %s - %v\n", req.method, conn.RemoteAddr(), err)
+			return
+		}
+		if err = w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readICAPRequest reads the request line, the ICAP headers and the encapsulated HTTP headers
+func readICAPRequest(r *bufio.Reader) (*icapRequest, error) {
+	tp := textproto.NewReader(r)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "ICAP/") {
+		return nil, fmt.Errorf("malformed request line %q", line)
+	}
+	req := &icapRequest{method: parts[0], uri: parts[1], sections: make(map[string][]byte)}
+	if req.header, err = tp.ReadMIMEHeader(); err != nil {
+		return nil, err
+	}
+	type section struct {
+		name   string
+		offset int
+	}
+	var sections []section
+	for _, part := range strings.Split(req.header.Get("Encapsulated"), ",") {
+		name, offset, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(offset)
+		if err != nil {
+			return nil, fmt.Errorf("malformed Encapsulated header %q", req.header.Get("Encapsulated"))
+		}
+		sections = append(sections, section{name, n})
+	}
+	sort.Slice(sections, func(i, j int) bool { return sections[i].offset < sections[j].offset })
+	for i, sec := range sections {
+		if strings.HasSuffix(sec.name, "-body") {
+			req.bodyName = sec.name
+			break
+		}
+		if i+1 == len(sections) {
+			return nil, fmt.Errorf("Encapsulated header does not end with a body")
+		}
+		b := make([]byte, sections[i+1].offset-sec.offset)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		req.sections[sec.name] = b
+	}
+	return req, nil
+}
+
+// readChunks copies a chunked body to w and reports whether the last chunk had the ieof extension
+func readChunks(r *bufio.Reader, w io.Writer) (bool, error) {
+	tp := textproto.NewReader(r)
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return false, err
+		}
+		sizeStr, ext, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+		if err != nil || size < 0 {
+			return false, fmt.Errorf("malformed chunk size %q", line)
+		}
+		if size == 0 {
+			// Skip the empty line ending the chunked body
+			if _, err = tp.ReadLine(); err != nil {
+				return false, err
+			}
+			return strings.TrimSpace(ext) == "ieof", nil
+		}
+		if _, err = io.CopyN(w, r, size); err != nil {
+			return false, err
+		}
+		if _, err = tp.ReadLine(); err != nil {
+			return false, err
+		}
+	}
+}
+
+// writeChunked writes the body as a single chunk followed by the last chunk
+func writeChunked(w io.Writer, body []byte) {
+	if len(body) > 0 {
+		fmt.Fprintf(w, "%x\r\n%s\r\n", len(body), body)
+	}
+	io.WriteString(w, "0\r\n\r\n")
+}
+
+func writeICAPStatus(w io.Writer, code int, reason string) {
+	fmt.Fprintf(w, "ICAP/1.0 %d %s\r\nISTag: %s\r\nEncapsulated: null-body=0\r\n\r\n", code, reason, istag)
+}
+
+// handleICAP answers a single ICAP request, reading its body from r
+func (s *Server) handleICAP(req *icapRequest, r *bufio.Reader, w *bufio.Writer) error {
+	switch req.method {
+	case "OPTIONS":
+		methods := "RESPMOD"
+		if strings.Contains(strings.ToLower(req.uri), "req") {
+			methods = "REQMOD"
+		}
+		fmt.Fprintf(w, "ICAP/1.0 200 OK\r\nMethods: %s\r\nService: infinigo Infinity ICAP service\r\nISTag: %s\r\n"+
+			"Allow: 204\r\nPreview: 0\r\nTransfer-Preview: *\r\nEncapsulated: null-body=0\r\n\r\n", methods, istag)
+		return nil
+	case "REQMOD", "RESPMOD":
+	default:
+		writeICAPStatus(w, 405, "Method Not Allowed")
+		return nil
+	}
+	allow204 := false
+	for _, v := range strings.Split(req.header.Get("Allow"), ",") {
+		allow204 = allow204 || strings.TrimSpace(v) == "204"
+	}
+	_, preview := req.header["Preview"]
+	if req.bodyName == "" || req.bodyName == "null-body" {
+		return s.writeICAPAllow(w, req, nil, allow204)
+	}
+	digest := sha256.New()
+	var body bytes.Buffer
+	out := io.Writer(digest)
+	if !allow204 {
+		// The unmodified body has to be sent back
+		out = io.MultiWriter(digest, &limitedBuffer{buf: &body, max: s.maxUploadSize})
+	}
+	ieof, err := readChunks(r, out)
+	if err != nil {
+		return err
+	}
+	if preview && !ieof {
+		// Ask for the rest of the body after the preview
+		fmt.Fprintf(w, "ICAP/1.0 100 Continue\r\n\r\n")
+		if err = w.Flush(); err != nil {
+			return err
+		}
+		if _, err = readChunks(r, out); err != nil {
+			return err
+		}
+	}
+	hash := hex.EncodeToString(digest.Sum(nil))
+	resp, err := s.lookup(context.Background(), "all", hash)
+	verdict := resp.Verdict()
+	if err != nil {
+		s.errorf("Failed checking %s - %v\n", hash, err)
+		verdict = infinigo.VerdictError
+	}
+	if s.block[verdict] {
+		return writeICAPBlock(w, hash, verdict)
+	}
+	// Without Allow: 204, a 204 can only answer a preview that held the whole body
+	return s.writeICAPAllow(w, req, body.Bytes(), allow204 || preview && ieof)
+}
+
+// writeICAPAllow lets the message through unmodified
+func (s *Server) writeICAPAllow(w io.Writer, req *icapRequest, body []byte, allow204 bool) error {
+	if allow204 {
+		writeICAPStatus(w, 204, "No Content")
+		return nil
+	}
+	hdrName := "req-hdr"
+	if req.method == "RESPMOD" {
+		hdrName = "res-hdr"
+	}
+	hdr := req.sections[hdrName]
+	encapsulated := "null-body=0"
+	if hdr != nil {
+		encapsulated = fmt.Sprintf("%s=0, null-body=%d", hdrName, len(hdr))
+		if req.bodyName != "" && req.bodyName != "null-body" {
+			encapsulated = fmt.Sprintf("%s=0, %s=%d", hdrName, req.bodyName, len(hdr))
+		}
+	}
+	fmt.Fprintf(w, "ICAP/1.0 200 OK\r\nISTag: %s\r\nEncapsulated: %s\r\n\r\n", istag, encapsulated)
+	w.Write(hdr)
+	if hdr != nil && req.bodyName != "" && req.bodyName != "null-body" {
+		writeChunked(w, body)
+	}
+	return nil
+}
+
+// writeICAPBlock replaces the message with a 403 Forbidden response
+func writeICAPBlock(w io.Writer, hash string, verdict infinigo.Verdict) error {
+	body := fmt.Sprintf("Blocked by Infinity: the content %s is %s\n", hash, verdict)
+	hdr := fmt.Sprintf("HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n", len(body))
+	fmt.Fprintf(w, "ICAP/1.0 200 OK\r\nISTag: %s\r\nX-Infection-Found: Type=0; Resolution=2; Threat=Infinity %s;\r\nX-Virus-ID: Infinity %s\r\n"+
+		"Encapsulated: res-hdr=0, res-body=%d\r\n\r\n%s", istag, verdict, verdict, len(hdr), hdr)
+	writeChunked(w, []byte(body))
+	return nil
+}
+
+// limitedBuffer buffers up to max bytes and fails after that
+type limitedBuffer struct {
+	buf *bytes.Buffer
+	max int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.max > 0 && int64(b.buf.Len()+len(p)) > b.max {
+		return 0, &infinigo.Error{ID: "too_large", Details: fmt.Sprintf("Payload is larger than %d bytes", b.max)}
+	}
+	return b.buf.Write(p)
+}
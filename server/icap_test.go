@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/demisto/infinigo"
+)
+
+// sha256Hex returns the hash Infinity is queried for
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// newTestServer returns a server querying a fake Infinity, which scores the hashes of the content
+// as given and leaves other hashes unknown
+func newTestServer(t *testing.T, scores map[string]float32, options ...OptionFunc) *Server {
+	t.Helper()
+	infinity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := make(map[string]infinigo.QueryResponse)
+		for _, hash := range strings.Split(r.URL.Query().Get("h"), ",") {
+			resp[hash] = infinigo.QueryResponse{Common: infinigo.Common{Status: "ok", StatusCode: 200}}
+			for content, score := range scores {
+				if sha256Hex([]byte(content)) == hash {
+					resp[hash] = infinigo.QueryResponse{Common: infinigo.Common{Status: "ok", StatusCode: 200}, GeneralScore: score}
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(infinity.Close)
+	client, err := infinigo.New(infinigo.SetKey("key"), infinigo.SetURL(infinity.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(client, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// dialICAP serves ICAP on a new listener and returns a connection to it
+func dialICAP(t *testing.T, s *Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.ServeICAP(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+// icapResponse is a parsed ICAP response
+type icapResponse struct {
+	status int
+	header textproto.MIMEHeader
+	hdr    string // hdr is the encapsulated HTTP header
+	body   string // body is the encapsulated HTTP body
+}
+
+func readICAPResponse(t *testing.T, r *bufio.Reader) icapResponse {
+	t.Helper()
+	tp := textproto.NewReader(r)
+	line, err := tp.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp icapResponse
+	if _, err = fmt.Sscanf(line, "ICAP/1.0 %d", &resp.status); err != nil {
+		t.Fatalf("Bad ICAP status line %q", line)
+	}
+	if resp.status == 100 {
+		tp.ReadLine()
+		return resp
+	}
+	if resp.header, err = tp.ReadMIMEHeader(); err != nil {
+		t.Fatal(err)
+	}
+	// The header, if any, is at 0 and the body section starts after it
+	encapsulated := resp.header.Get("Encapsulated")
+	parts := strings.Split(encapsulated, ",")
+	if len(parts) == 2 {
+		_, offset, _ := strings.Cut(parts[1], "=")
+		n, _ := strconv.Atoi(offset)
+		hdr := make([]byte, n)
+		if _, err = io.ReadFull(r, hdr); err != nil {
+			t.Fatal(err)
+		}
+		resp.hdr = string(hdr)
+	}
+	if strings.Contains(encapsulated, "-body=") && !strings.Contains(encapsulated, "null-body") {
+		var body bytes.Buffer
+		if _, err = readChunks(r, &body); err != nil {
+			t.Fatal(err)
+		}
+		resp.body = body.String()
+	}
+	return resp
+}
+
+// respmod returns a RESPMOD request for a 200 response with the body, sending only the preview if
+// preview is not negative
+func respmod(body string, allow204 bool, preview int) string {
+	hdr := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n", len(body))
+	req := "RESPMOD icap://127.0.0.1/respmod ICAP/1.0\r\nHost: 127.0.0.1\r\n"
+	if allow204 {
+		req += "Allow: 204\r\n"
+	}
+	chunk := body
+	if preview >= 0 {
+		req += fmt.Sprintf("Preview: %d\r\n", preview)
+		chunk = body[:min(preview, len(body))]
+	}
+	req += fmt.Sprintf("Encapsulated: res-hdr=0, res-body=%d\r\n\r\n%s", len(hdr), hdr)
+	if chunk != "" {
+		req += fmt.Sprintf("%x\r\n%s\r\n", len(chunk), chunk)
+	}
+	if preview >= len(body) {
+		return req + "0; ieof\r\n\r\n"
+	}
+	return req + "0\r\n\r\n"
+}
+
+func TestICAPOptions(t *testing.T) {
+	conn, r := dialICAP(t, newTestServer(t, nil))
+	fmt.Fprintf(conn, "OPTIONS icap://127.0.0.1/respmod ICAP/1.0\r\nHost: 127.0.0.1\r\nEncapsulated: null-body=0\r\n\r\n")
+	resp := readICAPResponse(t, r)
+	if resp.status != 200 || resp.header.Get("Methods") != "RESPMOD" || resp.header.Get("ISTag") == "" {
+		t.Fatalf("Expected the RESPMOD service options, got %d %v", resp.status, resp.header)
+	}
+	fmt.Fprintf(conn, "DELETE icap://127.0.0.1/respmod ICAP/1.0\r\nHost: 127.0.0.1\r\nEncapsulated: null-body=0\r\n\r\n")
+	if resp = readICAPResponse(t, r); resp.status != 405 {
+		t.Fatalf("Expected other methods not to be allowed, got %d", resp.status)
+	}
+}
+
+func TestICAPRespmod(t *testing.T) {
+	const malware, clean = "malicious payload", "clean payload"
+	tests := []struct {
+		name     string
+		body     string
+		allow204 bool
+		preview  int
+		status   int
+		blocked  bool
+	}{
+		{"malicious", malware, true, -1, 200, true},
+		{"malicious without 204", malware, false, -1, 200, true},
+		{"clean", clean, true, -1, 204, false},
+		{"clean echoed without 204", clean, false, -1, 200, false},
+		{"clean in the preview", clean, false, 64, 204, false},
+		{"malicious after the preview", malware, true, 4, 200, true},
+		{"clean after the preview", clean, true, 4, 204, false},
+	}
+	s := newTestServer(t, map[string]float32{malware: -1, clean: 1})
+	// Requests share a persistent connection
+	conn, r := dialICAP(t, s)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fmt.Fprint(conn, respmod(test.body, test.allow204, test.preview))
+			resp := readICAPResponse(t, r)
+			if test.preview >= 0 && test.preview < len(test.body) {
+				if resp.status != 100 {
+					t.Fatalf("Expected the rest of the body to be asked for, got %d", resp.status)
+				}
+				rest := test.body[test.preview:]
+				fmt.Fprintf(conn, "%x\r\n%s\r\n0\r\n\r\n", len(rest), rest)
+				resp = readICAPResponse(t, r)
+			}
+			if resp.status != test.status {
+				t.Fatalf("Expected status %d, got %d", test.status, resp.status)
+			}
+			blocked := strings.HasPrefix(resp.hdr, "HTTP/1.1 403 ")
+			if blocked != test.blocked || test.blocked && !strings.Contains(resp.body, sha256Hex([]byte(test.body))) {
+				t.Fatalf("Expected blocked to be %v, got %q %q", test.blocked, resp.hdr, resp.body)
+			}
+			if blocked && !strings.Contains(resp.header.Get("X-Infection-Found"), "malicious") {
+				t.Fatalf("Expected the infection to be reported, got %v", resp.header)
+			}
+			if !blocked && resp.status == 200 && (!strings.HasPrefix(resp.hdr, "HTTP/1.1 200 OK") || resp.body != test.body) {
+				t.Fatalf("Expected the response to be let through unmodified, got %q %q", resp.hdr, resp.body)
+			}
+		})
+	}
+}
+
+func TestICAPBlockVerdicts(t *testing.T) {
+	const suspicious = "suspicious payload"
+	s := newTestServer(t, map[string]float32{suspicious: -0.4}, SetBlockVerdicts(infinigo.VerdictSuspicious, infinigo.VerdictUnknown))
+	conn, r := dialICAP(t, s)
+	for _, body := range []string{suspicious, "unknown payload"} {
+		fmt.Fprint(conn, respmod(body, true, -1))
+		if resp := readICAPResponse(t, r); !strings.HasPrefix(resp.hdr, "HTTP/1.1 403 ") {
+			t.Fatalf("Expected %q to be blocked, got %d %q", body, resp.status, resp.hdr)
+		}
+	}
+}
+
+func TestICAPBadRequest(t *testing.T) {
+	conn, r := dialICAP(t, newTestServer(t, nil))
+	fmt.Fprint(conn, "RESPMOD icap://127.0.0.1/respmod\r\n\r\n")
+	if resp := readICAPResponse(t, r); resp.status != 400 {
+		t.Fatalf("Expected a malformed request to be refused, got %d", resp.status)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter is a token bucket limiting the rate of requests sent to Infinity
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64 // rate of tokens added per second, 0 for unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// set changes the rate and burst, keeping the tokens already available
+func (l *limiter) set(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if burst == 0 {
+		burst = 1
+	}
+	l.rate, l.burst = rate, float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// config returns the rate and burst
+func (l *limiter) config() RateLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return RateLimit{PerSecond: l.rate, Burst: int(l.burst)}
+}
+
+// allow takes a token if one is available, otherwise returns how long until one will be
+func (l *limiter) allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return true, 0
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// metrics counts requests to the server and to Infinity
+type metrics struct {
+	mu       sync.Mutex
+	requests map[labels]uint64 // requests by handler and status code
+	upstream map[labels]uint64 // upstream requests by tenant, operation and result
+	lastOK   time.Time         // lastOK is when Infinity last answered
+	lastErr  time.Time         // lastErr is when Infinity was last unreachable
+	since    time.Time         // since is when counting started
+}
+
+// labels are the label values of a counter
+type labels [3]string
+
+func newMetrics() *metrics {
+	return &metrics{requests: make(map[labels]uint64), upstream: make(map[labels]uint64), since: time.Now().UTC()}
+}
+
+// request counts a request handled by the server
+func (m *metrics) request(handler string, status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[labels{handler, strconv.Itoa(status)}]++
+}
+
+// observe records the outcome of a request to Infinity for the tenant
+func (m *metrics) observe(tenant, op string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := "ok"
+	switch {
+	case err == nil:
+		m.lastOK = time.Now()
+	case infinigo.Unreachable(err):
+		result = "unreachable"
+		m.lastErr = time.Now()
+	default:
+		// Infinity answered, even if with an error
+		result = "error"
+		m.lastOK = time.Now()
+	}
+	m.upstream[labels{tenant, op, result}]++
+}
+
+// reachable returns false if the last request to Infinity did not get a response
+func (m *metrics) reachable() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastErr.IsZero() || m.lastOK.After(m.lastErr)
+}
+
+// usage returns the upstream request counts by tenant
+func (m *metrics) usage() Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := Usage{Since: m.since, Tenants: make(map[string]TenantUsage)}
+	for k, v := range m.upstream {
+		tu, ok := u.Tenants[k[0]]
+		if !ok {
+			tu = TenantUsage{Upstream: make(map[string]map[string]uint64)}
+			u.Tenants[k[0]] = tu
+		}
+		if tu.Upstream[k[1]] == nil {
+			tu.Upstream[k[1]] = make(map[string]uint64)
+		}
+		tu.Upstream[k[1]][k[2]] = v
+	}
+	return u
+}
+
+// statusWriter records the status code of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// SetQueue reports the depth of the offline queue at path in the metrics.
+// The queue is read on every scrape as other processes add to it.
+func SetQueue(path string) OptionFunc {
+	return func(s *Server) error {
+		s.queuePath = path
+		return nil
+	}
+}
+
+// handleHealth reports the server is alive
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "ok\n")
+}
+
+// handleReady reports whether Infinity is reachable
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !s.metrics.reachable() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "Infinity is unreachable\n")
+		return
+	}
+	io.WriteString(w, "ok\n")
+}
+
+// handleMetrics writes the metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	var hits, misses uint64
+	var entries int
+	for _, c := range s.caches() {
+		h, m, e := c.stats()
+		hits, misses, entries = hits+h, misses+m, entries+e
+	}
+	pending := len(s.pending.list())
+	depth := -1
+	if s.queuePath != "" {
+		if q, err := infinigo.OpenQueue(s.queuePath); err == nil {
+			depth = q.Len()
+		} else {
+			s.errorf("Failed reading the queue - %v\n", err)
+		}
+	}
+	up := 0
+	if s.metrics.reachable() {
+		up = 1
+	}
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+	counters := func(name, help string, names []string, values map[labels]uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		keys := make([]labels, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := keys[i], keys[j]
+			return a[0] < b[0] || a[0] == b[0] && (a[1] < b[1] || a[1] == b[1] && a[2] < b[2])
+		})
+		for _, k := range keys {
+			pairs := make([]string, len(names))
+			for i, n := range names {
+				pairs[i] = fmt.Sprintf("%s=%q", n, k[i])
+			}
+			fmt.Fprintf(w, "%s{%s} %d\n", name, strings.Join(pairs, ","), values[k])
+		}
+	}
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	counters("infinigo_http_requests_total", "Requests handled by the server.", []string{"handler", "code"}, s.metrics.requests)
+	counters("infinigo_upstream_requests_total", "Requests sent to Infinity by result - ok, error or unreachable.", []string{"tenant", "op", "result"}, s.metrics.upstream)
+	metric("infinigo_upstream_up", "gauge", "Whether the last request to Infinity got a response.", up)
+	metric("infinigo_cache_hits_total", "counter", "Query responses served from the cache.", hits)
+	metric("infinigo_cache_misses_total", "counter", "Query responses not found in the cache.", misses)
+	metric("infinigo_cache_entries", "gauge", "Cached query responses.", entries)
+	metric("infinigo_uploads_pending", "gauge", "Uploads in progress and submitted files waiting for a verdict.", pending)
+	if depth >= 0 {
+		metric("infinigo_queue_depth", "gauge", "Requests waiting in the offline queue.", depth)
+	}
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// DefaultMilterTimeout is how long the milter waits for the verdicts of the attachments of a message
+const DefaultMilterTimeout = 5 * time.Second
+
+// MilterAction is what the milter does with a message
+type MilterAction int
+
+// Milter actions, from the mildest
+const (
+	MilterAccept MilterAction = iota
+	MilterQuarantine
+	MilterReject
+)
+
+var milterActionNames = map[MilterAction]string{MilterAccept: "accept", MilterQuarantine: "quarantine", MilterReject: "reject"}
+
+func (a MilterAction) String() string {
+	return milterActionNames[a]
+}
+
+// ParseMilterAction returns the action with the name, accept, quarantine or reject
+func ParseMilterAction(name string) (MilterAction, error) {
+	for a, n := range milterActionNames {
+		if n == name {
+			return a, nil
+		}
+	}
+	return MilterAccept, &infinigo.Error{ID: "bad_option", Details: "Unknown milter action [" + name + "], expected accept, quarantine or reject"}
+}
+
+// milter settings, set with SetMilterAction and SetMilterTimeout
+type milterConfig struct {
+	actions  map[infinigo.Verdict]MilterAction
+	timeout  time.Duration
+	fallback MilterAction
+}
+
+// Milter commands and responses
+const (
+	smficAbort   = 'A'
+	smficBody    = 'B'
+	smficConnect = 'C'
+	smficMacro   = 'D'
+	smficBodyEOB = 'E'
+	smficHelo    = 'H'
+	smficQuitNC  = 'K'
+	smficHeader  = 'L'
+	smficMail    = 'M'
+	smficEOH     = 'N'
+	smficOptNeg  = 'O'
+	smficQuit    = 'Q'
+	smficRcpt    = 'R'
+	smficData    = 'T'
+	smficUnknown = 'U'
+
+	smfirAccept     = 'a'
+	smfirContinue   = 'c'
+	smfirQuarantine = 'q'
+	smfirReplyCode  = 'y'
+
+	smfifAddHeaders = 0x01
+	smfifQuarantine = 0x20
+
+	// smfipSkip are the protocol steps the milter does not need: connect, helo, mail, rcpt, unknown and data
+	smfipSkip = 0x01 | 0x02 | 0x04 | 0x08 | 0x100 | 0x200
+)
+
+// SetMilterAction sets what the milter does with messages with an attachment with the verdict. By default
+// malicious messages are rejected, suspicious ones quarantined and others accepted.
+func SetMilterAction(verdict infinigo.Verdict, action MilterAction) OptionFunc {
+	return func(s *Server) error {
+		if _, ok := milterActionNames[action]; !ok {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Unknown milter action %d", action)}
+		}
+		s.milter.actions[verdict] = action
+		return nil
+	}
+}
+
+// SetMilterTimeout sets how long the milter waits for the verdicts of the attachments of a message, and what
+// it does with the message if they did not all arrive in time or failed. It is DefaultMilterTimeout and accept
+// by default, so mail keeps flowing when Infinity is slow or unreachable.
+func SetMilterTimeout(timeout time.Duration, fallback MilterAction) OptionFunc {
+	return func(s *Server) error {
+		if timeout <= 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Milter timeout must be positive"}
+		}
+		if _, ok := milterActionNames[fallback]; !ok {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Unknown milter action %d", fallback)}
+		}
+		s.milter.timeout, s.milter.fallback = timeout, fallback
+		return nil
+	}
+}
+
+// ServeMilter accepts connections of mail servers speaking the milter protocol, e.g. Postfix with
+// smtpd_milters or Sendmail with INPUT_MAIL_FILTER, on the listener until it is closed. The attachments of
+// every message are hashed and checked with Infinity through the cache, and the message is accepted,
+// quarantined or rejected by the most severe action of their verdicts.
+func (s *Server) ServeMilter(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serveMilterConn(conn)
+	}
+}
+
+// milterMessage is the message of a milter session being received
+type milterMessage struct {
+	header    textproto.MIMEHeader
+	body      bytes.Buffer
+	truncated bool
+}
+
+// serveMilterConn handles the messages of a milter connection
+func (s *Server) serveMilterConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	msg := &milterMessage{header: textproto.MIMEHeader{}}
+	for {
+		cmd, data, err := readMilterPacket(r)
+		if err != nil {
+			if err != io.EOF {
+				s.errorf("Bad milter packet from %s - %v\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		switch cmd {
+		case smficOptNeg:
+			if len(data) < 12 {
+				s.errorf("Bad milter negotiation from %s\n", conn.RemoteAddr())
+				return
+			}
+			reply := make([]byte, 12)
+			binary.BigEndian.PutUint32(reply, 6)
+			binary.BigEndian.PutUint32(reply[4:], binary.BigEndian.Uint32(data[4:])&(smfifQuarantine|smfifAddHeaders))
+			binary.BigEndian.PutUint32(reply[8:], binary.BigEndian.Uint32(data[8:])&smfipSkip)
+			writeMilterPacket(w, smficOptNeg, reply)
+		case smficMacro:
+			continue
+		case smficConnect, smficHelo, smficMail, smficRcpt, smficData, smficUnknown, smficEOH:
+			writeMilterPacket(w, smfirContinue, nil)
+		case smficHeader:
+			if name, value, ok := strings.Cut(strings.TrimSuffix(string(data), "\x00"), "\x00"); ok {
+				msg.header.Add(name, strings.TrimSpace(value))
+			}
+			writeMilterPacket(w, smfirContinue, nil)
+		case smficBody:
+			if s.maxUploadSize > 0 && int64(msg.body.Len()+len(data)) > s.maxUploadSize {
+				msg.truncated = true
+			} else {
+				msg.body.Write(data)
+			}
+			writeMilterPacket(w, smfirContinue, nil)
+		case smficBodyEOB:
+			action, reason := s.milterDecide(msg)
+			switch action {
+			case MilterReject:
+				writeMilterPacket(w, smfirReplyCode, []byte("554 5.7.1 "+reason+"\x00"))
+			case MilterQuarantine:
+				writeMilterPacket(w, smfirQuarantine, []byte(reason+"\x00"))
+				writeMilterPacket(w, smfirAccept, nil)
+			default:
+				writeMilterPacket(w, smfirAccept, nil)
+			}
+			msg = &milterMessage{header: textproto.MIMEHeader{}}
+		case smficAbort, smficQuitNC:
+			msg = &milterMessage{header: textproto.MIMEHeader{}}
+			continue
+		case smficQuit:
+			return
+		default:
+			// Unknown commands would not be sent if they were not negotiated, go on without a verdict
+			writeMilterPacket(w, smfirContinue, nil)
+		}
+		if err = w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// milterDecide checks the attachments of the message and returns what to do with it and why
+func (s *Server) milterDecide(msg *milterMessage) (MilterAction, string) {
+	if msg.truncated {
+		s.errorf("Message larger than %d bytes was not checked\n", s.maxUploadSize)
+		return s.milter.fallback, "Message could not be checked"
+	}
+	var hashes []string
+	err := hashAttachments(msg.header, &msg.body, func(hash string) {
+		hashes = append(hashes, hash)
+	})
+	if err != nil {
+		s.errorf("Failed parsing a message - %v\n", err)
+		return s.milter.fallback, "Message could not be checked"
+	}
+	if len(hashes) == 0 {
+		return MilterAccept, ""
+	}
+	type verdict struct {
+		hash string
+		resp infinigo.QueryResponse
+		err  error
+	}
+	results := make(chan verdict, len(hashes))
+	for _, hash := range hashes {
+		go func(hash string) {
+			// The lookup goes on after a timeout, so the verdict is cached for the next message
+			resp, err := s.lookup(context.Background(), "all", hash)
+			results <- verdict{hash, resp, err}
+		}(hash)
+	}
+	timeout := time.NewTimer(s.milter.timeout)
+	defer timeout.Stop()
+	action, reason := MilterAccept, ""
+	for range hashes {
+		select {
+		case v := <-results:
+			if v.err != nil {
+				s.errorf("Failed checking %s - %v\n", v.hash, v.err)
+				if s.milter.fallback > action {
+					action, reason = s.milter.fallback, "Attachment could not be checked"
+				}
+				continue
+			}
+			if a := s.milter.actions[v.resp.Verdict()]; a > action {
+				action, reason = a, fmt.Sprintf("Attachment %s is %s", v.hash, v.resp.Verdict())
+			}
+		case <-timeout.C:
+			s.errorf("Timed out checking the attachments of a message after %v\n", s.milter.timeout)
+			if s.milter.fallback > action {
+				return s.milter.fallback, "Attachments could not be checked in time"
+			}
+			return action, reason
+		}
+	}
+	return action, reason
+}
+
+// hashAttachments calls fn with the SHA-256 of every attachment of the entity with the header
+func hashAttachments(h textproto.MIMEHeader, body io.Reader, fn func(hash string)) error {
+	mediaType, params, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := r.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = hashAttachments(p.Header, p, fn); err != nil {
+				return err
+			}
+		}
+	}
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	if disposition != "attachment" && dparams["filename"] == "" && params["name"] == "" {
+		return nil
+	}
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	digest := sha256.New()
+	if _, err := io.Copy(digest, body); err != nil {
+		return err
+	}
+	fn(hex.EncodeToString(digest.Sum(nil)))
+	return nil
+}
+
+// readMilterPacket reads a packet, its length, command and data
+func readMilterPacket(r io.Reader) (byte, []byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return 0, nil, err
+	}
+	if size == 0 || size > 1<<20 {
+		return 0, nil, fmt.Errorf("bad packet length %d", size)
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+// writeMilterPacket writes a packet with the command and data
+func writeMilterPacket(w io.Writer, cmd byte, data []byte) {
+	binary.Write(w, binary.BigEndian, uint32(len(data)+1))
+	w.Write([]byte{cmd})
+	w.Write(data)
+}
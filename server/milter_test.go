@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// dialMilter serves the milter protocol on a new listener and returns a connection to it that
+// negotiated the protocol
+func dialMilter(t *testing.T, s *Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.ServeMilter(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	// Postfix offers every action and protocol step
+	offer := make([]byte, 12)
+	binary.BigEndian.PutUint32(offer, 6)
+	binary.BigEndian.PutUint32(offer[4:], 0x1ff)
+	binary.BigEndian.PutUint32(offer[8:], 0x1fffff)
+	writeMilterPacket(conn, smficOptNeg, offer)
+	r := bufio.NewReader(conn)
+	cmd, reply, err := readMilterPacket(r)
+	if err != nil || cmd != smficOptNeg || len(reply) != 12 {
+		t.Fatalf("Expected the negotiation reply, got %c %v - %v", cmd, reply, err)
+	}
+	if actions, steps := binary.BigEndian.Uint32(reply[4:]), binary.BigEndian.Uint32(reply[8:]); actions != smfifAddHeaders|smfifQuarantine || steps != smfipSkip {
+		t.Fatalf("Expected the quarantine action and the steps to skip, got %x and %x", actions, steps)
+	}
+	return conn, r
+}
+
+// sendMessage sends the header and body of a message and returns the replies to the end of the body
+func sendMessage(t *testing.T, conn net.Conn, r *bufio.Reader, header [][2]string, body string) []string {
+	t.Helper()
+	expectContinue := func() {
+		t.Helper()
+		if cmd, data, err := readMilterPacket(r); err != nil || cmd != smfirContinue {
+			t.Fatalf("Expected to continue, got %c %q - %v", cmd, data, err)
+		}
+	}
+	writeMilterPacket(conn, smficMacro, []byte("Ci\x00queue-id\x00"))
+	for _, h := range header {
+		writeMilterPacket(conn, smficHeader, []byte(h[0]+"\x00"+h[1]+"\x00"))
+		expectContinue()
+	}
+	writeMilterPacket(conn, smficEOH, nil)
+	expectContinue()
+	// The body is sent in chunks
+	for len(body) > 0 {
+		n := min(len(body), 64)
+		writeMilterPacket(conn, smficBody, []byte(body[:n]))
+		expectContinue()
+		body = body[n:]
+	}
+	writeMilterPacket(conn, smficBodyEOB, nil)
+	var replies []string
+	for {
+		cmd, data, err := readMilterPacket(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, string(cmd)+strings.TrimSuffix(string(data), "\x00"))
+		if cmd != smfirQuarantine {
+			return replies
+		}
+	}
+}
+
+// mail returns the header and body of a multipart message with a text part and the attachments, the
+// first one in base64 and the others in quoted-printable
+func mail(attachments ...string) ([][2]string, string) {
+	var b strings.Builder
+	b.WriteString("--b\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n")
+	for i, a := range attachments {
+		if i == 0 {
+			fmt.Fprintf(&b, "--b\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=\"%d.bin\"\r\nContent-Transfer-Encoding: base64\r\n\r\n%s\r\n", i, base64.StdEncoding.EncodeToString([]byte(a)))
+		} else {
+			fmt.Fprintf(&b, "--b\r\nContent-Type: application/pdf; name=\"%d.pdf\"\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n%s\r\n", i, strings.ReplaceAll(a, "=", "=3D"))
+		}
+	}
+	b.WriteString("--b--\r\n")
+	return [][2]string{{"Subject", "Invoice"}, {"Content-Type", `multipart/mixed; boundary="b"`}}, b.String()
+}
+
+func TestMilter(t *testing.T) {
+	const malware, suspicious, clean = "malicious attachment", "suspicious=attachment", "clean attachment"
+	tests := []struct {
+		name        string
+		attachments []string
+		replies     []string
+	}{
+		{"no attachment", nil, []string{"a"}},
+		{"clean", []string{clean}, []string{"a"}},
+		{"malicious", []string{malware}, []string{"y554 5.7.1 Attachment " + sha256Hex([]byte(malware)) + " is malicious"}},
+		{"suspicious", []string{clean, suspicious}, []string{"qAttachment " + sha256Hex([]byte(suspicious)) + " is suspicious", "a"}},
+		{"most severe", []string{clean, suspicious, malware}, []string{"y554 5.7.1 Attachment " + sha256Hex([]byte(malware)) + " is malicious"}},
+		{"unknown", []string{"unknown attachment"}, []string{"a"}},
+	}
+	s := newTestServer(t, map[string]float32{malware: -1, suspicious: -0.3, clean: 1})
+	// Messages share a connection
+	conn, r := dialMilter(t, s)
+	for _, test := range tests {
+		header, body := mail(test.attachments...)
+		if replies := sendMessage(t, conn, r, header, body); strings.Join(replies, "|") != strings.Join(test.replies, "|") {
+			t.Errorf("%s: expected %q, got %q", test.name, test.replies, replies)
+		}
+	}
+	// An aborted message is forgotten
+	writeMilterPacket(conn, smficHeader, []byte("Content-Type\x00multipart/mixed; boundary=\"b\"\x00"))
+	readMilterPacket(r)
+	writeMilterPacket(conn, smficAbort, nil)
+	if replies := sendMessage(t, conn, r, [][2]string{{"Subject", "Hello"}}, "Hello\r\n"); len(replies) != 1 || replies[0] != "a" {
+		t.Fatalf("Expected the message after the abort to be accepted, got %q", replies)
+	}
+}
+
+func TestMilterActions(t *testing.T) {
+	s := newTestServer(t, nil, SetMilterAction(infinigo.VerdictUnknown, MilterReject), SetMaxUploadSize(1024), SetMilterTimeout(time.Second, MilterQuarantine))
+	conn, r := dialMilter(t, s)
+	header, body := mail("unknown attachment")
+	if replies := sendMessage(t, conn, r, header, body); len(replies) != 1 || !strings.HasPrefix(replies[0], "y554 5.7.1 ") {
+		t.Fatalf("Expected unknown attachments to be rejected, got %q", replies)
+	}
+	header, body = mail(strings.Repeat("large attachment ", 100))
+	if replies := sendMessage(t, conn, r, header, body); len(replies) != 2 || replies[0] != "qMessage could not be checked" {
+		t.Fatalf("Expected a message too large to check to be quarantined, got %q", replies)
+	}
+	if _, err := ParseMilterAction("discard"); err == nil {
+		t.Fatal("Expected an unknown action to be refused")
+	}
+}
+
+func TestMilterTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	client, err := infinigo.New(infinigo.SetKey("key"), infinigo.SetURL(slow.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(client, SetMilterTimeout(50*time.Millisecond, MilterQuarantine))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, r := dialMilter(t, s)
+	header, body := mail("slow attachment")
+	if replies := sendMessage(t, conn, r, header, body); len(replies) != 2 || replies[0] != "qAttachments could not be checked in time" {
+		t.Fatalf("Expected the message to be quarantined once the verdicts are late, got %q", replies)
+	}
+	slow.CloseClientConnections()
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// AnonymousClient is the name usage is accounted to when clients do not authenticate
+const AnonymousClient = "anonymous"
+
+// Quota limits the daily use of Infinity. Zero values are unlimited. Days start at midnight UTC.
+type Quota struct {
+	Queries     int64 `json:"queries,omitempty"`      // Queries is the number of hashes sent to Infinity
+	Uploads     int64 `json:"uploads,omitempty"`      // Uploads is the number of files uploaded
+	UploadBytes int64 `json:"upload_bytes,omitempty"` // UploadBytes is the number of bytes uploaded
+}
+
+// DailyUsage is the use of Infinity on a day
+type DailyUsage struct {
+	Date        string `json:"date"`         // Date in UTC, e.g. 2016-01-02
+	Queries     int64  `json:"queries"`      // Queries is the number of hashes sent to Infinity
+	Cached      int64  `json:"cached"`       // Cached is the number of hashes answered from the cache
+	Uploads     int64  `json:"uploads"`      // Uploads is the number of files uploaded
+	UploadBytes int64  `json:"upload_bytes"` // UploadBytes is the number of bytes uploaded
+	Quota       Quota  `json:"quota"`        // Quota is the daily limit
+}
+
+// exceeds returns an error if adding the use would exceed the quota
+func (u *DailyUsage) exceeds(who string, add DailyUsage) error {
+	over := func(used, more, limit int64) bool { return limit > 0 && more > 0 && used+more > limit }
+	switch {
+	case over(u.Queries, add.Queries, u.Quota.Queries):
+		return &infinigo.Error{ID: "quota_exceeded", Details: fmt.Sprintf("%s used %d of %d daily queries", who, u.Queries, u.Quota.Queries)}
+	case over(u.Uploads, add.Uploads, u.Quota.Uploads):
+		return &infinigo.Error{ID: "quota_exceeded", Details: fmt.Sprintf("%s used %d of %d daily uploads", who, u.Uploads, u.Quota.Uploads)}
+	case over(u.UploadBytes, add.UploadBytes, u.Quota.UploadBytes):
+		return &infinigo.Error{ID: "quota_exceeded", Details: fmt.Sprintf("%s used %d of %d daily upload bytes", who, u.UploadBytes, u.Quota.UploadBytes)}
+	}
+	return nil
+}
+
+func (u *DailyUsage) add(more DailyUsage) {
+	u.Queries += more.Queries
+	u.Cached += more.Cached
+	u.Uploads += more.Uploads
+	u.UploadBytes += more.UploadBytes
+}
+
+// sub takes back use, not below zero as the use may have been charged the day before
+func (u *DailyUsage) sub(less DailyUsage) {
+	u.Queries = max(0, u.Queries-less.Queries)
+	u.Cached = max(0, u.Cached-less.Cached)
+	u.Uploads = max(0, u.Uploads-less.Uploads)
+	u.UploadBytes = max(0, u.UploadBytes-less.UploadBytes)
+}
+
+// accounting tracks the daily use of clients and tenants
+type accounting struct {
+	mu      sync.Mutex
+	clients map[string]*DailyUsage
+	tenants map[string]*DailyUsage
+}
+
+// today returns the usage of the name for the current day, resetting it on a new day
+func today(m map[string]*DailyUsage, name string, quota Quota) *DailyUsage {
+	date := time.Now().UTC().Format("2006-01-02")
+	u, ok := m[name]
+	if !ok || u.Date != date {
+		u = &DailyUsage{Date: date}
+		m[name] = u
+	}
+	u.Quota = quota
+	return u
+}
+
+// charge adds the use for the caller if neither the caller nor its tenant exceed their quota.
+// Use that already happened is added regardless with force.
+func (a *accounting) charge(c *caller, use DailyUsage, force bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.clients == nil {
+		a.clients, a.tenants = make(map[string]*DailyUsage), make(map[string]*DailyUsage)
+	}
+	cu := today(a.clients, c.name, c.quota)
+	tu := today(a.tenants, c.tenant.name, c.tenant.quota)
+	if !force {
+		if err := cu.exceeds("Client ["+c.name+"]", use); err != nil {
+			return err
+		}
+		if err := tu.exceeds("Tenant ["+c.tenant.name+"]", use); err != nil {
+			return err
+		}
+	}
+	cu.add(use)
+	tu.add(use)
+	return nil
+}
+
+// refund takes back use charged for the caller that did not happen
+func (a *accounting) refund(c *caller, use DailyUsage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.clients == nil {
+		return
+	}
+	today(a.clients, c.name, c.quota).sub(use)
+	today(a.tenants, c.tenant.name, c.tenant.quota).sub(use)
+}
+
+// usage returns copies of the daily usage of the clients and tenants
+func (a *accounting) usage() (clients, tenants map[string]DailyUsage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	clients, tenants = make(map[string]DailyUsage), make(map[string]DailyUsage)
+	for k, v := range a.clients {
+		clients[k] = *v
+	}
+	for k, v := range a.tenants {
+		tenants[k] = *v
+	}
+	return clients, tenants
+}
+
+// untilTomorrow returns the time until quotas reset
+func untilTomorrow() time.Duration {
+	now := time.Now().UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+// caller is the client making a request
+type caller struct {
+	name   string
+	quota  Quota
+	tenant *tenant
+}
+
+// newCaller returns the caller with the token of the tenant, the anonymous caller if there is no token
+func newCaller(tok *Token, t *tenant) *caller {
+	if tok == nil {
+		return &caller{name: AnonymousClient, tenant: t}
+	}
+	return &caller{name: tok.Name, quota: tok.Quota, tenant: t}
+}
+
+// callerKey is the context key of the caller
+type callerKey struct{}
+
+// callerOf returns the caller of the request, the anonymous caller of the default tenant if
+// clients do not authenticate
+func (s *Server) callerOf(ctx context.Context) *caller {
+	if c, ok := ctx.Value(callerKey{}).(*caller); ok {
+		return c
+	}
+	return newCaller(nil, s.def)
+}
+
+// charge accounts the use to the caller of the request, failing if it exceeds a quota
+func (s *Server) charge(ctx context.Context, use DailyUsage) error {
+	return s.accounting.charge(s.callerOf(ctx), use, false)
+}
+
+// refund takes back use charged to the caller of the request for a request that failed
+func (s *Server) refund(ctx context.Context, use DailyUsage) {
+	s.accounting.refund(s.callerOf(ctx), use)
+}
+
+// record accounts use that already happened to the caller of the request
+func (s *Server) record(ctx context.Context, use DailyUsage) {
+	s.accounting.charge(s.callerOf(ctx), use, true)
+}
+
+// callerOfSecret returns the caller with the client token, the anonymous caller if there is none
+func (s *Server) callerOfSecret(secret string) *caller {
+	if !s.tokens.enabled() {
+		return newCaller(nil, s.def)
+	}
+	tok, _ := s.tokens.find(secret)
+	t, err := s.tenantOfToken(tok)
+	if err != nil {
+		t = s.def
+	}
+	return newCaller(tok, t)
+}
+
+// Charge accounts the use of Infinity by the client with the token, failing with the quota_exceeded
+// ID if it would exceed the daily quota of the client or its tenant. It is used by the gRPC service.
+func (s *Server) Charge(secret string, queries, uploads, uploadBytes int64) error {
+	return s.accounting.charge(s.callerOfSecret(secret), DailyUsage{Queries: queries, Uploads: uploads, UploadBytes: uploadBytes}, false)
+}
+
+// Record accounts use of Infinity that already happened by the client with the token
+func (s *Server) Record(secret string, queries, uploads, uploadBytes int64) {
+	s.accounting.charge(s.callerOfSecret(secret), DailyUsage{Queries: queries, Uploads: uploads, UploadBytes: uploadBytes}, true)
+}
+
+// countingReader counts the bytes read
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,422 @@
+/*
+Package server implements an HTTP proxy in front of the Infinity API.
+
+The proxy holds the organization's API key so internal tools do not need their own credentials,
+caches query responses so repeated lookups do not consume quota, and rate limits the requests
+sent to Infinity.
+
+Endpoints:
+
+	GET /query?h=hash1,hash2[&c=classifiers]   Same response as the Infinity query API
+	PUT /upload/<confirmcode>[?h=hash]          Uploads the request body, optionally dropping the cached hash
+	POST /submit[?callback=url]                 Checks the multipart "file" field, uploading it if needed, and
+	                                            returns the verdict or posts it to the callback
+
+Health and monitoring endpoints:
+
+	GET /healthz    Always succeeds while the server is running
+	GET /readyz     Fails with 503 if the last request to Infinity did not get a response
+	GET /metrics    Request, cache, queue and error metrics in the Prometheus text format
+
+Clients authenticate with a bearer token when tokens are set with SetTokens or SetTokenFile.
+Tokens with the query scope can use /query, and tokens with the upload scope /upload and /submit.
+Tokens can belong to tenants set with SetTenants, so their requests use the tenant's API key and cache.
+Tokens and tenants can have daily quotas of queries, uploads and uploaded bytes. Requests exceeding them
+fail with 429 until midnight UTC.
+
+With SetAdminToken, operators can manage the running server under /admin/ - see handleAdmin.
+
+The server can also act as an ICAP (RFC 3507) antivirus service for web proxies and mail gateways with ServeICAP,
+and as a milter for Postfix and Sendmail with ServeMilter.
+*/
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+const (
+	DefaultCacheTTL        = time.Hour       // DefaultCacheTTL is how long query responses are cached
+	DefaultUnknownCacheTTL = 5 * time.Minute // DefaultUnknownCacheTTL is how long unknown verdicts are cached
+	DefaultMaxCacheEntries = 1000000         // DefaultMaxCacheEntries limits the cache size
+	DefaultMaxUploadSize   = 100 << 20       // DefaultMaxUploadSize is the largest accepted upload in bytes
+)
+
+// Server proxies requests to Infinity with caching and rate limiting
+type Server struct {
+	def           *tenant            // def is the tenant using the client passed to New
+	tenants       map[string]*tenant // tenants with their own API keys, by name
+	limiter       *limiter
+	maxUploadSize int64
+	errorlog      *log.Logger
+	mux           *http.ServeMux
+	block         map[infinigo.Verdict]bool // block are the verdicts ICAP blocks
+	milter        milterConfig              // milter decides what the milter does with messages
+	pollInterval  time.Duration             // pollInterval between queries for the verdict of submitted files
+	submitTimeout time.Duration             // submitTimeout bounds the wait for the verdict of submitted files
+	metrics       *metrics
+	queuePath     string // queuePath is the offline queue reported in the metrics
+	adminToken    string // adminToken enables the admin API
+	pending       pendingUploads
+	tokens        tokens
+	accounting    accounting    // accounting tracks the daily usage and quotas of clients and tenants
+	sink          pipeline.Sink // sink receives the responses fetched from Infinity, e.g. to record them in a database
+}
+
+// OptionFunc is a function that configures a Server.
+// It is used in New
+type OptionFunc func(*Server) error
+
+// New creates a server proxying to Infinity with the given client.
+// By default responses are cached for DefaultCacheTTL and requests are not rate limited.
+func New(client *infinigo.Client, options ...OptionFunc) (*Server, error) {
+	if client == nil {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "client is required"}
+	}
+	s := &Server{
+		def:           &tenant{name: DefaultTenant, client: client, cache: newCache(DefaultCacheTTL, DefaultUnknownCacheTTL, DefaultMaxCacheEntries)},
+		tenants:       make(map[string]*tenant),
+		limiter:       newLimiter(0, 0),
+		maxUploadSize: DefaultMaxUploadSize,
+		mux:           http.NewServeMux(),
+		block:         map[infinigo.Verdict]bool{infinigo.VerdictMalicious: true},
+		milter: milterConfig{
+			actions:  map[infinigo.Verdict]MilterAction{infinigo.VerdictMalicious: MilterReject, infinigo.VerdictSuspicious: MilterQuarantine},
+			timeout:  DefaultMilterTimeout,
+			fallback: MilterAccept,
+		},
+		pollInterval:  infinigo.DefaultPollInterval,
+		submitTimeout: DefaultSubmitTimeout,
+		metrics:       newMetrics(),
+	}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range s.tenants {
+		t.cache = newCache(s.def.cache.ttl, s.def.cache.unknownTTL, s.def.cache.maxEntries)
+	}
+	for i := range s.tokens.static {
+		if _, err := s.tenantOfToken(&s.tokens.static[i]); err != nil {
+			return nil, err
+		}
+	}
+	s.mux.HandleFunc("/query", s.authorize(ScopeQuery, s.handleQuery))
+	s.mux.HandleFunc("/upload/", s.authorize(ScopeUpload, s.handleUpload))
+	s.mux.HandleFunc("/submit", s.authorize(ScopeUpload, s.handleSubmit))
+	s.mux.HandleFunc("/healthz", s.handleHealth)
+	s.mux.HandleFunc("/readyz", s.handleReady)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
+	s.mux.HandleFunc("/admin/", s.handleAdmin)
+	return s, nil
+}
+
+// SetCache configures how long query responses are cached. Unknown verdicts use unknownTTL so they
+// are re-checked sooner. A zero ttl disables caching. maxEntries limits the number of cached responses.
+func SetCache(ttl, unknownTTL time.Duration, maxEntries int) OptionFunc {
+	return func(s *Server) error {
+		s.def.cache = newCache(ttl, unknownTTL, maxEntries)
+		return nil
+	}
+}
+
+// SetRateLimit limits the requests sent to Infinity to perSecond with bursts of up to burst requests.
+// Requests over the limit are rejected with 429 Too Many Requests. 0 disables the limit.
+func SetRateLimit(perSecond float64, burst int) OptionFunc {
+	return func(s *Server) error {
+		if perSecond < 0 || burst < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Rate limit cannot be negative"}
+		}
+		if burst == 0 {
+			burst = 1
+		}
+		s.limiter = newLimiter(perSecond, burst)
+		return nil
+	}
+}
+
+// SetMaxUploadSize limits the size of uploads accepted by the server
+func SetMaxUploadSize(size int64) OptionFunc {
+	return func(s *Server) error {
+		s.maxUploadSize = size
+		return nil
+	}
+}
+
+// SetErrorLog sets the logger for errors. It is nil by default.
+func SetErrorLog(logger *log.Logger) OptionFunc {
+	return func(s *Server) error {
+		s.errorlog = logger
+		return nil
+	}
+}
+
+// SetSink sends every response fetched from Infinity, but not the ones served from the cache, to the sink,
+// e.g. to record them in the results database the CLI uses. Sink errors are logged.
+func SetSink(sink pipeline.Sink) OptionFunc {
+	return func(s *Server) error {
+		s.sink = sink
+		return nil
+	}
+}
+
+// emit sends a response fetched from Infinity to the sink
+func (s *Server) emit(ctx context.Context, hash, path string, resp infinigo.QueryResponse) {
+	if s.sink == nil {
+		return
+	}
+	if err := s.sink.OnResult(ctx, pipeline.Result{Submission: pipeline.Submission{Hash: hash, Path: path}, Response: resp}); err != nil {
+		s.errorf("Failed recording the result of %s - %v\n", hash, err)
+	}
+}
+
+// errorf logs to the error log.
+func (s *Server) errorf(format string, args ...interface{}) {
+	if s.errorlog != nil {
+		s.errorlog.Printf(format, args...)
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	s.mux.ServeHTTP(sw, r)
+	_, handler := s.mux.Handler(r)
+	if handler == "" {
+		handler = "other"
+	}
+	s.metrics.request(handler, sw.status)
+}
+
+// writeJSON writes the value as a JSON response
+func (s *Server) writeJSON(w http.ResponseWriter, status int, val interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(val); err != nil {
+		s.errorf("Failed writing response - %v\n", err)
+	}
+}
+
+// writeError writes the error as JSON with a status matching its cause
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var e *infinigo.Error
+	var unavailable *infinigo.ServiceUnavailableError
+	switch {
+	case errors.As(err, &e) && (e.ID == "missing_arg" || e.ID == "bad_request" || e.ID == "invalid_confirm_code"):
+		status = http.StatusBadRequest
+	case errors.As(err, &e) && e.ID == "payload_too_large":
+		status = http.StatusRequestEntityTooLarge
+	case errors.As(err, &unavailable):
+		if unavailable.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(unavailable.RetryAfter/time.Second)+1))
+		}
+		status = http.StatusServiceUnavailable
+	case errors.As(err, &e) && e.ID == "unauthorized":
+		status = http.StatusUnauthorized
+	case errors.As(err, &e) && e.ID == "forbidden":
+		status = http.StatusForbidden
+	case errors.As(err, &e) && e.ID == "rate_limited":
+		status = http.StatusTooManyRequests
+	case errors.As(err, &e) && e.ID == "quota_exceeded":
+		w.Header().Set("Retry-After", strconv.Itoa(int(untilTomorrow()/time.Second)+1))
+		status = http.StatusTooManyRequests
+	case errors.As(err, &e), infinigo.Unreachable(err):
+		status = http.StatusBadGateway
+	}
+	if e == nil {
+		e = &infinigo.Error{ID: "internal_error", Details: err.Error()}
+	}
+	s.writeJSON(w, status, errorBody(err, e))
+}
+
+// errorBody returns the JSON of the error. The typed errors of infinigo unwrap to an *Error sentinel with
+// generic details, so the fields and message of the typed error are written along with its ID instead.
+func errorBody(err error, e *infinigo.Error) any {
+	for cur := err; cur != nil; cur = errors.Unwrap(cur) {
+		if _, ok := cur.(*infinigo.Error); ok {
+			break
+		}
+		if !unwrapsTo(cur, e) {
+			continue
+		}
+		body := make(map[string]any)
+		if data, merr := json.Marshal(cur); merr == nil {
+			json.Unmarshal(data, &body)
+		}
+		body["id"], body["details"] = e.ID, strings.TrimPrefix(cur.Error(), e.ID+": ")
+		return body
+	}
+	return e
+}
+
+// unwrapsTo returns true if err wraps target directly
+func unwrapsTo(err, target error) bool {
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		return u.Unwrap() == target
+	case interface{ Unwrap() []error }:
+		return slices.Contains(u.Unwrap(), target)
+	}
+	return false
+}
+
+// wait takes a rate limit token or sets Retry-After and returns an error
+func (s *Server) wait(w http.ResponseWriter) error {
+	if ok, retry := s.limiter.allow(); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry/time.Second)+1))
+		return &infinigo.Error{ID: "rate_limited", Details: "Too many requests to Infinity, retry later"}
+	}
+	return nil
+}
+
+// lookup returns the response for a single hash from the cache or Infinity, using the tenant of the
+// request's caller and charging the query to its quota if Infinity answered it
+func (s *Server) lookup(ctx context.Context, classifiers, hash string) (infinigo.QueryResponse, error) {
+	t := s.tenantOf(ctx)
+	if cached, ok := t.cache.get(classifiers, hash); ok {
+		s.record(ctx, DailyUsage{Cached: 1})
+		return cached, nil
+	}
+	if ok, _ := s.limiter.allow(); !ok {
+		return infinigo.QueryResponse{}, &infinigo.Error{ID: "rate_limited", Details: "Too many requests to Infinity, retry later"}
+	}
+	// Charged before the query so concurrent requests cannot exceed the quota, and refunded if it fails
+	use := DailyUsage{Queries: 1}
+	if err := s.charge(ctx, use); err != nil {
+		return infinigo.QueryResponse{}, err
+	}
+	resp, err := t.client.QueryContext(infinigo.WithLogFields(ctx, "tenant", t.name), classifiers, hash)
+	s.metrics.observe(t.name, "query", err)
+	if err != nil {
+		s.refund(ctx, use)
+		return infinigo.QueryResponse{}, err
+	}
+	for k, v := range resp {
+		if strings.EqualFold(k, hash) {
+			t.cache.put(classifiers, hash, v)
+			s.emit(ctx, hash, "", v)
+			return v, nil
+		}
+	}
+	return infinigo.QueryResponse{}, &infinigo.Error{ID: "bad_response", Details: "No response for " + hash}
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		s.writeJSON(w, http.StatusMethodNotAllowed, &infinigo.Error{ID: "bad_method", Details: "Use GET"})
+		return
+	}
+	classifiers := r.URL.Query().Get("c")
+	if classifiers == "" {
+		classifiers = "all"
+	}
+	var hashes []string
+	for _, h := range strings.Split(r.URL.Query().Get("h"), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hashes = append(hashes, h)
+		}
+	}
+	if len(hashes) == 0 {
+		s.writeError(w, &infinigo.Error{ID: "missing_arg", Details: "h is required"})
+		return
+	}
+	t := s.tenantOf(r.Context())
+	resp := make(map[string]infinigo.QueryResponse, len(hashes))
+	var missing []string
+	for _, h := range hashes {
+		if cached, ok := t.cache.get(classifiers, h); ok {
+			resp[h] = cached
+		} else {
+			missing = append(missing, h)
+		}
+	}
+	s.record(r.Context(), DailyUsage{Cached: int64(len(hashes) - len(missing))})
+	if len(missing) > 0 {
+		if err := s.wait(w); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		use := DailyUsage{Queries: int64(len(missing))}
+		if err := s.charge(r.Context(), use); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		fetched, err := t.client.QueryAllContext(infinigo.WithLogFields(r.Context(), "tenant", t.name), classifiers, missing...)
+		s.metrics.observe(t.name, "query", err)
+		if err != nil {
+			s.refund(r.Context(), use)
+			s.writeError(w, err)
+			return
+		}
+		for k, v := range fetched {
+			k = strings.ToLower(k)
+			resp[k] = v
+			t.cache.put(classifiers, k, v)
+			s.emit(r.Context(), k, "", v)
+		}
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "PUT, POST")
+		s.writeJSON(w, http.StatusMethodNotAllowed, &infinigo.Error{ID: "bad_method", Details: "Use PUT or POST"})
+		return
+	}
+	confirmCode := strings.TrimPrefix(r.URL.Path, "/upload/")
+	if confirmCode == "" {
+		s.writeError(w, &infinigo.Error{ID: "missing_arg", Details: "Confirmation code is required"})
+		return
+	}
+	if err := s.wait(w); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	// The size is charged upfront when known, otherwise once the body is read, and refunded if the upload fails
+	size := max(r.ContentLength, 0)
+	use := DailyUsage{Uploads: 1, UploadBytes: size}
+	if err := s.charge(r.Context(), use); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	t := s.tenantOf(r.Context())
+	defer s.pending.add(PendingUpload{ID: newID(), Kind: "upload", Tenant: t.name, SHA256: r.URL.Query().Get("h"), Size: r.ContentLength, ConfirmCode: confirmCode})()
+	body := &countingReader{r: http.MaxBytesReader(w, r.Body, s.maxUploadSize)}
+	resp, err := t.client.UploadContext(infinigo.WithLogFields(r.Context(), "tenant", t.name), confirmCode, body)
+	s.metrics.observe(t.name, "upload", err)
+	if err == nil && body.n > size {
+		// Already uploaded, so recorded even if it exceeds the quota
+		s.record(r.Context(), DailyUsage{UploadBytes: body.n - size})
+	}
+	if err != nil {
+		// Infinity may have received an unconfirmed upload, so it stays charged
+		if !errors.Is(err, infinigo.ErrUploadUnconfirmed) {
+			s.refund(r.Context(), use)
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.writeJSON(w, http.StatusRequestEntityTooLarge, &infinigo.Error{ID: "too_large", Details: err.Error()})
+			return
+		}
+		s.writeError(w, err)
+		return
+	}
+	if h := r.URL.Query().Get("h"); h != "" {
+		t.cache.remove(strings.ToLower(h))
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// DefaultSubmitTimeout bounds how long a submission waits for the verdict of an uploaded file
+const DefaultSubmitTimeout = 10 * time.Minute
+
+// Submission is the result of a file submitted to the /submit endpoint
+type Submission struct {
+	ID       string                  `json:"id"`                 // ID of the submission, sent with callbacks
+	Filename string                  `json:"filename"`           // Filename from the upload
+	Size     int64                   `json:"size"`               // Size of the file in bytes
+	SHA256   string                  `json:"sha256"`             // SHA256 of the file
+	Uploaded bool                    `json:"uploaded"`           // Uploaded is true if Infinity requested the file
+	Verdict  infinigo.Verdict        `json:"verdict,omitempty"`  // Verdict of the file, empty until it was checked
+	Response *infinigo.QueryResponse `json:"response,omitempty"` // Response from Infinity
+	Error    *infinigo.Error         `json:"error,omitempty"`    // Error if the file could not be checked
+	Callback string                  `json:"callback,omitempty"` // Callback the result is posted to
+}
+
+// SetSubmitWait sets how often submissions poll for the verdict of uploaded files and how long they wait
+func SetSubmitWait(interval, timeout time.Duration) OptionFunc {
+	return func(s *Server) error {
+		if timeout <= 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Submit timeout must be positive"}
+		}
+		s.pollInterval, s.submitTimeout = interval, timeout
+		return nil
+	}
+}
+
+// newID returns a random submission ID
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// handleSubmit accepts a multipart file upload in the "file" field, checks it with Infinity, uploading it
+// if requested, and waits for the verdict. With a callback URL parameter the request returns immediately
+// and the result is posted to the callback as JSON.
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		s.writeJSON(w, http.StatusMethodNotAllowed, &infinigo.Error{ID: "bad_method", Details: "Use POST"})
+		return
+	}
+	sub := &Submission{ID: newID(), Callback: r.URL.Query().Get("callback")}
+	if sub.Callback != "" {
+		if u, err := url.Parse(sub.Callback); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			s.writeError(w, &infinigo.Error{ID: "bad_request", Details: "callback must be an http or https URL"})
+			return
+		}
+	}
+	path, err := s.receive(w, r, sub)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.writeJSON(w, http.StatusRequestEntityTooLarge, &infinigo.Error{ID: "too_large", Details: err.Error()})
+			return
+		}
+		s.writeError(w, err)
+		return
+	}
+	if sub.Callback != "" {
+		s.writeJSON(w, http.StatusAccepted, sub)
+		ctx := context.WithoutCancel(r.Context())
+		go func() {
+			defer os.Remove(path)
+			s.check(ctx, sub, path)
+			s.callback(sub)
+		}()
+		return
+	}
+	defer os.Remove(path)
+	s.check(r.Context(), sub, path)
+	status := http.StatusOK
+	if sub.Error != nil && sub.Error.ID == infinigo.ErrTimeout.ID {
+		status = http.StatusGatewayTimeout
+	}
+	s.writeJSON(w, status, sub)
+}
+
+// receive stores the uploaded file in a temporary file while hashing it and returns its path
+func (s *Server) receive(w http.ResponseWriter, r *http.Request, sub *Submission) (string, error) {
+	// Leave room for the other form fields and the multipart framing
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUploadSize+1<<20)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return "", &infinigo.Error{ID: "bad_request", Details: err.Error()}
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return "", &infinigo.Error{ID: "missing_arg", Details: "file is required"}
+		}
+		if err != nil {
+			return "", &infinigo.Error{ID: "bad_request", Details: err.Error()}
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		f, err := os.CreateTemp("", "infinigo-submit-*")
+		if err != nil {
+			return "", err
+		}
+		digest := sha256.New()
+		sub.Filename = part.FileName()
+		sub.Size, err = io.Copy(io.MultiWriter(f, digest), io.LimitReader(part, s.maxUploadSize+1))
+		f.Close()
+		if err == nil && sub.Size > s.maxUploadSize {
+			err = &http.MaxBytesError{Limit: s.maxUploadSize}
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+		sub.SHA256 = hex.EncodeToString(digest.Sum(nil))
+		return f.Name(), nil
+	}
+}
+
+// check runs the query, upload and poll workflow for the submitted file
+func (s *Server) check(ctx context.Context, sub *Submission, path string) {
+	t := s.tenantOf(ctx)
+	defer s.pending.add(PendingUpload{ID: sub.ID, Kind: "submit", Tenant: t.name, Filename: sub.Filename, SHA256: sub.SHA256, Size: sub.Size})()
+	fail := func(err error) {
+		var e *infinigo.Error
+		if !errors.As(err, &e) {
+			e = &infinigo.Error{ID: "internal_error", Details: err.Error()}
+		}
+		sub.Error = e
+		sub.Verdict = infinigo.VerdictError
+		if errors.Is(err, infinigo.ErrTimeout) {
+			sub.Verdict = sub.Response.Verdict()
+		}
+	}
+	resp, err := s.lookup(ctx, "all", sub.SHA256)
+	sub.Response = &resp
+	if err != nil {
+		fail(err)
+		return
+	}
+	if resp.ConfirmCode != "" {
+		if ok, _ := s.limiter.allow(); !ok {
+			fail(&infinigo.Error{ID: "rate_limited", Details: "Too many requests to Infinity, retry later"})
+			return
+		}
+		use := DailyUsage{Uploads: 1, UploadBytes: sub.Size}
+		if err = s.charge(ctx, use); err != nil {
+			fail(err)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			s.refund(ctx, use)
+			fail(err)
+			return
+		}
+		defer f.Close()
+		sub.Uploaded = true
+		t.cache.remove(sub.SHA256)
+		resp, err = t.client.UploadAndWait(resp.ConfirmCode, f, s.pollInterval, s.submitTimeout)
+		if err == nil || errors.Is(err, infinigo.ErrTimeout) {
+			s.metrics.observe(t.name, "upload", nil)
+			sub.Response = &resp
+			t.cache.put("all", sub.SHA256, resp)
+			s.emit(ctx, sub.SHA256, sub.Filename, resp)
+		}
+		if err != nil {
+			// Timing out waiting for the verdict, the upload itself succeeded
+			if !errors.Is(err, infinigo.ErrTimeout) {
+				s.metrics.observe(t.name, "upload", err)
+			}
+			if !errors.Is(err, infinigo.ErrTimeout) && !errors.Is(err, infinigo.ErrUploadUnconfirmed) {
+				s.refund(ctx, use)
+			}
+			fail(err)
+			return
+		}
+	}
+	sub.Verdict = sub.Response.Verdict()
+}
+
+// callback posts the submission result to its callback URL
+func (s *Server) callback(sub *Submission) {
+	b, err := json.Marshal(sub)
+	if err != nil {
+		s.errorf("Failed encoding submission %s - %v\n", sub.ID, err)
+		return
+	}
+	c := &http.Client{Timeout: 30 * time.Second}
+	resp, err := c.Post(sub.Callback, "application/json", bytes.NewReader(b))
+	if err != nil {
+		s.errorf("Failed calling back %s for submission %s - %v\n", sub.Callback, sub.ID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		s.errorf("Callback %s for submission %s returned %s\n", sub.Callback, sub.ID, resp.Status)
+	}
+}
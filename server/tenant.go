@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/demisto/infinigo"
+)
+
+// DefaultTenant is the name of the tenant using the server's own client, for tokens without a tenant
+const DefaultTenant = "default"
+
+// Tenant is a licensed Infinity tenant with its own API key
+type Tenant struct {
+	Name  string `json:"name"`  // Name of the tenant, referenced by client tokens
+	Key   string `json:"key"`   // Key is the Infinity API key of the tenant
+	Quota Quota  `json:"quota"` // Quota is the daily limit of all the clients of the tenant
+}
+
+// tenant is the client, cache and quota of a tenant
+type tenant struct {
+	name   string
+	client *infinigo.Client
+	cache  *cache
+	quota  Quota
+}
+
+// LoadTenants reads tenants from a JSON file with a list of tenants, e.g.
+//
+//	[{"name": "emea", "key": "...", "quota": {"queries": 100000, "uploads": 500}}]
+func LoadTenants(path string) ([]Tenant, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []Tenant
+	if err = json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// SetTenants adds tenants with their own API keys. Client tokens are mapped to a tenant with their
+// Tenant field, and each tenant has its own cache and usage accounting.
+func SetTenants(list ...Tenant) OptionFunc {
+	return func(s *Server) error {
+		for _, t := range list {
+			if t.Name == "" || t.Name == DefaultTenant {
+				return &infinigo.Error{ID: "bad_option", Details: "Tenant name [" + t.Name + "] is reserved"}
+			}
+			if _, ok := s.tenants[t.Name]; ok {
+				return &infinigo.Error{ID: "bad_option", Details: "Tenant [" + t.Name + "] is defined twice"}
+			}
+			c, err := s.def.client.WithKey(t.Key)
+			if err != nil {
+				return &infinigo.Error{ID: "bad_option", Details: "Tenant [" + t.Name + "] has no key"}
+			}
+			// The cache is created once all the options are applied so it uses the cache settings
+			s.tenants[t.Name] = &tenant{name: t.Name, client: c, quota: t.Quota}
+		}
+		return nil
+	}
+}
+
+// tenantOf returns the tenant of the client authenticated for the request
+func (s *Server) tenantOf(ctx context.Context) *tenant {
+	return s.callerOf(ctx).tenant
+}
+
+// tenantOfToken returns the tenant of the token, the default tenant if it has none
+func (s *Server) tenantOfToken(tok *Token) (*tenant, error) {
+	if tok == nil || tok.Tenant == "" || tok.Tenant == DefaultTenant {
+		return s.def, nil
+	}
+	t, ok := s.tenants[tok.Tenant]
+	if !ok {
+		return nil, &infinigo.Error{ID: "forbidden", Details: "Client [" + tok.Name + "] belongs to unknown tenant [" + tok.Tenant + "]"}
+	}
+	return t, nil
+}
+
+// AuthorizeClient is like Authorize but also returns the Infinity client of the token's tenant
+func (s *Server) AuthorizeClient(secret, scope string) (*infinigo.Client, error) {
+	tok, err := s.Authorize(secret, scope)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.tenantOfToken(tok)
+	if err != nil {
+		return nil, err
+	}
+	return t.client, nil
+}
+
+// caches returns the caches of all the tenants
+func (s *Server) caches() []*cache {
+	all := []*cache{s.def.cache}
+	for _, t := range s.tenants {
+		all = append(all, t.cache)
+	}
+	return all
+}
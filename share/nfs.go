@@ -0,0 +1,654 @@
+package share
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// ONC RPC programs and procedures of NFSv3 (RFC 1813) and the port mapper (RFC 1833)
+const (
+	progPortmap      = 100000
+	progNFS          = 100003
+	progMount        = 100005
+	procGetPort      = 3
+	procMount        = 1
+	procUnmount      = 3
+	procGetAttr      = 1
+	procLookup       = 3
+	procRead         = 6
+	procReadDirPlus  = 17
+	defaultNFSPort   = 2049
+	nfsReadSize      = 64 << 10
+	nfsDirCount      = 8 << 10
+	nfsDirMaxCount   = 64 << 10
+	maxRPCRecordSize = 1 << 20
+)
+
+// portmapPort is the port of the port mapper, a variable so tests can run their own
+var portmapPort = 111
+
+// NFS file types
+const (
+	nfsRegular   = 1
+	nfsDirectory = 2
+	nfsSymlink   = 5
+)
+
+// rpcClient calls the procedures of an ONC RPC program over TCP
+type rpcClient struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	r       *bufio.Reader
+	prog    uint32
+	vers    uint32
+	xid     uint32
+	cred    []byte
+	timeout time.Duration
+}
+
+// dialRPC connects to the program at the address, from a privileged port when running as root
+func dialRPC(ctx context.Context, addr string, prog, vers uint32, cfg *config) (*rpcClient, error) {
+	var conn net.Conn
+	var err error
+	if os.Geteuid() == 0 {
+		// Servers trust requests from privileged ports to come from the kernel of a trusted host
+		for port := 1023; port >= 600 && conn == nil; port-- {
+			dialer := &net.Dialer{Timeout: cfg.timeout, LocalAddr: &net.TCPAddr{Port: port}}
+			conn, err = dialer.DialContext(ctx, "tcp", addr)
+			if err != nil && !strings.Contains(err.Error(), "address already in use") {
+				return nil, err
+			}
+		}
+	}
+	if conn == nil {
+		dialer := &net.Dialer{Timeout: cfg.timeout}
+		if conn, err = dialer.DialContext(ctx, "tcp", addr); err != nil {
+			return nil, err
+		}
+	}
+	c := &rpcClient{conn: conn, r: bufio.NewReader(conn), prog: prog, vers: vers, xid: rand.Uint32(), timeout: cfg.timeout}
+	// AUTH_SYS: stamp, machine name, uid, gid and no supplementary groups
+	var cred xdrWriter
+	cred.uint32(uint32(time.Now().Unix()))
+	cred.string(cfg.machine)
+	cred.uint32(cfg.uid)
+	cred.uint32(cfg.gid)
+	cred.uint32(0)
+	c.cred = cred.b
+	return c, nil
+}
+
+// call calls the procedure with the arguments and returns the reader of its results
+func (c *rpcClient) call(proc uint32, args []byte) (*xdrReader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.xid++
+	var w xdrWriter
+	w.uint32(0) // record mark, set below
+	w.uint32(c.xid)
+	w.uint32(0) // CALL
+	w.uint32(2) // RPC version
+	w.uint32(c.prog)
+	w.uint32(c.vers)
+	w.uint32(proc)
+	w.uint32(1) // AUTH_SYS
+	w.opaque(c.cred)
+	w.uint32(0) // AUTH_NONE verifier
+	w.uint32(0)
+	w.b = append(w.b, args...)
+	binary.BigEndian.PutUint32(w.b, 0x80000000|uint32(len(w.b)-4))
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(w.b); err != nil {
+		return nil, err
+	}
+	for {
+		reply, err := c.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		r := &xdrReader{b: reply}
+		if xid := r.uint32(); xid != c.xid {
+			// A late reply to an abandoned call
+			continue
+		}
+		if r.uint32() != 1 {
+			return nil, &infinigo.Error{ID: "bad_response", Details: "RPC reply expected"}
+		}
+		if stat := r.uint32(); stat != 0 {
+			return nil, &infinigo.Error{ID: "rpc_error", Details: fmt.Sprintf("RPC call of program %d was denied (%d), the server may require a privileged port", c.prog, r.uint32())}
+		}
+		r.uint32() // verifier flavor
+		r.opaque()
+		if stat := r.uint32(); stat != 0 {
+			return nil, &infinigo.Error{ID: "rpc_error", Details: fmt.Sprintf("RPC call %d of program %d failed (%d)", proc, c.prog, stat)}
+		}
+		return r, r.err
+	}
+}
+
+// readRecord reads the fragments of a record
+func (c *rpcClient) readRecord() ([]byte, error) {
+	var record []byte
+	for {
+		var mark [4]byte
+		if _, err := io.ReadFull(c.r, mark[:]); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(mark[:])
+		size := int(n & 0x7fffffff)
+		if len(record)+size > maxRPCRecordSize {
+			return nil, &infinigo.Error{ID: "too_large", Details: "RPC record is too large"}
+		}
+		fragment := make([]byte, size)
+		if _, err := io.ReadFull(c.r, fragment); err != nil {
+			return nil, err
+		}
+		record = append(record, fragment...)
+		if n&0x80000000 != 0 {
+			return record, nil
+		}
+	}
+}
+
+func (c *rpcClient) close() error {
+	return c.conn.Close()
+}
+
+// getPort asks the port mapper of the host for the TCP port of the program
+func getPort(ctx context.Context, host string, prog, vers uint32, cfg *config) (int, error) {
+	pm, err := dialRPC(ctx, net.JoinHostPort(host, strconv.Itoa(portmapPort)), progPortmap, 2, cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer pm.close()
+	var args xdrWriter
+	args.uint32(prog)
+	args.uint32(vers)
+	args.uint32(6) // TCP
+	args.uint32(0)
+	r, err := pm.call(procGetPort, args.b)
+	if err != nil {
+		return 0, err
+	}
+	port := r.uint32()
+	if r.err != nil || port == 0 {
+		return 0, &infinigo.Error{ID: "not_found", Details: fmt.Sprintf("%s does not serve RPC program %d over TCP", host, prog)}
+	}
+	return int(port), nil
+}
+
+// nfsError is the error of an NFS status
+func nfsError(op, name string, status uint32) error {
+	var err error
+	switch status {
+	case 2: // NFS3ERR_NOENT
+		err = fs.ErrNotExist
+	case 1, 13: // NFS3ERR_PERM, NFS3ERR_ACCES
+		err = fs.ErrPermission
+	default:
+		err = fmt.Errorf("NFS error %d", status)
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// openNFS mounts the NFS export of the location, finding the export among the parents of its path
+func openNFS(ctx context.Context, loc Location, cfg *config) (*Share, error) {
+	host, nfsPort := loc.Host, 0
+	if h, p, err := net.SplitHostPort(loc.Host); err == nil {
+		host = h
+		nfsPort, _ = strconv.Atoi(p)
+	}
+	mountPort, err := getPort(ctx, host, progMount, 3, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if nfsPort == 0 {
+		if nfsPort, err = getPort(ctx, host, progNFS, 3, cfg); err != nil {
+			nfsPort = defaultNFSPort
+		}
+	}
+	mnt, err := dialRPC(ctx, net.JoinHostPort(host, strconv.Itoa(mountPort)), progMount, 3, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer mnt.close()
+	export, rest := loc.Path, ""
+	var root []byte
+	for {
+		var args xdrWriter
+		args.string(export)
+		r, err := mnt.call(procMount, args.b)
+		if err != nil {
+			return nil, err
+		}
+		status := r.uint32()
+		if status == 0 {
+			root = r.opaque()
+			if r.err != nil {
+				return nil, r.err
+			}
+			break
+		}
+		if export == "/" || (status != 2 && status != 13 && status != 20) {
+			// Not a directory, not exported or denied
+			return nil, nfsError("mount", host+":"+loc.Path, status)
+		}
+		rest = path.Join(path.Base(export), rest)
+		export = path.Dir(export)
+	}
+	c, err := dialRPC(ctx, net.JoinHostPort(host, strconv.Itoa(nfsPort)), progNFS, 3, cfg)
+	if err != nil {
+		return nil, err
+	}
+	fsys := &nfsFS{c: c, handles: map[string][]byte{".": root}}
+	if rest != "" {
+		h, _, err := fsys.lookup(rest)
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+		fsys.handles = map[string][]byte{".": h}
+	}
+	return &Share{
+		FS:       fsys,
+		Location: loc,
+		close: func() error {
+			if mnt, err := dialRPC(context.Background(), net.JoinHostPort(host, strconv.Itoa(mountPort)), progMount, 3, cfg); err == nil {
+				var args xdrWriter
+				args.string(export)
+				mnt.call(procUnmount, args.b)
+				mnt.close()
+			}
+			return c.close()
+		},
+	}, nil
+}
+
+// nfsFS is the file system of an NFS directory
+type nfsFS struct {
+	c       *rpcClient
+	mu      sync.Mutex
+	handles map[string][]byte // handles of the paths found so far
+}
+
+// lookup returns the handle and attributes of the path, looking up the parents not found yet
+func (f *nfsFS) lookup(name string) ([]byte, *nfsAttr, error) {
+	f.mu.Lock()
+	h, ok := f.handles[name]
+	f.mu.Unlock()
+	if ok {
+		attr, err := f.getAttr(name, h)
+		return h, attr, err
+	}
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" {
+		dir = "."
+	}
+	dh, dattr, err := f.lookup(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dattr.typ != nfsDirectory {
+		return nil, nil, &fs.PathError{Op: "open", Path: name, Err: fmt.Errorf("not a directory")}
+	}
+	var args xdrWriter
+	args.opaque(dh)
+	args.string(base)
+	r, err := f.c.call(procLookup, args.b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if status := r.uint32(); status != 0 {
+		return nil, nil, nfsError("open", name, status)
+	}
+	h = r.opaque()
+	attr := r.postOpAttr()
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	if attr == nil {
+		if attr, err = f.getAttr(name, h); err != nil {
+			return nil, nil, err
+		}
+	}
+	f.mu.Lock()
+	f.handles[name] = h
+	f.mu.Unlock()
+	return h, attr, nil
+}
+
+// getAttr gets the attributes of the handle
+func (f *nfsFS) getAttr(name string, h []byte) (*nfsAttr, error) {
+	var args xdrWriter
+	args.opaque(h)
+	r, err := f.c.call(procGetAttr, args.b)
+	if err != nil {
+		return nil, err
+	}
+	if status := r.uint32(); status != 0 {
+		return nil, nfsError("stat", name, status)
+	}
+	attr := r.attr()
+	return attr, r.err
+}
+
+// Open opens the file or directory with the name
+func (f *nfsFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	h, attr, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return &nfsFile{fs: f, name: name, h: h, attr: attr}, nil
+}
+
+// ReadDir reads the entries of the directory with the name, sorted by name
+func (f *nfsFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	h, _, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	var entries []fs.DirEntry
+	var cookie uint64
+	var verifier []byte
+	for {
+		var args xdrWriter
+		args.opaque(h)
+		args.uint64(cookie)
+		args.fixed(verifier, 8)
+		args.uint32(nfsDirCount)
+		args.uint32(nfsDirMaxCount)
+		r, err := f.c.call(procReadDirPlus, args.b)
+		if err != nil {
+			return nil, err
+		}
+		if status := r.uint32(); status != 0 {
+			return nil, nfsError("readdir", name, status)
+		}
+		r.postOpAttr()
+		verifier = r.bytes(8)
+		for r.bool() {
+			r.uint64() // file id
+			entryName := r.string()
+			cookie = r.uint64()
+			attr := r.postOpAttr()
+			var eh []byte
+			if r.bool() {
+				eh = r.opaque()
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+			if entryName == "." || entryName == ".." {
+				continue
+			}
+			p := path.Join(name, entryName)
+			if eh != nil {
+				f.mu.Lock()
+				f.handles[p] = eh
+				f.mu.Unlock()
+			}
+			if attr == nil {
+				if _, attr, err = f.lookup(p); err != nil {
+					return nil, err
+				}
+			}
+			entries = append(entries, fs.FileInfoToDirEntry(&nfsInfo{name: entryName, attr: attr}))
+		}
+		eof := r.bool()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if eof {
+			break
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// nfsFile is an open file or directory
+type nfsFile struct {
+	fs     *nfsFS
+	name   string
+	h      []byte
+	attr   *nfsAttr
+	offset uint64
+	eof    bool
+	dir    []fs.DirEntry // entries left to read of a directory
+	listed bool
+}
+
+func (f *nfsFile) Stat() (fs.FileInfo, error) {
+	return &nfsInfo{name: path.Base(f.name), attr: f.attr}, nil
+}
+
+func (f *nfsFile) Read(p []byte) (int, error) {
+	if f.attr.typ != nfsRegular {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fmt.Errorf("not a regular file")}
+	}
+	if f.eof {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	count := len(p)
+	if count > nfsReadSize {
+		count = nfsReadSize
+	}
+	var args xdrWriter
+	args.opaque(f.h)
+	args.uint64(f.offset)
+	args.uint32(uint32(count))
+	r, err := f.fs.c.call(procRead, args.b)
+	if err != nil {
+		return 0, err
+	}
+	if status := r.uint32(); status != 0 {
+		return 0, nfsError("read", f.name, status)
+	}
+	r.postOpAttr()
+	r.uint32() // count
+	f.eof = r.bool()
+	data := r.opaque()
+	if r.err != nil {
+		return 0, r.err
+	}
+	n := copy(p, data)
+	f.offset += uint64(n)
+	if n == 0 && !f.eof {
+		return 0, io.ErrNoProgress
+	}
+	return n, nil
+}
+
+func (f *nfsFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.listed {
+		entries, err := f.fs.ReadDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.dir, f.listed = entries, true
+	}
+	if n <= 0 {
+		entries := f.dir
+		f.dir = nil
+		return entries, nil
+	}
+	if len(f.dir) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(f.dir))
+	entries := f.dir[:n]
+	f.dir = f.dir[n:]
+	return entries, nil
+}
+
+func (f *nfsFile) Close() error {
+	return nil
+}
+
+// nfsAttr are the attributes of a file
+type nfsAttr struct {
+	typ   uint32
+	mode  uint32
+	size  uint64
+	mtime time.Time
+}
+
+// nfsInfo describes a file
+type nfsInfo struct {
+	name string
+	attr *nfsAttr
+}
+
+func (i *nfsInfo) Name() string       { return i.name }
+func (i *nfsInfo) Size() int64        { return int64(i.attr.size) }
+func (i *nfsInfo) ModTime() time.Time { return i.attr.mtime }
+func (i *nfsInfo) IsDir() bool        { return i.attr.typ == nfsDirectory }
+func (i *nfsInfo) Sys() interface{}   { return nil }
+
+func (i *nfsInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(i.attr.mode & 0777)
+	switch i.attr.typ {
+	case nfsRegular:
+	case nfsDirectory:
+		mode |= fs.ModeDir
+	case nfsSymlink:
+		mode |= fs.ModeSymlink
+	default:
+		mode |= fs.ModeIrregular
+	}
+	return mode
+}
+
+// xdrWriter encodes XDR (RFC 4506)
+type xdrWriter struct {
+	b []byte
+}
+
+func (w *xdrWriter) uint32(v uint32) {
+	w.b = binary.BigEndian.AppendUint32(w.b, v)
+}
+
+func (w *xdrWriter) uint64(v uint64) {
+	w.b = binary.BigEndian.AppendUint64(w.b, v)
+}
+
+func (w *xdrWriter) opaque(v []byte) {
+	w.uint32(uint32(len(v)))
+	w.fixed(v, len(v))
+}
+
+func (w *xdrWriter) string(v string) {
+	w.opaque([]byte(v))
+}
+
+// fixed writes fixed length opaque data, padding v with zeroes to n bytes
+func (w *xdrWriter) fixed(v []byte, n int) {
+	w.b = append(w.b, v...)
+	w.b = append(w.b, make([]byte, n-len(v)+(4-n%4)%4)...)
+}
+
+// xdrReader decodes XDR, recording the first error
+type xdrReader struct {
+	b   []byte
+	err error
+}
+
+// bytes returns the next n bytes and skips their padding
+func (r *xdrReader) bytes(n int) []byte {
+	padded := n + (4-n%4)%4
+	if r.err != nil || n < 0 || padded > len(r.b) {
+		if r.err == nil {
+			r.err = &infinigo.Error{ID: "bad_response", Details: "Truncated RPC reply"}
+		}
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[padded:]
+	return v
+}
+
+func (r *xdrReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *xdrReader) uint64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *xdrReader) bool() bool {
+	return r.uint32() != 0
+}
+
+func (r *xdrReader) opaque() []byte {
+	n := r.uint32()
+	if n > uint32(len(r.b)) {
+		if r.err == nil {
+			r.err = &infinigo.Error{ID: "bad_response", Details: "Truncated RPC reply"}
+		}
+		return nil
+	}
+	return r.bytes(int(n))
+}
+
+func (r *xdrReader) string() string {
+	return string(r.opaque())
+}
+
+// attr reads fattr3
+func (r *xdrReader) attr() *nfsAttr {
+	a := &nfsAttr{typ: r.uint32(), mode: r.uint32()}
+	r.uint32() // nlink
+	r.uint32() // uid
+	r.uint32() // gid
+	a.size = r.uint64()
+	r.uint64() // used
+	r.uint64() // rdev
+	r.uint64() // fsid
+	r.uint64() // fileid
+	r.uint64() // atime
+	sec, nsec := r.uint32(), r.uint32()
+	a.mtime = time.Unix(int64(sec), int64(nsec))
+	r.uint64() // ctime
+	return a
+}
+
+// postOpAttr reads post_op_attr, returning nil if there are no attributes
+func (r *xdrReader) postOpAttr() *nfsAttr {
+	if !r.bool() {
+		return nil
+	}
+	return r.attr()
+}
@@ -0,0 +1,358 @@
+package share
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// nfsServer is a fake port mapper, mount and NFSv3 server over ONC RPC on a single TCP port, serving
+// the files under its export. File handles are the paths of the files.
+type nfsServer struct {
+	t      *testing.T
+	l      net.Listener
+	export string
+	files  fstest.MapFS
+	deny   bool // deny answers every call with MSG_DENIED, as servers do to unprivileged ports
+	mu     sync.Mutex
+	creds  []string // creds are the machine, uid and gid of the AUTH_SYS credentials of the calls
+	mounts []string // mounts are the paths mounted, or unmounted with a - prefix
+}
+
+func newNFSServer(t *testing.T, export string, files fstest.MapFS) *nfsServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &nfsServer{t: t, l: l, export: export, files: files}
+	old := portmapPort
+	portmapPort = l.Addr().(*net.TCPAddr).Port
+	t.Cleanup(func() {
+		portmapPort = old
+		l.Close()
+	})
+	go s.serve()
+	return s
+}
+
+func (s *nfsServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle answers the calls of the connection, splitting replies in two fragments to exercise reassembly
+func (s *nfsServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var mark [4]byte
+		if _, err := io.ReadFull(conn, mark[:]); err != nil {
+			return
+		}
+		call := make([]byte, binary.BigEndian.Uint32(mark[:])&0x7fffffff)
+		if _, err := io.ReadFull(conn, call); err != nil {
+			return
+		}
+		reply := s.reply(&xdrReader{b: call})
+		half := len(reply) / 2
+		var w xdrWriter
+		w.uint32(uint32(half))
+		w.b = append(w.b, reply[:half]...)
+		w.uint32(0x80000000 | uint32(len(reply)-half))
+		w.b = append(w.b, reply[half:]...)
+		if _, err := conn.Write(w.b); err != nil {
+			return
+		}
+	}
+}
+
+// reply returns the reply to the call
+func (s *nfsServer) reply(r *xdrReader) []byte {
+	xid := r.uint32()
+	r.uint32() // CALL
+	r.uint32() // RPC version
+	prog, _, proc := r.uint32(), r.uint32(), r.uint32()
+	flavor, cred := r.uint32(), xdrReader{b: r.opaque()}
+	if flavor == 1 {
+		cred.uint32() // stamp
+		machine := cred.string()
+		uid, gid := cred.uint32(), cred.uint32()
+		s.mu.Lock()
+		s.creds = append(s.creds, fmt.Sprintf("%s/%d/%d", machine, uid, gid))
+		s.mu.Unlock()
+	}
+	r.uint32() // verifier
+	r.opaque()
+	var w xdrWriter
+	w.uint32(xid)
+	w.uint32(1) // REPLY
+	if s.deny {
+		w.uint32(1) // MSG_DENIED
+		w.uint32(1) // AUTH_ERROR
+		w.uint32(1) // AUTH_BADCRED
+		return w.b
+	}
+	w.uint32(0) // MSG_ACCEPTED
+	w.uint32(0) // AUTH_NONE verifier
+	w.uint32(0)
+	w.uint32(0) // SUCCESS
+	switch prog {
+	case progPortmap:
+		w.uint32(uint32(s.l.Addr().(*net.TCPAddr).Port))
+	case progMount:
+		s.mount(proc, r, &w)
+	case progNFS:
+		s.nfs(proc, r, &w)
+	default:
+		s.t.Errorf("Unexpected RPC program %d", prog)
+	}
+	if r.err != nil {
+		s.t.Errorf("Bad arguments of procedure %d of program %d - %v", proc, prog, r.err)
+	}
+	return w.b
+}
+
+func (s *nfsServer) mount(proc uint32, r *xdrReader, w *xdrWriter) {
+	dir := r.string()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case proc == procUnmount:
+		s.mounts = append(s.mounts, "-"+dir)
+	case dir != s.export:
+		w.uint32(2) // MNT3ERR_NOENT
+	default:
+		s.mounts = append(s.mounts, dir)
+		w.uint32(0)
+		w.string(".")
+		w.uint32(1) // auth flavors
+		w.uint32(1)
+	}
+}
+
+func (s *nfsServer) nfs(proc uint32, r *xdrReader, w *xdrWriter) {
+	name := r.string()
+	switch proc {
+	case procGetAttr:
+		if s.status(name, w) {
+			s.attr(name, w)
+		}
+	case procLookup:
+		name = path.Join(name, r.string())
+		if s.status(name, w) {
+			w.string(name)
+			w.uint32(1)
+			s.attr(name, w)
+			w.uint32(0) // no directory attributes
+		}
+	case procRead:
+		offset, count := r.uint64(), r.uint32()
+		if !s.status(name, w) {
+			return
+		}
+		data := s.files[name].Data[min(offset, uint64(len(s.files[name].Data))):]
+		data = data[:min(len(data), int(count))]
+		w.uint32(0) // no attributes
+		w.uint32(uint32(len(data)))
+		w.uint32(b2u(offset+uint64(len(data)) == uint64(len(s.files[name].Data))))
+		w.opaque(data)
+	case procReadDirPlus:
+		// Two entries a call, to exercise the cookies continuing the listing
+		cookie := r.uint64()
+		r.bytes(8) // verifier
+		r.uint32() // dircount
+		r.uint32() // maxcount
+		if !s.status(name, w) {
+			return
+		}
+		entries := append([]string{".", ".."}, s.children(name)...)
+		w.uint32(0) // no directory attributes
+		w.fixed([]byte("verifier"), 8)
+		for i := cookie; i < uint64(len(entries)) && i < cookie+2; i++ {
+			child := path.Join(name, entries[i])
+			w.uint32(1)
+			w.uint64(i + 1) // file id
+			w.string(entries[i])
+			w.uint64(i + 1) // cookie
+			// Entries without attributes or handles are looked up
+			if i%2 == 0 && i > 1 {
+				w.uint32(0)
+				w.uint32(0)
+				continue
+			}
+			w.uint32(1)
+			s.attr(child, w)
+			w.uint32(1)
+			w.string(child)
+		}
+		w.uint32(0)
+		w.uint32(b2u(cookie+2 >= uint64(len(entries))))
+	default:
+		s.t.Errorf("Unexpected NFS procedure %d", proc)
+	}
+}
+
+// status writes NFS3_OK if the file exists, NFS3ERR_NOENT otherwise
+func (s *nfsServer) status(name string, w *xdrWriter) bool {
+	if _, err := fs.Stat(s.files, name); err != nil {
+		w.uint32(2)
+		return false
+	}
+	w.uint32(0)
+	return true
+}
+
+// attr writes the fattr3 of the file
+func (s *nfsServer) attr(name string, w *xdrWriter) {
+	info, err := fs.Stat(s.files, name)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+	typ := uint32(nfsRegular)
+	if info.IsDir() {
+		typ = nfsDirectory
+	}
+	w.uint32(typ)
+	w.uint32(uint32(info.Mode().Perm()))
+	w.uint32(1) // nlink
+	w.uint32(0) // uid
+	w.uint32(0) // gid
+	w.uint64(uint64(info.Size()))
+	w.uint64(0) // used
+	w.uint64(0) // rdev
+	w.uint64(0) // fsid
+	w.uint64(0) // fileid
+	w.uint64(0) // atime
+	w.uint32(uint32(info.ModTime().Unix()))
+	w.uint32(uint32(info.ModTime().Nanosecond()))
+	w.uint64(0) // ctime
+}
+
+// children returns the names in the directory in reverse order, as servers list them in any order
+func (s *nfsServer) children(name string) []string {
+	entries, err := fs.ReadDir(s.files, name)
+	if err != nil {
+		s.t.Error(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names
+}
+
+func b2u(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// nfsFiles are the files of the fake server, the export being the root
+func nfsFiles() fstest.MapFS {
+	mtime := time.Unix(1451606400, 500)
+	return fstest.MapFS{
+		"sub/a.txt":           {Data: []byte("hello"), Mode: 0644, ModTime: mtime},
+		"sub/big.bin":         {Data: bytes.Repeat([]byte("0123456789"), nfsReadSize/5), Mode: 0600, ModTime: mtime},
+		"sub/dir/b.txt":       {Data: []byte("world"), Mode: 0644, ModTime: mtime},
+		"sub/dir/c.txt":       {Data: nil, Mode: 0644, ModTime: mtime},
+		"sub/dir/deeper/d.sh": {Data: []byte("#!/bin/sh\n"), Mode: 0755, ModTime: mtime},
+		"other/e.txt":         {Data: []byte("elsewhere"), Mode: 0644, ModTime: mtime},
+	}
+}
+
+func TestNFS(t *testing.T) {
+	files := nfsFiles()
+	srv := newNFSServer(t, "/export", files)
+	share, err := Open(context.Background(), "127.0.0.1:/export/sub", SetIdentity(1000, 100), SetTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := fs.Sub(files, "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The export is mounted and the rest of the path looked up, so the share is rooted at sub
+	if err = fstest.TestFS(share, "a.txt", "big.bin", "dir/b.txt", "dir/c.txt", "dir/deeper/d.sh"); err != nil {
+		t.Fatal(err)
+	}
+	err = fs.WalkDir(sub, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		want, _ := fs.ReadFile(sub, name)
+		got, err := fs.ReadFile(share, name)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Expected %d bytes of %s, got %d", len(want), name, len(got))
+		}
+		info, err := fs.Stat(share, name)
+		if err != nil {
+			return err
+		}
+		if info.Mode() != files["sub/"+name].Mode || !info.ModTime().Equal(files["sub/"+name].ModTime) {
+			t.Errorf("Expected %s to have mode %v at %v, got %v at %v", name, files["sub/"+name].Mode, files["sub/"+name].ModTime, info.Mode(), info.ModTime())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.ReadFile(share, "missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected a missing file not to exist, got %v", err)
+	}
+	if _, err = fs.ReadFile(share, "../other/e.txt"); err == nil {
+		t.Fatal("Expected files outside the share not to be opened")
+	}
+	if err = share.Close(); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if strings.Join(srv.mounts, " ") != "/export -/export" {
+		t.Fatalf("Expected the export to be mounted and unmounted, got %v", srv.mounts)
+	}
+	for _, cred := range srv.creds {
+		if !strings.HasSuffix(cred, "/1000/100") {
+			t.Fatalf("Expected calls to be made as uid 1000 and gid 100, got %q", cred)
+		}
+	}
+}
+
+func TestNFSNotExported(t *testing.T) {
+	newNFSServer(t, "/export", nfsFiles())
+	_, err := Open(context.Background(), "127.0.0.1:/elsewhere/dir", SetTimeout(5*time.Second))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected a path outside the exports not to exist, got %v", err)
+	}
+}
+
+func TestNFSDenied(t *testing.T) {
+	srv := newNFSServer(t, "/export", nfsFiles())
+	srv.deny = true
+	_, err := Open(context.Background(), "127.0.0.1:/export", SetTimeout(5*time.Second))
+	if err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Fatalf("Expected the call to be denied, got %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Package share reads network file shares without mounting them, so a central host can sweep file servers
+without installing anything on them: SMB shares, addressed as \\server\share\path or
+smb://server/share/path and authenticated with NTLM credentials, and NFSv3 exports, addressed as
+server:/export/path or nfs://server/export/path and accessed with an AUTH_SYS identity.
+
+A share is an fs.FS rooted at the path of its location, so it is walked with fs.WalkDir. Most NFS servers
+only accept requests from privileged ports, which need root, unless the export has the insecure option.
+*/
+package share
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+)
+
+// Kinds of shares
+const (
+	SMB = "smb"
+	NFS = "nfs"
+)
+
+// DefaultTimeout of connecting to a server
+const DefaultTimeout = 30 * time.Second
+
+// Credentials authenticate to SMB servers
+type Credentials struct {
+	User     string
+	Password string
+	Domain   string
+}
+
+// LoadCredentials reads the credentials file in the format of mount.cifs, with lines such as
+// username=scanner, password=secret and domain=CORP. The user name can also be given as DOMAIN\user.
+func LoadCredentials(path string) (Credentials, error) {
+	var c Credentials
+	f, err := os.Open(path)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "username", "user":
+			c.User = strings.TrimSpace(value)
+		case "password", "pass":
+			c.Password = value
+		case "domain", "workgroup", "dom":
+			c.Domain = strings.TrimSpace(value)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return c, err
+	}
+	if domain, user, ok := strings.Cut(c.User, `\`); ok && c.Domain == "" {
+		c.Domain, c.User = domain, user
+	}
+	return c, nil
+}
+
+// Location is a parsed share location
+type Location struct {
+	Kind   string // Kind is SMB or NFS
+	Host   string // Host is the server, with a port if not the default one
+	Share  string // Share is the SMB share name, empty for NFS
+	Path   string // Path is the slash separated path in the share, or the absolute path of the NFS export
+	String string // String is the location as given
+}
+
+// nfsHostPath matches the server:/path form of NFS locations, telling them apart from Windows drives
+var nfsHostPath = regexp.MustCompile(`^([^/\\:\s]{2,}|\[[0-9a-fA-F:.]+\]):(/.*)$`)
+
+// ParseLocation parses a share location, returning false if it is not one
+func ParseLocation(location string) (Location, bool) {
+	loc := Location{String: location}
+	switch {
+	case strings.HasPrefix(location, `\\`) || strings.HasPrefix(location, "//"):
+		parts := strings.SplitN(strings.ReplaceAll(location[2:], `\`, "/"), "/", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return loc, false
+		}
+		loc.Kind, loc.Host, loc.Share = SMB, parts[0], parts[1]
+		if len(parts) == 3 {
+			loc.Path = parts[2]
+		}
+	case strings.HasPrefix(location, "smb://") || strings.HasPrefix(location, "nfs://"):
+		u, err := url.Parse(location)
+		if err != nil || u.Host == "" {
+			return loc, false
+		}
+		loc.Kind, loc.Host = u.Scheme, u.Host
+		if loc.Kind == NFS {
+			loc.Path = u.Path
+			break
+		}
+		share, rest, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+		if share == "" {
+			return loc, false
+		}
+		loc.Share, loc.Path = share, rest
+	default:
+		m := nfsHostPath.FindStringSubmatch(location)
+		if m == nil {
+			return loc, false
+		}
+		loc.Kind, loc.Host, loc.Path = NFS, strings.Trim(m[1], "[]"), m[2]
+	}
+	if loc.Kind == SMB {
+		loc.Path = strings.Trim(path.Clean("/"+loc.Path), "/")
+	} else if loc.Path = path.Clean("/" + loc.Path); loc.Path == "" {
+		loc.Path = "/"
+	}
+	return loc, true
+}
+
+// Share is an open share
+type Share struct {
+	fs.FS
+	Location Location
+	close    func() error
+}
+
+// config is the configuration of opening a share
+type config struct {
+	credentials Credentials
+	uid, gid    uint32
+	machine     string
+	timeout     time.Duration
+}
+
+// OptionFunc is a function that configures opening a share.
+// It is used in Open
+type OptionFunc func(*config) error
+
+// SetCredentials sets the credentials of SMB shares. Anonymous guest access is attempted without.
+func SetCredentials(c Credentials) OptionFunc {
+	return func(cfg *config) error {
+		cfg.credentials = c
+		return nil
+	}
+}
+
+// SetIdentity sets the user and group IDs NFS requests are made as. It is nobody (65534) by default,
+// which exports that squash root allow.
+func SetIdentity(uid, gid uint32) OptionFunc {
+	return func(cfg *config) error {
+		cfg.uid, cfg.gid = uid, gid
+		return nil
+	}
+}
+
+// SetTimeout sets how long connecting to a server may take. It is DefaultTimeout by default.
+func SetTimeout(timeout time.Duration) OptionFunc {
+	return func(cfg *config) error {
+		if timeout <= 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Timeout must be positive"}
+		}
+		cfg.timeout = timeout
+		return nil
+	}
+}
+
+// Open connects to the share at the location
+func Open(ctx context.Context, location string, options ...OptionFunc) (*Share, error) {
+	loc, ok := ParseLocation(location)
+	if !ok {
+		return nil, &infinigo.Error{ID: "bad_request", Details: fmt.Sprintf("Bad share location [%s], expected e.g. \\\\server\\share\\path or server:/export/path", location)}
+	}
+	cfg := &config{uid: 65534, gid: 65534, timeout: DefaultTimeout}
+	cfg.machine, _ = os.Hostname()
+	for _, option := range options {
+		if err := option(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if loc.Kind == SMB {
+		return openSMB(ctx, loc, cfg)
+	}
+	return openNFS(ctx, loc, cfg)
+}
+
+// Close disconnects from the server
+func (s *Share) Close() error {
+	return s.close()
+}
+
+// Name returns how a path of the share file system is shown, e.g. \\server\share\dir\file or server:/export/dir/file
+func (s *Share) Name(name string) string {
+	if s.Location.Kind == SMB {
+		p := `\\` + s.Location.Host + `\` + s.Location.Share
+		for _, part := range []string{s.Location.Path, name} {
+			if part != "" && part != "." {
+				p += `\` + strings.ReplaceAll(part, "/", `\`)
+			}
+		}
+		return p
+	}
+	if strings.Contains(strings.TrimPrefix(s.Location.Host, "["), ":") {
+		// A port or an IPv6 address
+		return "nfs://" + s.Location.Host + path.Join(s.Location.Path, name)
+	}
+	return s.Location.Host + ":" + path.Join(s.Location.Path, name)
+}
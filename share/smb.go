@@ -0,0 +1,48 @@
+package share
+
+import (
+	"context"
+	"net"
+
+	"github.com/hirochachacha/go-smb2"
+)
+
+// openSMB connects to the SMB share of the location
+func openSMB(ctx context.Context, loc Location, cfg *config) (*Share, error) {
+	addr := loc.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "445")
+	}
+	dialer := &net.Dialer{Timeout: cfg.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	user := cfg.credentials.User
+	if user == "" {
+		user = "guest"
+	}
+	d := &smb2.Dialer{Initiator: &smb2.NTLMInitiator{User: user, Password: cfg.credentials.Password, Domain: cfg.credentials.Domain}}
+	session, err := d.DialContext(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	share, err := session.Mount(loc.Share)
+	if err != nil {
+		session.Logoff()
+		return nil, err
+	}
+	root := loc.Path
+	if root == "" {
+		root = "."
+	}
+	return &Share{
+		FS:       share.DirFS(root),
+		Location: loc,
+		close: func() error {
+			share.Umount()
+			return session.Logoff()
+		},
+	}, nil
+}
@@ -0,0 +1,55 @@
+package siem
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// CEF formats the result as an ArcSight CEF record observed at t. The verdict is the signature ID,
+// and the extensions include the hash, file, score as cfp1 and classifier scores as cs2.
+func CEF(r pipeline.Result, t time.Time) string {
+	fields := []field{
+		{"rt", millis(t)},
+		{"fileHash", r.Submission.Hash},
+		{"filePath", r.Submission.Path},
+		{"fname", fileName(r)},
+		{"cs1Label", "verdict"},
+		{"cs1", string(r.Response.Verdict())},
+		{"cfp1Label", "score"},
+		{"cfp1", formatScore(r.Response.GeneralScore)},
+	}
+	if names := classifierNames(r); len(names) > 0 {
+		scores := make([]string, len(names))
+		for i, name := range names {
+			scores[i] = name + ":" + formatScore(r.Response.Classifiers[name])
+		}
+		fields = append(fields, field{"cs2Label", "classifiers"}, field{"cs2", strings.Join(scores, " ")})
+	}
+	if r.Response.ConfirmCode != "" {
+		fields = append(fields, field{"cs3Label", "confirmCode"}, field{"cs3", r.Response.ConfirmCode})
+	}
+	if r.Response.Error != "" {
+		fields = append(fields, field{"reason", r.Response.Error})
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|%s|%s|%s|%s|%s|%d|", cefHeaderEscaper.Replace(Vendor), cefHeaderEscaper.Replace(Product),
+		infinigo.APIVersion, r.Response.Verdict(), cefHeaderEscaper.Replace(Name(r)), Severity(r))
+	sep := ""
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		b.WriteString(sep + f.key + "=" + cefExtensionEscaper.Replace(f.value))
+		sep = " "
+	}
+	return b.String()
+}
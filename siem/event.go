@@ -0,0 +1,51 @@
+package siem
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// Event is a flat record of a result for SIEMs and search engines ingesting JSON
+type Event struct {
+	Time        time.Time          `json:"time"`
+	Host        string             `json:"host,omitempty"` // Host the result was observed on
+	Hash        string             `json:"hash"`
+	Path        string             `json:"path,omitempty"`
+	FileName    string             `json:"file_name,omitempty"`
+	Verdict     string             `json:"verdict"`
+	Score       float32            `json:"score"`
+	Severity    int                `json:"severity"`
+	Classifiers map[string]float32 `json:"classifiers,omitempty"`
+	ConfirmCode string             `json:"confirm_code,omitempty"`
+	Error       string             `json:"error,omitempty"`
+	Uploaded    bool               `json:"uploaded"`
+}
+
+var (
+	hostname     string
+	hostnameOnce sync.Once
+)
+
+// NewEvent returns the event of the result observed at t on this host
+func NewEvent(r pipeline.Result, t time.Time) Event {
+	hostnameOnce.Do(func() {
+		hostname, _ = os.Hostname()
+	})
+	return Event{
+		Time:        t.UTC(),
+		Host:        hostname,
+		Hash:        r.Submission.Hash,
+		Path:        r.Submission.Path,
+		FileName:    fileName(r),
+		Verdict:     string(r.Response.Verdict()),
+		Score:       r.Response.GeneralScore,
+		Severity:    Severity(r),
+		Classifiers: r.Response.Classifiers,
+		ConfirmCode: r.Response.ConfirmCode,
+		Error:       r.Response.Error,
+		Uploaded:    r.Submission.Uploaded,
+	}
+}
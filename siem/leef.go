@@ -0,0 +1,62 @@
+package siem
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// Formats of devTime, in Go and in the Java syntax QRadar expects in devTimeFormat
+const (
+	leefTimeFormat     = "2006-01-02T15:04:05.000-0700"
+	leefJavaTimeFormat = "yyyy-MM-dd'T'HH:mm:ss.SSSZ"
+)
+
+// leefValueEscaper removes the tab delimiter and line breaks from values
+var leefValueEscaper = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// LEEF formats the result as a QRadar LEEF 1.0 record observed at t, mirroring the fields of CEF.
+// The verdict is the event ID and category.
+func LEEF(r pipeline.Result, t time.Time) string {
+	sev := Severity(r)
+	if sev < 1 {
+		sev = 1
+	}
+	fields := []field{
+		{"devTime", t.UTC().Format(leefTimeFormat)},
+		{"devTimeFormat", leefJavaTimeFormat},
+		{"cat", string(r.Response.Verdict())},
+		{"sev", strconv.Itoa(sev)},
+		{"fileHash", r.Submission.Hash},
+		{"filePath", r.Submission.Path},
+		{"fileName", fileName(r)},
+		{"score", formatScore(r.Response.GeneralScore)},
+	}
+	if names := classifierNames(r); len(names) > 0 {
+		scores := make([]string, len(names))
+		for i, name := range names {
+			scores[i] = name + ":" + formatScore(r.Response.Classifiers[name])
+		}
+		fields = append(fields, field{"classifiers", strings.Join(scores, " ")})
+	}
+	if r.Response.ConfirmCode != "" {
+		fields = append(fields, field{"confirmCode", r.Response.ConfirmCode})
+	}
+	if r.Response.Error != "" {
+		fields = append(fields, field{"reason", r.Response.Error})
+	}
+	var b strings.Builder
+	b.WriteString("LEEF:1.0|" + Vendor + "|" + Product + "|" + infinigo.APIVersion + "|" + string(r.Response.Verdict()) + "|")
+	sep := ""
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		b.WriteString(sep + f.key + "=" + leefValueEscaper.Replace(f.value))
+		sep = "\t"
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+/*
+Package siem formats results as the event records SIEMs ingest, ArcSight CEF and QRadar LEEF, one record
+per hash with the verdict, score and classifier scores as extensions.
+*/
+package siem
+
+import (
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// Record header fields
+const (
+	Vendor  = "Cylance"  // Vendor of the device
+	Product = "Infinity" // Product of the device
+)
+
+// Severity returns the severity of the result from 0 to 10. Malicious results are 8 to 10 and
+// suspicious results 4 to 7 depending on how low the score is.
+func Severity(r pipeline.Result) int {
+	score := math.Abs(float64(r.Response.GeneralScore))
+	threshold := math.Abs(infinigo.MaliciousThreshold)
+	switch r.Response.Verdict() {
+	case infinigo.VerdictMalicious:
+		return 8 + int(math.Round(math.Min((score-threshold)/(1-threshold), 1)*2))
+	case infinigo.VerdictSuspicious:
+		return 4 + int(math.Round(score/threshold*3))
+	case infinigo.VerdictBenign:
+		return 0
+	}
+	return 2
+}
+
+// Name returns the event name of the result, e.g. Malicious file
+func Name(r pipeline.Result) string {
+	v := string(r.Response.Verdict())
+	if v == "" {
+		return "File"
+	}
+	return strings.ToUpper(v[:1]) + v[1:] + " file"
+}
+
+// field is a key and value of a record extension
+type field struct {
+	key, value string
+}
+
+// classifierNames returns the names of the classifiers of the result in order
+func classifierNames(r pipeline.Result) []string {
+	names := make([]string, 0, len(r.Response.Classifiers))
+	for name := range r.Response.Classifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileName returns the base name of the path of the result, or an empty string
+func fileName(r pipeline.Result) string {
+	if r.Submission.Path == "" {
+		return ""
+	}
+	return filepath.Base(r.Submission.Path)
+}
+
+func formatScore(score float32) string {
+	return strconv.FormatFloat(float64(score), 'f', -1, 32)
+}
+
+func millis(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
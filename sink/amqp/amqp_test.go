@@ -0,0 +1,302 @@
+package amqp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/siem"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// message is a message published to the fake broker
+type message struct {
+	exchange, key, contentType string
+	deliveryMode               byte
+	body                       []byte
+}
+
+// broker is a fake AMQP 0-9-1 broker with the exchange "results", accepting the user infinigo with
+// the password secret and confirming the messages, but for those with the routing key "rejected"
+type broker struct {
+	mu       sync.Mutex
+	conns    int
+	vhosts   []string
+	messages []message
+	drop     bool // drop closes the connection once a message is confirmed, as an idle timeout does
+}
+
+func newBroker(t *testing.T) (*broker, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	b := &broker{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b, l.Addr().String()
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil || string(header) != "AMQP\x00\x00\x09\x01" {
+		return
+	}
+	b.mu.Lock()
+	b.conns++
+	b.mu.Unlock()
+	// Connection.Start with no server properties
+	writeMethod(conn, 0, 10, 10, []byte{0, 9}, u32(0), longstr("PLAIN"), longstr("en_US"))
+	var (
+		tag     uint64
+		publish message
+		size    uint64
+	)
+	for {
+		typ, channel, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case 1:
+			args := bytes.NewReader(payload[4:])
+			switch id := binary.BigEndian.Uint32(payload); id {
+			case 10<<16 | 11: // Connection.Start-Ok
+				readLongstr(args)
+				readShortstr(args)
+				if readLongstr(args) != "\x00infinigo\x00secret" {
+					// RabbitMQ closes the socket of a client that failed to authenticate
+					return
+				}
+				writeMethod(conn, 0, 10, 30, u16(2047), u32(131072), u16(0))
+			case 10<<16 | 40: // Connection.Open
+				b.mu.Lock()
+				b.vhosts = append(b.vhosts, readShortstr(args))
+				b.mu.Unlock()
+				writeMethod(conn, 0, 10, 41, shortstr(""))
+			case 10<<16 | 50: // Connection.Close
+				writeMethod(conn, 0, 10, 51)
+				return
+			case 20<<16 | 10: // Channel.Open
+				writeMethod(conn, channel, 20, 11, longstr(""))
+			case 20<<16 | 40: // Channel.Close
+				writeMethod(conn, channel, 20, 41)
+			case 85<<16 | 10: // Confirm.Select
+				tag = 0
+				writeMethod(conn, channel, 85, 11)
+			case 60<<16 | 40: // Basic.Publish
+				args.Seek(2, io.SeekCurrent)
+				publish = message{exchange: readShortstr(args), key: readShortstr(args)}
+			}
+		case 2:
+			// Content header, with the content type and the delivery mode only
+			size = binary.BigEndian.Uint64(payload[4:])
+			props := bytes.NewReader(payload[14:])
+			flags := binary.BigEndian.Uint16(payload[12:])
+			if flags&0x8000 != 0 {
+				publish.contentType = readShortstr(props)
+			}
+			if flags&0x1000 != 0 {
+				publish.deliveryMode, _ = props.ReadByte()
+			}
+		case 3:
+			publish.body = append(publish.body, payload...)
+			if uint64(len(publish.body)) < size {
+				continue
+			}
+			tag++
+			switch {
+			case publish.exchange != "results":
+				writeMethod(conn, channel, 20, 40, u16(404), shortstr(fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '/'", publish.exchange)), u16(60), u16(40))
+			case publish.key == "rejected":
+				writeMethod(conn, channel, 60, 120, u64(tag), []byte{0})
+			default:
+				b.mu.Lock()
+				b.messages = append(b.messages, publish)
+				drop := b.drop
+				b.mu.Unlock()
+				writeMethod(conn, channel, 60, 80, u64(tag), []byte{0})
+				if drop {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *broker) received() (int, []string, []message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.conns, b.vhosts, b.messages
+}
+
+// readFrame reads a frame and returns its type, channel and payload
+func readFrame(r *bufio.Reader) (byte, uint16, []byte, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[3:])+1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	if payload[len(payload)-1] != 0xce {
+		return 0, 0, nil, errors.New("bad frame end")
+	}
+	return header[0], binary.BigEndian.Uint16(header[1:]), payload[:len(payload)-1], nil
+}
+
+// writeMethod writes a method frame with the arguments
+func writeMethod(w io.Writer, channel, class, method uint16, args ...[]byte) {
+	payload := append(u16(class), u16(method)...)
+	for _, arg := range args {
+		payload = append(payload, arg...)
+	}
+	frame := append([]byte{1}, u16(channel)...)
+	frame = append(frame, u32(uint32(len(payload)))...)
+	frame = append(frame, payload...)
+	w.Write(append(frame, 0xce))
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func shortstr(s string) []byte { return append([]byte{byte(len(s))}, s...) }
+func longstr(s string) []byte  { return append(u32(uint32(len(s))), s...) }
+
+func readShortstr(r *bytes.Reader) string {
+	n, _ := r.ReadByte()
+	b := make([]byte, n)
+	io.ReadFull(r, b)
+	return string(b)
+}
+
+func readLongstr(r *bytes.Reader) string {
+	var n uint32
+	binary.Read(r, binary.BigEndian, &n)
+	b := make([]byte, n)
+	io.ReadFull(r, b)
+	return string(b)
+}
+
+func result(hash string, score float32) pipeline.Result {
+	return pipeline.Result{Submission: pipeline.Submission{Hash: hash}, Response: infinigo.QueryResponse{GeneralScore: score}}
+}
+
+func TestOnResult(t *testing.T) {
+	b, addr := newBroker(t)
+	s, err := New("amqp://infinigo:secret@"+addr+"/infinity", "results", SetRoute(infinigo.VerdictBenign, "clean"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, r := range []pipeline.Result{result("abc", -1), result("def", 1)} {
+		if err = s.OnResult(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conns, vhosts, messages := b.received()
+	if conns != 1 || len(vhosts) != 1 || vhosts[0] != "infinity" {
+		t.Fatalf("Expected a single connection to the virtual host, got %d to %v", conns, vhosts)
+	}
+	if len(messages) != 2 || messages[0].key != "infinity.malicious" || messages[1].key != "clean" {
+		t.Fatalf("Expected the results to be published with the routing keys of their verdicts, got %v", messages)
+	}
+	if messages[0].exchange != "results" || messages[0].contentType != "application/json" || messages[0].deliveryMode != amqp091.Persistent {
+		t.Fatalf("Expected persistent JSON messages to the exchange, got %+v", messages[0])
+	}
+	var e siem.Event
+	if err = json.Unmarshal(messages[0].body, &e); err != nil || e.Hash != "abc" || e.Verdict != "malicious" {
+		t.Fatalf("Expected the event as JSON, got %s - %v", messages[0].body, err)
+	}
+
+	// The broker closes the connection, the next result is published on a new one
+	b.mu.Lock()
+	b.drop = true
+	b.mu.Unlock()
+	closed := s.conn.NotifyClose(make(chan *amqp091.Error, 1))
+	if err = s.OnResult(context.Background(), result("ghi", -1)); err != nil {
+		t.Fatal(err)
+	}
+	<-closed
+	if err = s.OnResult(context.Background(), result("jkl", -1)); err != nil {
+		t.Fatal(err)
+	}
+	if conns, _, messages = b.received(); conns != 2 || len(messages) != 4 {
+		t.Fatalf("Expected the result to be published again on a new connection, got %d connections and %d messages", conns, len(messages))
+	}
+}
+
+func TestErrors(t *testing.T) {
+	b, addr := newBroker(t)
+	tests := []struct {
+		name     string
+		url      string
+		exchange string
+		options  []OptionFunc
+		err      func(error) bool
+	}{
+		{"no exchange", "amqp://infinigo:secret@" + addr, "missing", nil, func(err error) bool {
+			var amqpErr *amqp091.Error
+			return errors.As(err, &amqpErr) && amqpErr.Code == amqp091.NotFound && amqpErr.Server
+		}},
+		{"rejected", "amqp://infinigo:secret@" + addr, "results", []OptionFunc{SetRoute(infinigo.VerdictMalicious, "rejected")}, func(err error) bool {
+			var ierr *infinigo.Error
+			return errors.As(err, &ierr) && ierr.ID == "amqp_error"
+		}},
+		{"wrong password", "amqp://infinigo:wrong@" + addr, "results", nil, func(err error) bool {
+			return errors.Is(err, amqp091.ErrCredentials)
+		}},
+	}
+	for _, test := range tests {
+		s, err := New(test.url, test.exchange, test.options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.OnResult(context.Background(), result("abc", -1)); !test.err(err) {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		s.Close()
+	}
+	// Errors of the broker are not retried on a new connection
+	if conns, _, messages := b.received(); conns != len(tests) || len(messages) != 0 {
+		t.Fatalf("Expected a connection per error, got %d connections", conns)
+	}
+
+	var ierr *infinigo.Error
+	for _, err := range []error{
+		func() error { _, err := New("http://rabbitmq", "results"); return err }(),
+		func() error {
+			_, err := New("amqp://rabbitmq", "results", SetRoute(infinigo.VerdictMalicious, strings.Repeat("k", 256)))
+			return err
+		}(),
+		func() error { _, err := New("amqp://rabbitmq", "results", SetTLSConfig(nil)); return err }(),
+	} {
+		if !errors.As(err, &ierr) || ierr.ID != "bad_option" {
+			t.Errorf("Expected a bad option, got %v", err)
+		}
+	}
+}
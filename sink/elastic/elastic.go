@@ -0,0 +1,269 @@
+/*
+Package elastic is a pipeline sink indexing results into Elasticsearch or OpenSearch with the bulk API,
+for dashboards over the scan history.
+
+Results are indexed as siem.Event documents with the hash as the document ID, so the index keeps the
+latest result of every hash, or of every hash per day with daily indices. An index template mapping
+the fields is installed on first use. Documents are sent in batches, once a batch is full or it waited
+for the flush interval, and Close sends the last batch.
+*/
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/siem"
+)
+
+const (
+	DefaultIndex         = "infinigo-results" // DefaultIndex of the results
+	DefaultBatchSize     = 500                // DefaultBatchSize is the number of documents sent in a request
+	DefaultFlushInterval = 5 * time.Second    // DefaultFlushInterval is how long documents wait for a batch to fill
+)
+
+// mappings of siem.Event documents
+const mappings = `{
+	"dynamic_templates": [{"classifiers": {"path_match": "classifiers.*", "mapping": {"type": "float"}}}],
+	"properties": {
+		"time": {"type": "date"},
+		"host": {"type": "keyword"},
+		"hash": {"type": "keyword"},
+		"path": {"type": "keyword", "fields": {"text": {"type": "text"}}},
+		"file_name": {"type": "keyword"},
+		"verdict": {"type": "keyword"},
+		"score": {"type": "float"},
+		"severity": {"type": "byte"},
+		"classifiers": {"type": "object"},
+		"confirm_code": {"type": "keyword"},
+		"error": {"type": "text"},
+		"uploaded": {"type": "boolean"}
+	}
+}`
+
+// Sink indexes results into Elasticsearch or OpenSearch
+type Sink struct {
+	url       string
+	index     string
+	daily     bool
+	user      string
+	password  string
+	apiKey    string
+	c         *http.Client
+	batchSize int
+	interval  time.Duration
+	errorlog  *log.Logger
+	mu        sync.Mutex
+	templated bool
+	batch     []byte
+	n         int
+	timer     *time.Timer
+}
+
+// OptionFunc is a function that configures a Sink.
+// It is used in New
+type OptionFunc func(*Sink) error
+
+// New creates a sink for the cluster at url, e.g. https://elastic.example.com:9200
+func New(url string, options ...OptionFunc) (*Sink, error) {
+	if url == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Elasticsearch URL is required"}
+	}
+	s := &Sink{
+		url:       strings.TrimSuffix(url, "/"),
+		index:     DefaultIndex,
+		c:         http.DefaultClient,
+		batchSize: DefaultBatchSize,
+		interval:  DefaultFlushInterval,
+	}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SetHTTPClient sets the client of the bulk requests, e.g. to trust the CA of a self-managed cluster or
+// present a client certificate. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(s *Sink) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		s.c = c
+		return nil
+	}
+}
+
+// SetIndex sets the name of the index, or the prefix of daily indices. It is DefaultIndex by default.
+func SetIndex(index string, daily bool) OptionFunc {
+	return func(s *Sink) error {
+		if index == "" || strings.ToLower(index) != index {
+			return &infinigo.Error{ID: "bad_option", Details: "Index must be a lowercase name"}
+		}
+		s.index, s.daily = index, daily
+		return nil
+	}
+}
+
+// SetBasicAuth sets the credentials of the cluster
+func SetBasicAuth(user, password string) OptionFunc {
+	return func(s *Sink) error {
+		s.user, s.password = user, password
+		return nil
+	}
+}
+
+// SetAPIKey sets the base64 encoded API key of the cluster, used instead of basic authentication
+func SetAPIKey(key string) OptionFunc {
+	return func(s *Sink) error {
+		s.apiKey = key
+		return nil
+	}
+}
+
+// SetBatch sets the number of documents sent in a request and how long documents wait for a batch to fill.
+// They are DefaultBatchSize and DefaultFlushInterval by default.
+func SetBatch(size int, interval time.Duration) OptionFunc {
+	return func(s *Sink) error {
+		if size < 1 || interval <= 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Batch size and interval must be positive"}
+		}
+		s.batchSize, s.interval = size, interval
+		return nil
+	}
+}
+
+// SetErrorLog sets the logger for batches that failed when sent after the flush interval. It is nil by default.
+func SetErrorLog(logger *log.Logger) OptionFunc {
+	return func(s *Sink) error {
+		s.errorlog = logger
+		return nil
+	}
+}
+
+// OnResult adds the result to the batch and sends the batch if it is full
+func (s *Sink) OnResult(ctx context.Context, r pipeline.Result) error {
+	event := siem.NewEvent(r, time.Now())
+	index := s.index
+	if s.daily {
+		index += "-" + event.Time.Format("2006.01.02")
+	}
+	action, err := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": index, "_id": strings.ToLower(event.Hash)}})
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batch = append(append(append(append(s.batch, action...), '\n'), doc...), '\n')
+	s.n++
+	if s.n >= s.batchSize {
+		return s.flush(ctx)
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.interval, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if err := s.flush(context.Background()); err != nil && s.errorlog != nil {
+				s.errorlog.Printf("Failed indexing results - %v", err)
+			}
+		})
+	}
+	return nil
+}
+
+// Close sends the batch
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush(context.Background())
+}
+
+// flush installs the index template if needed and sends the batch. The batch is dropped if it fails.
+func (s *Sink) flush(ctx context.Context) error {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.n == 0 {
+		return nil
+	}
+	batch, n := s.batch, s.n
+	s.batch, s.n = nil, 0
+	if !s.templated {
+		template := fmt.Sprintf(`{"index_patterns": [%q, %q], "template": {"mappings": %s}}`, s.index, s.index+"-*", mappings)
+		if err := s.do(ctx, http.MethodPut, "/_index_template/"+s.index, "application/json", []byte(template), nil); err != nil {
+			return fmt.Errorf("dropped %d documents, failed installing the index template - %w", n, err)
+		}
+		s.templated = true
+	}
+	var resp struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := s.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", batch, &resp); err != nil {
+		return fmt.Errorf("dropped %d documents - %w", n, err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+	failed, first := 0, ""
+	for _, item := range resp.Items {
+		for _, result := range item {
+			if result.Status >= 300 {
+				if failed == 0 {
+					first = fmt.Sprintf("%s: %s", result.ID, result.Error)
+				}
+				failed++
+			}
+		}
+	}
+	return &infinigo.Error{ID: "bad_response", Details: fmt.Sprintf("Failed indexing %d of %d documents, e.g. %s", failed, n, first)}
+}
+
+// do sends the request to the cluster and decodes the response into result if not nil
+func (s *Sink) do(ctx context.Context, method, path, contentType string, body []byte, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, s.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case s.apiKey != "":
+		req.Header.Set("Authorization", "ApiKey "+s.apiKey)
+	case s.user != "":
+		req.SetBasicAuth(s.user, s.password)
+	}
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("%s returned %d - %s", path, resp.StatusCode, bytes.TrimSpace(msg))}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
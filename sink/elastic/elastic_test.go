@@ -0,0 +1,139 @@
+package elastic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// cluster is a fake cluster keeping the index templates and documents indexed with the bulk API, and
+// failing to index the documents of the hash in reject
+type cluster struct {
+	mu        sync.Mutex
+	templates map[string]string
+	docs      map[string]map[string]interface{} // docs by index and ID
+	bulks     int
+	reject    string
+}
+
+func newCluster(t *testing.T) (*cluster, string) {
+	t.Helper()
+	c := &cluster{templates: map[string]string{}, docs: map[string]map[string]interface{}{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "ApiKey key" {
+			http.Error(w, `{"error":"security_exception"}`, http.StatusUnauthorized)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		switch {
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/_index_template/"):
+			var template struct {
+				Patterns []string `json:"index_patterns"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+				t.Error(err)
+			}
+			c.templates[strings.TrimPrefix(r.URL.Path, "/_index_template/")] = strings.Join(template.Patterns, ",")
+			w.Write([]byte(`{"acknowledged":true}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
+			if r.Header.Get("Content-Type") != "application/x-ndjson" {
+				t.Errorf("Expected NDJSON, got %s", r.Header.Get("Content-Type"))
+			}
+			c.bulks++
+			var items []interface{}
+			failed := false
+			s := bufio.NewScanner(r.Body)
+			for s.Scan() {
+				var action struct {
+					Index struct {
+						Index string `json:"_index"`
+						ID    string `json:"_id"`
+					} `json:"index"`
+				}
+				var doc map[string]interface{}
+				json.Unmarshal(s.Bytes(), &action)
+				s.Scan()
+				json.Unmarshal(s.Bytes(), &doc)
+				result := map[string]interface{}{"_id": action.Index.ID, "status": 201}
+				if action.Index.ID == c.reject {
+					result["status"], result["error"] = 400, map[string]string{"type": "mapper_parsing_exception"}
+					failed = true
+				} else {
+					c.docs[action.Index.Index+"/"+action.Index.ID] = doc
+				}
+				items = append(items, map[string]interface{}{"index": result})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": failed, "items": items})
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return c, srv.URL
+}
+
+func result(hash string, score float32) pipeline.Result {
+	return pipeline.Result{Submission: pipeline.Submission{Hash: hash}, Response: infinigo.QueryResponse{GeneralScore: score}}
+}
+
+func TestOnResult(t *testing.T) {
+	c, url := newCluster(t)
+	s, err := New(url+"/", SetAPIKey("key"), SetIndex("scans", true), SetBatch(2, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []pipeline.Result{result("ABC", -1), result("def", 1), result("abc", 0.5)} {
+		if err = s.OnResult(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.templates) != 1 || c.templates["scans"] != "scans,scans-*" {
+		t.Fatalf("Expected the template of the daily indices to be installed once, got %v", c.templates)
+	}
+	// Documents are keyed by the lowercased hash, so the index keeps the latest result of every hash
+	index := "scans-" + time.Now().UTC().Format("2006.01.02")
+	if c.bulks != 2 || len(c.docs) != 2 {
+		t.Fatalf("Expected two batches indexing two documents, got %d and %v", c.bulks, c.docs)
+	}
+	if doc := c.docs[index+"/abc"]; doc == nil || doc["verdict"] != "benign" {
+		t.Fatalf("Expected the latest result of abc in %s, got %v", index, c.docs)
+	}
+}
+
+func TestOnResultFailures(t *testing.T) {
+	c, url := newCluster(t)
+	c.reject = "bad"
+	s, err := New(url, SetAPIKey("key"), SetBatch(2, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.OnResult(context.Background(), result("good", -1))
+	var ierr *infinigo.Error
+	if err = s.OnResult(context.Background(), result("bad", -1)); !errors.As(err, &ierr) || ierr.ID != "bad_response" || !strings.Contains(ierr.Details, "1 of 2") {
+		t.Fatalf("Expected the failed document to be reported, got %v", err)
+	}
+	if s, err = New(url, SetAPIKey("wrong")); err != nil {
+		t.Fatal(err)
+	}
+	s.OnResult(context.Background(), result("good", -1))
+	if err = s.Close(); !errors.As(err, &ierr) || ierr.ID != "http_error" || !strings.Contains(err.Error(), "dropped 1 documents") {
+		t.Fatalf("Expected the refused key to drop the batch, got %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+/*
+Package jira is a pipeline sink opening a Jira issue per malicious finding, so findings enter the
+incident workflow of the project.
+
+An issue has the verdict details in its description, the result attached as JSON and a priority mapped
+from the score. Issues are labeled with the hash, and finding the hash again while its issue is not
+done adds a comment to it instead of opening another one. The REST API v2 is used, which Jira Cloud,
+Server and Data Center all support.
+*/
+package jira
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/siem"
+)
+
+// DefaultIssueType of the issues
+const DefaultIssueType = "Task"
+
+// Sink opens Jira issues for malicious results
+type Sink struct {
+	url        string
+	project    string
+	issueType  string
+	user       string
+	token      string
+	c          *http.Client
+	priorities map[int]string
+	labels     []string
+}
+
+// OptionFunc is a function that configures a Sink.
+// It is used in New
+type OptionFunc func(*Sink) error
+
+// New creates a sink opening issues in the project with the key at the Jira instance at url,
+// e.g. https://example.atlassian.net
+func New(url, project string, options ...OptionFunc) (*Sink, error) {
+	if url == "" || project == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Jira URL and project are required"}
+	}
+	s := &Sink{
+		url:        strings.TrimSuffix(url, "/"),
+		project:    project,
+		issueType:  DefaultIssueType,
+		c:          http.DefaultClient,
+		priorities: map[int]string{10: "Highest", 9: "High", 8: "Medium"},
+		labels:     []string{"infinigo"},
+	}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SetHTTPClient sets the client of the requests to Jira, e.g. to trust the CA of a Jira Data Center
+// server. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(s *Sink) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		s.c = c
+		return nil
+	}
+}
+
+// SetBasicAuth sets the user and API token of Jira Cloud, or the user and password of Jira Server
+func SetBasicAuth(user, token string) OptionFunc {
+	return func(s *Sink) error {
+		s.user, s.token = user, token
+		return nil
+	}
+}
+
+// SetToken sets the personal access token of Jira Server or Data Center, used instead of basic authentication
+func SetToken(token string) OptionFunc {
+	return func(s *Sink) error {
+		s.user, s.token = "", token
+		return nil
+	}
+}
+
+// SetIssueType sets the type of the issues. It is DefaultIssueType by default.
+func SetIssueType(name string) OptionFunc {
+	return func(s *Sink) error {
+		if name == "" {
+			return &infinigo.Error{ID: "bad_option", Details: "Issue type is required"}
+		}
+		s.issueType = name
+		return nil
+	}
+}
+
+// SetPriority sets the priority of the issues with the siem.Severity, from 8 to 10 for malicious results.
+// By default 10 is Highest, 9 High and 8 Medium. An empty name leaves the priority to the project default.
+func SetPriority(severity int, name string) OptionFunc {
+	return func(s *Sink) error {
+		if severity < 8 || severity > 10 {
+			return &infinigo.Error{ID: "bad_option", Details: "Severity of malicious results is between 8 and 10"}
+		}
+		s.priorities[severity] = name
+		return nil
+	}
+}
+
+// SetLabels adds labels to the issues, in addition to infinigo and the label of the hash
+func SetLabels(labels ...string) OptionFunc {
+	return func(s *Sink) error {
+		for _, label := range labels {
+			if label == "" || strings.ContainsAny(label, " \t\n") {
+				return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad label [%s], labels cannot have spaces", label)}
+			}
+		}
+		s.labels = append(s.labels, labels...)
+		return nil
+	}
+}
+
+// Label returns the label of the issues of the hash
+func Label(hash string) string {
+	return "infinigo-" + strings.ToLower(hash)
+}
+
+// OnResult opens an issue for a malicious result, or comments on the open issue of the hash
+func (s *Sink) OnResult(ctx context.Context, r pipeline.Result) error {
+	if r.Response.Verdict() != infinigo.VerdictMalicious {
+		return nil
+	}
+	e := siem.NewEvent(r, time.Now())
+	key, err := s.find(ctx, e.Hash)
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		comment := fmt.Sprintf("Found again at %s.\n\n%s", e.Time.Format(time.RFC3339), details(e))
+		return s.do(ctx, http.MethodPost, "/rest/api/2/issue/"+key+"/comment", map[string]string{"body": comment}, nil)
+	}
+	name := e.FileName
+	if name == "" {
+		name = e.Hash
+	}
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": s.project},
+		"issuetype":   map[string]string{"name": s.issueType},
+		"summary":     fmt.Sprintf("%s %s on %s", siem.Name(r), name, e.Host),
+		"description": fmt.Sprintf("Infinity classified a file as malicious.\n\n%s", details(e)),
+		"labels":      append(append([]string{}, s.labels...), Label(e.Hash)),
+	}
+	if priority := s.priorities[e.Severity]; priority != "" {
+		fields["priority"] = map[string]string{"name": priority}
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err = s.do(ctx, http.MethodPost, "/rest/api/2/issue", map[string]interface{}{"fields": fields}, &created); err != nil {
+		return err
+	}
+	report, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = s.attach(ctx, created.Key, Label(e.Hash)+".json", report); err != nil {
+		return fmt.Errorf("created %s but failed attaching the report - %w", created.Key, err)
+	}
+	return nil
+}
+
+// find returns the key of the issue of the hash that is not done, or an empty string
+func (s *Sink) find(ctx context.Context, hash string) (string, error) {
+	jql := fmt.Sprintf(`project = "%s" AND labels = "%s" AND statusCategory != Done ORDER BY created DESC`, s.project, Label(hash))
+	query := url.Values{"jql": {jql}, "fields": {"key"}, "maxResults": {"1"}}.Encode()
+	var found struct {
+		Issues []struct {
+			Key string `json:"key"`
+		} `json:"issues"`
+	}
+	// Jira Cloud replaced search with search/jql, which Server and Data Center do not have
+	err := s.do(ctx, http.MethodGet, "/rest/api/2/search/jql?"+query, nil, &found)
+	if e, ok := err.(*infinigo.Error); ok && e.ID == "http_error" {
+		err = s.do(ctx, http.MethodGet, "/rest/api/2/search?"+query, nil, &found)
+	}
+	if err != nil || len(found.Issues) == 0 {
+		return "", err
+	}
+	return found.Issues[0].Key, nil
+}
+
+// attach adds the file to the issue
+func (s *Sink) attach(ctx context.Context, key, name string, content []byte) error {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	part, err := w.CreateFormFile("file", name)
+	if err != nil {
+		return err
+	}
+	part.Write(content)
+	if err = w.Close(); err != nil {
+		return err
+	}
+	req, err := s.request(ctx, http.MethodPost, "/rest/api/2/issue/"+key+"/attachments", &b)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("X-Atlassian-Token", "no-check")
+	return s.send(req, nil)
+}
+
+// do sends the request with the JSON body if not nil, and decodes the response into result if not nil
+func (s *Sink) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := s.request(ctx, method, path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return s.send(req, result)
+}
+
+// request creates an authenticated request
+func (s *Sink) request(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case s.user != "":
+		req.SetBasicAuth(s.user, s.token)
+	case s.token != "":
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	return req, nil
+}
+
+// send sends the request and decodes the response into result if not nil
+func (s *Sink) send(req *http.Request, result interface{}) error {
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("Jira %s returned %d - %s", req.URL.Path, resp.StatusCode, bytes.TrimSpace(msg))}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// details returns the verdict details of the event as a table in Jira wiki markup
+func details(e siem.Event) string {
+	rows := [][2]string{
+		{"Hash", e.Hash},
+		{"Verdict", e.Verdict},
+		{"Score", fmt.Sprint(e.Score)},
+		{"Severity", fmt.Sprint(e.Severity)},
+		{"Host", e.Host},
+		{"Path", e.Path},
+		{"Uploaded", fmt.Sprint(e.Uploaded)},
+		{"Confirm code", e.ConfirmCode},
+	}
+	names := make([]string, 0, len(e.Classifiers))
+	for name := range e.Classifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rows = append(rows, [2]string{"Classifier " + name, fmt.Sprint(e.Classifiers[name])})
+	}
+	var b strings.Builder
+	b.WriteString("||Field||Value||\n")
+	for _, row := range rows {
+		if row[1] != "" {
+			fmt.Fprintf(&b, "|%s|%s|\n", row[0], escape(row[1]))
+		}
+	}
+	return b.String()
+}
+
+// escape escapes the characters of a table cell that Jira wiki markup reserves. Backslashes are
+// kept as is since a double backslash is a line break.
+func escape(text string) string {
+	return strings.NewReplacer("|", `\|`, "{", `\{`, "}", `\}`, "[", `\[`, "]", `\]`, "*", `\*`, "_", `\_`).Replace(text)
+}
@@ -0,0 +1,164 @@
+package jira
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// issue is an issue of the fake Jira
+type issue struct {
+	fields      map[string]interface{}
+	comments    []string
+	attachments map[string][]byte
+	done        bool
+}
+
+// jira is a fake Jira keeping the issues by key. A server has no search/jql endpoint, like Jira
+// Server and Data Center.
+type jira struct {
+	mu     sync.Mutex
+	issues map[string]*issue
+	server bool
+}
+
+var labelRegexp = regexp.MustCompile(`labels = "([^"]+)"`)
+
+func newJira(t *testing.T, server bool) (*jira, string) {
+	t.Helper()
+	j := &jira{issues: map[string]*issue{}, server: server}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, token, _ := r.BasicAuth(); user != "user" || token != "token" {
+			http.Error(w, `{"errorMessages":["Unauthorized"]}`, http.StatusUnauthorized)
+			return
+		}
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		path := strings.TrimPrefix(r.URL.Path, "/rest/api/2/")
+		switch {
+		case r.Method == http.MethodGet && (path == "search" || path == "search/jql" && !j.server):
+			label := labelRegexp.FindStringSubmatch(r.URL.Query().Get("jql"))
+			var found []map[string]string
+			for key, i := range j.issues {
+				for _, l := range i.fields["labels"].([]interface{}) {
+					if len(label) == 2 && l == label[1] && !i.done {
+						found = append(found, map[string]string{"key": key})
+					}
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"issues": found})
+		case r.Method == http.MethodPost && path == "issue":
+			var body struct {
+				Fields map[string]interface{} `json:"fields"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			key := fmt.Sprintf("SEC-%d", len(j.issues)+1)
+			j.issues[key] = &issue{fields: body.Fields, attachments: map[string][]byte{}}
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"id":"1000%d","key":"%s"}`, len(j.issues), key)
+		case r.Method == http.MethodPost && strings.HasSuffix(path, "/comment"):
+			i := j.issues[strings.TrimSuffix(strings.TrimPrefix(path, "issue/"), "/comment")]
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			i.comments = append(i.comments, body["body"])
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodPost && strings.HasSuffix(path, "/attachments"):
+			i := j.issues[strings.TrimSuffix(strings.TrimPrefix(path, "issue/"), "/attachments")]
+			if r.Header.Get("X-Atlassian-Token") != "no-check" {
+				http.Error(w, "XSRF check failed", http.StatusForbidden)
+				return
+			}
+			f, header, err := r.FormFile("file")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			i.attachments[header.Filename], _ = io.ReadAll(f)
+			w.Write([]byte(`[]`))
+		default:
+			http.Error(w, `{"errorMessages":["Not found"]}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return j, srv.URL
+}
+
+func result(hash string, score float32) pipeline.Result {
+	return pipeline.Result{Submission: pipeline.Submission{Hash: hash, Path: "/tmp/sample|1.exe"}, Response: infinigo.QueryResponse{GeneralScore: score}}
+}
+
+func TestOnResult(t *testing.T) {
+	for _, server := range []bool{false, true} {
+		j, url := newJira(t, server)
+		s, err := New(url, "SEC", SetBasicAuth("user", "token"), SetLabels("malware"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range []pipeline.Result{result("ABC", -1), result("def", 1), result("abc", -0.5), result("ghi", -0.5)} {
+			if err = s.OnResult(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		j.mu.Lock()
+		if len(j.issues) != 2 {
+			t.Fatalf("Expected an issue per malicious hash, got %v", j.issues)
+		}
+		i := j.issues["SEC-1"]
+		if !strings.HasPrefix(i.fields["summary"].(string), "Malicious file sample|1.exe on ") || fmt.Sprint(i.fields["labels"]) != "[infinigo malware infinigo-abc]" {
+			t.Fatalf("Expected the issue to be labeled with the hash, got %v", i.fields)
+		}
+		if fmt.Sprint(i.fields["priority"]) != "map[name:Highest]" || fmt.Sprint(j.issues["SEC-2"].fields["priority"]) != "map[name:Medium]" {
+			t.Fatalf("Expected the priorities to be mapped from the scores, got %v", j.issues)
+		}
+		if !strings.Contains(i.fields["description"].(string), `|Path|/tmp/sample\|1.exe|`) {
+			t.Fatalf("Expected the details to be escaped, got %s", i.fields["description"])
+		}
+		if len(i.comments) != 1 || !strings.Contains(i.comments[0], "|Score|-0.5|") {
+			t.Fatalf("Expected finding the hash again to comment on its issue, got %v", i.comments)
+		}
+		var report pipeline.Result
+		if err = json.Unmarshal(i.attachments["infinigo-abc.json"], &report); err != nil || report.Submission.Hash != "ABC" {
+			t.Fatalf("Expected the result to be attached, got %v - %v", i.attachments, err)
+		}
+		// Once the issue is done, the hash opens another one
+		i.done = true
+		j.mu.Unlock()
+		if err = s.OnResult(context.Background(), result("abc", -1)); err != nil {
+			t.Fatal(err)
+		}
+		j.mu.Lock()
+		if len(j.issues) != 3 {
+			t.Fatalf("Expected a new issue once the previous one is done, got %v", j.issues)
+		}
+		j.mu.Unlock()
+	}
+}
+
+func TestOnResultRefused(t *testing.T) {
+	_, url := newJira(t, false)
+	s, err := New(url, "SEC", SetBasicAuth("user", "wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ierr *infinigo.Error
+	if err = s.OnResult(context.Background(), result("abc", -1)); !errors.As(err, &ierr) || ierr.ID != "http_error" || !strings.Contains(ierr.Details, "401") {
+		t.Fatalf("Expected the refused credentials to fail, got %v", err)
+	}
+	if _, err = New(url, "SEC", SetLabels("two words")); !errors.As(err, &ierr) || ierr.ID != "bad_option" {
+		t.Fatalf("Expected a label with spaces to be refused, got %v", err)
+	}
+}
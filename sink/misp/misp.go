@@ -0,0 +1,261 @@
+/*
+Package misp is a pipeline sink adding malicious findings to a MISP event through the MISP REST API.
+
+The event is found by its info, or created on the first finding with the configured distribution
+level. Every finding becomes an attribute with the hash, commented with the score and classifier
+scores of Infinity. Findings already in the event update the comment of their attribute instead of
+adding another one.
+*/
+package misp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// Distribution levels of MISP events
+const (
+	DistributionOrganisation = 0 // DistributionOrganisation shares the event with your organisation only
+	DistributionCommunity    = 1 // DistributionCommunity shares the event with this community only
+	DistributionConnected    = 2 // DistributionConnected shares the event with connected communities
+	DistributionAll          = 3 // DistributionAll shares the event with all communities
+)
+
+// DefaultEventInfo is the info of the event findings are added to
+const DefaultEventInfo = "Cylance Infinity findings"
+
+// Sink adds findings to a MISP event
+type Sink struct {
+	url          string
+	key          string
+	c            *http.Client
+	info         string
+	distribution int
+	verdicts     map[infinigo.Verdict]bool
+	mu           sync.Mutex
+	eventID      string
+}
+
+// OptionFunc is a function that configures a Sink.
+// It is used in New
+type OptionFunc func(*Sink) error
+
+// New creates a sink for the MISP instance at url with the given automation key
+func New(url, key string, options ...OptionFunc) (*Sink, error) {
+	if url == "" || key == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "MISP URL and key are required"}
+	}
+	s := &Sink{
+		url:          strings.TrimSuffix(url, "/"),
+		key:          key,
+		c:            http.DefaultClient,
+		info:         DefaultEventInfo,
+		distribution: DistributionOrganisation,
+		verdicts:     map[infinigo.Verdict]bool{infinigo.VerdictMalicious: true},
+	}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SetHTTPClient sets the client of the requests to MISP, whose instances often use a self-signed
+// certificate. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(s *Sink) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		s.c = c
+		return nil
+	}
+}
+
+// SetEvent sets the info of the event to add findings to. It is DefaultEventInfo by default.
+func SetEvent(info string) OptionFunc {
+	return func(s *Sink) error {
+		if info == "" {
+			return &infinigo.Error{ID: "bad_option", Details: "Event info is required"}
+		}
+		s.info = info
+		return nil
+	}
+}
+
+// SetDistribution sets the distribution level of a created event, from DistributionOrganisation to
+// DistributionAll. It is DistributionOrganisation by default.
+func SetDistribution(level int) OptionFunc {
+	return func(s *Sink) error {
+		if level < DistributionOrganisation || level > DistributionAll {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Distribution must be between %d and %d", DistributionOrganisation, DistributionAll)}
+		}
+		s.distribution = level
+		return nil
+	}
+}
+
+// SetVerdicts sets the verdicts of the findings to add. It is only VerdictMalicious by default.
+func SetVerdicts(verdicts ...infinigo.Verdict) OptionFunc {
+	return func(s *Sink) error {
+		s.verdicts = make(map[infinigo.Verdict]bool, len(verdicts))
+		for _, v := range verdicts {
+			s.verdicts[v] = true
+		}
+		return nil
+	}
+}
+
+// OnResult adds the result to the event if its verdict is one of the configured ones
+func (s *Sink) OnResult(ctx context.Context, r pipeline.Result) error {
+	if !s.verdicts[r.Response.Verdict()] {
+		return nil
+	}
+	kind, ok := attributeType(r.Submission.Hash)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event, err := s.event(ctx)
+	if err != nil {
+		return err
+	}
+	comment := describe(r)
+	var found struct {
+		Response struct {
+			Attribute []struct {
+				ID string `json:"id"`
+			} `json:"Attribute"`
+		} `json:"response"`
+	}
+	search := map[string]interface{}{"returnFormat": "json", "eventid": event, "value": r.Submission.Hash}
+	if err = s.do(ctx, "/attributes/restSearch", search, &found); err != nil {
+		return err
+	}
+	if len(found.Response.Attribute) > 0 {
+		return s.do(ctx, "/attributes/edit/"+found.Response.Attribute[0].ID, map[string]interface{}{"comment": comment}, nil)
+	}
+	attribute := map[string]interface{}{
+		"type":         kind,
+		"category":     "Payload delivery",
+		"value":        r.Submission.Hash,
+		"to_ids":       true,
+		"comment":      comment,
+		"distribution": "5", // Inherit the distribution of the event
+	}
+	return s.do(ctx, "/attributes/add/"+event, attribute, nil)
+}
+
+// event returns the ID of the event, finding or creating it on first use
+func (s *Sink) event(ctx context.Context) (string, error) {
+	if s.eventID != "" {
+		return s.eventID, nil
+	}
+	var found struct {
+		Response []struct {
+			Event struct {
+				ID   string `json:"id"`
+				Info string `json:"info"`
+			} `json:"Event"`
+		} `json:"response"`
+	}
+	if err := s.do(ctx, "/events/restSearch", map[string]interface{}{"returnFormat": "json", "eventinfo": s.info, "metadata": true}, &found); err != nil {
+		return "", err
+	}
+	for _, e := range found.Response {
+		// eventinfo is a substring match
+		if e.Event.Info == s.info {
+			s.eventID = e.Event.ID
+			return s.eventID, nil
+		}
+	}
+	var created struct {
+		Event struct {
+			ID string `json:"id"`
+		} `json:"Event"`
+	}
+	event := map[string]interface{}{"Event": map[string]interface{}{
+		"info":            s.info,
+		"distribution":    fmt.Sprint(s.distribution),
+		"threat_level_id": "1", // High
+		"analysis":        "1", // Ongoing
+	}}
+	if err := s.do(ctx, "/events/add", event, &created); err != nil {
+		return "", err
+	}
+	if created.Event.ID == "" {
+		return "", &infinigo.Error{ID: "bad_response", Details: "MISP did not return the ID of the created event"}
+	}
+	s.eventID = created.Event.ID
+	return s.eventID, nil
+}
+
+// do posts the JSON body to the path of the MISP API and decodes the response into result if not nil
+func (s *Sink) do(ctx context.Context, path string, body, result interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", s.key)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("MISP %s returned %d - %s", path, resp.StatusCode, bytes.TrimSpace(msg))}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// describe returns the score and classifier scores of the result
+func describe(r pipeline.Result) string {
+	parts := []string{fmt.Sprintf("Cylance Infinity %s, score %v", r.Response.Verdict(), r.Response.GeneralScore)}
+	names := make([]string, 0, len(r.Response.Classifiers))
+	for name := range r.Response.Classifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s %v", name, r.Response.Classifiers[name]))
+	}
+	if r.Submission.Path != "" {
+		parts = append(parts, "seen at "+r.Submission.Path)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// attributeType returns the MISP attribute type of the hash by its length
+func attributeType(hash string) (string, bool) {
+	switch len(hash) {
+	case 32:
+		return "md5", true
+	case 40:
+		return "sha1", true
+	case 64:
+		return "sha256", true
+	}
+	return "", false
+}
@@ -0,0 +1,152 @@
+package misp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+const hash = "2d7ab0e8cfaf0fd1f2a2a3d2a1a0c4dc6e4a4cba8b19b1f0dfd1a8b0bcd8e1f0"
+
+// mispServer is a fake MISP keeping the events and attributes added through the API
+type mispServer struct {
+	mu         sync.Mutex
+	events     map[string]string            // events are the infos by ID
+	attributes map[string]map[string]string // attributes are the fields of the attributes by ID
+	calls      []string
+}
+
+func newMISPServer(t *testing.T, events map[string]string) (*mispServer, *httptest.Server) {
+	t.Helper()
+	m := &mispServer{events: events, attributes: map[string]map[string]string{}}
+	srv := httptest.NewServer(m)
+	t.Cleanup(srv.Close)
+	return m, srv
+}
+
+func (m *mispServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, r.URL.Path)
+	if r.Header.Get("Authorization") != "key" {
+		http.Error(w, `{"message":"Authentication failed"}`, http.StatusForbidden)
+		return
+	}
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	var resp interface{}
+	switch path := r.URL.Path; {
+	case path == "/events/restSearch":
+		var found []interface{}
+		for id, info := range m.events {
+			if strings.Contains(info, body["eventinfo"].(string)) {
+				found = append(found, map[string]interface{}{"Event": map[string]string{"id": id, "info": info}})
+			}
+		}
+		resp = map[string]interface{}{"response": found}
+	case path == "/events/add":
+		id := strconv.Itoa(100 + len(m.events))
+		m.events[id] = body["Event"].(map[string]interface{})["info"].(string)
+		resp = map[string]interface{}{"Event": map[string]string{"id": id}}
+	case path == "/attributes/restSearch":
+		found := []interface{}{}
+		for id, a := range m.attributes {
+			if a["event"] == body["eventid"] && a["value"] == body["value"] {
+				found = append(found, map[string]string{"id": id})
+			}
+		}
+		resp = map[string]interface{}{"response": map[string]interface{}{"Attribute": found}}
+	case strings.HasPrefix(path, "/attributes/add/"):
+		m.attributes[strconv.Itoa(len(m.attributes)+1)] = map[string]string{
+			"event":   strings.TrimPrefix(path, "/attributes/add/"),
+			"type":    body["type"].(string),
+			"value":   body["value"].(string),
+			"comment": body["comment"].(string),
+		}
+	case strings.HasPrefix(path, "/attributes/edit/"):
+		m.attributes[strings.TrimPrefix(path, "/attributes/edit/")]["comment"] = body["comment"].(string)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func result(score float32) pipeline.Result {
+	return pipeline.Result{
+		Submission: pipeline.Submission{Hash: hash, Path: "/tmp/sample.exe"},
+		Response:   infinigo.QueryResponse{GeneralScore: score, Classifiers: map[string]float32{"ml": score}},
+	}
+}
+
+func TestOnResult(t *testing.T) {
+	// The search matches events whose info contains the one of the sink
+	m, srv := newMISPServer(t, map[string]string{"7": DefaultEventInfo + " (old)"})
+	s, err := New(srv.URL+"/", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, r := range []pipeline.Result{result(-1), result(1), result(-0.9)} {
+		if err = s.OnResult(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.events) != 2 || m.events["101"] != DefaultEventInfo {
+		t.Fatalf("Expected an event to be created, got %v", m.events)
+	}
+	if len(m.attributes) != 1 {
+		t.Fatalf("Expected a single attribute for the hash, got %v", m.attributes)
+	}
+	a := m.attributes["1"]
+	if a["event"] != "101" || a["type"] != "sha256" || a["value"] != hash {
+		t.Fatalf("Expected a sha256 attribute in the created event, got %v", a)
+	}
+	if a["comment"] != "Cylance Infinity malicious, score -0.9, ml -0.9, seen at /tmp/sample.exe" {
+		t.Fatalf("Expected the comment to be updated with the last result, got %q", a["comment"])
+	}
+	if m.calls[0] != "/events/restSearch" || strings.Count(strings.Join(m.calls, " "), "/events/") != 2 {
+		t.Fatalf("Expected the event to be looked up once, got %v", m.calls)
+	}
+}
+
+func TestOnResultExistingEvent(t *testing.T) {
+	m, srv := newMISPServer(t, map[string]string{"7": "Phishing", "8": "Phishing (old)"})
+	s, err := New(srv.URL, "key", SetEvent("Phishing"), SetVerdicts(infinigo.VerdictSuspicious))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []pipeline.Result{result(-1), result(-0.3)} {
+		if err = s.OnResult(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.events) != 2 || len(m.attributes) != 1 || m.attributes["1"]["event"] != "7" {
+		t.Fatalf("Expected the suspicious finding to be added to event 7, got %v %v", m.events, m.attributes)
+	}
+}
+
+func TestOnResultRefused(t *testing.T) {
+	_, srv := newMISPServer(t, map[string]string{})
+	s, err := New(srv.URL, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ierr *infinigo.Error
+	if err = s.OnResult(context.Background(), result(-1)); !errors.As(err, &ierr) || ierr.ID != "http_error" || !strings.Contains(ierr.Details, "403") {
+		t.Fatalf("Expected the refused key to be reported, got %v", err)
+	}
+}
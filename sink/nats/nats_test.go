@@ -0,0 +1,205 @@
+package nats
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/siem"
+)
+
+// message is a message published to the fake server
+type message struct {
+	subject string
+	data    []byte
+}
+
+// server is a fake NATS server keeping the messages it was published and the CONNECT options of the
+// clients. A JetStream stream captures the subjects starting with "stored.", and one rejecting the
+// messages the subjects starting with "full.".
+type server struct {
+	mu       sync.Mutex
+	connects []map[string]interface{}
+	messages []message
+}
+
+func newServer(t *testing.T) (*server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &server{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, l.Addr().String()
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"proto\":1,\"headers\":true,\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(conn)
+	subs := map[string]string{} // sids by subject
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		fields := strings.Fields(args)
+		switch strings.ToUpper(op) {
+		case "CONNECT":
+			var options map[string]interface{}
+			json.Unmarshal([]byte(args), &options)
+			s.mu.Lock()
+			s.connects = append(s.connects, options)
+			s.mu.Unlock()
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "SUB":
+			subs[fields[0]] = fields[len(fields)-1]
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			data := make([]byte, size+2)
+			if _, err = io.ReadFull(r, data); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, message{fields[0], data[:size]})
+			s.mu.Unlock()
+			if len(fields) == 3 {
+				s.reply(conn, fields[0], fields[1], subs)
+			}
+		}
+	}
+}
+
+// reply answers a request on the subject as JetStream does
+func (s *server) reply(conn net.Conn, subject, inbox string, subs map[string]string) {
+	sid := ""
+	for pattern, id := range subs {
+		if strings.HasPrefix(inbox, strings.TrimSuffix(pattern, "*")) {
+			sid = id
+		}
+	}
+	switch {
+	case strings.HasPrefix(subject, "stored."):
+		ack := `{"stream":"INFINITY","seq":1}`
+		fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", inbox, sid, len(ack), ack)
+	case strings.HasPrefix(subject, "full."):
+		ack := `{"error":{"code":503,"err_code":10077,"description":"maximum messages exceeded"}}`
+		fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", inbox, sid, len(ack), ack)
+	default:
+		// No responders
+		header := "NATS/1.0 503\r\n\r\n"
+		fmt.Fprintf(conn, "HMSG %s %s %d %d\r\n%s\r\n", inbox, sid, len(header), len(header), header)
+	}
+}
+
+func (s *server) received() ([]map[string]interface{}, []message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connects, s.messages
+}
+
+func result(hash string, score float32) pipeline.Result {
+	return pipeline.Result{Submission: pipeline.Submission{Hash: hash, Path: "/tmp/sample file.exe"}, Response: infinigo.QueryResponse{GeneralScore: score}}
+}
+
+func TestPublish(t *testing.T) {
+	srv, addr := newServer(t)
+	s, err := New("nats://infinigo:secret@" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []pipeline.Result{result("abc", -1), result("def", 1)} {
+		if err = s.OnResult(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	connects, messages := srv.received()
+	if len(connects) != 1 || connects[0]["user"] != "infinigo" || connects[0]["pass"] != "secret" || connects[0]["name"] != "infinigo" {
+		t.Fatalf("Expected a single authenticated connection, got %v", connects)
+	}
+	if len(messages) != 2 || messages[0].subject != "infinity.verdict.malicious" || messages[1].subject != "infinity.verdict.benign" {
+		t.Fatalf("Expected the results to be published by verdict, got %v", messages)
+	}
+	var e siem.Event
+	if err = json.Unmarshal(messages[0].data, &e); err != nil || e.Hash != "abc" || e.Verdict != "malicious" {
+		t.Fatalf("Expected the event as JSON, got %s - %v", messages[0].data, err)
+	}
+}
+
+func TestJetStream(t *testing.T) {
+	_, addr := newServer(t)
+	tests := []struct {
+		subject string
+		err     string
+	}{
+		{"stored.{{.Verdict}}", ""},
+		{"full.{{.Verdict}}", "JetStream rejected the message on full.malicious - 503 maximum messages exceeded"},
+		{"lost.{{.Verdict}}", "No JetStream stream captures lost.malicious"},
+	}
+	for _, test := range tests {
+		s, err := New("nats://"+addr, SetSubject(test.subject), SetJetStream(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.timeout = time.Second
+		err = s.OnResult(context.Background(), result("abc", -1))
+		s.Close()
+		var ierr *infinigo.Error
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: expected the message to be acknowledged, got %v", test.subject, err)
+		case test.err != "" && (!errors.As(err, &ierr) || ierr.ID != "nats_error" || ierr.Details != test.err):
+			t.Errorf("%s: expected %s, got %v", test.subject, test.err, err)
+		}
+	}
+}
+
+func TestSubject(t *testing.T) {
+	e := siem.NewEvent(result("abc", -1), time.Now())
+	tests := []struct {
+		template, subject string
+	}{
+		{DefaultSubject, "infinity.verdict.malicious"},
+		{"files.{{.FileName}}", "files.sample_file.exe"},
+		{"files.{{.Hash}}.{{.ConfirmCode}}", ""},
+		{"files..{{.Hash}}", ""},
+	}
+	for _, test := range tests {
+		s, err := New("nats://127.0.0.1:4222", SetSubject(test.template))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subject, err := s.Subject(e); subject != test.subject || (test.subject == "") != (err != nil) {
+			t.Errorf("%s: expected [%s], got [%s] - %v", test.template, test.subject, subject, err)
+		}
+	}
+	if _, err := New("http://nats:4222"); err == nil {
+		t.Fatal("Expected a URL that is not of NATS to be refused")
+	}
+}
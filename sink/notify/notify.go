@@ -0,0 +1,126 @@
+/*
+Package notify has the rules shared by the chat notification sinks, which results are worth a message,
+where the message goes and how many messages are sent, so a scan finding hundreds of files does not
+flood a channel.
+*/
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// DefaultVerdicts are the verdicts notified by default
+var DefaultVerdicts = []infinigo.Verdict{infinigo.VerdictMalicious, infinigo.VerdictSuspicious}
+
+// Rules decide which results are notified, where and how often
+type Rules struct {
+	Verdicts   []infinigo.Verdict          // Verdicts notified, DefaultVerdicts if empty
+	MaxScore   float32                     // MaxScore notified, e.g. -0.9 for confident findings only. 0 for any score.
+	Routes     map[infinigo.Verdict]string // Routes results with a verdict to another destination than the default one
+	Rate       int                         // Rate is the most messages sent per RatePeriod, 0 for no limit
+	RatePeriod time.Duration               // RatePeriod of the rate, a minute if 0
+	ReportURL  string                      // ReportURL links to the report of a hash with {hash} replaced, e.g. http://infinigo:8080/query?h={hash}
+}
+
+// Validate returns an error if the rules are inconsistent
+func (rules Rules) Validate() error {
+	if rules.MaxScore < -1 || rules.MaxScore > 1 {
+		return &infinigo.Error{ID: "bad_option", Details: "Notification score must be between -1 and 1"}
+	}
+	if rules.Rate < 0 || rules.RatePeriod < 0 {
+		return &infinigo.Error{ID: "bad_option", Details: "Notification rate must not be negative"}
+	}
+	return nil
+}
+
+// Match returns whether the result is worth a notification
+func (rules Rules) Match(r pipeline.Result) bool {
+	verdicts := rules.Verdicts
+	if len(verdicts) == 0 {
+		verdicts = DefaultVerdicts
+	}
+	for _, v := range verdicts {
+		if r.Response.Verdict() == v {
+			return rules.MaxScore == 0 || r.Response.GeneralScore <= rules.MaxScore
+		}
+	}
+	return false
+}
+
+// Route returns the destination of the result, or def if it has no route
+func (rules Rules) Route(r pipeline.Result, def string) string {
+	if dest, ok := rules.Routes[r.Response.Verdict()]; ok && dest != "" {
+		return dest
+	}
+	return def
+}
+
+// Report returns the link to the report of the hash, or an empty string without a ReportURL
+func (rules Rules) Report(hash string) string {
+	if rules.ReportURL == "" {
+		return ""
+	}
+	return strings.ReplaceAll(rules.ReportURL, "{hash}", strings.ToLower(hash))
+}
+
+// ParseRoute parses a route given as verdict=destination, e.g. malicious=#soc-critical
+func ParseRoute(route string) (infinigo.Verdict, string, error) {
+	verdict, dest, ok := strings.Cut(route, "=")
+	if !ok || dest == "" {
+		return "", "", &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad route [%s], expected verdict=destination", route)}
+	}
+	return infinigo.Verdict(strings.ToLower(strings.TrimSpace(verdict))), strings.TrimSpace(dest), nil
+}
+
+// Limiter limits the messages to the rate of the rules, counting the ones it suppressed
+type Limiter struct {
+	mu         sync.Mutex
+	rate       int
+	period     time.Duration
+	start      time.Time
+	sent       int
+	suppressed int
+}
+
+// NewLimiter creates a limiter for the rate of the rules
+func NewLimiter(rules Rules) *Limiter {
+	l := &Limiter{rate: rules.Rate, period: rules.RatePeriod}
+	if l.period == 0 {
+		l.period = time.Minute
+	}
+	return l
+}
+
+// Allow returns whether a message can be sent now and, if it can, how many messages were suppressed
+// since the last one sent so the message can mention them
+func (l *Limiter) Allow() (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate > 0 {
+		now := time.Now()
+		if now.Sub(l.start) >= l.period {
+			l.start, l.sent = now, 0
+		}
+		if l.sent >= l.rate {
+			l.suppressed++
+			return false, 0
+		}
+		l.sent++
+	}
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
+
+// Suppressed returns how many messages were suppressed since the last one sent
+func (l *Limiter) Suppressed() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.suppressed
+}
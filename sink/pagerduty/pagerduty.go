@@ -0,0 +1,252 @@
+/*
+Package pagerduty is a pipeline sink triggering PagerDuty incidents through the Events API v2 when
+high-confidence malicious files are found on critical paths or hosts.
+
+The dedup key of an event is derived from the hash and the host, so finding the same file again on
+the same host adds to the open incident instead of paging again.
+*/
+package pagerduty
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/internal/backoff"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/policy"
+	"github.com/demisto/infinigo/siem"
+)
+
+const (
+	DefaultURL      = "https://events.pagerduty.com/v2/enqueue" // DefaultURL of the Events API v2
+	DefaultMaxScore = -0.9                                      // DefaultMaxScore of the results triggering events
+	DefaultRetries  = 3                                         // DefaultRetries of an event that failed
+)
+
+// Sink triggers PagerDuty events for malicious results
+type Sink struct {
+	routingKey string
+	url        string
+	c          *http.Client
+	maxScore   float32
+	paths      []*regexp.Regexp
+	hosts      []string
+	reportURL  string
+	retries    int
+	backoff    time.Duration
+}
+
+// OptionFunc is a function that configures a Sink.
+// It is used in New
+type OptionFunc func(*Sink) error
+
+// New creates a sink triggering events with the routing key of a PagerDuty service integration
+func New(routingKey string, options ...OptionFunc) (*Sink, error) {
+	if routingKey == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "PagerDuty routing key is required"}
+	}
+	s := &Sink{routingKey: routingKey, url: DefaultURL, c: http.DefaultClient, maxScore: DefaultMaxScore, retries: DefaultRetries, backoff: time.Second}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SetHTTPClient sets the client posting to the Events API, e.g. to go through the proxy of a network
+// without direct access to PagerDuty. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(s *Sink) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		s.c = c
+		return nil
+	}
+}
+
+// SetURL sets the URL events are sent to. It is DefaultURL by default.
+func SetURL(url string) OptionFunc {
+	return func(s *Sink) error {
+		if url == "" {
+			return &infinigo.Error{ID: "bad_option", Details: "PagerDuty URL is required"}
+		}
+		s.url = url
+		return nil
+	}
+}
+
+// SetMaxScore sets the score at or below which malicious results trigger events. It is DefaultMaxScore by default.
+func SetMaxScore(score float32) OptionFunc {
+	return func(s *Sink) error {
+		if score < -1 || score > infinigo.MaliciousThreshold {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("PagerDuty score must be between -1 and %v", infinigo.MaliciousThreshold)}
+		}
+		s.maxScore = score
+		return nil
+	}
+}
+
+// SetPaths limits the events to files matching one of the glob patterns, with ** matching directories,
+// e.g. /srv/** or C:/Windows/System32/**. Files on any path trigger events by default.
+func SetPaths(patterns ...string) OptionFunc {
+	return func(s *Sink) error {
+		for _, pattern := range patterns {
+			re, err := policy.GlobRegexp(pattern)
+			if err != nil {
+				return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad path [%s] - %v", pattern, err)}
+			}
+			s.paths = append(s.paths, re)
+		}
+		return nil
+	}
+}
+
+// SetHosts limits the events to results observed on hosts matching one of the patterns, e.g. db-*,
+// so one configuration can be deployed everywhere. Results on any host trigger events by default.
+func SetHosts(patterns ...string) OptionFunc {
+	return func(s *Sink) error {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad host [%s] - %v", pattern, err)}
+			}
+			s.hosts = append(s.hosts, strings.ToLower(pattern))
+		}
+		return nil
+	}
+}
+
+// SetReportURL links events to the report of the hash at the URL with {hash} replaced
+func SetReportURL(url string) OptionFunc {
+	return func(s *Sink) error {
+		s.reportURL = url
+		return nil
+	}
+}
+
+// SetRetries sets how many times a failed event is sent again, doubling the delay from a second.
+// It is DefaultRetries by default.
+func SetRetries(retries int) OptionFunc {
+	return func(s *Sink) error {
+		if retries < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Retries must not be negative"}
+		}
+		s.retries = retries
+		return nil
+	}
+}
+
+// DedupKey returns the dedup key of the events about the hash on the host
+func DedupKey(hash, host string) string {
+	return fmt.Sprintf("infinigo:%s:%s", strings.ToLower(hash), strings.ToLower(host))
+}
+
+// OnResult triggers an event if the result is a high-confidence malicious file on a critical path and host
+func (s *Sink) OnResult(ctx context.Context, r pipeline.Result) error {
+	e := siem.NewEvent(r, time.Now())
+	if !s.matches(r, e.Host) {
+		return nil
+	}
+	body, err := json.Marshal(s.event(r, e))
+	if err != nil {
+		return err
+	}
+	return backoff.Retry(ctx, s.retries, s.backoff, func() (bool, error) {
+		return s.send(ctx, body)
+	})
+}
+
+// matches returns whether the result on the host triggers an event
+func (s *Sink) matches(r pipeline.Result, host string) bool {
+	if r.Response.Verdict() != infinigo.VerdictMalicious || r.Response.GeneralScore > s.maxScore {
+		return false
+	}
+	if len(s.paths) > 0 {
+		p := filepath.ToSlash(r.Submission.Path)
+		found := false
+		for _, re := range s.paths {
+			found = found || p != "" && re.MatchString(p)
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(s.hosts) > 0 {
+		found := false
+		for _, pattern := range s.hosts {
+			ok, _ := path.Match(pattern, strings.ToLower(host))
+			found = found || ok
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// event returns the trigger event of the result
+func (s *Sink) event(r pipeline.Result, e siem.Event) map[string]interface{} {
+	name := e.FileName
+	if name == "" {
+		name = e.Hash
+	}
+	summary := fmt.Sprintf("%s %s on %s (score %v)", siem.Name(r), name, e.Host, e.Score)
+	if len(summary) > 1024 {
+		summary = summary[:1021] + "..."
+	}
+	payload := map[string]interface{}{
+		"summary":        summary,
+		"source":         e.Host,
+		"severity":       "critical",
+		"timestamp":      e.Time.Format(time.RFC3339),
+		"group":          "infinigo",
+		"class":          e.Verdict,
+		"custom_details": e,
+	}
+	if e.Path != "" {
+		payload["component"] = e.Path
+	}
+	event := map[string]interface{}{
+		"routing_key":  s.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    DedupKey(e.Hash, e.Host),
+		"client":       "infinigo",
+		"payload":      payload,
+	}
+	if s.reportURL != "" {
+		report := strings.ReplaceAll(s.reportURL, "{hash}", strings.ToLower(e.Hash))
+		event["links"] = []map[string]string{{"href": report, "text": "Infinity report"}}
+	}
+	return event
+}
+
+// send posts the event and returns whether a failure is worth retrying
+func (s *Sink) send(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("PagerDuty returned %d - %s", resp.StatusCode, bytes.TrimSpace(msg))}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
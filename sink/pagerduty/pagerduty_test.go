@@ -0,0 +1,135 @@
+package pagerduty
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// eventsAPI is a fake Events API v2 keeping the events it accepted, answering the first requests with
+// the statuses in fail
+type eventsAPI struct {
+	mu     sync.Mutex
+	events []map[string]interface{}
+	fail   []int
+	calls  int
+}
+
+func newEventsAPI(t *testing.T, fail ...int) (*eventsAPI, string) {
+	t.Helper()
+	api := &eventsAPI{fail: fail}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		api.calls++
+		if len(api.fail) > 0 {
+			w.WriteHeader(api.fail[0])
+			api.fail = api.fail[1:]
+			return
+		}
+		var event map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		api.events = append(api.events, event)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status":"success","message":"Event processed","dedup_key":"x"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return api, srv.URL + "/v2/enqueue"
+}
+
+func (api *eventsAPI) received() ([]map[string]interface{}, int) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.events, api.calls
+}
+
+func result(hash, path string, score float32) pipeline.Result {
+	return pipeline.Result{Submission: pipeline.Submission{Hash: hash, Path: path}, Response: infinigo.QueryResponse{GeneralScore: score}}
+}
+
+func TestOnResult(t *testing.T) {
+	api, url := newEventsAPI(t)
+	host, _ := os.Hostname()
+	s, err := New("routing", SetURL(url), SetPaths("/srv/**"), SetHosts("*"), SetReportURL("https://infinigo.example.com/query?h={hash}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []pipeline.Result{
+		result("ABC", "/srv/app/bin/sample", -1),
+		result("def", "/tmp/sample", -1),       // not on a critical path
+		result("ghi", "/srv/app/sample", -0.6), // not confident enough
+		result("jkl", "/srv/app/sample", 1),
+	} {
+		if err = s.OnResult(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, _ := api.received()
+	if len(events) != 1 {
+		t.Fatalf("Expected a single event, got %v", events)
+	}
+	e := events[0]
+	if e["routing_key"] != "routing" || e["event_action"] != "trigger" || e["dedup_key"] != DedupKey("abc", host) {
+		t.Fatalf("Expected a trigger event deduplicated by hash and host, got %v", e)
+	}
+	payload := e["payload"].(map[string]interface{})
+	if payload["severity"] != "critical" || payload["component"] != "/srv/app/bin/sample" || payload["source"] != host {
+		t.Fatalf("Expected a critical event about the file on this host, got %v", payload)
+	}
+	links := e["links"].([]interface{})
+	if links[0].(map[string]interface{})["href"] != "https://infinigo.example.com/query?h=abc" {
+		t.Fatalf("Expected a link to the report, got %v", links)
+	}
+}
+
+func TestOnResultHosts(t *testing.T) {
+	api, url := newEventsAPI(t)
+	s, err := New("routing", SetURL(url), SetHosts("no-such-host-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.OnResult(context.Background(), result("abc", "/srv/sample", -1)); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := api.received(); len(events) != 0 {
+		t.Fatalf("Expected results on other hosts not to trigger events, got %v", events)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	api, url := newEventsAPI(t, http.StatusTooManyRequests, http.StatusInternalServerError)
+	s, err := New("routing", SetURL(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.backoff = time.Millisecond
+	if err = s.OnResult(context.Background(), result("abc", "", -1)); err != nil {
+		t.Fatal(err)
+	}
+	if events, calls := api.received(); len(events) != 1 || calls != 3 {
+		t.Fatalf("Expected the event to be sent on the third attempt, got %d attempts", calls)
+	}
+
+	api, url = newEventsAPI(t, http.StatusBadRequest)
+	if s, err = New("routing", SetURL(url)); err != nil {
+		t.Fatal(err)
+	}
+	var ierr *infinigo.Error
+	if err = s.OnResult(context.Background(), result("abc", "", -1)); !errors.As(err, &ierr) || ierr.ID != "http_error" {
+		t.Fatalf("Expected the invalid event to fail, got %v", err)
+	}
+	if _, calls := api.received(); calls != 1 {
+		t.Fatalf("Expected an invalid event not to be retried, got %d attempts", calls)
+	}
+}
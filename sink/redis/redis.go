@@ -0,0 +1,151 @@
+/*
+Package redis is a pipeline sink publishing every result to a Redis channel, or appending it to a
+Redis stream, so lightweight subscribers such as dashboards and bots can react in real time.
+
+The message is the siem.Event of the result as JSON. Stream entries have the fields hash, verdict and
+event, the latter holding the JSON, so consumers can filter without decoding it. Connections are opened
+on first use, authenticated and switched to the database if set, and commands are retried once on a new
+connection if sending fails.
+*/
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/siem"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultChannel results are published to
+const DefaultChannel = "infinity:results"
+
+// Sink publishes results to Redis
+type Sink struct {
+	addr      string
+	tlsConfig *tls.Config
+	user      string
+	password  string
+	db        int
+	channel   string
+	stream    string
+	maxLen    int
+	timeout   time.Duration
+	client    *redis.Client
+}
+
+// OptionFunc is a function that configures a Sink.
+// It is used in New
+type OptionFunc func(*Sink) error
+
+// New creates a sink for the Redis server at addr, e.g. redis.example.com:6379
+func New(addr string, options ...OptionFunc) (*Sink, error) {
+	if addr == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Redis address is required"}
+	}
+	s := &Sink{addr: addr, channel: DefaultChannel, timeout: 10 * time.Second}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	s.client = redis.NewClient(&redis.Options{
+		Addr:         s.addr,
+		Username:     s.user,
+		Password:     s.password,
+		DB:           s.db,
+		TLSConfig:    s.tlsConfig,
+		DialTimeout:  s.timeout,
+		ReadTimeout:  s.timeout,
+		WriteTimeout: s.timeout,
+		MaxRetries:   1,
+	})
+	return s, nil
+}
+
+// SetTLSConfig connects with TLS with the configuration, e.g. to trust a private CA. Connections are not encrypted by default.
+func SetTLSConfig(config *tls.Config) OptionFunc {
+	return func(s *Sink) error {
+		if config == nil {
+			config = &tls.Config{}
+		}
+		s.tlsConfig = config
+		return nil
+	}
+}
+
+// SetAuth authenticates with the password, as the ACL user if not empty
+func SetAuth(user, password string) OptionFunc {
+	return func(s *Sink) error {
+		s.user, s.password = user, password
+		return nil
+	}
+}
+
+// SetDB selects the database of the stream. It is 0 by default, and does not matter to channels.
+func SetDB(db int) OptionFunc {
+	return func(s *Sink) error {
+		if db < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Redis database must not be negative"}
+		}
+		s.db = db
+		return nil
+	}
+}
+
+// SetChannel sets the channel results are published to. It is DefaultChannel by default.
+func SetChannel(channel string) OptionFunc {
+	return func(s *Sink) error {
+		if channel == "" {
+			return &infinigo.Error{ID: "bad_option", Details: "Redis channel is required"}
+		}
+		s.channel, s.stream = channel, ""
+		return nil
+	}
+}
+
+// SetStream appends results to the stream instead of publishing them to a channel, trimming it to about
+// maxLen entries if positive, so subscribers that were away can catch up
+func SetStream(stream string, maxLen int) OptionFunc {
+	return func(s *Sink) error {
+		if stream == "" {
+			return &infinigo.Error{ID: "bad_option", Details: "Redis stream is required"}
+		}
+		s.stream, s.maxLen = stream, maxLen
+		return nil
+	}
+}
+
+// OnResult publishes the result
+func (s *Sink) OnResult(ctx context.Context, r pipeline.Result) error {
+	e := siem.NewEvent(r, time.Now())
+	event, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if s.stream == "" {
+		err = s.client.Publish(ctx, s.channel, event).Err()
+	} else {
+		err = s.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: s.stream,
+			MaxLen: int64(s.maxLen),
+			Approx: true,
+			Values: []string{"hash", e.Hash, "verdict", e.Verdict, "event", string(event)},
+		}).Err()
+	}
+	var reply redis.Error
+	if errors.As(err, &reply) {
+		return &infinigo.Error{ID: "redis_error", Details: reply.Error()}
+	}
+	return err
+}
+
+// Close closes the connections to the server
+func (s *Sink) Close() error {
+	return s.client.Close()
+}
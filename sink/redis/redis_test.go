@@ -0,0 +1,175 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// server is a fake Redis server speaking RESP2, keeping the PUBLISH and XADD commands it was sent.
+// The key "wrongtype" is not a stream.
+type server struct {
+	mu       sync.Mutex
+	commands [][]string
+}
+
+func newServer(t *testing.T) (*server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &server{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, l.Addr().String()
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authenticated := false
+	for {
+		cmd, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		reply := "+OK"
+		switch strings.ToUpper(cmd[0]) {
+		case "HELLO":
+			reply = "-ERR unknown command 'HELLO'"
+		case "AUTH":
+			if authenticated = len(cmd) == 3 && cmd[1] == "infinigo" && cmd[2] == "secret"; !authenticated {
+				reply = "-WRONGPASS invalid username-password pair or user is disabled."
+			}
+		case "CLIENT", "SELECT":
+		case "PUBLISH", "XADD":
+			switch {
+			case !authenticated:
+				reply = "-NOAUTH Authentication required."
+			case cmd[1] == "wrongtype":
+				reply = "-WRONGTYPE Operation against a key holding the wrong kind of value"
+			case strings.EqualFold(cmd[0], "PUBLISH"):
+				reply = ":1"
+			default:
+				reply = "$3\r\n1-0"
+			}
+			s.mu.Lock()
+			s.commands = append(s.commands, cmd)
+			s.mu.Unlock()
+		default:
+			reply = fmt.Sprintf("-ERR unknown command '%s'", cmd[0])
+		}
+		if _, err = io.WriteString(conn, reply+"\r\n"); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("bad command %q - %v", line, err)
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	cmd := make([]string, n)
+	for i := range cmd {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		b := make([]byte, size+2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		cmd[i] = string(b[:size])
+	}
+	return cmd, nil
+}
+
+func (s *server) received() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands
+}
+
+var malicious = pipeline.Result{
+	Submission: pipeline.Submission{Hash: "abc", Path: "/tmp/sample.exe"},
+	Response:   infinigo.QueryResponse{GeneralScore: -1},
+}
+
+func TestPublish(t *testing.T) {
+	srv, addr := newServer(t)
+	s, err := New(addr, SetAuth("infinigo", "secret"), SetDB(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.OnResult(context.Background(), malicious); err != nil {
+		t.Fatal(err)
+	}
+	commands := srv.received()
+	if len(commands) != 1 || commands[0][0] != "publish" || commands[0][1] != DefaultChannel {
+		t.Fatalf("Expected the result to be published to the default channel, got %v", commands)
+	}
+	var e map[string]interface{}
+	if err = json.Unmarshal([]byte(commands[0][2]), &e); err != nil || e["hash"] != "abc" || e["verdict"] != "malicious" {
+		t.Fatalf("Expected the event as JSON, got %s - %v", commands[0][2], err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	srv, addr := newServer(t)
+	s, err := New(addr, SetAuth("infinigo", "secret"), SetStream("infinity:stream", 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.OnResult(context.Background(), malicious); err != nil {
+		t.Fatal(err)
+	}
+	cmd := srv.received()[0]
+	if strings.Join(cmd[:9], " ") != "xadd infinity:stream maxlen ~ 1000 * hash abc verdict" || cmd[9] != "malicious" || cmd[10] != "event" {
+		t.Fatalf("Expected the result to be added to the trimmed stream, got %v", cmd)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, addr := newServer(t)
+	s, err := New(addr, SetAuth("infinigo", "secret"), SetStream("wrongtype", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var ierr *infinigo.Error
+	if err = s.OnResult(context.Background(), malicious); !errors.As(err, &ierr) || ierr.ID != "redis_error" || !strings.HasPrefix(ierr.Details, "WRONGTYPE") {
+		t.Fatalf("Expected the reply error, got %v", err)
+	}
+	if s, err = New(addr, SetAuth("infinigo", "wrong")); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.OnResult(context.Background(), malicious); !errors.As(err, &ierr) || ierr.ID != "redis_error" || !strings.HasPrefix(ierr.Details, "WRONGPASS") {
+		t.Fatalf("Expected the wrong password to be refused, got %v", err)
+	}
+}
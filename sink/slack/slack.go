@@ -0,0 +1,227 @@
+/*
+Package slack is a pipeline sink posting a message to Slack when a scan finds malicious or suspicious
+files, with the file name, hash, score and a link to the report of the hash.
+
+Messages are posted to an incoming webhook, or to a channel with a bot token. Which results are
+notified, where and how often follows notify.Rules, and messages over the rate are suppressed and
+counted in the next message, or in a summary posted by Close.
+*/
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/siem"
+	"github.com/demisto/infinigo/sink/notify"
+)
+
+// DefaultAPIURL is the Slack Web API used with a bot token
+const DefaultAPIURL = "https://slack.com/api/"
+
+// Sink posts messages about results to Slack
+type Sink struct {
+	dest    string
+	token   string
+	apiURL  string
+	c       *http.Client
+	rules   notify.Rules
+	limiter *notify.Limiter
+}
+
+// OptionFunc is a function that configures a Sink.
+// It is used in New
+type OptionFunc func(*Sink) error
+
+// New creates a sink posting to dest, an incoming webhook URL, or a channel such as #security with SetToken
+func New(dest string, options ...OptionFunc) (*Sink, error) {
+	if dest == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Slack webhook URL or channel is required"}
+	}
+	s := &Sink{dest: dest, apiURL: DefaultAPIURL, c: http.DefaultClient}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.token == "" && !isURL(dest) {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Slack bot token is required to post to a channel"}
+	}
+	s.limiter = notify.NewLimiter(s.rules)
+	return s, nil
+}
+
+// SetHTTPClient sets the client posting to the Slack webhook, e.g. to go through the egress proxy of
+// the network. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(s *Sink) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		s.c = c
+		return nil
+	}
+}
+
+// SetToken sets the bot token posting with chat.postMessage, so destinations are channels instead of webhooks
+func SetToken(token string) OptionFunc {
+	return func(s *Sink) error {
+		s.token = token
+		return nil
+	}
+}
+
+// SetAPIURL sets the URL of the Slack Web API. It is DefaultAPIURL by default.
+func SetAPIURL(url string) OptionFunc {
+	return func(s *Sink) error {
+		if url == "" {
+			return &infinigo.Error{ID: "bad_option", Details: "Slack API URL is required"}
+		}
+		s.apiURL = strings.TrimSuffix(url, "/") + "/"
+		return nil
+	}
+}
+
+// SetRules sets which results are notified, where and how often. Malicious and suspicious results are
+// notified to the destination of New without a limit by default.
+func SetRules(rules notify.Rules) OptionFunc {
+	return func(s *Sink) error {
+		if err := rules.Validate(); err != nil {
+			return err
+		}
+		s.rules = rules
+		return nil
+	}
+}
+
+// OnResult posts a message about the result if it matches the rules and the rate allows it
+func (s *Sink) OnResult(ctx context.Context, r pipeline.Result) error {
+	if !s.rules.Match(r) {
+		return nil
+	}
+	ok, suppressed := s.limiter.Allow()
+	if !ok {
+		return nil
+	}
+	return s.post(ctx, s.rules.Route(r, s.dest), s.message(r, suppressed))
+}
+
+// Close posts how many messages were suppressed since the last one, if any
+func (s *Sink) Close() error {
+	n := s.limiter.Suppressed()
+	if n == 0 {
+		return nil
+	}
+	text := fmt.Sprintf("%d more findings were not notified because of the rate limit", n)
+	return s.post(context.Background(), s.dest, map[string]interface{}{"text": text})
+}
+
+// message returns the message about the result
+func (s *Sink) message(r pipeline.Result, suppressed int) map[string]interface{} {
+	e := siem.NewEvent(r, time.Now())
+	name := e.FileName
+	if name == "" {
+		name = e.Hash
+	}
+	title := fmt.Sprintf("%s %s", siem.Name(r), name)
+	if len(title) > 150 {
+		title = title[:147] + "..."
+	}
+	fields := []map[string]string{
+		mrkdwn(fmt.Sprintf("*Hash*\n`%s`", escape(e.Hash))),
+		mrkdwn(fmt.Sprintf("*Score*\n%v", e.Score)),
+	}
+	if e.Host != "" {
+		fields = append(fields, mrkdwn("*Host*\n"+escape(e.Host)))
+	}
+	if e.Path != "" {
+		fields = append(fields, mrkdwn(fmt.Sprintf("*Path*\n`%s`", escape(e.Path))))
+	}
+	blocks := []interface{}{
+		map[string]interface{}{"type": "header", "text": map[string]string{"type": "plain_text", "text": title}},
+		map[string]interface{}{"type": "section", "fields": fields},
+	}
+	if report := s.rules.Report(e.Hash); report != "" {
+		blocks = append(blocks, map[string]interface{}{"type": "actions", "elements": []interface{}{map[string]interface{}{
+			"type": "button", "text": map[string]string{"type": "plain_text", "text": "View report"}, "url": report,
+		}}})
+	}
+	if suppressed > 0 {
+		blocks = append(blocks, map[string]interface{}{"type": "context", "elements": []interface{}{
+			mrkdwn(fmt.Sprintf("%d more findings were not notified because of the rate limit", suppressed)),
+		}})
+	}
+	text := fmt.Sprintf("%s (score %v)", title, e.Score)
+	if e.Host != "" {
+		text = fmt.Sprintf("%s on %s (score %v)", title, e.Host, e.Score)
+	}
+	return map[string]interface{}{"text": text, "blocks": blocks}
+}
+
+// post sends the message to the destination
+func (s *Sink) post(ctx context.Context, dest string, msg map[string]interface{}) error {
+	url := dest
+	if !isURL(dest) {
+		url = s.apiURL + "chat.postMessage"
+		msg["channel"] = dest
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if url != dest {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("Slack returned %d - %s", resp.StatusCode, bytes.TrimSpace(body))}
+	}
+	if url == dest {
+		return nil
+	}
+	// The Web API reports errors in the body of successful responses
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return &infinigo.Error{ID: "bad_response", Details: fmt.Sprintf("Slack response - %v", err)}
+	}
+	if !result.OK {
+		return &infinigo.Error{ID: "bad_response", Details: fmt.Sprintf("Slack failed posting to %s - %s", dest, result.Error)}
+	}
+	return nil
+}
+
+// isURL returns whether the destination is a webhook URL rather than a channel
+func isURL(dest string) bool {
+	return strings.HasPrefix(dest, "https://") || strings.HasPrefix(dest, "http://")
+}
+
+// mrkdwn returns a text object formatted with Slack markup
+func mrkdwn(text string) map[string]string {
+	return map[string]string{"type": "mrkdwn", "text": text}
+}
+
+// escape escapes the characters Slack markup reserves
+func escape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
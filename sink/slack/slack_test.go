@@ -0,0 +1,127 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/sink/notify"
+)
+
+// slack is a fake Slack with an incoming webhook at /hook and the Web API at /api/, keeping the
+// messages posted by destination
+type slack struct {
+	mu       sync.Mutex
+	messages map[string][]map[string]interface{}
+}
+
+func newSlack(t *testing.T) (*slack, string) {
+	t.Helper()
+	s := &slack{messages: map[string][]map[string]interface{}{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Error(err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.URL.Path {
+		case "/hook":
+			s.messages["hook"] = append(s.messages["hook"], msg)
+			w.Write([]byte("ok"))
+		case "/api/chat.postMessage":
+			channel, _ := msg["channel"].(string)
+			switch {
+			case r.Header.Get("Authorization") != "Bearer xoxb-token":
+				w.Write([]byte(`{"ok":false,"error":"invalid_auth"}`))
+			case channel == "#missing":
+				w.Write([]byte(`{"ok":false,"error":"channel_not_found"}`))
+			default:
+				s.messages[channel] = append(s.messages[channel], msg)
+				w.Write([]byte(`{"ok":true}`))
+			}
+		default:
+			http.Error(w, "no_service", http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return s, srv.URL
+}
+
+func (s *slack) posted(dest string) []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messages[dest]
+}
+
+func result(hash string, score float32) pipeline.Result {
+	return pipeline.Result{Submission: pipeline.Submission{Hash: hash, Path: "/tmp/<b>.exe"}, Response: infinigo.QueryResponse{GeneralScore: score}}
+}
+
+func TestWebhook(t *testing.T) {
+	fake, url := newSlack(t)
+	s, err := New(url+"/hook", SetRules(notify.Rules{Rate: 1, RatePeriod: time.Hour, ReportURL: "https://infinigo.example.com/query?h={hash}"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []pipeline.Result{result("ABC", -1), result("def", 1), result("ghi", -0.3), result("jkl", -1)} {
+		if err = s.OnResult(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The benign result is not notified, and the rate allows a single message
+	messages := fake.posted("hook")
+	if len(messages) != 2 {
+		t.Fatalf("Expected a message and the summary, got %v", messages)
+	}
+	var b strings.Builder
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	e.Encode(messages[0])
+	for _, want := range []string{"`ABC`", "/tmp/&lt;b&gt;.exe", "https://infinigo.example.com/query?h=abc"} {
+		if !strings.Contains(b.String(), want) {
+			t.Fatalf("Expected the message to contain %q, got %s", want, b.String())
+		}
+	}
+	if messages[1]["text"] != "2 more findings were not notified because of the rate limit" {
+		t.Fatalf("Expected the suppressed messages to be counted, got %v", messages[1])
+	}
+}
+
+func TestChannels(t *testing.T) {
+	fake, url := newSlack(t)
+	rules := notify.Rules{Routes: map[infinigo.Verdict]string{infinigo.VerdictMalicious: "#soc-critical"}}
+	s, err := New("#security", SetToken("xoxb-token"), SetAPIURL(url+"/api"), SetRules(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []pipeline.Result{result("abc", -1), result("def", -0.3)} {
+		if err = s.OnResult(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(fake.posted("#soc-critical")) != 1 || len(fake.posted("#security")) != 1 {
+		t.Fatalf("Expected the malicious result to be routed, got %v", fake.messages)
+	}
+	var ierr *infinigo.Error
+	if s, err = New("#missing", SetToken("xoxb-token"), SetAPIURL(url+"/api")); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.OnResult(context.Background(), result("abc", -1)); !errors.As(err, &ierr) || ierr.ID != "bad_response" || !strings.Contains(ierr.Details, "channel_not_found") {
+		t.Fatalf("Expected the error of the API to be reported, got %v", err)
+	}
+	if _, err = New("#security"); !errors.As(err, &ierr) || ierr.ID != "missing_arg" {
+		t.Fatalf("Expected a channel to require a token, got %v", err)
+	}
+}
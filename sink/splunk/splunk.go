@@ -0,0 +1,247 @@
+/*
+Package splunk is a pipeline sink posting results to a Splunk HTTP Event Collector.
+
+Results are sent as siem.Event JSON events in batches, once a batch is full or it waited for the
+flush interval, and Close sends the last batch. Batches are retried with backoff when Splunk is
+unreachable or busy.
+*/
+package splunk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/internal/backoff"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/siem"
+)
+
+const (
+	DefaultSourceType    = "infinigo:result" // DefaultSourceType of the events
+	DefaultBatchSize     = 100               // DefaultBatchSize is the number of events sent in a request
+	DefaultFlushInterval = 5 * time.Second   // DefaultFlushInterval is how long events wait for a batch to fill
+	DefaultRetries       = 3                 // DefaultRetries of a batch that failed
+)
+
+// eventPath is the path of the event endpoint of the collector
+const eventPath = "/services/collector/event"
+
+// Sink posts results to a Splunk HTTP Event Collector
+type Sink struct {
+	url        string
+	token      string
+	index      string
+	sourceType string
+	c          *http.Client
+	batchSize  int
+	interval   time.Duration
+	retries    int
+	backoff    time.Duration
+	errorlog   *log.Logger
+	mu         sync.Mutex
+	batch      []byte
+	n          int
+	timer      *time.Timer
+	sending    sync.WaitGroup // sending are the batches being sent after the flush interval, waited for by Close
+}
+
+// OptionFunc is a function that configures a Sink.
+// It is used in New
+type OptionFunc func(*Sink) error
+
+// New creates a sink for the collector at url, e.g. https://splunk.example.com:8088, with the given token
+func New(url, token string, options ...OptionFunc) (*Sink, error) {
+	if url == "" || token == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Splunk HEC URL and token are required"}
+	}
+	url = strings.TrimSuffix(url, "/")
+	if !strings.Contains(url, "/services/collector") {
+		url += eventPath
+	}
+	s := &Sink{
+		url:        url,
+		token:      token,
+		sourceType: DefaultSourceType,
+		c:          http.DefaultClient,
+		batchSize:  DefaultBatchSize,
+		interval:   DefaultFlushInterval,
+		retries:    DefaultRetries,
+		backoff:    time.Second,
+	}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SetHTTPClient sets the client posting to the collector, e.g. to trust the self-signed certificate
+// HEC uses by default. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(s *Sink) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		s.c = c
+		return nil
+	}
+}
+
+// SetIndex sets the index of the events. The default index of the token is used by default.
+func SetIndex(index string) OptionFunc {
+	return func(s *Sink) error {
+		s.index = index
+		return nil
+	}
+}
+
+// SetSourceType sets the sourcetype of the events. It is DefaultSourceType by default.
+func SetSourceType(sourceType string) OptionFunc {
+	return func(s *Sink) error {
+		if sourceType == "" {
+			return &infinigo.Error{ID: "bad_option", Details: "Source type is required"}
+		}
+		s.sourceType = sourceType
+		return nil
+	}
+}
+
+// SetBatch sets the number of events sent in a request and how long events wait for a batch to fill.
+// They are DefaultBatchSize and DefaultFlushInterval by default.
+func SetBatch(size int, interval time.Duration) OptionFunc {
+	return func(s *Sink) error {
+		if size < 1 || interval <= 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Batch size and interval must be positive"}
+		}
+		s.batchSize, s.interval = size, interval
+		return nil
+	}
+}
+
+// SetRetries sets how many times a failed batch is sent again, doubling the delay from a second.
+// It is DefaultRetries by default.
+func SetRetries(retries int) OptionFunc {
+	return func(s *Sink) error {
+		if retries < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Retries must not be negative"}
+		}
+		s.retries = retries
+		return nil
+	}
+}
+
+// SetErrorLog sets the logger for batches that failed when sent after the flush interval. It is nil by default.
+func SetErrorLog(logger *log.Logger) OptionFunc {
+	return func(s *Sink) error {
+		s.errorlog = logger
+		return nil
+	}
+}
+
+// OnResult adds the result to the batch and sends the batch if it is full
+func (s *Sink) OnResult(ctx context.Context, r pipeline.Result) error {
+	now := time.Now()
+	e := siem.NewEvent(r, now)
+	event := map[string]interface{}{
+		"time":       float64(now.UnixMilli()) / 1000,
+		"host":       e.Host,
+		"source":     "infinigo",
+		"sourcetype": s.sourceType,
+		"event":      e,
+	}
+	if s.index != "" {
+		event["index"] = s.index
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.batch = append(s.batch, b...)
+	s.n++
+	if s.n >= s.batchSize {
+		batch, n := s.take()
+		s.mu.Unlock()
+		return s.flush(ctx, batch, n)
+	}
+	if s.timer == nil {
+		s.sending.Add(1)
+		s.timer = time.AfterFunc(s.interval, func() {
+			defer s.sending.Done()
+			s.mu.Lock()
+			batch, n := s.take()
+			s.mu.Unlock()
+			if err := s.flush(context.Background(), batch, n); err != nil && s.errorlog != nil {
+				s.errorlog.Printf("Failed sending results to Splunk - %v", err)
+			}
+		})
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Close sends the batch, after the batches being sent
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	batch, n := s.take()
+	s.mu.Unlock()
+	s.sending.Wait()
+	return s.flush(context.Background(), batch, n)
+}
+
+// take removes the batch and stops its timer. Must be called with the lock held.
+func (s *Sink) take() ([]byte, int) {
+	if s.timer != nil && s.timer.Stop() {
+		s.sending.Done()
+	}
+	s.timer = nil
+	batch, n := s.batch, s.n
+	s.batch, s.n = nil, 0
+	return batch, n
+}
+
+// flush sends the n events of the batch, retrying with backoff without holding the lock so results
+// keep being added. The batch is dropped if it still fails.
+func (s *Sink) flush(ctx context.Context, batch []byte, n int) error {
+	if n == 0 {
+		return nil
+	}
+	err := backoff.Retry(ctx, s.retries, s.backoff, func() (bool, error) {
+		return s.send(ctx, batch)
+	})
+	if err != nil {
+		return fmt.Errorf("dropped %d events - %w", n, err)
+	}
+	return nil
+}
+
+// send posts the events and returns whether a failure is worth retrying
+func (s *Sink) send(ctx context.Context, events []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(events))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Splunk "+s.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("Splunk returned %d - %s", resp.StatusCode, bytes.TrimSpace(msg))}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
@@ -0,0 +1,156 @@
+package splunk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// collector is a fake HTTP Event Collector keeping the batches it received, failing the first
+// requests with the statuses in fail
+type collector struct {
+	mu      sync.Mutex
+	batches [][]map[string]interface{}
+	fail    []int
+	calls   int
+}
+
+func newCollector(t *testing.T, fail ...int) (*collector, string) {
+	t.Helper()
+	c := &collector{fail: fail}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != eventPath || r.Header.Get("Authorization") != "Splunk token" {
+			t.Errorf("Unexpected request to %s with %v", r.URL.Path, r.Header)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.calls++
+		if len(c.fail) > 0 {
+			status := c.fail[0]
+			c.fail = c.fail[1:]
+			http.Error(w, `{"text":"Server is busy","code":9}`, status)
+			return
+		}
+		var batch []map[string]interface{}
+		d := json.NewDecoder(r.Body)
+		for {
+			var event map[string]interface{}
+			if err := d.Decode(&event); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Error(err)
+				break
+			}
+			batch = append(batch, event)
+		}
+		c.batches = append(c.batches, batch)
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	t.Cleanup(srv.Close)
+	return c, srv.URL
+}
+
+func (c *collector) received() [][]map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.batches
+}
+
+func (c *collector) attempts() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func result(hash string) pipeline.Result {
+	return pipeline.Result{Submission: pipeline.Submission{Hash: hash}, Response: infinigo.QueryResponse{GeneralScore: -1}}
+}
+
+func TestBatches(t *testing.T) {
+	c, url := newCollector(t)
+	s, err := New(url+"/", "token", SetBatch(2, time.Hour), SetIndex("security"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []string{"a", "b", "c"} {
+		if err = s.OnResult(context.Background(), result(hash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if batches := c.received(); len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("Expected a full batch to be sent, got %v", batches)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	batches := c.received()
+	if len(batches) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("Expected the last batch to be sent on close, got %v", batches)
+	}
+	e := batches[1][0]
+	if e["index"] != "security" || e["sourcetype"] != DefaultSourceType || e["source"] != "infinigo" {
+		t.Fatalf("Expected the index and source type to be set, got %v", e)
+	}
+	if event, _ := e["event"].(map[string]interface{}); event["hash"] != "c" || event["verdict"] != "malicious" {
+		t.Fatalf("Expected the result as the event, got %v", e["event"])
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	c, url := newCollector(t)
+	s, err := New(url, "token", SetBatch(100, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.OnResult(context.Background(), result("a")); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(c.received()) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the batch to be sent after the flush interval")
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if batches := c.received(); len(batches) != 1 {
+		t.Fatalf("Expected a single batch, got %v", batches)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	c, url := newCollector(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	s, err := New(url, "token", SetBatch(1, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.backoff = time.Millisecond
+	if err = s.OnResult(context.Background(), result("a")); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.received()) != 1 || c.attempts() != 3 {
+		t.Fatalf("Expected the batch to be sent on the third attempt, got %d attempts", c.attempts())
+	}
+
+	c, url = newCollector(t, http.StatusBadRequest)
+	if s, err = New(url, "token", SetBatch(1, time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	var ierr *infinigo.Error
+	if err = s.OnResult(context.Background(), result("a")); !errors.As(err, &ierr) || !strings.Contains(err.Error(), "dropped 1 events") {
+		t.Fatalf("Expected the batch to be dropped, got %v", err)
+	}
+	if c.attempts() != 1 {
+		t.Fatalf("Expected a bad request not to be retried, got %d attempts", c.attempts())
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Package syslog is a pipeline sink sending results to a syslog collector as RFC 5424 messages over UDP,
+TCP or TLS, for environments where syslog is the only accepted ingestion path.
+
+The message is a CEF record by default, or LEEF or JSON. The syslog severity is derived from the
+verdict, and TCP and TLS messages are framed with octet counting as described in RFC 5425.
+The connection is opened on first use and reopened once if sending fails.
+*/
+package syslog
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/siem"
+)
+
+// Severities of syslog messages
+const (
+	SeverityEmergency = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInfo
+	SeverityDebug
+)
+
+// facilities by name, see RFC 5424
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "ntp": 12, "security": 13, "console": 14,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Format converts a result observed at a time to the message of a syslog event, e.g. siem.CEF
+type Format func(r pipeline.Result, t time.Time) string
+
+// JSON formats the result as JSON
+func JSON(r pipeline.Result, t time.Time) string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+// Sink sends results to a syslog collector
+type Sink struct {
+	network    string
+	addr       string
+	tlsConfig  *tls.Config
+	facility   int
+	severities map[infinigo.Verdict]int
+	format     Format
+	hostname   string
+	appName    string
+	timeout    time.Duration
+	mu         sync.Mutex
+	conn       net.Conn
+}
+
+// OptionFunc is a function that configures a Sink.
+// It is used in New
+type OptionFunc func(*Sink) error
+
+// New creates a sink for the collector at addr, e.g. siem.example.com:514. network is udp, tcp or tls.
+func New(network, addr string, options ...OptionFunc) (*Sink, error) {
+	switch network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Unknown syslog network [%s], expected udp, tcp or tls", network)}
+	}
+	if addr == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Syslog address is required"}
+	}
+	s := &Sink{
+		network:  network,
+		addr:     addr,
+		facility: facilities["user"],
+		severities: map[infinigo.Verdict]int{
+			infinigo.VerdictMalicious:  SeverityCritical,
+			infinigo.VerdictSuspicious: SeverityWarning,
+			infinigo.VerdictError:      SeverityError,
+			infinigo.VerdictUnknown:    SeverityNotice,
+			infinigo.VerdictBenign:     SeverityInfo,
+		},
+		format:  siem.CEF,
+		appName: filepath.Base(os.Args[0]),
+		timeout: 10 * time.Second,
+	}
+	s.hostname, _ = os.Hostname()
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SetTLSConfig sets the TLS configuration of the tls network, e.g. to trust a private CA or present a client certificate
+func SetTLSConfig(config *tls.Config) OptionFunc {
+	return func(s *Sink) error {
+		s.tlsConfig = config
+		return nil
+	}
+}
+
+// SetFacility sets the facility by name, e.g. local0. It is user by default.
+func SetFacility(name string) OptionFunc {
+	return func(s *Sink) error {
+		facility, ok := facilities[name]
+		if !ok {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Unknown syslog facility [%s]", name)}
+		}
+		s.facility = facility
+		return nil
+	}
+}
+
+// SetSeverity sets the severity of the results with the verdict. By default malicious results are
+// critical, errors are errors, suspicious results are warnings, unknown ones notices and benign ones info.
+func SetSeverity(verdict infinigo.Verdict, severity int) OptionFunc {
+	return func(s *Sink) error {
+		if severity < SeverityEmergency || severity > SeverityDebug {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Syslog severity must be between %d and %d", SeverityEmergency, SeverityDebug)}
+		}
+		s.severities[verdict] = severity
+		return nil
+	}
+}
+
+// SetFormat sets the format of the messages. It is siem.CEF by default.
+func SetFormat(format Format) OptionFunc {
+	return func(s *Sink) error {
+		if format == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "Format is required"}
+		}
+		s.format = format
+		return nil
+	}
+}
+
+// SetAppName sets the APP-NAME of the messages. It is the name of the executable by default.
+func SetAppName(name string) OptionFunc {
+	return func(s *Sink) error {
+		s.appName = name
+		return nil
+	}
+}
+
+// OnResult sends the result as a syslog message
+func (s *Sink) OnResult(ctx context.Context, r pipeline.Result) error {
+	now := time.Now()
+	msg := s.message(r, now)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.send(ctx, msg)
+	if err != nil && s.conn != nil {
+		// The collector may have closed an idle connection, try again once with a new one
+		s.conn.Close()
+		s.conn = nil
+		err = s.send(ctx, msg)
+	}
+	return err
+}
+
+// Close closes the connection to the collector
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// message returns the RFC 5424 message of the result
+func (s *Sink) message(r pipeline.Result, t time.Time) []byte {
+	severity, ok := s.severities[r.Response.Verdict()]
+	if !ok {
+		severity = SeverityNotice
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", s.facility*8+severity, t.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		header(s.hostname, 255), header(s.appName, 48), os.Getpid(), header(string(r.Response.Verdict()), 32), s.format(r, t))
+	if s.network != "udp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	return []byte(msg)
+}
+
+// send writes the message, connecting first if needed
+func (s *Sink) send(ctx context.Context, msg []byte) error {
+	if s.conn == nil {
+		dialer := &net.Dialer{Timeout: s.timeout}
+		var err error
+		if s.network == "tls" {
+			s.conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.tlsConfig}).DialContext(ctx, "tcp", s.addr)
+		} else {
+			s.conn, err = dialer.DialContext(ctx, s.network, s.addr)
+		}
+		if err != nil {
+			s.conn = nil
+			return err
+		}
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+// header returns the value as a header field, printable ASCII without spaces of at most max characters or - if empty
+func header(value string, max int) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, value)
+	if len(value) > max {
+		value = value[:max]
+	}
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,154 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+const hash = "2d7ab0e8cfaf0fd1f2a2a3d2a1a0c4dc6e4a4cba8b19b1f0dfd1a8b0bcd8e1f0"
+
+func result(score float32) pipeline.Result {
+	return pipeline.Result{Submission: pipeline.Submission{Hash: hash}, Response: infinigo.QueryResponse{GeneralScore: score}}
+}
+
+// fields returns the PRI, APP-NAME, MSGID and MSG of an RFC 5424 message
+func fields(t *testing.T, msg string) (int, string, string, string) {
+	t.Helper()
+	parts := strings.SplitN(msg, " ", 8)
+	if len(parts) != 8 || !strings.HasPrefix(parts[0], "<") || !strings.HasSuffix(parts[0], ">1") || parts[6] != "-" {
+		t.Fatalf("Bad syslog message %q", msg)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, parts[1]); err != nil {
+		t.Fatalf("Bad timestamp in %q - %v", msg, err)
+	}
+	pri, err := strconv.Atoi(strings.TrimSuffix(parts[0][1:], ">1"))
+	if err != nil {
+		t.Fatalf("Bad priority in %q", msg)
+	}
+	return pri, parts[3], parts[5], parts[7]
+}
+
+func TestUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s, err := New("udp", conn.LocalAddr().String(), SetFacility("local4"), SetAppName("infinity scanner"), SetFormat(JSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.OnResult(context.Background(), result(-1)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pri, app, msgID, msg := fields(t, string(buf[:n]))
+	if pri != 20*8+SeverityCritical || app != "infinityscanner" || msgID != "malicious" {
+		t.Fatalf("Expected a critical local4 message of infinityscanner, got %d %s %s", pri, app, msgID)
+	}
+	if !strings.Contains(msg, `"hash":"`+hash+`"`) {
+		t.Fatalf("Expected the result as JSON, got %s", msg)
+	}
+}
+
+// readFrame reads a message framed with octet counting
+func readFrame(r *bufio.Reader) (string, error) {
+	length, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, n)
+	_, err = io.ReadFull(r, msg)
+	return string(msg), err
+}
+
+func TestTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	messages := make(chan string)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					msg, err := readFrame(r)
+					if err != nil {
+						return
+					}
+					messages <- msg
+				}
+			}()
+		}
+	}()
+	s, err := New("tcp", l.Addr().String(), SetSeverity(infinigo.VerdictBenign, SeverityDebug))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, test := range []struct {
+		score    float32
+		severity int
+		verdict  string
+	}{
+		{-1, SeverityCritical, "malicious"},
+		{1, SeverityDebug, "benign"},
+		{-0.3, SeverityWarning, "suspicious"},
+	} {
+		if err = s.OnResult(context.Background(), result(test.score)); err != nil {
+			t.Fatal(err)
+		}
+		var msg string
+		select {
+		case msg = <-messages:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the %s result to be sent", test.verdict)
+		}
+		pri, _, msgID, body := fields(t, msg)
+		if pri != 1*8+test.severity || msgID != test.verdict || !strings.HasPrefix(body, "CEF:0|") {
+			t.Fatalf("Expected a CEF %s message with severity %d, got %q", test.verdict, test.severity, msg)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, test := range []struct {
+		network, addr string
+		options       []OptionFunc
+	}{
+		{"http", "127.0.0.1:514", nil},
+		{"udp", "", nil},
+		{"udp", "127.0.0.1:514", []OptionFunc{SetFacility("local8")}},
+		{"udp", "127.0.0.1:514", []OptionFunc{SetSeverity(infinigo.VerdictBenign, 8)}},
+	} {
+		if _, err := New(test.network, test.addr, test.options...); err == nil {
+			t.Errorf("Expected %s %s with %d options to be refused", test.network, test.addr, len(test.options))
+		}
+	}
+}
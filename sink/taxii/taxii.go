@@ -0,0 +1,125 @@
+/*
+Package taxii is a pipeline sink publishing the STIX indicators of malicious and suspicious findings
+to a TAXII 2.1 collection, so consumers subscribed to the collection receive them.
+
+Indicators are created by the stix package with IDs derived from the hash, so publishing a finding
+again adds a new version of the same indicator.
+*/
+package taxii
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/stix"
+)
+
+// MediaType of TAXII 2.1 requests and responses
+const MediaType = "application/taxii+json;version=2.1"
+
+// Sink publishes indicators to a TAXII collection
+type Sink struct {
+	url      string
+	user     string
+	password string
+	c        *http.Client
+}
+
+// OptionFunc is a function that configures a Sink.
+// It is used in New
+type OptionFunc func(*Sink) error
+
+// New creates a sink for the TAXII collection at url, e.g. https://taxii.example.com/api1/collections/<id>/
+func New(url string, options ...OptionFunc) (*Sink, error) {
+	if url == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "TAXII collection URL is required"}
+	}
+	s := &Sink{url: strings.TrimSuffix(url, "/") + "/", c: http.DefaultClient}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SetHTTPClient sets the client of the requests to the TAXII server, e.g. to present the client
+// certificate of mutual TLS some servers require. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(s *Sink) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		s.c = c
+		return nil
+	}
+}
+
+// SetBasicAuth sets the credentials of the TAXII server
+func SetBasicAuth(user, password string) OptionFunc {
+	return func(s *Sink) error {
+		s.user, s.password = user, password
+		return nil
+	}
+}
+
+// OnResult publishes the indicator of the result, if it has one
+func (s *Sink) OnResult(ctx context.Context, r pipeline.Result) error {
+	indicator, ok := stix.NewIndicator(r, time.Now())
+	if !ok {
+		return nil
+	}
+	return s.Publish(ctx, stix.Infinity, indicator)
+}
+
+// Publish adds the STIX objects to the collection and returns an error if the server rejected any of them
+func (s *Sink) Publish(ctx context.Context, objects ...interface{}) error {
+	b, err := json.Marshal(map[string]interface{}{"objects": objects})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+"objects/", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", MediaType)
+	req.Header.Set("Content-Type", MediaType)
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("TAXII returned %d - %s", resp.StatusCode, bytes.TrimSpace(msg))}
+	}
+	var status struct {
+		FailureCount int `json:"failure_count"`
+		Failures     []struct {
+			ID      string `json:"id"`
+			Message string `json:"message"`
+		} `json:"failures"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return &infinigo.Error{ID: "bad_response", Details: fmt.Sprintf("TAXII status - %v", err)}
+	}
+	if status.FailureCount > 0 {
+		var failures []string
+		for _, f := range status.Failures {
+			failures = append(failures, fmt.Sprintf("%s: %s", f.ID, f.Message))
+		}
+		return &infinigo.Error{ID: "bad_response", Details: fmt.Sprintf("TAXII rejected %d objects - %s", status.FailureCount, strings.Join(failures, ", "))}
+	}
+	return nil
+}
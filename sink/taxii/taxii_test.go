@@ -0,0 +1,112 @@
+package taxii
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/stix"
+)
+
+const hash = "2d7ab0e8cfaf0fd1f2a2a3d2a1a0c4dc6e4a4cba8b19b1f0dfd1a8b0bcd8e1f0"
+
+// collection is a fake TAXII collection keeping the objects added to it, or rejecting them if reject is set
+type collection struct {
+	mu      sync.Mutex
+	objects []map[string]interface{}
+	reject  bool
+}
+
+func newCollection(t *testing.T) (*collection, string) {
+	t.Helper()
+	c := &collection{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+			http.Error(w, `{"title":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/api1/collections/abc/objects/" {
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Content-Type") != MediaType || r.Header.Get("Accept") != MediaType {
+			t.Errorf("Expected TAXII media types, got %v", r.Header)
+		}
+		var envelope struct {
+			Objects []map[string]interface{} `json:"objects"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			t.Error(err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		status := map[string]interface{}{"status": "complete", "total_count": len(envelope.Objects), "success_count": len(envelope.Objects)}
+		if c.reject {
+			status["success_count"], status["failure_count"] = 0, 1
+			status["failures"] = []map[string]string{{"id": envelope.Objects[1]["id"].(string), "message": "not allowed"}}
+		} else {
+			c.objects = append(c.objects, envelope.Objects...)
+		}
+		w.Header().Set("Content-Type", MediaType)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(status)
+	}))
+	t.Cleanup(srv.Close)
+	return c, srv.URL + "/api1/collections/abc"
+}
+
+func result(score float32) pipeline.Result {
+	return pipeline.Result{Submission: pipeline.Submission{Hash: hash}, Response: infinigo.QueryResponse{GeneralScore: score}}
+}
+
+func TestOnResult(t *testing.T) {
+	c, url := newCollection(t)
+	s, err := New(url, SetBasicAuth("user", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []pipeline.Result{result(-1), result(1), result(-0.3)} {
+		if err = s.OnResult(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Benign results have no indicator, and the suspicious one is a new version of the malicious one
+	if len(c.objects) != 4 {
+		t.Fatalf("Expected two identities and indicators, got %v", c.objects)
+	}
+	first, second := c.objects[1], c.objects[3]
+	if c.objects[0]["id"] != stix.Infinity.ID || first["type"] != "indicator" || first["id"] != second["id"] {
+		t.Fatalf("Expected versions of the same indicator, got %v", c.objects)
+	}
+	if first["name"] != "malicious file "+hash || second["name"] != "suspicious file "+hash {
+		t.Fatalf("Expected the indicators to be named after the verdicts, got %v and %v", first["name"], second["name"])
+	}
+}
+
+func TestPublishRejected(t *testing.T) {
+	c, url := newCollection(t)
+	c.reject = true
+	s, err := New(url, SetBasicAuth("user", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ierr *infinigo.Error
+	if err = s.OnResult(context.Background(), result(-1)); !errors.As(err, &ierr) || ierr.ID != "bad_response" || !strings.Contains(ierr.Details, "not allowed") {
+		t.Fatalf("Expected the rejected indicator to be reported, got %v", err)
+	}
+	s, err = New(url, SetBasicAuth("user", "wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.OnResult(context.Background(), result(-1)); !errors.As(err, &ierr) || ierr.ID != "http_error" || !strings.Contains(ierr.Details, "401") {
+		t.Fatalf("Expected the refused credentials to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Package teams is a pipeline sink posting an Adaptive Card to a Microsoft Teams channel when a scan
+finds malicious or suspicious files, with the file name, hash, score and a link to the report of the hash.
+
+Cards are posted to a Teams incoming webhook or a Workflows webhook. Which results are notified, where
+and how often follows notify.Rules like the slack sink, and cards over the rate are suppressed and
+counted in the next card, or in a summary posted by Close.
+*/
+package teams
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/siem"
+	"github.com/demisto/infinigo/sink/notify"
+)
+
+// Sink posts Adaptive Cards about results to Teams
+type Sink struct {
+	url     string
+	c       *http.Client
+	rules   notify.Rules
+	limiter *notify.Limiter
+}
+
+// OptionFunc is a function that configures a Sink.
+// It is used in New
+type OptionFunc func(*Sink) error
+
+// New creates a sink posting to the Teams webhook at url
+func New(url string, options ...OptionFunc) (*Sink, error) {
+	if url == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Teams webhook URL is required"}
+	}
+	s := &Sink{url: url, c: http.DefaultClient}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	s.limiter = notify.NewLimiter(s.rules)
+	return s, nil
+}
+
+// SetHTTPClient sets the client posting to the Teams webhook, e.g. to go through the egress proxy of
+// the network. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(s *Sink) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		s.c = c
+		return nil
+	}
+}
+
+// SetRules sets which results are notified, where and how often, with webhook URLs as the destinations
+// of routes. Malicious and suspicious results are notified to the webhook of New without a limit by default.
+func SetRules(rules notify.Rules) OptionFunc {
+	return func(s *Sink) error {
+		if err := rules.Validate(); err != nil {
+			return err
+		}
+		s.rules = rules
+		return nil
+	}
+}
+
+// OnResult posts a card about the result if it matches the rules and the rate allows it
+func (s *Sink) OnResult(ctx context.Context, r pipeline.Result) error {
+	if !s.rules.Match(r) {
+		return nil
+	}
+	ok, suppressed := s.limiter.Allow()
+	if !ok {
+		return nil
+	}
+	return s.post(ctx, s.rules.Route(r, s.url), s.card(r, suppressed))
+}
+
+// Close posts how many cards were suppressed since the last one, if any
+func (s *Sink) Close() error {
+	n := s.limiter.Suppressed()
+	if n == 0 {
+		return nil
+	}
+	return s.post(context.Background(), s.url, map[string]interface{}{
+		"body": []interface{}{textBlock(fmt.Sprintf("%d more findings were not notified because of the rate limit", n))},
+	})
+}
+
+// card returns the body and actions of the card about the result
+func (s *Sink) card(r pipeline.Result, suppressed int) map[string]interface{} {
+	e := siem.NewEvent(r, time.Now())
+	name := e.FileName
+	if name == "" {
+		name = e.Hash
+	}
+	title := textBlock(fmt.Sprintf("%s %s", siem.Name(r), name))
+	title["size"], title["weight"] = "Medium", "Bolder"
+	if r.Response.Verdict() == infinigo.VerdictMalicious {
+		title["color"] = "Attention"
+	} else {
+		title["color"] = "Warning"
+	}
+	facts := []map[string]string{
+		{"title": "Hash", "value": e.Hash},
+		{"title": "Score", "value": fmt.Sprint(e.Score)},
+	}
+	if e.Host != "" {
+		facts = append(facts, map[string]string{"title": "Host", "value": e.Host})
+	}
+	if e.Path != "" {
+		facts = append(facts, map[string]string{"title": "Path", "value": e.Path})
+	}
+	body := []interface{}{title, map[string]interface{}{"type": "FactSet", "facts": facts}}
+	if suppressed > 0 {
+		more := textBlock(fmt.Sprintf("%d more findings were not notified because of the rate limit", suppressed))
+		more["isSubtle"], more["size"] = true, "Small"
+		body = append(body, more)
+	}
+	card := map[string]interface{}{"body": body}
+	if report := s.rules.Report(e.Hash); report != "" {
+		card["actions"] = []interface{}{map[string]string{"type": "Action.OpenUrl", "title": "View report", "url": report}}
+	}
+	return card
+}
+
+// post sends the card to the webhook
+func (s *Sink) post(ctx context.Context, url string, card map[string]interface{}) error {
+	card["type"] = "AdaptiveCard"
+	card["version"] = "1.4"
+	card["$schema"] = "http://adaptivecards.io/schemas/adaptive-card.json"
+	b, err := json.Marshal(map[string]interface{}{
+		"type":        "message",
+		"attachments": []interface{}{map[string]interface{}{"contentType": "application/vnd.microsoft.card.adaptive", "content": card}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("Teams returned %d - %s", resp.StatusCode, bytes.TrimSpace(msg))}
+	}
+	return nil
+}
+
+// textBlock returns a TextBlock element of the text
+func textBlock(text string) map[string]interface{} {
+	return map[string]interface{}{"type": "TextBlock", "text": text, "wrap": true}
+}
@@ -0,0 +1,118 @@
+package teams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/sink/notify"
+)
+
+// card is the adaptive card of a message posted to a webhook
+type card struct {
+	Type    string `json:"type"`
+	Version string `json:"version"`
+	Body    []struct {
+		Type  string              `json:"type"`
+		Text  string              `json:"text"`
+		Color string              `json:"color"`
+		Facts []map[string]string `json:"facts"`
+	} `json:"body"`
+	Actions []map[string]string `json:"actions"`
+}
+
+// newWebhooks returns a server with a webhook per path, and the cards posted to them by path
+func newWebhooks(t *testing.T) (func(path string) []card, string) {
+	t.Helper()
+	var mu sync.Mutex
+	cards := map[string][]card{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/expired" {
+			http.Error(w, "Webhook is disabled", http.StatusGone)
+			return
+		}
+		var msg struct {
+			Type        string `json:"type"`
+			Attachments []struct {
+				ContentType string `json:"contentType"`
+				Content     card   `json:"content"`
+			} `json:"attachments"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Error(err)
+		}
+		if msg.Type != "message" || len(msg.Attachments) != 1 || msg.Attachments[0].ContentType != "application/vnd.microsoft.card.adaptive" {
+			t.Errorf("Expected a message with an adaptive card, got %+v", msg)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		cards[r.URL.Path] = append(cards[r.URL.Path], msg.Attachments[0].Content)
+		w.Write([]byte("1"))
+	}))
+	t.Cleanup(srv.Close)
+	return func(path string) []card {
+		mu.Lock()
+		defer mu.Unlock()
+		return cards[path]
+	}, srv.URL
+}
+
+func result(hash string, score float32) pipeline.Result {
+	return pipeline.Result{Submission: pipeline.Submission{Hash: hash, Path: "/tmp/sample.exe"}, Response: infinigo.QueryResponse{GeneralScore: score}}
+}
+
+func TestOnResult(t *testing.T) {
+	posted, url := newWebhooks(t)
+	rules := notify.Rules{
+		Routes:     map[infinigo.Verdict]string{infinigo.VerdictMalicious: url + "/critical"},
+		Rate:       2,
+		RatePeriod: time.Hour,
+		ReportURL:  "https://infinigo.example.com/query?h={hash}",
+	}
+	s, err := New(url+"/soc", SetRules(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []pipeline.Result{result("abc", -1), result("def", 1), result("ghi", -0.3), result("jkl", -1)} {
+		if err = s.OnResult(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	critical, soc := posted("/critical"), posted("/soc")
+	if len(critical) != 1 || len(soc) != 2 {
+		t.Fatalf("Expected the malicious result to be routed and the summary to be posted, got %v and %v", critical, soc)
+	}
+	c := critical[0]
+	if c.Type != "AdaptiveCard" || c.Body[0].Text != "Malicious file sample.exe" || c.Body[0].Color != "Attention" {
+		t.Fatalf("Expected a card about the malicious file, got %+v", c)
+	}
+	if c.Body[1].Facts[0]["value"] != "abc" || c.Actions[0]["url"] != "https://infinigo.example.com/query?h=abc" {
+		t.Fatalf("Expected the hash and report link, got %+v", c)
+	}
+	if soc[0].Body[0].Color != "Warning" || soc[1].Body[0].Text != "1 more findings were not notified because of the rate limit" {
+		t.Fatalf("Expected the suspicious result and the summary, got %+v", soc)
+	}
+}
+
+func TestOnResultFailed(t *testing.T) {
+	_, url := newWebhooks(t)
+	s, err := New(url + "/expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ierr *infinigo.Error
+	if err = s.OnResult(context.Background(), result("abc", -1)); !errors.As(err, &ierr) || ierr.ID != "http_error" {
+		t.Fatalf("Expected the disabled webhook to fail, got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Package webhook is a pipeline sink posting every result to a webhook URL, covering any HTTP service
+without a dedicated integration.
+
+The body is the result as JSON by default, or rendered from a text/template with the fields of
+siem.Event and the full result as .Result, e.g.
+
+	{"text": {{printf "%s file %s scored %v" .Verdict .Path .Score | json}}, "hash": {{json .Hash}}}
+
+The json template function encodes values as JSON. With a secret, the body is signed with
+HMAC-SHA256 in the X-Infinigo-Signature-256 header as sha256=<hex>, so the receiver can check it
+came from us. Requests are retried with backoff when the receiver is unreachable or fails.
+*/
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/internal/backoff"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/siem"
+)
+
+const (
+	SignatureHeader = "X-Infinigo-Signature-256" // SignatureHeader has the HMAC of the body
+	DefaultRetries  = 3                          // DefaultRetries of a request that failed
+)
+
+// Data is what templates render
+type Data struct {
+	siem.Event
+	Result pipeline.Result
+}
+
+// Sink posts results to a webhook
+type Sink struct {
+	url         string
+	c           *http.Client
+	template    *template.Template
+	contentType string
+	headers     http.Header
+	secret      []byte
+	retries     int
+	backoff     time.Duration
+}
+
+// OptionFunc is a function that configures a Sink.
+// It is used in New
+type OptionFunc func(*Sink) error
+
+// New creates a sink for the webhook at url
+func New(url string, options ...OptionFunc) (*Sink, error) {
+	if url == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "Webhook URL is required"}
+	}
+	s := &Sink{url: url, c: http.DefaultClient, contentType: "application/json", headers: http.Header{}, retries: DefaultRetries, backoff: time.Second}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SetHTTPClient sets the client posting to the receiver, e.g. to trust its private CA or limit how long
+// a request may take. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(s *Sink) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		s.c = c
+		return nil
+	}
+}
+
+// SetTemplate sets the text/template rendering the body from Data, and its content type
+func SetTemplate(text, contentType string) OptionFunc {
+	return func(s *Sink) error {
+		t, err := template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Parse(text)
+		if err != nil {
+			return &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Webhook template - %v", err)}
+		}
+		s.template = t
+		if contentType != "" {
+			s.contentType = contentType
+		}
+		return nil
+	}
+}
+
+// SetHeader adds a header to the requests, e.g. for authentication
+func SetHeader(key, value string) OptionFunc {
+	return func(s *Sink) error {
+		s.headers.Add(key, value)
+		return nil
+	}
+}
+
+// SetSecret signs the body with HMAC-SHA256 using the secret. Bodies are not signed with an empty secret.
+func SetSecret(secret string) OptionFunc {
+	return func(s *Sink) error {
+		s.secret = nil
+		if secret != "" {
+			s.secret = []byte(secret)
+		}
+		return nil
+	}
+}
+
+// SetRetries sets how many times a failed request is sent again, doubling the delay from a second.
+// It is DefaultRetries by default.
+func SetRetries(retries int) OptionFunc {
+	return func(s *Sink) error {
+		if retries < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Retries must not be negative"}
+		}
+		s.retries = retries
+		return nil
+	}
+}
+
+// OnResult posts the result
+func (s *Sink) OnResult(ctx context.Context, r pipeline.Result) error {
+	body, err := s.body(r)
+	if err != nil {
+		return err
+	}
+	return backoff.Retry(ctx, s.retries, s.backoff, func() (bool, error) {
+		return s.send(ctx, body)
+	})
+}
+
+// Sign returns the signature of the body with the secret, as sent in SignatureHeader
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// body renders the body of the result
+func (s *Sink) body(r pipeline.Result) ([]byte, error) {
+	if s.template == nil {
+		return json.Marshal(r)
+	}
+	var b bytes.Buffer
+	if err := s.template.Execute(&b, Data{Event: siem.NewEvent(r, time.Now()), Result: r}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// send posts the body and returns whether a failure is worth retrying
+func (s *Sink) send(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range s.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", s.contentType)
+	if s.secret != nil {
+		req.Header.Set(SignatureHeader, Sign(s.secret, body))
+	}
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("Webhook returned %d - %s", resp.StatusCode, bytes.TrimSpace(msg))}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// toJSON is the json template function
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
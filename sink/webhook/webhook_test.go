@@ -0,0 +1,132 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// request is a request received by the fake receiver
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// newReceiver returns a receiver answering with the statuses in turn, then 204
+func newReceiver(t *testing.T, statuses ...int) (func() []request, string) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, request{r.Header, body})
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return func() []request {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}, srv.URL
+}
+
+var malicious = pipeline.Result{
+	Submission: pipeline.Submission{Hash: "abc", Path: "/tmp/sample.exe"},
+	Response:   infinigo.QueryResponse{GeneralScore: -1},
+}
+
+func TestOnResult(t *testing.T) {
+	received, url := newReceiver(t)
+	s, err := New(url, SetHeader("Authorization", "Bearer token"), SetSecret("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.OnResult(context.Background(), malicious); err != nil {
+		t.Fatal(err)
+	}
+	requests := received()
+	if len(requests) != 1 {
+		t.Fatalf("Expected a request, got %d", len(requests))
+	}
+	r := requests[0]
+	var got pipeline.Result
+	if err = json.Unmarshal(r.body, &got); err != nil || got.Submission.Hash != "abc" {
+		t.Fatalf("Expected the result as JSON, got %s - %v", r.body, err)
+	}
+	if r.header.Get("Content-Type") != "application/json" || r.header.Get("Authorization") != "Bearer token" {
+		t.Fatalf("Expected the content type and headers to be set, got %v", r.header)
+	}
+	// The receiver checks the signature with the secret
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(r.body)
+	if r.header.Get(SignatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("Expected the body to be signed, got %s", r.header.Get(SignatureHeader))
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	received, url := newReceiver(t)
+	s, err := New(url, SetTemplate(`{"text": {{printf "%s file %s" .Verdict .Path | json}}, "score": {{.Result.Response.GeneralScore}}}`, "application/vnd.alert+json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.OnResult(context.Background(), malicious); err != nil {
+		t.Fatal(err)
+	}
+	r := received()[0]
+	if string(r.body) != `{"text": "malicious file /tmp/sample.exe", "score": -1}` || r.header.Get("Content-Type") != "application/vnd.alert+json" {
+		t.Fatalf("Expected the rendered template, got %s %v", r.body, r.header)
+	}
+	if r.header.Get(SignatureHeader) != "" {
+		t.Fatal("Expected the body not to be signed without a secret")
+	}
+	if _, err = New(url, SetTemplate(`{{.Verdict`, "")); err == nil {
+		t.Fatal("Expected a bad template to be refused")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	received, url := newReceiver(t, http.StatusBadGateway, http.StatusTooManyRequests)
+	s, err := New(url, SetRetries(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.backoff = time.Millisecond
+	if err = s.OnResult(context.Background(), malicious); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(received()); n != 3 {
+		t.Fatalf("Expected the result to be sent on the third attempt, got %d attempts", n)
+	}
+
+	received, url = newReceiver(t, http.StatusUnauthorized)
+	if s, err = New(url); err != nil {
+		t.Fatal(err)
+	}
+	var ierr *infinigo.Error
+	if err = s.OnResult(context.Background(), malicious); !errors.As(err, &ierr) || ierr.ID != "http_error" {
+		t.Fatalf("Expected the refused request to fail, got %v", err)
+	}
+	if n := len(received()); n != 1 {
+		t.Fatalf("Expected a refused request not to be retried, got %d attempts", n)
+	}
+}
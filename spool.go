@@ -0,0 +1,71 @@
+package infinigo
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// SetUploadSpoolThreshold spills compressed upload bodies larger than threshold bytes to a temporary
+// file instead of memory, so concurrent uploads of large samples do not exhaust it. 0 (the default)
+// keeps every body in memory.
+func SetUploadSpoolThreshold(threshold int64) OptionFunc {
+	return func(c *Client) error {
+		if threshold < 0 {
+			err := &Error{ID: "bad_option", Details: "Upload spool threshold cannot be negative"}
+			c.errorf("%v\n", err)
+			return err
+		}
+		c.spoolThreshold = threshold
+		return nil
+	}
+}
+
+// spool holds an upload body in memory up to a threshold, and in a temporary file past it
+type spool struct {
+	threshold int64 // threshold in bytes, 0 to never spill
+	buf       bytes.Buffer
+	file      *os.File
+	size      int64
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil && s.threshold > 0 && s.size+int64(len(p)) > s.threshold {
+		f, err := os.CreateTemp("", "infinigo-upload")
+		if err != nil {
+			return 0, err
+		}
+		s.file = f
+		if _, err = s.buf.WriteTo(f); err != nil {
+			return 0, err
+		}
+		s.buf = bytes.Buffer{}
+	}
+	var n int
+	var err error
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// reader returns a reader of the whole body. It is not a Closer, so the HTTP client sending it does
+// not close the file.
+func (s *spool) reader() io.Reader {
+	if s.file == nil {
+		return bytes.NewReader(s.buf.Bytes())
+	}
+	return io.NewSectionReader(s.file, 0, s.size)
+}
+
+// Close removes the temporary file, if any
+func (s *spool) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
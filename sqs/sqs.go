@@ -0,0 +1,443 @@
+/*
+Package sqs connects pipelines to Amazon SQS queues, receiving submissions from one queue as a pipeline
+source and sending results to another as a pipeline sink, so submissions can flow without a server to run.
+
+A message to submit is either text with a hash per line, a JSON object
+
+	{"hash": "<hash>", "classifiers": "ml", "upload": false, "priority": "high"}
+
+or a pointer to an S3 object as {"bucket": "<bucket>", "key": "<key>"}, or an S3 event notification,
+whose objects are downloaded and submitted as files. Results are sent as the JSON of their siem.Event,
+with hash and verdict message attributes for subscription filters.
+
+Credentials and the region are read as in the archive/s3 package, and the region defaults to the one
+of the queue URL.
+*/
+package sqs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/archive/s3"
+	"github.com/demisto/infinigo/internal/backoff"
+	"github.com/demisto/infinigo/internal/sigv4"
+	"github.com/demisto/infinigo/pipeline"
+	"github.com/demisto/infinigo/siem"
+)
+
+const (
+	DefaultRetries  = 3  // DefaultRetries of a request that failed
+	DefaultWaitTime = 20 // DefaultWaitTime in seconds of a receive long polling for messages
+)
+
+// Queue sends and receives the messages of an SQS queue
+type Queue struct {
+	url         string
+	endpoint    string
+	region      string
+	credentials sigv4.Credentials
+	c           *http.Client
+	retries     int
+	backoff     time.Duration
+	drain       bool
+	downloadDir string
+	s3Endpoint  string
+	errorlog    *log.Logger
+}
+
+// OptionFunc is a function that configures a Queue.
+// It is used in New
+type OptionFunc func(*Queue) error
+
+// New creates a queue for the queue URL, e.g. https://sqs.us-east-1.amazonaws.com/123456789012/infinity
+func New(queueURL string, options ...OptionFunc) (*Queue, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, &infinigo.Error{ID: "bad_option", Details: fmt.Sprintf("Bad SQS queue URL [%s]", queueURL)}
+	}
+	q := &Queue{
+		url:         queueURL,
+		endpoint:    u.Scheme + "://" + u.Host + "/",
+		region:      sigv4.Region(s3.DefaultRegion),
+		credentials: sigv4.FromEnv(),
+		c:           http.DefaultClient,
+		retries:     DefaultRetries,
+		backoff:     time.Second,
+		downloadDir: os.TempDir(),
+	}
+	// sqs.<region>.amazonaws.com
+	if parts := strings.Split(u.Hostname(), "."); len(parts) >= 4 && parts[0] == "sqs" && parts[2] == "amazonaws" {
+		q.region = parts[1]
+	}
+	for _, option := range options {
+		if err := option(q); err != nil {
+			return nil, err
+		}
+	}
+	if q.credentials.AccessKey == "" || q.credentials.SecretKey == "" {
+		return nil, &infinigo.Error{ID: "missing_arg", Details: "AWS access key and secret key are required"}
+	}
+	return q, nil
+}
+
+// SetHTTPClient sets the client of the requests to SQS, also used to download the objects of S3 events,
+// e.g. to go through a proxy to a VPC endpoint. It is http.DefaultClient by default.
+func SetHTTPClient(c *http.Client) OptionFunc {
+	return func(q *Queue) error {
+		if c == nil {
+			return &infinigo.Error{ID: "bad_option", Details: "HTTP client is required"}
+		}
+		q.c = c
+		return nil
+	}
+}
+
+// SetRegion sets the region of the queue
+func SetRegion(region string) OptionFunc {
+	return func(q *Queue) error {
+		if region == "" {
+			return &infinigo.Error{ID: "bad_option", Details: "SQS region is required"}
+		}
+		q.region = region
+		return nil
+	}
+}
+
+// SetCredentials sets the access key, secret key and optional session token of the requests
+func SetCredentials(accessKey, secretKey, sessionToken string) OptionFunc {
+	return func(q *Queue) error {
+		q.credentials = sigv4.Credentials{AccessKey: accessKey, SecretKey: secretKey, SessionToken: sessionToken}
+		return nil
+	}
+}
+
+// SetRetries sets how many times a failed request is sent again, doubling the delay from a second.
+// It is DefaultRetries by default.
+func SetRetries(retries int) OptionFunc {
+	return func(q *Queue) error {
+		if retries < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Retries must not be negative"}
+		}
+		q.retries = retries
+		return nil
+	}
+}
+
+// SetDrain makes the source end once a receive finds no message, to process what is queued and exit.
+// The source waits for messages until its context is done by default.
+func SetDrain(drain bool) OptionFunc {
+	return func(q *Queue) error {
+		q.drain = drain
+		return nil
+	}
+}
+
+// SetDownloadDir sets the directory S3 objects are downloaded to. They are not removed once processed.
+// It is os.TempDir() by default.
+func SetDownloadDir(dir string) OptionFunc {
+	return func(q *Queue) error {
+		if dir == "" {
+			return &infinigo.Error{ID: "bad_option", Details: "Download directory is required"}
+		}
+		q.downloadDir = dir
+		return nil
+	}
+}
+
+// SetS3Endpoint sets the URL of a service compatible with S3 to download objects from, see s3.SetEndpoint
+func SetS3Endpoint(endpoint string) OptionFunc {
+	return func(q *Queue) error {
+		q.s3Endpoint = endpoint
+		return nil
+	}
+}
+
+// SetErrorLog sets the logger of the messages the source skips because they cannot be submitted
+func SetErrorLog(logger *log.Logger) OptionFunc {
+	return func(q *Queue) error {
+		q.errorlog = logger
+		return nil
+	}
+}
+
+// OnResult sends the result to the queue
+func (q *Queue) OnResult(ctx context.Context, r pipeline.Result) error {
+	e := siem.NewEvent(r, time.Now())
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	in := map[string]interface{}{
+		"QueueUrl":    q.url,
+		"MessageBody": string(body),
+		"MessageAttributes": map[string]interface{}{
+			"hash":    map[string]string{"DataType": "String", "StringValue": e.Hash},
+			"verdict": map[string]string{"DataType": "String", "StringValue": e.Verdict},
+		},
+	}
+	if strings.HasSuffix(q.url, ".fifo") {
+		in["MessageGroupId"] = e.Hash
+		in["MessageDeduplicationId"] = fmt.Sprintf("%s-%d", e.Hash, e.Time.UnixNano())
+	}
+	return q.call(ctx, "SendMessage", in, nil)
+}
+
+// message is a received message
+type message struct {
+	Body          string `json:"Body"`
+	ReceiptHandle string `json:"ReceiptHandle"`
+	MessageID     string `json:"MessageId"`
+}
+
+// Source returns a source of the submissions of the messages of the queue. Submissions are copies of the
+// template with the fields of a message. A message is deleted when the submission after its last one is
+// asked for, once Feed submitted it, so a message whose submission failed is received again.
+func (q *Queue) Source(template pipeline.Submission) pipeline.Source {
+	var pending []pipeline.Submission
+	var receipts []string // receipts of the messages of pending, the last one once it is empty
+	var done []string     // done are the receipts of the messages whose submissions were all handed out
+	return pipeline.SourceFunc(func(ctx context.Context) (pipeline.Submission, error) {
+		if len(done) > 0 {
+			if err := q.delete(ctx, done); err != nil && q.errorlog != nil {
+				q.errorlog.Printf("Failed deleting SQS messages, they will be received again - %v", err)
+			}
+			done = nil
+		}
+		for len(pending) == 0 {
+			if err := ctx.Err(); err != nil {
+				return pipeline.Submission{}, err
+			}
+			var out struct {
+				Messages []message `json:"Messages"`
+			}
+			in := map[string]interface{}{"QueueUrl": q.url, "MaxNumberOfMessages": 10, "WaitTimeSeconds": DefaultWaitTime}
+			if err := q.call(ctx, "ReceiveMessage", in, &out); err != nil {
+				return pipeline.Submission{}, err
+			}
+			if len(out.Messages) == 0 && q.drain {
+				return pipeline.Submission{}, io.EOF
+			}
+			for _, m := range out.Messages {
+				subs, err := q.submissions(ctx, m, template)
+				if err != nil {
+					if q.errorlog != nil {
+						q.errorlog.Printf("Skipped SQS message %s - %v", m.MessageID, err)
+					}
+					continue
+				}
+				if len(subs) == 0 {
+					// Nothing to submit, e.g. the test event of an S3 notification
+					done = append(done, m.ReceiptHandle)
+				}
+				for range subs {
+					receipts = append(receipts, "")
+				}
+				if len(subs) > 0 {
+					receipts[len(receipts)-1] = m.ReceiptHandle
+				}
+				pending = append(pending, subs...)
+			}
+		}
+		sub := pending[0]
+		if receipts[0] != "" {
+			done = append(done, receipts[0])
+		}
+		pending, receipts = pending[1:], receipts[1:]
+		return sub, nil
+	})
+}
+
+// submissions returns the submissions of a message, downloading the S3 objects it points to
+func (q *Queue) submissions(ctx context.Context, m message, template pipeline.Submission) ([]pipeline.Submission, error) {
+	body := strings.TrimSpace(m.Body)
+	if !strings.HasPrefix(body, "{") {
+		var subs []pipeline.Submission
+		for _, line := range strings.Split(body, "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 && !strings.HasPrefix(fields[0], "#") {
+				sub := template
+				sub.Hash = fields[0]
+				subs = append(subs, sub)
+			}
+		}
+		return subs, nil
+	}
+	var msg struct {
+		Hash        string `json:"hash"`
+		Classifiers string `json:"classifiers"`
+		Upload      *bool  `json:"upload"`
+		Priority    string `json:"priority"`
+		Bucket      string `json:"bucket"`
+		Key         string `json:"key"`
+		Records     []struct {
+			S3 struct {
+				Bucket struct {
+					Name string `json:"name"`
+				} `json:"bucket"`
+				Object struct {
+					Key string `json:"key"`
+				} `json:"object"`
+			} `json:"s3"`
+		} `json:"Records"`
+	}
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		return nil, err
+	}
+	sub := template
+	if msg.Classifiers != "" {
+		sub.Classifiers = msg.Classifiers
+	}
+	if msg.Upload != nil {
+		sub.Upload = *msg.Upload
+	}
+	if msg.Priority != "" {
+		prio, err := pipeline.ParsePriority(msg.Priority)
+		if err != nil {
+			return nil, err
+		}
+		sub.Priority = prio
+	}
+	switch {
+	case msg.Hash != "":
+		sub.Hash = msg.Hash
+		return []pipeline.Submission{sub}, nil
+	case msg.Bucket != "" && msg.Key != "":
+		path, err := q.download(ctx, msg.Bucket, msg.Key)
+		if err != nil {
+			return nil, err
+		}
+		sub.Path = path
+		return []pipeline.Submission{sub}, nil
+	case len(msg.Records) > 0:
+		var subs []pipeline.Submission
+		for _, r := range msg.Records {
+			// Keys of event notifications are URL encoded, with + for spaces
+			key, err := url.QueryUnescape(r.S3.Object.Key)
+			if err != nil || r.S3.Bucket.Name == "" || key == "" {
+				return nil, &infinigo.Error{ID: "bad_request", Details: "S3 event notification without an object"}
+			}
+			path, err := q.download(ctx, r.S3.Bucket.Name, key)
+			if err != nil {
+				return nil, err
+			}
+			s := sub
+			s.Path = path
+			subs = append(subs, s)
+		}
+		return subs, nil
+	case strings.Contains(body, `"s3:TestEvent"`):
+		return nil, nil
+	}
+	return nil, &infinigo.Error{ID: "bad_request", Details: "Message has no hash, S3 object or S3 event"}
+}
+
+// download downloads the S3 object to the download directory and returns its path
+func (q *Queue) download(ctx context.Context, bucket, key string) (string, error) {
+	options := []s3.OptionFunc{s3.SetRegion(q.region), s3.SetHTTPClient(q.c),
+		s3.SetCredentials(q.credentials.AccessKey, q.credentials.SecretKey, q.credentials.SessionToken)}
+	if q.s3Endpoint != "" {
+		options = append(options, s3.SetEndpoint(q.s3Endpoint))
+	}
+	a, err := s3.New(bucket, options...)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(q.downloadDir, "sqs-*-"+filepath.Base(key))
+	if err != nil {
+		return "", err
+	}
+	err = a.Get(ctx, key, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// delete deletes the messages with the receipts
+func (q *Queue) delete(ctx context.Context, receipts []string) error {
+	for len(receipts) > 0 {
+		n := len(receipts)
+		if n > 10 {
+			n = 10
+		}
+		var entries []map[string]string
+		for i, r := range receipts[:n] {
+			entries = append(entries, map[string]string{"Id": fmt.Sprint(i), "ReceiptHandle": r})
+		}
+		var out struct {
+			Failed []struct {
+				Message string `json:"Message"`
+			} `json:"Failed"`
+		}
+		if err := q.call(ctx, "DeleteMessageBatch", map[string]interface{}{"QueueUrl": q.url, "Entries": entries}, &out); err != nil {
+			return err
+		}
+		if len(out.Failed) > 0 {
+			return &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("Failed deleting %d SQS messages - %s", len(out.Failed), out.Failed[0].Message)}
+		}
+		receipts = receipts[n:]
+	}
+	return nil
+}
+
+// call calls the action of the SQS JSON API, retrying with backoff
+func (q *Queue) call(ctx context.Context, action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return backoff.Retry(ctx, q.retries, q.backoff, func() (bool, error) {
+		return q.send(ctx, action, body, out)
+	})
+}
+
+// send sends the request of the action and returns whether a failure is worth retrying
+func (q *Queue) send(ctx context.Context, action string, body []byte, out interface{}) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, q.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+	sigv4.Sign(req, q.credentials, q.region, "sqs", sigv4.PayloadHash(body), time.Now())
+	resp, err := q.c.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		details := string(bytes.TrimSpace(msg))
+		if json.Unmarshal(msg, &e) == nil && e.Type != "" {
+			details = e.Type[strings.LastIndex(e.Type, "#")+1:] + " " + e.Message
+		}
+		err = &infinigo.Error{ID: "http_error", Details: fmt.Sprintf("SQS %s returned %d - %s", action, resp.StatusCode, details)}
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+	}
+	if out == nil {
+		return false, nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return false, &infinigo.Error{ID: "bad_response", Details: fmt.Sprintf("Bad SQS %s response - %v", action, err)}
+	}
+	return false, nil
+}
@@ -0,0 +1,169 @@
+package sqs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// queue is a fake SQS JSON API with a single queue, and a fake S3 serving the objects in samples/.
+// Received messages are in flight until deleted, so they are not received again.
+type queue struct {
+	mu       sync.Mutex
+	sent     []map[string]interface{}
+	messages []string
+	inFlight map[string]string // bodies by receipt
+	received int
+	deleted  int
+	fail     []int
+}
+
+func newQueue(t *testing.T, bodies ...string) (*queue, string) {
+	t.Helper()
+	q := &queue{messages: bodies, inFlight: map[string]string{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/samples/") {
+			fmt.Fprintf(w, "content of %s", strings.TrimPrefix(r.URL.Path, "/samples/"))
+			return
+		}
+		if len(q.fail) > 0 {
+			w.WriteHeader(q.fail[0])
+			w.Write([]byte(`{"__type":"com.amazonaws.sqs#RequestThrottled","message":"Rate exceeded"}`))
+			q.fail = q.fail[1:]
+			return
+		}
+		var in map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&in)
+		if in["QueueUrl"] == nil || r.Header.Get("Content-Type") != "application/x-amz-json-1.0" {
+			http.Error(w, `{"__type":"com.amazonaws.sqs#InvalidAddress"}`, http.StatusBadRequest)
+			return
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSQS.SendMessage":
+			q.sent = append(q.sent, in)
+			w.Write([]byte(`{"MessageId":"1"}`))
+		case "AmazonSQS.ReceiveMessage":
+			var out []message
+			for len(q.messages) > 0 && len(out) < 10 {
+				q.received++
+				receipt := fmt.Sprintf("receipt-%d", q.received)
+				out = append(out, message{Body: q.messages[0], ReceiptHandle: receipt, MessageID: receipt})
+				q.inFlight[receipt] = q.messages[0]
+				q.messages = q.messages[1:]
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"Messages": out})
+		case "AmazonSQS.DeleteMessageBatch":
+			for _, e := range in["Entries"].([]interface{}) {
+				delete(q.inFlight, e.(map[string]interface{})["ReceiptHandle"].(string))
+				q.deleted++
+			}
+			w.Write([]byte(`{"Successful":[],"Failed":[]}`))
+		default:
+			http.Error(w, `{"__type":"com.amazonaws.sqs#UnsupportedOperation"}`, http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return q, srv.URL
+}
+
+func newTestQueue(t *testing.T, url string, options ...OptionFunc) *Queue {
+	t.Helper()
+	options = append([]OptionFunc{SetRegion("eu-west-1"), SetCredentials("AKID", "secret", "")}, options...)
+	q, err := New(url, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.backoff = time.Millisecond
+	return q
+}
+
+func TestOnResult(t *testing.T) {
+	fake, url := newQueue(t)
+	fake.fail = []int{http.StatusInternalServerError}
+	q := newTestQueue(t, url+"/123456789012/results.fifo")
+	r := pipeline.Result{Submission: pipeline.Submission{Hash: "abc"}, Response: infinigo.QueryResponse{GeneralScore: -1}}
+	if err := q.OnResult(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.sent) != 1 {
+		t.Fatalf("Expected the result to be sent once the error is retried, got %v", fake.sent)
+	}
+	in := fake.sent[0]
+	attributes, _ := json.Marshal(in["MessageAttributes"])
+	if in["QueueUrl"] != url+"/123456789012/results.fifo" || in["MessageGroupId"] != "abc" || in["MessageDeduplicationId"] == nil ||
+		string(attributes) != `{"hash":{"DataType":"String","StringValue":"abc"},"verdict":{"DataType":"String","StringValue":"malicious"}}` {
+		t.Fatalf("Expected the message of a FIFO queue with its attributes, got %v", in)
+	}
+	var e map[string]interface{}
+	if err := json.Unmarshal([]byte(in["MessageBody"].(string)), &e); err != nil || e["verdict"] != "malicious" {
+		t.Fatalf("Expected the event as JSON, got %v - %v", in["MessageBody"], err)
+	}
+
+	fake.fail = []int{http.StatusBadRequest}
+	var ierr *infinigo.Error
+	if err := q.OnResult(context.Background(), r); !errors.As(err, &ierr) || ierr.Details != "SQS SendMessage returned 400 - RequestThrottled Rate exceeded" {
+		t.Fatalf("Expected the error of SQS, got %v", err)
+	}
+}
+
+func TestSource(t *testing.T) {
+	fake, url := newQueue(t,
+		"ABC\n# comment\n\ndef",
+		`{"hash": "ghi", "priority": "high", "upload": false}`,
+		`{"bucket": "samples", "key": "dir/a.exe"}`,
+		`{"Records": [{"s3": {"bucket": {"name": "samples"}, "object": {"key": "b+c.exe"}}}]}`,
+		`{"Service": "Amazon S3", "Event": "s3:TestEvent"}`,
+		`{"unknown": true}`,
+	)
+	dir := t.TempDir()
+	q := newTestQueue(t, url+"/123456789012/submissions", SetDrain(true), SetDownloadDir(dir), SetS3Endpoint(url))
+	source := q.Source(pipeline.Submission{Classifiers: "ml", Upload: true})
+	var subs []pipeline.Submission
+	for {
+		sub, err := source.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) != 5 {
+		t.Fatalf("Expected 5 submissions, got %+v", subs)
+	}
+	if subs[0].Hash != "ABC" || subs[1].Hash != "def" || subs[0].Classifiers != "ml" || !subs[0].Upload {
+		t.Fatalf("Expected the hashes of the text message with the template, got %+v", subs[:2])
+	}
+	if subs[2].Hash != "ghi" || subs[2].Priority != pipeline.PriorityHigh || subs[2].Upload {
+		t.Fatalf("Expected the fields of the JSON message, got %+v", subs[2])
+	}
+	for i, want := range []string{"content of dir/a.exe", "content of b c.exe"} {
+		b, err := os.ReadFile(subs[3+i].Path)
+		if err != nil || string(b) != want || !strings.HasPrefix(subs[3+i].Path, dir) {
+			t.Fatalf("Expected the object to be downloaded, got %s - %v", subs[3+i].Path, err)
+		}
+	}
+	// All the messages but the one that could not be submitted are deleted
+	if len(fake.inFlight) != 1 || fake.deleted != 5 {
+		t.Fatalf("Expected the handled messages to be deleted, got %d deleted and %v in flight", fake.deleted, fake.inFlight)
+	}
+}
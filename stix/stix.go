@@ -0,0 +1,291 @@
+/*
+Package stix converts Infinity results to STIX 2.1 bundles so they can be loaded into threat
+intelligence platforms.
+
+Every result becomes a file object with its hash and name, and a malware-analysis object with the
+verdict, score and classifier scores of Infinity. Malicious and suspicious results also become
+indicators with a STIX pattern matching the hash and a confidence derived from the score.
+The IDs of the file, malware-analysis and indicator objects are derived from the lowercased hash and
+their created time is fixed, so publishing a result again creates a new version of the same objects,
+with a later modified time, rather than duplicating them.
+*/
+package stix
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+const (
+	SpecVersion = "2.1"                               // SpecVersion of STIX implemented by this package
+	MediaType   = "application/stix+json;version=2.1" // MediaType of STIX bundles
+	Product     = "cylance infinity"                  // Product name in malware-analysis objects
+)
+
+// timeFormat is the STIX timestamp format with millisecond precision
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+var (
+	// scoNamespace is the STIX namespace for the deterministic IDs of cyber observables
+	scoNamespace = mustUUID("00abedb4-aa42-466c-9c01-fed23315a9b7")
+	// namespace is the namespace of the deterministic IDs of the other objects created by this package
+	namespace = mustUUID("5c6f8f7e-3b8a-5c2e-9a41-2f1d0c7e6b93")
+	// created is the creation time of the Infinity identity, and of the objects whose IDs are derived
+	// from hashes, as STIX forbids versions of an object with different creation times
+	created = time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Infinity is the identity the indicators and analyses are created by
+var Infinity = Identity{
+	Type:          "identity",
+	SpecVersion:   SpecVersion,
+	ID:            "identity--" + uuid5(namespace, []byte("identity:"+Product)),
+	Created:       Timestamp(created),
+	Modified:      Timestamp(created),
+	Name:          "Cylance Infinity",
+	IdentityClass: "system",
+}
+
+// Timestamp formats a time as a STIX timestamp
+type Timestamp time.Time
+
+// MarshalJSON formats the time in UTC with millisecond precision
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Time(t).UTC().Format(timeFormat))
+}
+
+// UnmarshalJSON parses an RFC 3339 time
+func (t *Timestamp) UnmarshalJSON(b []byte) error {
+	var tt time.Time
+	if err := json.Unmarshal(b, &tt); err != nil {
+		return err
+	}
+	*t = Timestamp(tt)
+	return nil
+}
+
+// Bundle is a STIX bundle
+type Bundle struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id"`
+	Objects []interface{} `json:"objects"`
+}
+
+// Identity is a STIX identity
+type Identity struct {
+	Type          string    `json:"type"`
+	SpecVersion   string    `json:"spec_version"`
+	ID            string    `json:"id"`
+	Created       Timestamp `json:"created"`
+	Modified      Timestamp `json:"modified"`
+	Name          string    `json:"name"`
+	IdentityClass string    `json:"identity_class"`
+}
+
+// File is a STIX file cyber observable
+type File struct {
+	Type        string            `json:"type"`
+	SpecVersion string            `json:"spec_version"`
+	ID          string            `json:"id"`
+	Hashes      map[string]string `json:"hashes"`
+	Name        string            `json:"name,omitempty"`
+}
+
+// Indicator is a STIX indicator matching a file hash
+type Indicator struct {
+	Type           string    `json:"type"`
+	SpecVersion    string    `json:"spec_version"`
+	ID             string    `json:"id"`
+	CreatedByRef   string    `json:"created_by_ref"`
+	Created        Timestamp `json:"created"`
+	Modified       Timestamp `json:"modified"`
+	Name           string    `json:"name"`
+	IndicatorTypes []string  `json:"indicator_types"`
+	Pattern        string    `json:"pattern"`
+	PatternType    string    `json:"pattern_type"`
+	ValidFrom      Timestamp `json:"valid_from"`
+	Confidence     int       `json:"confidence"`
+	Labels         []string  `json:"labels,omitempty"`
+}
+
+// MalwareAnalysis is a STIX malware-analysis of a file by Infinity
+type MalwareAnalysis struct {
+	Type             string             `json:"type"`
+	SpecVersion      string             `json:"spec_version"`
+	ID               string             `json:"id"`
+	CreatedByRef     string             `json:"created_by_ref"`
+	Created          Timestamp          `json:"created"`
+	Modified         Timestamp          `json:"modified"`
+	Product          string             `json:"product"`
+	AnalysisEnded    Timestamp          `json:"analysis_ended"`
+	Result           string             `json:"result"`
+	SampleRef        string             `json:"sample_ref"`
+	AnalysisSCORefs  []string           `json:"analysis_sco_refs"`
+	Score            float32            `json:"x_infinity_score"`
+	ClassifierScores map[string]float32 `json:"x_infinity_classifiers,omitempty"`
+	ConfirmationCode string             `json:"x_infinity_confirmcode,omitempty"`
+	Uploaded         bool               `json:"x_infinity_uploaded,omitempty"`
+}
+
+// NewBundle creates a bundle with the objects of the results, see Objects
+func NewBundle(results []pipeline.Result, now time.Time) Bundle {
+	return Bundle{Type: "bundle", ID: "bundle--" + uuid4(), Objects: Objects(results, now)}
+}
+
+// Objects returns the identity of Infinity followed by the file, malware-analysis and indicator objects
+// of the results. Results with errors and without a hash are skipped.
+func Objects(results []pipeline.Result, now time.Time) []interface{} {
+	objects := []interface{}{Infinity}
+	for _, r := range results {
+		file, ok := NewFile(r)
+		if !ok || r.Response.Verdict() == infinigo.VerdictError {
+			continue
+		}
+		objects = append(objects, file, NewMalwareAnalysis(r, file, now))
+		if indicator, ok := NewIndicator(r, now); ok {
+			objects = append(objects, indicator)
+		}
+	}
+	return objects
+}
+
+// NewFile returns the file object of the result, or false if its hash type is not known
+func NewFile(r pipeline.Result) (File, bool) {
+	hash := normalize(r.Submission.Hash)
+	algorithm, ok := hashAlgorithm(hash)
+	if !ok {
+		return File{}, false
+	}
+	f := File{Type: "file", SpecVersion: SpecVersion, Hashes: map[string]string{algorithm: hash}}
+	if r.Submission.Path != "" {
+		f.Name = filepath.Base(r.Submission.Path)
+	}
+	// The ID contributing properties of files are hashes and name
+	contributing, _ := json.Marshal(struct {
+		Hashes map[string]string `json:"hashes"`
+		Name   string            `json:"name,omitempty"`
+	}{f.Hashes, f.Name})
+	f.ID = "file--" + uuid5(scoNamespace, contributing)
+	return f, true
+}
+
+// NewMalwareAnalysis returns the analysis of the file by Infinity, modified at now
+func NewMalwareAnalysis(r pipeline.Result, file File, now time.Time) MalwareAnalysis {
+	result := string(r.Response.Verdict())
+	return MalwareAnalysis{
+		Type:             "malware-analysis",
+		SpecVersion:      SpecVersion,
+		ID:               "malware-analysis--" + uuid5(namespace, []byte("malware-analysis:"+normalize(r.Submission.Hash))),
+		CreatedByRef:     Infinity.ID,
+		Created:          Timestamp(created),
+		Modified:         Timestamp(now),
+		Product:          Product,
+		AnalysisEnded:    Timestamp(now),
+		Result:           result,
+		SampleRef:        file.ID,
+		AnalysisSCORefs:  []string{file.ID},
+		Score:            r.Response.GeneralScore,
+		ClassifierScores: r.Response.Classifiers,
+		ConfirmationCode: r.Response.ConfirmCode,
+		Uploaded:         r.Submission.Uploaded,
+	}
+}
+
+// NewIndicator returns an indicator matching the hash of malicious and suspicious results, modified
+// and valid from now, or false for other results
+func NewIndicator(r pipeline.Result, now time.Time) (Indicator, bool) {
+	hash := normalize(r.Submission.Hash)
+	algorithm, ok := hashAlgorithm(hash)
+	if !ok {
+		return Indicator{}, false
+	}
+	var types []string
+	switch r.Response.Verdict() {
+	case infinigo.VerdictMalicious:
+		types = []string{"malicious-activity"}
+	case infinigo.VerdictSuspicious:
+		types = []string{"anomalous-activity"}
+	default:
+		return Indicator{}, false
+	}
+	verdict := r.Response.Verdict()
+	return Indicator{
+		Type:           "indicator",
+		SpecVersion:    SpecVersion,
+		ID:             "indicator--" + uuid5(namespace, []byte("indicator:"+hash)),
+		CreatedByRef:   Infinity.ID,
+		Created:        Timestamp(created),
+		Modified:       Timestamp(now),
+		Name:           fmt.Sprintf("%s file %s", verdict, hash),
+		IndicatorTypes: types,
+		Pattern:        fmt.Sprintf("[file:hashes.'%s' = '%s']", algorithm, hash),
+		PatternType:    "stix",
+		ValidFrom:      Timestamp(now),
+		Confidence:     Confidence(r.Response.GeneralScore),
+		Labels:         []string{string(verdict)},
+	}, true
+}
+
+// Confidence converts an Infinity score from -1 (malicious) to 1 (benign) to a STIX confidence from 0 to 100
+// in the verdict it implies
+func Confidence(score float32) int {
+	return int(math.Round(math.Min(math.Abs(float64(score)), 1) * 100))
+}
+
+// normalize returns the hash in lower case, as the same file may be submitted with either case
+func normalize(hash string) string {
+	return strings.ToLower(strings.TrimSpace(hash))
+}
+
+// hashAlgorithm returns the STIX name of the algorithm of the hash by its length
+func hashAlgorithm(hash string) (string, bool) {
+	switch len(hash) {
+	case 32:
+		return "MD5", true
+	case 40:
+		return "SHA-1", true
+	case 64:
+		return "SHA-256", true
+	}
+	return "", false
+}
+
+func uuid4() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return format(b[:])
+}
+
+func uuid5(ns [16]byte, name []byte) string {
+	h := sha1.New()
+	h.Write(ns[:])
+	h.Write(name)
+	b := h.Sum(nil)[:16]
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	return format(b)
+}
+
+func format(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func mustUUID(s string) [16]byte {
+	var b [16]byte
+	if n, err := hex.Decode(b[:], []byte(strings.ReplaceAll(s, "-", ""))); err != nil || n != len(b) {
+		panic("bad UUID " + s)
+	}
+	return b
+}
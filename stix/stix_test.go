@@ -0,0 +1,107 @@
+package stix
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+const sha256 = "2d7ab0e8cfaf0fd1f2a2a3d2a1a0c4dc6e4a4cba8b19b1f0dfd1a8b0bcd8e1f0"
+
+func result(hash string, score float32) pipeline.Result {
+	return pipeline.Result{
+		Submission: pipeline.Submission{Hash: hash, Path: "/tmp/sample.exe"},
+		Response:   infinigo.QueryResponse{GeneralScore: score},
+	}
+}
+
+// exported returns the objects of the result keyed by type, as they are published
+func exported(t *testing.T, r pipeline.Result, now time.Time) map[string]map[string]interface{} {
+	t.Helper()
+	b, err := json.Marshal(NewBundle([]pipeline.Result{r}, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle struct {
+		Objects []map[string]interface{} `json:"objects"`
+	}
+	if err = json.Unmarshal(b, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	objects := make(map[string]map[string]interface{})
+	for _, o := range bundle.Objects {
+		objects[o["type"].(string)] = o
+	}
+	return objects
+}
+
+func TestIDsStable(t *testing.T) {
+	first := exported(t, result(sha256, -0.9), time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	second := exported(t, result(strings.ToUpper(sha256), -0.4), time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC))
+	for _, typ := range []string{"identity", "file", "malware-analysis", "indicator"} {
+		a, b := first[typ], second[typ]
+		if a == nil || b == nil {
+			t.Fatalf("Expected a %s object in both exports, got %v and %v", typ, a, b)
+		}
+		if a["id"] != b["id"] {
+			t.Errorf("Expected the %s ID to be stable, got %v and %v", typ, a["id"], b["id"])
+		}
+		if a["created"] != b["created"] {
+			t.Errorf("Expected the %s creation time to be stable, got %v and %v", typ, a["created"], b["created"])
+		}
+	}
+	for _, typ := range []string{"malware-analysis", "indicator"} {
+		if first[typ]["modified"] != "2026-01-01T00:00:00.000Z" || second[typ]["modified"] != "2026-02-01T00:00:00.000Z" {
+			t.Errorf("Expected the %s to be modified when exported, got %v and %v", typ, first[typ]["modified"], second[typ]["modified"])
+		}
+	}
+	if second["indicator"]["pattern"] != "[file:hashes.'SHA-256' = '"+sha256+"']" {
+		t.Errorf("Expected the pattern to match the lowercased hash, got %v", second["indicator"]["pattern"])
+	}
+	if second["malware-analysis"]["result"] != "suspicious" {
+		t.Errorf("Expected the new verdict, got %v", second["malware-analysis"]["result"])
+	}
+}
+
+func TestIDsDiffer(t *testing.T) {
+	other := strings.Repeat("0", len(sha256))
+	now := time.Now()
+	a, b := exported(t, result(sha256, -1), now), exported(t, result(other, -1), now)
+	for _, typ := range []string{"file", "malware-analysis", "indicator"} {
+		if a[typ]["id"] == b[typ]["id"] {
+			t.Errorf("Expected files with different hashes to have different %s IDs", typ)
+		}
+	}
+}
+
+func TestObjects(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		r     pipeline.Result
+		types []string
+	}{
+		{"malicious", result(sha256, -1), []string{"identity", "file", "malware-analysis", "indicator"}},
+		{"benign", result(sha256, 1), []string{"identity", "file", "malware-analysis"}},
+		{"unknown hash type", result("abc", -1), []string{"identity"}},
+		{"error", pipeline.Result{Submission: pipeline.Submission{Hash: sha256}, Response: infinigo.QueryResponse{Common: infinigo.Common{Error: "failed"}}}, []string{"identity"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var types []string
+			for _, o := range Objects([]pipeline.Result{test.r}, now) {
+				b, _ := json.Marshal(o)
+				var typ struct{ Type string }
+				json.Unmarshal(b, &typ)
+				types = append(types, typ.Type)
+			}
+			if strings.Join(types, " ") != strings.Join(test.types, " ") {
+				t.Fatalf("Expected %v, got %v", test.types, types)
+			}
+		})
+	}
+}
@@ -0,0 +1,437 @@
+/*
+Package sqlite keeps the history of Infinity verdicts in a SQLite database, so the CLI, the queue
+worker and serve mode can share one history and it can be queried with SQL.
+
+Unlike the JSON file store every response is kept, not only the latest one. The schema is
+
+	files     a row per hash with the path it was last seen at, when it was first seen, last checked
+	          and when its verdict or score last changed
+	verdicts  a row per response from Infinity with its verdict, score, the full response as JSON and
+	          the scan it was recorded by
+	scans     a row per process that recorded verdicts, with its source, host and start and end times
+	tags      the tags of the hashes
+	notes     the notes attached to the hashes
+
+Times are stored as UTC RFC 3339 text so they sort and compare as strings.
+
+Writes go to the database immediately. The database uses write ahead logging so readers do not block
+the writer, and writers wait for each other up to SetBusyTimeout. The driver requires cgo.
+*/
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/store"
+	_ "github.com/mattn/go-sqlite3" // registers the sqlite3 driver
+)
+
+// DefaultBusyTimeout is how long writers wait for other processes writing to the database
+const DefaultBusyTimeout = 10 * time.Second
+
+// Schema creates the tables of the database if they do not exist
+const Schema = `
+CREATE TABLE IF NOT EXISTS files (
+	hash         TEXT PRIMARY KEY,
+	path         TEXT NOT NULL DEFAULT '',
+	first_seen   TEXT NOT NULL,
+	last_checked TEXT,
+	changed      TEXT
+);
+CREATE INDEX IF NOT EXISTS files_last_checked ON files (last_checked);
+CREATE TABLE IF NOT EXISTS scans (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	source   TEXT NOT NULL,
+	host     TEXT NOT NULL,
+	started  TEXT NOT NULL,
+	finished TEXT
+);
+CREATE TABLE IF NOT EXISTS verdicts (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash     TEXT NOT NULL REFERENCES files (hash) ON DELETE CASCADE,
+	scan_id  INTEGER REFERENCES scans (id),
+	checked  TEXT NOT NULL,
+	verdict  TEXT NOT NULL,
+	score    REAL NOT NULL,
+	response TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS verdicts_hash ON verdicts (hash, id);
+CREATE INDEX IF NOT EXISTS verdicts_checked ON verdicts (checked);
+CREATE TABLE IF NOT EXISTS tags (
+	hash TEXT NOT NULL REFERENCES files (hash) ON DELETE CASCADE,
+	tag  TEXT NOT NULL,
+	PRIMARY KEY (hash, tag)
+);
+CREATE TABLE IF NOT EXISTS notes (
+	hash TEXT NOT NULL REFERENCES files (hash) ON DELETE CASCADE,
+	time TEXT NOT NULL,
+	text TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS notes_hash ON notes (hash);
+`
+
+// timeFormat keeps a fixed width so times compare as strings
+const timeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// Store is a SQLite backed history of verdicts. It is safe for concurrent use, also by other processes.
+type Store struct {
+	path        string
+	db          *sql.DB
+	source      string
+	busyTimeout time.Duration
+	mu          sync.Mutex
+	scan        int64 // scan is the id of the scan of this process, 0 until the first verdict is recorded
+}
+
+// OptionFunc is a function that configures a Store.
+// It is used in Open
+type OptionFunc func(*Store) error
+
+// Open opens the database at path, creating it and its tables if needed
+func Open(path string, options ...OptionFunc) (*Store, error) {
+	s := &Store{path: path, source: "infinigo", busyTimeout: DefaultBusyTimeout}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	q := url.Values{}
+	q.Set("_journal_mode", "WAL")
+	q.Set("_foreign_keys", "on")
+	q.Set("_busy_timeout", fmt.Sprint(s.busyTimeout.Milliseconds()))
+	q.Set("_txlock", "immediate")
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serializes the writes of this process instead of failing them as busy
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec(Schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s.db = db
+	return s, nil
+}
+
+// SetSource names the scans recorded by this store in the scans table, e.g. "scan /home" or "serve".
+// It is "infinigo" by default.
+func SetSource(source string) OptionFunc {
+	return func(s *Store) error {
+		s.source = source
+		return nil
+	}
+}
+
+// SetBusyTimeout sets how long writes wait for other processes writing to the database. It is DefaultBusyTimeout by default.
+func SetBusyTimeout(timeout time.Duration) OptionFunc {
+	return func(s *Store) error {
+		if timeout < 0 {
+			return &infinigo.Error{ID: "bad_option", Details: "Busy timeout cannot be negative"}
+		}
+		s.busyTimeout = timeout
+		return nil
+	}
+}
+
+// Path returns the file of the database
+func (s *Store) Path() string {
+	return s.path
+}
+
+// DB returns the database, for queries the Store does not provide
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+// Close marks the scan of this process as finished and closes the database
+func (s *Store) Close() error {
+	s.mu.Lock()
+	scan := s.scan
+	s.mu.Unlock()
+	if scan != 0 {
+		if _, err := s.db.Exec(`UPDATE scans SET finished = ? WHERE id = ?`, formatTime(time.Now()), scan); err != nil {
+			s.db.Close()
+			return err
+		}
+	}
+	return s.db.Close()
+}
+
+// scanID returns the id of the scan of this process, starting it on the first call
+func (s *Store) scanID(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.scan != 0 {
+		return s.scan, nil
+	}
+	host, _ := os.Hostname()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO scans (source, host, started) VALUES (?, ?, ?)`, s.source, host, formatTime(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	if s.scan, err = res.LastInsertId(); err != nil {
+		return 0, err
+	}
+	return s.scan, nil
+}
+
+// GetByHash returns the record of the hash if it exists
+func (s *Store) GetByHash(ctx context.Context, hash string) (store.Record, bool, error) {
+	records, err := queryRecords(ctx, s.db, `WHERE f.hash = ?`, key(hash))
+	if err != nil || len(records) == 0 {
+		return store.Record{}, false, err
+	}
+	return records[0], true, nil
+}
+
+// SaveResult records a new response for the hash and returns the previous record, if any.
+// path is optional and only overrides the stored path when provided.
+func (s *Store) SaveResult(ctx context.Context, hash, path string, resp infinigo.QueryResponse) (prev store.Record, existed bool, err error) {
+	scan, err := s.scanID(ctx)
+	if err != nil {
+		return prev, false, err
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return prev, false, err
+	}
+	k := key(hash)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return prev, false, err
+	}
+	defer tx.Rollback()
+	records, err := queryRecords(ctx, tx, `WHERE f.hash = ?`, k)
+	if err != nil {
+		return prev, false, err
+	}
+	now := formatTime(time.Now())
+	switch {
+	case len(records) == 0:
+		_, err = tx.ExecContext(ctx, `INSERT INTO files (hash, path, first_seen, last_checked, changed) VALUES (?, ?, ?, ?, ?)`, k, path, now, now, now)
+	default:
+		prev, existed = records[0], true
+		changed := formatTime(prev.Changed)
+		// A record created by AddTags has no response to compare to
+		if prev.LastChecked.IsZero() || store.Changed(prev.Response, resp) {
+			changed = now
+		}
+		if path == "" {
+			path = prev.Path
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE files SET path = ?, last_checked = ?, changed = ? WHERE hash = ?`, path, now, changed, k)
+	}
+	if err != nil {
+		return prev, existed, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO verdicts (hash, scan_id, checked, verdict, score, response) VALUES (?, ?, ?, ?, ?, ?)`,
+		k, scan, now, string(resp.Verdict()), score(resp.GeneralScore), string(body))
+	if err != nil {
+		return prev, existed, err
+	}
+	return prev, existed, tx.Commit()
+}
+
+// AddTags adds tags and an optional note to the hash, creating an empty record if the hash is not known yet
+func (s *Store) AddTags(ctx context.Context, hash string, tags []string, note string) error {
+	k := key(hash)
+	now := formatTime(time.Now())
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, `INSERT INTO files (hash, first_seen) VALUES (?, ?) ON CONFLICT (hash) DO NOTHING`, k, now); err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if _, err = tx.ExecContext(ctx, `INSERT INTO tags (hash, tag) VALUES (?, ?) ON CONFLICT DO NOTHING`, k, t); err != nil {
+			return err
+		}
+	}
+	if note != "" {
+		if _, err = tx.ExecContext(ctx, `INSERT INTO notes (hash, time, text) VALUES (?, ?, ?)`, k, now, note); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RemoveTags removes tags from the hash
+func (s *Store) RemoveTags(ctx context.Context, hash string, tags []string) error {
+	for _, t := range tags {
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM tags WHERE hash = ? AND tag = ?`, key(hash), t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeBefore removes the records last checked before the given time with their history and returns how many were removed.
+// Tagged records are kept unless includeTagged is set.
+func (s *Store) PurgeBefore(ctx context.Context, before time.Time, includeTagged bool) (int, error) {
+	q := `DELETE FROM files WHERE (last_checked IS NULL OR last_checked < ?)`
+	if !includeTagged {
+		q += ` AND NOT EXISTS (SELECT 1 FROM tags t WHERE t.hash = files.hash)`
+	}
+	res, err := s.db.ExecContext(ctx, q, formatTime(before))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// ListTagged returns the records having the tag, sorted by hash. An empty tag returns all the records.
+func (s *Store) ListTagged(ctx context.Context, tag string) ([]store.Record, error) {
+	if tag == "" {
+		return s.ListSince(ctx, time.Time{})
+	}
+	return queryRecords(ctx, s.db, `WHERE EXISTS (SELECT 1 FROM tags t WHERE t.hash = f.hash AND t.tag = ?)`, tag)
+}
+
+// ListSince returns the records checked since the given time, sorted by hash. A zero time returns all the records.
+func (s *Store) ListSince(ctx context.Context, since time.Time) ([]store.Record, error) {
+	if since.IsZero() {
+		return queryRecords(ctx, s.db, ``)
+	}
+	return queryRecords(ctx, s.db, `WHERE f.last_checked >= ?`, formatTime(since))
+}
+
+// ListChanged returns the records whose verdict or score changed since the given time, including the
+// ones first seen since then, sorted by hash
+func (s *Store) ListChanged(ctx context.Context, since time.Time) ([]store.Record, error) {
+	return queryRecords(ctx, s.db, `WHERE f.changed >= ?`, formatTime(since))
+}
+
+// History returns every response recorded for the hash, oldest first
+func (s *Store) History(ctx context.Context, hash string) ([]infinigo.QueryResponse, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT response FROM verdicts WHERE hash = ? ORDER BY id`, key(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var history []infinigo.QueryResponse
+	for rows.Next() {
+		var body string
+		var resp infinigo.QueryResponse
+		if err = rows.Scan(&body); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(body), &resp); err != nil {
+			return nil, err
+		}
+		history = append(history, resp)
+	}
+	return history, rows.Err()
+}
+
+// querier is a database or a transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryRecords returns the records of the files matching the where clause with their latest response, tags and notes
+func queryRecords(ctx context.Context, q querier, where string, args ...interface{}) ([]store.Record, error) {
+	rows, err := q.QueryContext(ctx, `SELECT f.hash, f.path, f.first_seen, f.last_checked, f.changed, v.response
+		FROM files f LEFT JOIN verdicts v ON v.id = (SELECT max(id) FROM verdicts WHERE hash = f.hash) `+where+` ORDER BY f.hash`, args...)
+	if err != nil {
+		return nil, err
+	}
+	var records []store.Record
+	byHash := make(map[string]*store.Record)
+	for rows.Next() {
+		var r store.Record
+		var firstSeen string
+		var lastChecked, changed, body sql.NullString
+		if err = rows.Scan(&r.Hash, &r.Path, &firstSeen, &lastChecked, &changed, &body); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		r.FirstSeen = parseTime(firstSeen)
+		r.LastChecked = parseTime(lastChecked.String)
+		r.Changed = parseTime(changed.String)
+		if body.Valid {
+			if err = json.Unmarshal([]byte(body.String), &r.Response); err != nil {
+				rows.Close()
+				return nil, err
+			}
+		}
+		records = append(records, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil || len(records) == 0 {
+		return records, err
+	}
+	for i := range records {
+		byHash[records[i].Hash] = &records[i]
+	}
+	// Tags and notes are few, so they are read whole rather than per record
+	rows, err = q.QueryContext(ctx, `SELECT hash, tag FROM tags ORDER BY hash, tag`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var hash, tag string
+		if err = rows.Scan(&hash, &tag); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if r, ok := byHash[hash]; ok {
+			r.Tags = append(r.Tags, tag)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = q.QueryContext(ctx, `SELECT hash, time, text FROM notes ORDER BY hash, time`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash, t, text string
+		if err = rows.Scan(&hash, &t, &text); err != nil {
+			return nil, err
+		}
+		if r, ok := byHash[hash]; ok {
+			r.Notes = append(r.Notes, store.Note{Time: parseTime(t), Text: text})
+		}
+	}
+	return records, rows.Err()
+}
+
+// key normalizes a hash as the store package does
+func key(hash string) string {
+	return strings.ToLower(strings.TrimSpace(hash))
+}
+
+// score converts the score to the float64 with the same shortest representation, so -0.9 is stored as -0.9
+func score(f float32) float64 {
+	s, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'f', -1, 32), 64)
+	return s
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(timeFormat)
+}
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
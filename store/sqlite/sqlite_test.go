@@ -0,0 +1,49 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/store"
+	"github.com/demisto/infinigo/store/storetest"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := Open(path, SetSource("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	s, err := Open(filepath.Join(t.TempDir(), "store.db"), SetSource("scan /home"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, score := range []float32{-0.9, -0.9, 0.3} {
+		if _, _, err = s.SaveResult(ctx, "ABC", "", infinigo.QueryResponse{GeneralScore: score}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, err := s.History(ctx, "abc")
+	if err != nil || len(history) != 3 || history[0].GeneralScore != -0.9 || history[2].GeneralScore != 0.3 {
+		t.Fatalf("Expected every response to be kept, got %v - %v", history, err)
+	}
+	// The scores are stored as they read, and the responses recorded by one scan
+	var score float64
+	var scans int
+	if err = s.DB().QueryRow(`SELECT score FROM verdicts ORDER BY id LIMIT 1`).Scan(&score); err != nil || score != -0.9 {
+		t.Fatalf("Expected the score -0.9, got %v - %v", score, err)
+	}
+	if err = s.DB().QueryRow(`SELECT count(DISTINCT scan_id) FROM verdicts v JOIN scans s ON s.id = v.scan_id AND s.source = 'scan /home'`).Scan(&scans); err != nil || scans != 1 {
+		t.Fatalf("Expected a single scan, got %d - %v", scans, err)
+	}
+}
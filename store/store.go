@@ -0,0 +1,399 @@
+/*
+Package store keeps a local history of Infinity verdicts so hashes can be
+re-checked and compared later without keeping the original result files around.
+
+Store is the interface the CLI, the pipeline and serve mode record results to.
+File implements it as a single JSON file that is loaded in memory on Open and
+written back atomically on Save. The sqlite and postgres subpackages implement it
+with SQL databases keeping every response, for a history shared by several
+processes. Other databases can be used by implementing Store.
+*/
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// Record is the latest known verdict for a single hash
+type Record struct {
+	Hash        string                 `json:"hash"`              // Hash as queried (lower case)
+	Path        string                 `json:"path,omitempty"`    // Path of the file the hash was computed from, if known
+	Response    infinigo.QueryResponse `json:"response"`          // Response is the last response from Infinity
+	FirstSeen   time.Time              `json:"first_seen"`        // FirstSeen is when the hash was first recorded
+	LastChecked time.Time              `json:"last_checked"`      // LastChecked is when the hash was last queried
+	Changed     time.Time              `json:"changed,omitempty"` // Changed is when the verdict or score last changed
+	Tags        []string               `json:"tags,omitempty"`    // Tags attached by analysts
+	Notes       []Note                 `json:"notes,omitempty"`   // Notes attached by analysts
+}
+
+// Note is a free text annotation on a record
+type Note struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// HasTag returns true if the record is tagged with the given tag
+func (r Record) HasTag(tag string) bool {
+	for _, t := range r.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Store is a history of verdicts
+type Store interface {
+	// SaveResult records a new response for the hash and returns the previous record, if any.
+	// path is optional and only overrides the stored path when provided.
+	SaveResult(ctx context.Context, hash, path string, resp infinigo.QueryResponse) (prev Record, existed bool, err error)
+	// GetByHash returns the record of the hash if it exists
+	GetByHash(ctx context.Context, hash string) (Record, bool, error)
+	// ListSince returns the records checked since the given time, sorted by hash. A zero time returns all the records.
+	ListSince(ctx context.Context, since time.Time) ([]Record, error)
+	// ListChanged returns the records whose verdict or score changed since the given time, including the
+	// ones first seen since then, sorted by hash
+	ListChanged(ctx context.Context, since time.Time) ([]Record, error)
+	// ListTagged returns the records having the tag, sorted by hash. An empty tag returns all the records.
+	ListTagged(ctx context.Context, tag string) ([]Record, error)
+	// AddTags adds tags and an optional note to the hash, creating an empty record if the hash is not known yet
+	AddTags(ctx context.Context, hash string, tags []string, note string) error
+	// RemoveTags removes tags from the hash
+	RemoveTags(ctx context.Context, hash string, tags []string) error
+	// PurgeBefore removes the records last checked before the given time and returns how many were removed.
+	// Tagged records are kept unless includeTagged is set.
+	PurgeBefore(ctx context.Context, before time.Time, includeTagged bool) (int, error)
+	// Path describes where the store is kept, without credentials
+	Path() string
+	// Close writes any pending changes and releases the store
+	Close() error
+}
+
+// File is a file backed map of hash to Record. It is safe for concurrent use.
+type File struct {
+	path    string
+	mu      sync.Mutex
+	records map[string]*Record
+	dirty   bool // dirty is set when records changed since the last Save
+}
+
+// Open loads the store from the given path. A missing file results in an empty store.
+func Open(path string) (*File, error) {
+	s := &File{path: path, records: make(map[string]*Record)}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var records []*Record
+	if err = json.NewDecoder(f).Decode(&records); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		s.records[key(r.Hash)] = r
+	}
+	return s, nil
+}
+
+// key normalizes a hash for use as a map key
+func key(hash string) string {
+	return strings.ToLower(strings.TrimSpace(hash))
+}
+
+// Path returns the file the store is persisted to
+func (s *File) Path() string {
+	return s.path
+}
+
+// Get returns the record for the hash if it exists
+func (s *File) Get(hash string) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[key(hash)]
+	if !ok {
+		return Record{}, false
+	}
+	return r.copy(), true
+}
+
+// Put records a new response for the hash and returns the previous record, if any.
+// path is optional and only overrides the stored path when provided.
+func (s *File) Put(hash, path string, resp infinigo.QueryResponse) (prev Record, existed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	k := key(hash)
+	r, existed := s.records[k]
+	if !existed {
+		r = &Record{Hash: k, FirstSeen: now, Changed: now}
+		s.records[k] = r
+	} else {
+		prev = *r
+		if Changed(prev.Response, resp) {
+			r.Changed = now
+		}
+	}
+	if path != "" {
+		r.Path = path
+	}
+	r.Response = resp
+	r.LastChecked = now
+	s.dirty = true
+	return prev, existed
+}
+
+// Expired returns true if the record was last checked longer than ttl ago.
+// Records with an unknown verdict expire after unknownTTL instead, so they are re-checked sooner.
+func (r Record) Expired(now time.Time, ttl, unknownTTL time.Duration) bool {
+	if r.Response.Verdict() == infinigo.VerdictUnknown {
+		ttl = unknownTTL
+	}
+	return now.Sub(r.LastChecked) > ttl
+}
+
+// copy returns a deep copy of the record so callers cannot modify the store
+func (r *Record) copy() Record {
+	c := *r
+	c.Tags = append([]string(nil), r.Tags...)
+	c.Notes = append([]Note(nil), r.Notes...)
+	return c
+}
+
+// Tag adds tags and an optional note to the hash, creating an empty record if the hash is not known yet
+func (s *File) Tag(hash string, tags []string, note string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	k := key(hash)
+	r, ok := s.records[k]
+	if !ok {
+		r = &Record{Hash: k, FirstSeen: now}
+		s.records[k] = r
+	}
+	for _, t := range tags {
+		if !r.HasTag(t) {
+			r.Tags = append(r.Tags, t)
+		}
+	}
+	sort.Strings(r.Tags)
+	if note != "" {
+		r.Notes = append(r.Notes, Note{Time: now, Text: note})
+	}
+	s.dirty = true
+}
+
+// Untag removes tags from the hash
+func (s *File) Untag(hash string, tags []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[key(hash)]
+	if !ok {
+		return
+	}
+	kept := r.Tags[:0]
+	for _, t := range r.Tags {
+		remove := false
+		for _, rt := range tags {
+			if t == rt {
+				remove = true
+				break
+			}
+		}
+		if !remove {
+			kept = append(kept, t)
+		}
+	}
+	r.Tags = kept
+	s.dirty = true
+}
+
+// Purge removes the records last checked before the given time and returns how many were removed.
+// Tagged records are kept unless includeTagged is set.
+func (s *File) Purge(before time.Time, includeTagged bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for k, r := range s.records {
+		if r.LastChecked.Before(before) && (includeTagged || len(r.Tags) == 0) {
+			delete(s.records, k)
+			removed++
+		}
+	}
+	s.dirty = s.dirty || removed > 0
+	return removed
+}
+
+// Hashes returns all the hashes in the store, sorted
+func (s *File) Hashes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hashes := make([]string, 0, len(s.records))
+	for k := range s.records {
+		hashes = append(hashes, k)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// Tagged returns a copy of the records having the given tag, sorted by hash.
+// An empty tag returns all the records.
+func (s *File) Tagged(tag string) []Record {
+	records := s.Records()
+	if tag == "" {
+		return records
+	}
+	tagged := records[:0]
+	for _, r := range records {
+		if r.HasTag(tag) {
+			tagged = append(tagged, r)
+		}
+	}
+	return tagged
+}
+
+// Records returns a copy of all the records, sorted by hash
+func (s *File) Records() []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]Record, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r.copy())
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Hash < records[j].Hash })
+	return records
+}
+
+// Save writes the store to disk, replacing the previous file atomically
+func (s *File) Save() error {
+	records := s.Records()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err = enc.Encode(records); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err = os.Rename(f.Name(), s.path); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.dirty = false
+	s.mu.Unlock()
+	return nil
+}
+
+// Sink returns a pipeline sink saving every result to the store, for pipeline.AddSink and server.SetSink.
+// A File is saved after every result.
+func Sink(s Store) pipeline.Sink {
+	return pipeline.SinkFunc(func(ctx context.Context, r pipeline.Result) error {
+		if _, _, err := s.SaveResult(ctx, r.Submission.Hash, r.Submission.Path, r.Response); err != nil {
+			return err
+		}
+		if f, ok := s.(*File); ok {
+			return f.Save()
+		}
+		return nil
+	})
+}
+
+// SaveResult calls Put. The record is written on Save or Close.
+func (s *File) SaveResult(ctx context.Context, hash, path string, resp infinigo.QueryResponse) (Record, bool, error) {
+	prev, existed := s.Put(hash, path, resp)
+	return prev, existed, nil
+}
+
+// GetByHash calls Get
+func (s *File) GetByHash(ctx context.Context, hash string) (Record, bool, error) {
+	r, ok := s.Get(hash)
+	return r, ok, nil
+}
+
+// ListSince returns a copy of the records checked since the given time, sorted by hash.
+// A zero time returns all the records.
+func (s *File) ListSince(ctx context.Context, since time.Time) ([]Record, error) {
+	records := s.Records()
+	if since.IsZero() {
+		return records, nil
+	}
+	checked := records[:0]
+	for _, r := range records {
+		if !r.LastChecked.Before(since) {
+			checked = append(checked, r)
+		}
+	}
+	return checked, nil
+}
+
+// ListChanged returns a copy of the records whose verdict or score changed since the given time, sorted by hash
+func (s *File) ListChanged(ctx context.Context, since time.Time) ([]Record, error) {
+	records := s.Records()
+	changed := records[:0]
+	for _, r := range records {
+		if !r.Changed.IsZero() && !r.Changed.Before(since) {
+			changed = append(changed, r)
+		}
+	}
+	return changed, nil
+}
+
+// ListTagged calls Tagged
+func (s *File) ListTagged(ctx context.Context, tag string) ([]Record, error) {
+	return s.Tagged(tag), nil
+}
+
+// AddTags calls Tag. The tags are written on Save or Close.
+func (s *File) AddTags(ctx context.Context, hash string, tags []string, note string) error {
+	s.Tag(hash, tags, note)
+	return nil
+}
+
+// RemoveTags calls Untag. The change is written on Save or Close.
+func (s *File) RemoveTags(ctx context.Context, hash string, tags []string) error {
+	s.Untag(hash, tags)
+	return nil
+}
+
+// PurgeBefore calls Purge. The change is written on Save or Close.
+func (s *File) PurgeBefore(ctx context.Context, before time.Time, includeTagged bool) (int, error) {
+	return s.Purge(before, includeTagged), nil
+}
+
+// Close saves the store if it changed since the last Save
+func (s *File) Close() error {
+	s.mu.Lock()
+	dirty := s.dirty
+	s.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+	return s.Save()
+}
+
+// Changed returns true if the verdict or general score differ between the responses
+func Changed(prev, cur infinigo.QueryResponse) bool {
+	return prev.Verdict() != cur.Verdict() || prev.GeneralScore != cur.GeneralScore
+}
@@ -0,0 +1,20 @@
+package store_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/demisto/infinigo/store"
+	"github.com/demisto/infinigo/store/storetest"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history", "store.json")
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := store.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
@@ -0,0 +1,183 @@
+/*
+Package storetest checks that a store.Store implementation behaves as the store package documents,
+so the JSON file, SQLite and PostgreSQL stores and other implementations can be tested alike.
+*/
+package storetest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/store"
+)
+
+// Run records, tags, lists and purges hashes in an empty store returned by open. open is called again
+// once the store is closed, and must return the same store so it can be checked the records were kept.
+func Run(t *testing.T, open func(t *testing.T) store.Store) {
+	t.Helper()
+	ctx := context.Background()
+	s := open(t)
+	malicious := infinigo.QueryResponse{GeneralScore: -0.9, ConfirmCode: "code", Classifiers: map[string]float32{"ml": -0.9, "human": -1}}
+	benign := infinigo.QueryResponse{GeneralScore: 0.7}
+
+	// A new hash is normalized and first seen, checked and changed at once
+	prev, existed, err := s.SaveResult(ctx, " ABC ", "/tmp/sample", malicious)
+	check(t, err)
+	if existed || prev.Hash != "" {
+		t.Fatalf("Expected the hash to be new, got %+v", prev)
+	}
+	first, ok, err := s.GetByHash(ctx, "abc")
+	check(t, err)
+	if !ok || first.Hash != "abc" || first.Path != "/tmp/sample" || !reflect.DeepEqual(first.Response, malicious) {
+		t.Fatalf("Expected the record of abc, got %+v", first)
+	}
+	if first.FirstSeen.IsZero() || !first.LastChecked.Equal(first.FirstSeen) || !first.Changed.Equal(first.FirstSeen) {
+		t.Fatalf("Expected the times of the new record to be set, got %+v", first)
+	}
+	if _, ok, err = s.GetByHash(ctx, "missing"); err != nil || ok {
+		t.Fatalf("Expected an unknown hash not to be found, got %v", err)
+	}
+
+	// The same verdict keeps the path and the change time
+	mark := tick()
+	prev, existed, err = s.SaveResult(ctx, "abc", "", malicious)
+	check(t, err)
+	if !existed || !equal(prev, first) {
+		t.Fatalf("Expected the previous record %+v, got %+v", first, prev)
+	}
+	r := get(t, s, "abc")
+	if r.Path != "/tmp/sample" || !r.Changed.Equal(first.Changed) || !r.LastChecked.After(first.LastChecked) {
+		t.Fatalf("Expected the same verdict to only update the check time, got %+v", r)
+	}
+	expect(t, "checked", list(s.ListSince(ctx, mark)), "abc")
+	expect(t, "changed", list(s.ListChanged(ctx, mark)))
+
+	// Another score is a change
+	mark = tick()
+	_, _, err = s.SaveResult(ctx, "ABC", "/srv/sample", benign)
+	check(t, err)
+	r = get(t, s, "abc")
+	if r.Path != "/srv/sample" || r.Response.GeneralScore != 0.7 || !r.FirstSeen.Equal(first.FirstSeen) || r.Changed.Before(mark) {
+		t.Fatalf("Expected the new score to change the record, got %+v", r)
+	}
+	_, _, err = s.SaveResult(ctx, "def", "", malicious)
+	check(t, err)
+	expect(t, "changed", list(s.ListChanged(ctx, mark)), "abc", "def")
+
+	// Tagging an unknown hash creates a record that was never checked
+	mark = tick()
+	check(t, s.AddTags(ctx, "GHI", []string{"triage", "escalated"}, "seen on the mail gateway"))
+	check(t, s.AddTags(ctx, "abc", []string{"triage"}, ""))
+	check(t, s.AddTags(ctx, "abc", []string{"triage"}, "false positive"))
+	r = get(t, s, "ghi")
+	if !reflect.DeepEqual(r.Tags, []string{"escalated", "triage"}) || len(r.Notes) != 1 || r.Notes[0].Text != "seen on the mail gateway" || r.Notes[0].Time.Before(mark) {
+		t.Fatalf("Expected the tags and note of ghi, got %+v", r)
+	}
+	if !r.LastChecked.IsZero() || !r.Changed.IsZero() {
+		t.Fatalf("Expected ghi not to be checked, got %+v", r)
+	}
+	if r = get(t, s, "abc"); !reflect.DeepEqual(r.Tags, []string{"triage"}) || len(r.Notes) != 1 {
+		t.Fatalf("Expected tags to be added once, got %+v", r)
+	}
+	expect(t, "all", list(s.ListSince(ctx, time.Time{})), "abc", "def", "ghi")
+	expect(t, "tagged", list(s.ListTagged(ctx, "triage")), "abc", "ghi")
+	expect(t, "tagged", list(s.ListTagged(ctx, "")), "abc", "def", "ghi")
+	expect(t, "changed", list(s.ListChanged(ctx, mark)))
+	check(t, s.RemoveTags(ctx, "ABC", []string{"triage", "missing"}))
+	check(t, s.RemoveTags(ctx, "missing", []string{"triage"}))
+	expect(t, "tagged", list(s.ListTagged(ctx, "triage")), "ghi")
+
+	// The records are kept once the store is closed
+	records, err := s.ListSince(ctx, time.Time{})
+	check(t, err)
+	check(t, s.Close())
+	s = open(t)
+	defer s.Close()
+	reopened, err := s.ListSince(ctx, time.Time{})
+	check(t, err)
+	if len(reopened) != len(records) {
+		t.Fatalf("Expected %d records once reopened, got %d", len(records), len(reopened))
+	}
+	for i := range records {
+		if !equal(records[i], reopened[i]) {
+			t.Fatalf("Expected %+v once reopened, got %+v", records[i], reopened[i])
+		}
+	}
+
+	// Tagged records are only purged with includeTagged
+	n, err := s.PurgeBefore(ctx, tick(), false)
+	check(t, err)
+	if n != 2 {
+		t.Fatalf("Expected the untagged records to be purged, got %d", n)
+	}
+	expect(t, "all", list(s.ListSince(ctx, time.Time{})), "ghi")
+	if n, err = s.PurgeBefore(ctx, tick(), true); err != nil || n != 1 {
+		t.Fatalf("Expected the tagged record to be purged, got %d - %v", n, err)
+	}
+	expect(t, "all", list(s.ListSince(ctx, time.Time{})))
+}
+
+// tick waits for the clock to move on and returns the time, so the changes after it are after it in
+// stores keeping microseconds
+func tick() time.Time {
+	time.Sleep(5 * time.Millisecond)
+	now := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	return now
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(t *testing.T, s store.Store, hash string) store.Record {
+	t.Helper()
+	r, ok, err := s.GetByHash(context.Background(), hash)
+	check(t, err)
+	if !ok {
+		t.Fatalf("Expected the record of %s", hash)
+	}
+	return r
+}
+
+// list returns the hashes of the records, or the error
+func list(records []store.Record, err error) interface{} {
+	if err != nil {
+		return err
+	}
+	hashes := []string{}
+	for _, r := range records {
+		hashes = append(hashes, r.Hash)
+	}
+	return hashes
+}
+
+func expect(t *testing.T, name string, got interface{}, hashes ...string) {
+	t.Helper()
+	if hashes == nil {
+		hashes = []string{}
+	}
+	if !reflect.DeepEqual(got, hashes) {
+		t.Fatalf("Expected the %s records %v, got %v", name, hashes, got)
+	}
+}
+
+// equal returns true if the records are the same, whatever the locations of their times
+func equal(a, b store.Record) bool {
+	if !a.FirstSeen.Equal(b.FirstSeen) || !a.LastChecked.Equal(b.LastChecked) || !a.Changed.Equal(b.Changed) || len(a.Notes) != len(b.Notes) {
+		return false
+	}
+	for i := range a.Notes {
+		if !a.Notes[i].Time.Equal(b.Notes[i].Time) || a.Notes[i].Text != b.Notes[i].Text {
+			return false
+		}
+	}
+	a.FirstSeen, a.LastChecked, a.Changed, a.Notes = b.FirstSeen, b.LastChecked, b.Changed, b.Notes
+	return reflect.DeepEqual(a, b)
+}
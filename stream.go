@@ -0,0 +1,98 @@
+package infinigo
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// QueryFunc is called by QueryStream with the response for each hash, as given by the caller.
+// Returning an error stops the query.
+type QueryFunc func(hash string, resp QueryResponse) error
+
+// QueryStream queries any number of hashes in batches of QueryBatchSize as QueryAll does, but decodes
+// each response incrementally and calls fn with the entries as they are parsed, so memory stays flat
+// however many hashes a batch holds. With SetQueryRetries, the hashes Infinity answered with an error
+// are held back and retried once their batch is read.
+func (c *Client) QueryStream(ctx context.Context, classifiers string, hash []string, fn QueryFunc) error {
+	if len(hash) == 0 {
+		return &Error{ID: "missing_arg", Details: "hash is required"}
+	}
+	if fn == nil {
+		return &Error{ID: "missing_arg", Details: "Query function is required"}
+	}
+	if classifiers == "" {
+		classifiers = "all"
+	}
+	for start := 0; start < len(hash); start += QueryBatchSize {
+		if err := c.streamBatch(ctx, classifiers, hash[start:min(start+QueryBatchSize, len(hash))], fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamBatch queries a single batch for QueryStream
+func (c *Client) streamBatch(ctx context.Context, classifiers string, hash []string, fn QueryFunc) error {
+	normalized, originals := c.normalizeHashes(hash)
+	emit := func(h string, r QueryResponse) error {
+		c.confirmCodes.add(r)
+		hashes, ok := originals[strings.ToLower(h)]
+		if !ok {
+			return fn(h, r)
+		}
+		for _, original := range hashes {
+			if err := fn(original, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	failed := make(map[string]QueryResponse)
+	decode := func(body io.Reader) error {
+		dec := json.NewDecoder(body)
+		if t, err := dec.Token(); err != nil || t == nil {
+			return err
+		} else if t != json.Delim('{') {
+			return &Error{ID: "bad_response", Details: "Expected an object of responses"}
+		}
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			h, _ := t.(string)
+			var r QueryResponse
+			if err = dec.Decode(&r); err != nil {
+				return err
+			}
+			if r.Error != "" && c.queryRetries > 0 {
+				failed[h] = r
+				continue
+			}
+			if err = emit(h, r); err != nil {
+				return err
+			}
+		}
+		// More is false at the end of the body as well, which ends before the object does if it was cut short
+		if _, err := dec.Token(); err != io.EOF {
+			return err
+		}
+		return io.ErrUnexpectedEOF
+	}
+	entry := &AuditEntry{Action: AuditQuery, Classifiers: classifiers, Hashes: normalized}
+	if err := c.do(ctx, "GET", "q", map[string]string{"c": classifiers, "h": strings.Join(normalized, ",")}, nil, 0, decode, entry); err != nil {
+		return err
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	c.retryFailed(ctx, classifiers, failed)
+	for h, r := range failed {
+		if err := emit(h, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package infinigo
+
+import (
+	"io"
+	"time"
+)
+
+// throttledReader limits the rate at which data is read from the underlying reader
+type throttledReader struct {
+	r     io.Reader
+	limit int64 // limit in bytes per second
+	start time.Time
+	read  int64
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	// Read in small chunks so the rate is smooth rather than bursty
+	if chunk := t.limit/10 + 1; int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+	expected := time.Duration(float64(t.read) / float64(t.limit) * float64(time.Second))
+	if wait := expected - time.Since(t.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// SetUploadBandwidth limits the rate at which uploads are sent, in bytes per second.
+// 0 (the default) means no limit.
+func SetUploadBandwidth(bytesPerSecond int64) OptionFunc {
+	return func(c *Client) error {
+		if bytesPerSecond < 0 {
+			err := &Error{ID: "bad_option", Details: "Upload bandwidth cannot be negative"}
+			c.errorf("%v\n", err)
+			return err
+		}
+		c.uploadLimit = bytesPerSecond
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package infinigo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// checkedBody counts the bytes of a response body, failing with a TruncatedResponseError if it ends
+// before its Content-Length
+type checkedBody struct {
+	io.ReadCloser
+	expected int64
+	read     int64
+	eof      bool
+	peeked   []byte // peeked is the byte read by empty, returned by the next Read
+}
+
+func (b *checkedBody) Read(p []byte) (int, error) {
+	if len(b.peeked) > 0 && len(p) > 0 {
+		n := copy(p, b.peeked)
+		b.peeked = nil
+		return n, nil
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	b.eof = b.eof || err == io.EOF
+	if errors.Is(err, io.ErrUnexpectedEOF) || err == io.EOF && b.expected >= 0 && b.read < b.expected {
+		err = b.truncated()
+	}
+	return n, err
+}
+
+// empty returns true if the body has no data at all, which some gateways strip
+func (b *checkedBody) empty() (bool, error) {
+	var p [1]byte
+	if _, err := io.ReadFull(b, p[:]); err != nil {
+		if err == io.EOF {
+			return true, nil
+		}
+		return false, err
+	}
+	b.peeked = p[:]
+	return false, nil
+}
+
+// check classifies an error decoding the body. A body ending in the middle of its JSON was truncated,
+// unless all of its Content-Length was received, in which case Infinity sent JSON that is not valid.
+func (b *checkedBody) check(err error) error {
+	if err == nil || errors.Is(err, ErrTruncatedResponse) {
+		return err
+	}
+	// json.Decoder reports the end of the body in the middle of a token as a syntax error at its end
+	var syntaxErr *json.SyntaxError
+	if !errors.Is(err, io.ErrUnexpectedEOF) && !(errors.As(err, &syntaxErr) && b.eof && syntaxErr.Offset >= b.read) {
+		return err
+	}
+	if b.expected >= 0 && b.read >= b.expected {
+		return &Error{ID: "bad_response", Details: fmt.Sprintf("Response of %d bytes ends before its JSON does", b.read)}
+	}
+	return b.truncated()
+}
+
+func (b *checkedBody) truncated() error {
+	return &TruncatedResponseError{Received: b.read, Expected: b.expected}
+}
@@ -0,0 +1,51 @@
+package infinigo
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maintenanceMarker is looked for in 502 and 503 responses, and in the HTML pages returned instead of JSON
+var maintenanceMarker = []byte("maintenance")
+
+// serviceUnavailable returns a ServiceUnavailableError if the response is a 502 or 503, or a maintenance
+// page, which may come with any status but is only looked for in HTML when JSON was expected, as other
+// bodies such as files or the details of errors may mention maintenance. The body read to tell is put back.
+func serviceUnavailable(resp *http.Response, expectJSON bool) *ServiceUnavailableError {
+	unavailable := resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get(ContentTypeHeader))
+	if !unavailable && !(expectJSON && ct == "text/html") {
+		return nil
+	}
+	maintenance := false
+	if resp.Body != nil {
+		peek, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(peek), resp.Body), resp.Body}
+		maintenance = bytes.Contains(bytes.ToLower(peek), maintenanceMarker)
+	}
+	if !unavailable && !maintenance {
+		return nil
+	}
+	return &ServiceUnavailableError{Status: resp.StatusCode, Maintenance: maintenance, RetryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+}
+
+// retryAfter parses a Retry-After header, in seconds or an HTTP date, returning 0 if there is none
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return max(0, time.Duration(secs)*time.Second)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(0, time.Until(t).Round(time.Second))
+	}
+	return 0
+}
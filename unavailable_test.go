@@ -0,0 +1,79 @@
+package infinigo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServiceUnavailable(t *testing.T) {
+	const page = "<html><body>Infinity is down for scheduled Maintenance</body></html>"
+	query := func(c *Client) (string, error) {
+		_, err := c.Query("", "abc")
+		return "", err
+	}
+	fetch := func(c *Client) (string, error) {
+		resp, err := Do[bytes.Buffer](context.Background(), c, http.MethodGet, "files/abc", nil, nil)
+		return resp.String(), err
+	}
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		fetch       bool // fetch the body into a writer rather than querying
+		unavailable bool
+		maintenance bool
+	}{
+		{"503 maintenance page", http.StatusServiceUnavailable, "text/html", page, false, true, true},
+		{"502", http.StatusBadGateway, "text/plain", "bad gateway", false, true, false},
+		{"maintenance page instead of JSON", http.StatusOK, "text/html; charset=utf-8", page, false, true, true},
+		{"maintenance page with another status", http.StatusInternalServerError, "text/html", page, false, true, true},
+		{"HTML page without the marker", http.StatusOK, "text/html", "<html></html>", false, false, false},
+		{"JSON error mentioning maintenance", http.StatusBadRequest, "application/json", `{"error":"maintenance window is not valid"}`, false, false, false},
+		{"JSON response mentioning maintenance", http.StatusOK, "application/json", `{"abc":{"error":"hash is under maintenance"}}`, false, false, false},
+		{"text fetched into a writer", http.StatusOK, "text/plain", "maintenance notes", true, false, false},
+		{"HTML fetched into a writer", http.StatusOK, "text/html", page, true, false, false},
+		{"file fetched into a writer", http.StatusOK, "application/octet-stream", "MZ maintenance", true, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(ContentTypeHeader, test.contentType)
+				w.Header().Set("Retry-After", "120")
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			}))
+			defer srv.Close()
+			c, err := New(SetKey("key"), SetURL(srv.URL+"/"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			call := query
+			if test.fetch {
+				call = fetch
+			}
+			body, err := call(c)
+			var unavailable *ServiceUnavailableError
+			if errors.As(err, &unavailable) != test.unavailable {
+				t.Fatalf("Expected unavailable to be %v, got %v", test.unavailable, err)
+			}
+			if !test.unavailable {
+				if test.fetch && (err != nil || body != test.body) {
+					t.Fatalf("Expected the body to be fetched as is, got %q", body)
+				}
+				return
+			}
+			if !errors.Is(err, ErrServiceUnavailable) || unavailable.Status != test.status {
+				t.Fatalf("Expected Infinity to be unavailable with status %d, got %v", test.status, err)
+			}
+			if unavailable.Maintenance != test.maintenance || unavailable.RetryAfter != 2*time.Minute {
+				t.Fatalf("Expected maintenance to be %v, retrying after 2m, got %v", test.maintenance, err)
+			}
+		})
+	}
+}
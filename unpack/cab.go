@@ -0,0 +1,221 @@
+package unpack
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/demisto/infinigo"
+)
+
+// Compression types of cabinet folders
+const (
+	cabStored = 0
+	cabMSZIP  = 1
+)
+
+const (
+	cabReservePresent = 0x0004
+	cabPrevCabinet    = 0x0001
+	cabNextCabinet    = 0x0002
+	cabHeaderSize     = 36
+	cabFileSize       = 16
+	mszipWindow       = 32 << 10
+	cabContinued      = 0xfffd // folder indexes from 0xfffd up are of files spanning cabinets
+)
+
+var cabMagic = []byte("MSCF")
+
+// cabFolder is a CFFOLDER of a cabinet
+type cabFolder struct {
+	offset      uint32
+	blocks      uint16
+	compression uint16
+}
+
+// cabFile is a CFFILE of a cabinet
+type cabFile struct {
+	size   uint32
+	offset uint32 // offset of the file in the uncompressed data of its folder
+	folder uint16
+	name   string
+}
+
+// walkCab walks the files of a cabinet, prefixing their names. Folders with unsupported compression
+// are added to skipped.
+func walkCab(r *io.SectionReader, prefix string, skipped *unsupported, fn WalkFunc) error {
+	var hdr [cabHeaderSize]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return errTruncated
+	}
+	filesOffset := binary.LittleEndian.Uint32(hdr[16:])
+	folderCount := binary.LittleEndian.Uint16(hdr[26:])
+	fileCount := binary.LittleEndian.Uint16(hdr[28:])
+	flags := binary.LittleEndian.Uint16(hdr[30:])
+	br := bufio.NewReader(io.NewSectionReader(r, cabHeaderSize, r.Size()-cabHeaderSize))
+	var folderReserve, dataReserve int
+	if flags&cabReservePresent != 0 {
+		var sizes [4]byte
+		if _, err := io.ReadFull(br, sizes[:]); err != nil {
+			return errTruncated
+		}
+		folderReserve, dataReserve = int(sizes[2]), int(sizes[3])
+		br.Discard(int(binary.LittleEndian.Uint16(sizes[:])))
+	}
+	// The names and disks of the previous and next cabinets of a set
+	for _, flag := range []uint16{cabPrevCabinet, cabNextCabinet} {
+		if flags&flag != 0 {
+			br.ReadString(0)
+			br.ReadString(0)
+		}
+	}
+	folders := make([]cabFolder, folderCount)
+	for i := range folders {
+		var b [8]byte
+		if _, err := io.ReadFull(br, b[:]); err != nil {
+			return errTruncated
+		}
+		folders[i] = cabFolder{binary.LittleEndian.Uint32(b[:]), binary.LittleEndian.Uint16(b[4:]), binary.LittleEndian.Uint16(b[6:])}
+		br.Discard(folderReserve)
+	}
+	br = bufio.NewReader(io.NewSectionReader(r, int64(filesOffset), r.Size()-int64(filesOffset)))
+	files := make([]cabFile, 0, fileCount)
+	for i := 0; i < int(fileCount); i++ {
+		var b [cabFileSize]byte
+		if _, err := io.ReadFull(br, b[:]); err != nil {
+			return errTruncated
+		}
+		name, err := br.ReadString(0)
+		if err != nil {
+			return errTruncated
+		}
+		f := cabFile{size: binary.LittleEndian.Uint32(b[:]), offset: binary.LittleEndian.Uint32(b[4:]), folder: binary.LittleEndian.Uint16(b[8:])}
+		f.name = path.Join(prefix, cleanName(strings.ReplaceAll(strings.TrimSuffix(name, "\x00"), `\`, "/")))
+		if f.folder >= cabContinued {
+			skipped.add("%s spans several cabinets", f.name)
+			continue
+		}
+		if int(f.folder) >= len(folders) {
+			return errTruncated
+		}
+		files = append(files, f)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].folder != files[j].folder {
+			return files[i].folder < files[j].folder
+		}
+		return files[i].offset < files[j].offset
+	})
+	for i := 0; i < len(files); {
+		folder := folders[files[i].folder]
+		end := i
+		for end < len(files) && files[end].folder == files[i].folder {
+			end++
+		}
+		if folder.compression&0x0f != cabStored && folder.compression&0x0f != cabMSZIP {
+			skipped.add("%d files of %s compressed with %s", end-i, prefix, cabCompression(folder.compression))
+			i = end
+			continue
+		}
+		data := &cabData{r: bufio.NewReader(io.NewSectionReader(r, int64(folder.offset), r.Size()-int64(folder.offset))),
+			blocks: int(folder.blocks), reserve: dataReserve, mszip: folder.compression&0x0f == cabMSZIP}
+		var pos int64
+		for ; i < end; i++ {
+			f := files[i]
+			if skip := int64(f.offset) - pos; skip < 0 {
+				// Files overlapping in a folder, which cabinet tools do not write
+				continue
+			} else if skip > 0 {
+				if _, err := io.CopyN(io.Discard, data, skip); err != nil {
+					return errTruncated
+				}
+				pos += skip
+			}
+			content := &io.LimitedReader{R: data, N: int64(f.size)}
+			if err := fn(File{Name: f.name, Size: int64(f.size), Mode: 0644}, content); err != nil {
+				return err
+			}
+			if _, err := io.Copy(io.Discard, content); err != nil {
+				return err
+			}
+			if content.N > 0 {
+				return errTruncated
+			}
+			pos += int64(f.size)
+		}
+	}
+	return nil
+}
+
+// cabCompression names the compression type of a folder
+func cabCompression(compression uint16) string {
+	switch compression & 0x0f {
+	case 2:
+		return "Quantum"
+	case 3:
+		return "LZX"
+	}
+	return "an unknown compression"
+}
+
+// cabData reads the uncompressed data of the CFDATA blocks of a folder
+type cabData struct {
+	r       *bufio.Reader
+	blocks  int // blocks left to read
+	reserve int
+	mszip   bool
+	window  []byte       // the last uncompressed bytes, the dictionary of the next MSZIP block
+	block   bytes.Reader // the rest of the current block
+}
+
+func (d *cabData) Read(p []byte) (int, error) {
+	for d.block.Len() == 0 {
+		if d.blocks == 0 {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	return d.block.Read(p)
+}
+
+// next decompresses the next block
+func (d *cabData) next() error {
+	d.blocks--
+	// checksum[4] compressed size[2] uncompressed size[2], the reserve and the data
+	var hdr [8]byte
+	if _, err := io.ReadFull(d.r, hdr[:]); err != nil {
+		return errTruncated
+	}
+	compressed := make([]byte, binary.LittleEndian.Uint16(hdr[4:]))
+	size := int(binary.LittleEndian.Uint16(hdr[6:]))
+	d.r.Discard(d.reserve)
+	if _, err := io.ReadFull(d.r, compressed); err != nil {
+		return errTruncated
+	}
+	if !d.mszip {
+		d.block.Reset(compressed)
+		return nil
+	}
+	if !bytes.HasPrefix(compressed, []byte("CK")) {
+		return &infinigo.Error{ID: "bad_request", Details: "Bad cabinet, MSZIP block without its signature"}
+	}
+	// Each block is a deflate stream using the previous block as its dictionary
+	fr := flate.NewReaderDict(bytes.NewReader(compressed[2:]), d.window)
+	out := make([]byte, size)
+	if _, err := io.ReadFull(fr, out); err != nil {
+		return err
+	}
+	d.window = out
+	if len(d.window) > mszipWindow {
+		d.window = d.window[len(d.window)-mszipWindow:]
+	}
+	d.block.Reset(out)
+	return nil
+}
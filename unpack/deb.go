@@ -0,0 +1,66 @@
+package unpack
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// arHeaderSize is the size of the header of an ar member
+const arHeaderSize = 60
+
+// walkDeb walks the data archive of a Debian package, and the maintainer scripts of its control archive
+// under control/
+func walkDeb(r io.Reader, fn WalkFunc) error {
+	br := bufio.NewReader(r)
+	if _, err := br.Discard(len("!<arch>\n")); err != nil {
+		return err
+	}
+	for {
+		var hdr [arHeaderSize]byte
+		if _, err := io.ReadFull(br, hdr[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errTruncated
+		}
+		// name[16] mtime[12] uid[6] gid[6] mode[8] size[10] magic[2]
+		name := strings.TrimSuffix(strings.TrimSpace(string(hdr[:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return errTruncated
+		}
+		member := io.LimitReader(br, size)
+		switch {
+		case strings.HasPrefix(name, "data.tar"):
+			err = walkCompressedTar(member, "", fn)
+		case strings.HasPrefix(name, "control.tar"):
+			err = walkCompressedTar(member, "control", func(f File, r io.Reader) error {
+				if f.Name == "control/control" || f.Name == "control/md5sums" || f.Name == "control/conffiles" {
+					return nil
+				}
+				return fn(f, r)
+			})
+		}
+		if err != nil {
+			return err
+		}
+		// Members are padded to an even size
+		if _, err = io.Copy(io.Discard, member); err != nil {
+			return err
+		}
+		if size%2 == 1 {
+			br.Discard(1)
+		}
+	}
+}
+
+// walkCompressedTar walks a tar stream compressed in any of the supported formats
+func walkCompressedTar(r io.Reader, prefix string, fn WalkFunc) error {
+	dr, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	return walkTar(dr, prefix, fn)
+}
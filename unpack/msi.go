@@ -0,0 +1,67 @@
+package unpack
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/richardlehane/mscfb"
+)
+
+// msiNameChars are the characters of the names of MSI streams, which are packed two per UTF-16 unit
+const msiNameChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz._"
+
+// msiStreamName decodes the name of a stream of an MSI database, e.g. Binary.CustomAction.dll.
+// Table streams are returned with a leading !.
+func msiStreamName(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		switch {
+		case c >= 0x3800 && c < 0x4800:
+			c -= 0x3800
+			b.WriteByte(msiNameChars[c&0x3f])
+			b.WriteByte(msiNameChars[c>>6&0x3f])
+		case c >= 0x4800 && c < 0x4840:
+			b.WriteByte(msiNameChars[c-0x4800])
+		case c == 0x4840:
+			b.WriteByte('!')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// walkMSI walks the files of the cabinets embedded in an MSI package under the names of the cabinets,
+// and the streams of its Binary table under Binary/
+func walkMSI(r io.ReaderAt, fn WalkFunc) error {
+	doc, err := mscfb.New(r)
+	if err != nil {
+		return err
+	}
+	var skipped unsupported
+	for _, f := range doc.File {
+		if !f.FileInfo().Mode().IsRegular() || f.Size == 0 {
+			continue
+		}
+		name := msiStreamName(f.Name)
+		head := make([]byte, 4)
+		if n, _ := f.ReadAt(head, 0); n < len(head) {
+			continue
+		}
+		switch {
+		case bytes.Equal(head, cabMagic):
+			if err = walkCab(io.NewSectionReader(f, 0, f.Size), name, &skipped, fn); err != nil {
+				return err
+			}
+		case strings.HasPrefix(name, "Binary."):
+			stream := bufio.NewReader(io.NewSectionReader(f, 0, f.Size))
+			if err = fn(File{Name: path.Join("Binary", strings.TrimPrefix(name, "Binary.")), Size: f.Size, Mode: 0644}, stream); err != nil {
+				return err
+			}
+		}
+	}
+	return skipped.err()
+}
@@ -0,0 +1,62 @@
+package unpack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/cavaliergopher/cpio"
+	"github.com/demisto/infinigo"
+)
+
+const (
+	rpmLeadSize        = 96
+	rpmHeaderIntroSize = 16
+)
+
+// walkRPM walks the cpio payload of an RPM package after skipping its lead, signature and header
+func walkRPM(r io.Reader, fn WalkFunc) error {
+	br := bufio.NewReader(r)
+	if _, err := br.Discard(rpmLeadSize); err != nil {
+		return errTruncated
+	}
+	for _, part := range []string{"signature", "header"} {
+		// magic[3] version[1] reserved[4] entries[4] data size[4], then 16 bytes per entry and the data
+		var intro [rpmHeaderIntroSize]byte
+		if _, err := io.ReadFull(br, intro[:]); err != nil {
+			return errTruncated
+		}
+		if intro[0] != 0x8e || intro[1] != 0xad || intro[2] != 0xe8 {
+			return &infinigo.Error{ID: "bad_request", Details: "Bad RPM " + part}
+		}
+		size := int64(binary.BigEndian.Uint32(intro[8:]))*16 + int64(binary.BigEndian.Uint32(intro[12:]))
+		// The signature is padded to a multiple of 8 bytes
+		if part == "signature" && size%8 != 0 {
+			size += 8 - size%8
+		}
+		if n, _ := io.CopyN(io.Discard, br, size); n != size {
+			return errTruncated
+		}
+	}
+	payload, err := decompress(br)
+	if err != nil {
+		return err
+	}
+	defer payload.Close()
+	cr := cpio.NewReader(payload)
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !hdr.Mode.IsRegular() {
+			continue
+		}
+		if err = fn(File{Name: cleanName(hdr.Name), Size: hdr.Size, Mode: hdr.FileInfo().Mode()}, cr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Package unpack reads the files embedded in software packages, so each binary a package would install is
+checked on its own before the package is distributed:
+
+	deb    Debian packages, the files of their data archive and the maintainer scripts of their control archive
+	rpm    RPM packages, the files of their cpio payload
+	msi    Windows Installer packages, the files of their embedded cabinets and the Binary table streams,
+	       which hold the DLLs of custom actions
+	zip    NuGet packages and other zip based formats such as jar, whl, vsix and apk
+
+Payloads compressed with gzip, bzip2, xz, lzma and zstd are supported. Cabinets compressed with LZX or
+Quantum are not, and are reported once the rest of the package is read.
+*/
+package unpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/demisto/infinigo"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Formats of packages
+const (
+	Deb = "deb"
+	RPM = "rpm"
+	MSI = "msi"
+	Zip = "zip"
+)
+
+// File is a file embedded in a package
+type File struct {
+	Name string // Name is the slash separated path of the file in the package
+	Size int64
+	Mode fs.FileMode
+}
+
+// WalkFunc is called with every regular file of a package, and its content.
+// Walking stops at the first error it returns.
+type WalkFunc func(f File, r io.Reader) error
+
+var (
+	debMagic = []byte("!<arch>\ndebian-binary")
+	rpmMagic = []byte{0xed, 0xab, 0xee, 0xdb}
+	cfbMagic = []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}
+	zipMagic = []byte("PK\x03\x04")
+)
+
+// Detect returns the format of the package from its first bytes, or an empty string if it is not a package
+func Detect(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, debMagic):
+		return Deb
+	case bytes.HasPrefix(head, rpmMagic):
+		return RPM
+	case bytes.HasPrefix(head, cfbMagic):
+		return MSI
+	case bytes.HasPrefix(head, zipMagic):
+		return Zip
+	}
+	return ""
+}
+
+// Walk calls fn with every regular file of the package of size bytes read from r.
+// Parts of the package that cannot be read, such as cabinets compressed with LZX, are skipped and
+// reported in the error returned after walking the rest.
+func Walk(r io.ReaderAt, size int64, fn WalkFunc) error {
+	head := make([]byte, len(debMagic))
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch Detect(head[:n]) {
+	case Deb:
+		return walkDeb(io.NewSectionReader(r, 0, size), fn)
+	case RPM:
+		return walkRPM(io.NewSectionReader(r, 0, size), fn)
+	case MSI:
+		return walkMSI(r, fn)
+	case Zip:
+		return walkZip(r, size, fn)
+	}
+	return &infinigo.Error{ID: "bad_request", Details: "Not a deb, rpm, msi or zip package"}
+}
+
+// walkZip walks the files of a zip archive
+func walkZip(r io.ReaderAt, size int64, fn WalkFunc) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(File{Name: cleanName(zf.Name), Size: int64(zf.UncompressedSize64), Mode: zf.Mode()}, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTar walks the regular files of a tar stream, prefixing their names
+func walkTar(r io.Reader, prefix string, fn WalkFunc) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = fn(File{Name: path.Join(prefix, cleanName(hdr.Name)), Size: hdr.Size, Mode: hdr.FileInfo().Mode()}, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// cleanName returns the name of a file without a leading ./ or /
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// decompress detects the compression of a stream from its magic bytes, returning the stream as is if
+// it is not compressed
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(6)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0}):
+		xr, err := xz.NewReader(br)
+		return io.NopCloser(xr), err
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	case bytes.HasPrefix(magic, []byte{0x5d, 0, 0}):
+		lr, err := lzma.NewReader(br)
+		return io.NopCloser(lr), err
+	}
+	return io.NopCloser(br), nil
+}
+
+// unsupported collects the parts of a package that could not be read
+type unsupported []string
+
+func (u *unsupported) add(format string, args ...interface{}) {
+	*u = append(*u, fmt.Sprintf(format, args...))
+}
+
+// err returns the error reporting the parts, or nil if there are none
+func (u unsupported) err() error {
+	if len(u) == 0 {
+		return nil
+	}
+	return &infinigo.Error{ID: "unsupported", Details: strings.Join(u, ", ")}
+}
+
+// errTruncated is returned for packages that end before their structures say
+var errTruncated = errors.New("truncated package")
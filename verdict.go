@@ -0,0 +1,33 @@
+package infinigo
+
+// Verdict is a coarse classification derived from a QueryResponse
+type Verdict string
+
+const (
+	VerdictUnknown    Verdict = "unknown"    // VerdictUnknown when Infinity has no score for the hash yet
+	VerdictBenign     Verdict = "benign"     // VerdictBenign for positive scores
+	VerdictSuspicious Verdict = "suspicious" // VerdictSuspicious for negative scores above MaliciousThreshold
+	VerdictMalicious  Verdict = "malicious"  // VerdictMalicious for scores at or below MaliciousThreshold
+	VerdictError      Verdict = "error"      // VerdictError when Infinity returned an error for the hash
+)
+
+// MaliciousThreshold is the general score at or below which a hash is considered malicious.
+// Infinity scores range from -1 (malicious) to 1 (benign).
+const MaliciousThreshold = -0.5
+
+// Verdict classifies the response based on the error and general score.
+// A zero score means Infinity did not score the hash (yet).
+func (r QueryResponse) Verdict() Verdict {
+	switch {
+	case r.Error != "":
+		return VerdictError
+	case r.GeneralScore == 0:
+		return VerdictUnknown
+	case r.GeneralScore <= MaliciousThreshold:
+		return VerdictMalicious
+	case r.GeneralScore < 0:
+		return VerdictSuspicious
+	default:
+		return VerdictBenign
+	}
+}
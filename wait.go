@@ -0,0 +1,70 @@
+package infinigo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"time"
+)
+
+// DefaultPollInterval is how often UploadAndWait queries for the verdict of an uploaded file
+const DefaultPollInterval = 10 * time.Second
+
+// ErrTimeout is returned by UploadAndWait when Infinity did not provide a verdict in time
+var ErrTimeout = &Error{ID: "timeout", Details: "Timed out waiting for a verdict"}
+
+// UploadAndWait uploads a file and queries Infinity for its SHA256 every interval until the verdict
+// is no longer unknown. If there is no verdict within timeout, the last response is returned with ErrTimeout.
+// An interval of 0 uses DefaultPollInterval.
+func (c *Client) UploadAndWait(confirmCode string, data io.Reader, interval, timeout time.Duration) (QueryResponse, error) {
+	return c.UploadAndWaitContext(context.Background(), confirmCode, data, interval, timeout)
+}
+
+// UploadAndWaitContext uploads and waits as UploadAndWait does with the context of the requests.
+// It stops waiting with the error of the context once it is done.
+func (c *Client) UploadAndWaitContext(ctx context.Context, confirmCode string, data io.Reader, interval, timeout time.Duration) (QueryResponse, error) {
+	if data == nil {
+		return QueryResponse{}, &Error{ID: "missing_arg", Details: "Data is required"}
+	}
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	h := sha256.New()
+	if _, err := c.UploadContext(ctx, confirmCode, io.TeeReader(data, h)); err != nil {
+		return QueryResponse{}, err
+	}
+	return c.wait(ctx, hex.EncodeToString(h.Sum(nil)), interval, timeout)
+}
+
+// wait polls for the verdict of the hash until the timeout or the context is done
+func (c *Client) wait(ctx context.Context, hash string, interval, timeout time.Duration) (QueryResponse, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := c.QueryContext(ctx, "", hash)
+		if err != nil {
+			return QueryResponse{}, err
+		}
+		res, ok := resp[hash]
+		if !ok {
+			// Infinity may key the response with the hash in a different case
+			for _, v := range resp {
+				res = v
+			}
+		}
+		if res.Verdict() != VerdictUnknown {
+			return res, nil
+		}
+		if !time.Now().Add(interval).Before(deadline) {
+			return res, ErrTimeout
+		}
+		c.tracef("No verdict for %s yet, checking again in %v\n", hash, interval)
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package infinigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUploadAndWaitStopsWithTheContext(t *testing.T) {
+	var queries atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			queries.Add(1)
+		}
+		// No verdict yet
+		w.Header().Set(ContentTypeHeader, "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	c, err := New(SetKey("key"), SetURL(srv.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.UploadAndWaitContext(ctx, "code", strings.NewReader("data"), time.Minute, 10*time.Minute)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected waiting to stop at the deadline, got %v", err)
+	}
+	if time.Since(start) > 10*time.Second || queries.Load() != 1 {
+		t.Fatalf("Expected a single query before the deadline, got %d in %v", queries.Load(), time.Since(start))
+	}
+}
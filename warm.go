@@ -0,0 +1,67 @@
+package infinigo
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// SetWarmConnections has New open n connections to Infinity in the background, so the first requests
+// sent in parallel reuse them instead of each paying for the TLS handshake. The transport of the
+// http.Client is copied to keep n idle connections if it keeps fewer. It is 0 by default.
+func SetWarmConnections(n int) OptionFunc {
+	return func(c *Client) error {
+		if n < 0 {
+			err := &Error{ID: "bad_option", Details: "Warm connections cannot be negative"}
+			c.errorf("%v\n", err)
+			return err
+		}
+		c.warmConns = n
+		return nil
+	}
+}
+
+// prewarm opens the warm connections in the background
+func (c *Client) prewarm() {
+	t, ok := c.c.Transport.(*http.Transport)
+	if c.c.Transport == nil {
+		t, ok = http.DefaultTransport.(*http.Transport)
+	}
+	idle := http.DefaultMaxIdleConnsPerHost
+	if ok && t.MaxIdleConnsPerHost > 0 {
+		idle = t.MaxIdleConnsPerHost
+	}
+	if ok && idle < c.warmConns {
+		t = t.Clone()
+		t.MaxIdleConnsPerHost = c.warmConns
+		hc := *c.c
+		hc.Transport = t
+		c.c = &hc
+	}
+	hc, n := c.c, c.warmConns
+	go func() {
+		// Requests in flight at the same time each open a connection, returned to the idle pool once
+		// the response is read
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, c.url, nil)
+				if err != nil {
+					return
+				}
+				resp, err := hc.Do(req)
+				if err != nil {
+					c.tracef("Failed to warm a connection - %v\n", err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}()
+		}
+		wg.Wait()
+		c.tracef("Warmed %d connections\n", n)
+	}()
+}
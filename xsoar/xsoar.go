@@ -0,0 +1,109 @@
+/*
+Package xsoar shapes results as the standard DBotScore and File context entries of Cortex XSOAR
+(formerly Demisto), so automations can use the output of infinigo as the output of an integration.
+*/
+package xsoar
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/demisto/infinigo"
+	"github.com/demisto/infinigo/pipeline"
+)
+
+// Vendor of the scores
+const Vendor = "Cylance Infinity"
+
+// DBot scores
+const (
+	ScoreUnknown    = 0
+	ScoreGood       = 1
+	ScoreSuspicious = 2
+	ScoreBad        = 3
+)
+
+// Source reliabilities, see the reliability parameter of XSOAR integrations
+const (
+	ReliabilityA  = "A - Completely reliable"
+	ReliabilityA1 = "A+ - 3rd party enrichment"
+	ReliabilityB  = "B - Usually reliable"
+	ReliabilityC  = "C - Fairly reliable"
+	ReliabilityD  = "D - Not usually reliable"
+	ReliabilityE  = "E - Unreliable"
+	ReliabilityF  = "F - Reliability cannot be judged"
+)
+
+// Reliabilities are the valid source reliabilities
+var Reliabilities = []string{ReliabilityA1, ReliabilityA, ReliabilityB, ReliabilityC, ReliabilityD, ReliabilityE, ReliabilityF}
+
+// DBotScore is the score of an indicator by a vendor
+type DBotScore struct {
+	Indicator   string `json:"Indicator"`
+	Type        string `json:"Type"`
+	Vendor      string `json:"Vendor"`
+	Score       int    `json:"Score"`
+	Reliability string `json:"Reliability,omitempty"`
+}
+
+// Malicious describes why a file is malicious
+type Malicious struct {
+	Vendor      string `json:"Vendor"`
+	Description string `json:"Description"`
+}
+
+// File is the standard context of a file
+type File struct {
+	MD5       string     `json:"MD5,omitempty"`
+	SHA1      string     `json:"SHA1,omitempty"`
+	SHA256    string     `json:"SHA256,omitempty"`
+	Name      string     `json:"Name,omitempty"`
+	Path      string     `json:"Path,omitempty"`
+	Malicious *Malicious `json:"Malicious,omitempty"`
+}
+
+// Context is the entry context of results
+type Context struct {
+	DBotScore []DBotScore `json:"DBotScore"`
+	File      []File      `json:"File"`
+}
+
+// Score returns the DBot score of the verdict
+func Score(v infinigo.Verdict) int {
+	switch v {
+	case infinigo.VerdictMalicious:
+		return ScoreBad
+	case infinigo.VerdictSuspicious:
+		return ScoreSuspicious
+	case infinigo.VerdictBenign:
+		return ScoreGood
+	}
+	return ScoreUnknown
+}
+
+// NewContext returns the entry context of the results with the reliability of the source, e.g. ReliabilityB
+func NewContext(results []pipeline.Result, reliability string) Context {
+	ctx := Context{DBotScore: make([]DBotScore, 0, len(results)), File: make([]File, 0, len(results))}
+	for _, r := range results {
+		score := Score(r.Response.Verdict())
+		ctx.DBotScore = append(ctx.DBotScore, DBotScore{Indicator: r.Submission.Hash, Type: "file", Vendor: Vendor, Score: score, Reliability: reliability})
+		f := File{Path: r.Submission.Path}
+		if f.Path != "" {
+			f.Name = filepath.Base(f.Path)
+		}
+		// Infinity hashes are SHA-256, but keep whatever was queried under the matching key
+		switch len(r.Submission.Hash) {
+		case 32:
+			f.MD5 = r.Submission.Hash
+		case 40:
+			f.SHA1 = r.Submission.Hash
+		default:
+			f.SHA256 = r.Submission.Hash
+		}
+		if score == ScoreBad {
+			f.Malicious = &Malicious{Vendor: Vendor, Description: fmt.Sprintf("Infinity score %v", r.Response.GeneralScore)}
+		}
+		ctx.File = append(ctx.File, f)
+	}
+	return ctx
+}